@@ -1,9 +1,16 @@
 package config
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
 	"fmt"
-	"log/slog"
+	"maps"
+	"math"
+	"net"
 	"os"
+	"path"
+	"strconv"
 	"strings"
 	"time"
 
@@ -15,20 +22,84 @@ import (
 	"github.com/bluenviron/gomavlib/v2/pkg/dialects/minimal"
 	"github.com/bluenviron/gomavlib/v2/pkg/dialects/paparazzi"
 	"github.com/bluenviron/gomavlib/v2/pkg/dialects/standard"
+	"github.com/bluenviron/gomavlib/v2/pkg/frame"
+	"github.com/makinje/aero-arc-relay/pkg/telemetry"
 	"gopkg.in/yaml.v3"
 )
 
 // Config represents the application configuration
 type Config struct {
-	Relay   RelayConfig   `yaml:"relay"`
-	MAVLink MAVLinkConfig `yaml:"mavlink"`
-	Sinks   SinksConfig   `yaml:"sinks"`
-	Logging LoggingConfig `yaml:"logging"`
+	Relay    RelayConfig    `yaml:"relay"`
+	MAVLink  MAVLinkConfig  `yaml:"mavlink"`
+	Sinks    SinksConfig    `yaml:"sinks"`
+	Logging  LoggingConfig  `yaml:"logging"`
+	Commands CommandsConfig `yaml:"commands"`
+	Missions MissionsConfig `yaml:"missions"`
+	Params   ParamsConfig   `yaml:"params"`
+	Liveness LivenessConfig `yaml:"liveness"`
+	Admin    AdminConfig    `yaml:"admin"`
+	Recorder RecorderConfig `yaml:"recorder"`
+
+	Path string `yaml:"-"` // file the config was loaded from, reread on reload
+}
+
+// CommandsConfig controls the command uplink API
+type CommandsConfig struct {
+	Enabled    bool          `yaml:"enabled"`
+	Token      string        `yaml:"token,omitempty"`       // bearer token required by the HTTP APIs, required when any of them is enabled
+	Retries    *int          `yaml:"retries,omitempty"`     // resends when no COMMAND_ACK arrives, defaults to 3
+	AckTimeout time.Duration `yaml:"ack_timeout,omitempty"` // wait for a COMMAND_ACK per attempt, defaults to 1s
+	Timeout    time.Duration `yaml:"timeout,omitempty"`     // overall limit including IN_PROGRESS acks, defaults to 10s
+}
+
+// MissionsConfig controls the mission upload/download API. Requests use the
+// commands bearer token.
+type MissionsConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Retries     *int          `yaml:"retries,omitempty"`      // resends of an unanswered protocol message, defaults to 5
+	ItemTimeout time.Duration `yaml:"item_timeout,omitempty"` // wait for each reply, defaults to 1500ms
+	Timeout     time.Duration `yaml:"timeout,omitempty"`      // overall limit for a transfer, defaults to 60s
+}
+
+// ParamsConfig controls fetching and caching autopilot parameters and the
+// parameter API. Requests use the commands bearer token.
+type ParamsConfig struct {
+	Enabled     bool          `yaml:"enabled"`
+	Retries     *int          `yaml:"retries,omitempty"`      // quiet periods before giving up on missing params, and PARAM_SET resends; defaults to 3
+	ItemTimeout time.Duration `yaml:"item_timeout,omitempty"` // quiet period before re-requesting, and wait for a PARAM_SET echo; defaults to 1s
+	Timeout     time.Duration `yaml:"timeout,omitempty"`      // overall limit for the fetch on connect, defaults to 2m
+}
+
+// AdminConfig controls the endpoint admin API, which adds and removes MAVLink
+// endpoints at runtime. Requests must carry the admin bearer token, which is
+// required when the API is enabled.
+type AdminConfig struct {
+	Enabled   bool             `yaml:"enabled"`
+	Token     string           `yaml:"token,omitempty"`      // bearer token required by the admin API
+	StateFile string           `yaml:"state_file,omitempty"` // endpoint changes are saved here and reapplied at startup, empty keeps them in memory
+	Changes   *EndpointChanges `yaml:"-"`                    // loaded from the state file at load time
+}
+
+// RecorderConfig controls recording the raw MAVLink traffic of every endpoint
+// to .tlog files, one per endpoint
+type RecorderConfig struct {
+	Enabled          bool          `yaml:"enabled"`
+	Path             string        `yaml:"path,omitempty"`              // directory the .tlog files are written to
+	MaxBytes         int64         `yaml:"max_bytes,omitempty"`         // size after which a file is rotated, 0 disables
+	RotationInterval time.Duration `yaml:"rotation_interval,omitempty"` // age after which a file is rotated, 0 disables
+}
+
+// LivenessConfig controls when a drone is considered offline
+type LivenessConfig struct {
+	Enabled *bool         `yaml:"enabled,omitempty"` // defaults to true
+	Timeout time.Duration `yaml:"timeout,omitempty"` // time without autopilot heartbeats before a drone is offline; defaults to 5s
 }
 
 // RelayConfig contains relay-specific configuration
 type RelayConfig struct {
-	BufferSize int `yaml:"buffer_size"`
+	BufferSize         int           `yaml:"buffer_size"`
+	LinkStatusInterval time.Duration `yaml:"link_status_interval,omitempty"` // period of LinkStatus envelopes; defaults to 10s
+	WatchConfig        time.Duration `yaml:"watch_config,omitempty"`         // how often to check the config file for changes and reload it, 0 reloads on SIGHUP only
 }
 
 // MAVLinkConfig contains MAVLink connection settings
@@ -36,41 +107,273 @@ type MAVLinkConfig struct {
 	DialectName string            `yaml:"dialect"` // common, ardupilot, px4, etc.
 	Dialect     *dialect.Dialect  `yaml:"-"`       // resolved at load time
 	Endpoints   []MAVLinkEndpoint `yaml:"endpoints"`
+	Messages    []string          `yaml:"messages,omitempty"` // message names or IDs forwarded to sinks, empty forwards all
+	Filter      MessageFilter     `yaml:",inline"`            // default ingest filter for endpoints without their own
+	MessageIDs  []uint32          `yaml:"-"`                  // resolved at load time
+	Routing     RoutingConfig     `yaml:"routing,omitempty"`
+	Identity    IdentityConfig    `yaml:"identity,omitempty"` // defaults for every endpoint
+
+	// Downsampling rules applied on every endpoint: message name or ID to
+	// rule. Endpoints may replace the rule of a message type.
+	Downsample      map[string]DownsampleRule `yaml:"downsample,omitempty"`
+	DownsampleRules map[uint32]DownsampleRule `yaml:"-"` // resolved at load time
+}
+
+// IdentityConfig is the MAVLink identity the relay uses on an endpoint and the
+// heartbeat it sends there. Unset endpoint fields inherit the global identity.
+type IdentityConfig struct {
+	SystemID    uint8           `yaml:"system_id,omitempty"`    // defaults to 255
+	ComponentID uint8           `yaml:"component_id,omitempty"` // defaults to 190 (MAV_COMP_ID_MISSIONPLANNER)
+	Version     int             `yaml:"version,omitempty"`      // MAVLink 1 or 2, defaults to 2
+	Heartbeat   HeartbeatConfig `yaml:"heartbeat,omitempty"`
+}
+
+// HeartbeatConfig controls the HEARTBEAT the relay sends on open channels.
+// Autopilot GCS failsafes expect it at 1Hz from the GCS system ID.
+type HeartbeatConfig struct {
+	Enabled  *bool           `yaml:"enabled,omitempty"` // defaults to true
+	Rate     float64         `yaml:"rate,omitempty"`    // Hz, defaults to 1
+	TypeName string          `yaml:"type,omitempty"`    // MAV_TYPE advertised, defaults to MAV_TYPE_GCS
+	Type     common.MAV_TYPE `yaml:"-"`                 // resolved at load time
+}
+
+// Period returns the time between heartbeats
+func (h HeartbeatConfig) Period() time.Duration {
+	return time.Duration(float64(time.Second) / h.Rate)
+}
+
+// RoutingConfig controls forwarding of MAVLink frames between endpoints
+type RoutingConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+// MessageFilter selects the message types ingested from an endpoint. Entries
+// are message names or IDs; names may use * and ? wildcards, e.g.
+// "RC_CHANNELS*".
+type MessageFilter struct {
+	Include    []string `yaml:"include,omitempty"` // only these are ingested, empty ingests all
+	Exclude    []string `yaml:"exclude,omitempty"` // never ingested, wins over include
+	IncludeIDs []uint32 `yaml:"-"`                 // resolved at load time
+	ExcludeIDs []uint32 `yaml:"-"`                 // resolved at load time
+}
+
+// DownsampleRule reduces the rate of a message type before it reaches the
+// sinks. Without a strategy, rules with every use every_nth and the others
+// latest.
+type DownsampleRule struct {
+	StrategyName string             `yaml:"strategy,omitempty"` // latest, every_nth or aggregate
+	Strategy     DownsampleStrategy `yaml:"-"`                  // resolved at load time
+	Interval     time.Duration      `yaml:"interval,omitempty"` // window of latest and aggregate
+	Every        int                `yaml:"every,omitempty"`    // every_nth forwards one message of every N
+}
+
+// DownsampleStrategy is how a downsampling rule reduces a message stream
+type DownsampleStrategy string
+
+const (
+	DownsampleLatest    DownsampleStrategy = "latest"    // forward the latest message of each interval
+	DownsampleEveryNth  DownsampleStrategy = "every_nth" // forward the first of every N messages
+	DownsampleAggregate DownsampleStrategy = "aggregate" // forward the min, max and average of numeric fields per interval
+)
+
+// MAVLinkEndpointRouting holds the routing rules for frames sent out of an
+// endpoint. Empty allow lists allow everything; deny lists win over allow
+// lists.
+type MAVLinkEndpointRouting struct {
+	Disabled        bool     `yaml:"disabled,omitempty"`       // keep this endpoint out of routing entirely
+	AllowMessages   []string `yaml:"allow_messages,omitempty"` // message names or IDs
+	DenyMessages    []string `yaml:"deny_messages,omitempty"`  // message names or IDs
+	AllowSystems    []uint8  `yaml:"allow_systems,omitempty"`  // source system IDs
+	DenySystems     []uint8  `yaml:"deny_systems,omitempty"`   // source system IDs
+	AllowMessageIDs []uint32 `yaml:"-"`                        // resolved at load time
+	DenyMessageIDs  []uint32 `yaml:"-"`                        // resolved at load time
 }
 
 // MAVLinkEndpoint represents a single MAVLink connection
 type MAVLinkEndpoint struct {
 	Name         string                  `yaml:"name"`
 	DroneID      string                  `yaml:"drone_id,omitempty"`
-	ProtocolName string                  `yaml:"protocol"` // udp, tcp, serial
+	ProtocolName string                  `yaml:"protocol"` // udp, tcp, udp_client, tcp_client, udp_broadcast, serial, replay, sim
 	Protocol     MAVLinkEndpointProtocol `yaml:"-"`        // resolved at load time
 	ModeName     string                  `yaml:"mode,omitempty"`
-	Mode         MAVLinkMode             `yaml:"-"` // resolved at load time
+	Mode         MAVLinkMode             `yaml:"-"`                       // resolved at load time
+	Address      string                  `yaml:"address,omitempty"`       // bind host for servers, remote host:port for clients, broadcast host:port
+	LocalAddress string                  `yaml:"local_address,omitempty"` // udp_broadcast only: local host:port to send from
 	Port         int                     `yaml:"port,omitempty"`
+	Device       string                  `yaml:"device,omitempty"` // serial device path, e.g. /dev/ttyACM0 or /dev/serial/by-id/...
 	BaudRate     int                     `yaml:"baud_rate,omitempty"`
+	Replay       ReplayConfig            `yaml:"replay,omitempty"` // replay only: the .tlog file played back
+	Sim          SimConfig               `yaml:"sim,omitempty"`    // sim only: the simulated vehicles
+
+	// Multi mode only: maps MAVLink system (and optionally component) IDs
+	// seen on this endpoint to drone IDs.
+	Systems         []MAVLinkSystemMapping `yaml:"systems,omitempty"`
+	DroneIDTemplate string                 `yaml:"drone_id_template,omitempty"` // Fallback for unlisted systems, e.g. "{endpoint}-{system_id}"
+
+	Routing MAVLinkEndpointRouting `yaml:"routing,omitempty"`
+
+	// Filter drops message types before they become envelopes. Lists left
+	// empty inherit the global ones.
+	Filter MessageFilter `yaml:",inline"`
+
+	// Downsampling rules for this endpoint: message name or ID to rule.
+	// Message types without a rule here use the global one.
+	Downsample      map[string]DownsampleRule `yaml:"downsample,omitempty"`
+	DownsampleRules map[uint32]DownsampleRule `yaml:"-"` // resolved at load time, including the global rules
+
+	// Stream rates requested from vehicles on this endpoint when they
+	// connect: message name or ID to rate in Hz, 0 stops the message.
+	Streams     map[string]float64 `yaml:"streams,omitempty"`
+	StreamRates map[uint32]float64 `yaml:"-"` // resolved at load time
+
+	// Identity overrides the global relay identity on this endpoint and
+	// holds the resolved identity after loading.
+	Identity IdentityConfig `yaml:"identity,omitempty"`
+
+	Signing SigningConfig `yaml:"signing,omitempty"`
+
+	// Error is why the endpoint failed validation or resolution at load
+	// time. Invalid endpoints are kept so the relay reports them as failed.
+	Error error `yaml:"-"`
+}
+
+// ReplayConfig plays a .tlog file back through the relay as if its frames
+// arrived on a link
+type ReplayConfig struct {
+	File        string        `yaml:"file"`
+	SpeedName   string        `yaml:"speed,omitempty"`        // factor of the recorded timing, or "max" for as fast as possible; defaults to 1
+	Speed       float64       `yaml:"-"`                      // resolved at load time, 0 plays as fast as possible
+	Loop        bool          `yaml:"loop,omitempty"`         // start over at the end of the file
+	StartOffset time.Duration `yaml:"start_offset,omitempty"` // skip this much of the recording from its first frame
+}
+
+// SimConfig generates telemetry for virtual vehicles on a sim endpoint. The
+// vehicles use system IDs 1 to Vehicles and fly the orbit or, when waypoints
+// are set, a closed path through the waypoints, spread evenly along it.
+type SimConfig struct {
+	Vehicles     int                `yaml:"vehicles,omitempty"`      // defaults to 1
+	Autopilot    string             `yaml:"autopilot,omitempty"`     // ardupilot or px4, sets how flight modes are encoded; defaults to ardupilot
+	Speed        float64            `yaml:"speed,omitempty"`         // ground speed in m/s, defaults to 10
+	BatteryDrain float64            `yaml:"battery_drain,omitempty"` // percent per minute of flight, defaults to 2
+	Orbit        SimOrbit           `yaml:"orbit,omitempty"`
+	Waypoints    []SimWaypoint      `yaml:"waypoints,omitempty"`
+	Rates        map[string]float64 `yaml:"rates,omitempty"` // message name to rate in Hz, 0 disables the message
+	MessageRates map[string]float64 `yaml:"-"`               // resolved at load time, including the default rates
+}
+
+// SimOrbit is the circle simulated vehicles fly when no waypoints are set
+type SimOrbit struct {
+	Latitude  float64 `yaml:"latitude,omitempty"`  // center, defaults to the PX4 SITL home 47.397742
+	Longitude float64 `yaml:"longitude,omitempty"` // center, defaults to the PX4 SITL home 8.545594
+	Radius    float64 `yaml:"radius,omitempty"`    // meters, defaults to 100
+	Altitude  float64 `yaml:"altitude,omitempty"`  // meters above home, defaults to 50
+}
+
+// SimWaypoint is a point on the path of simulated vehicles
+type SimWaypoint struct {
+	Latitude  float64 `yaml:"latitude"`
+	Longitude float64 `yaml:"longitude"`
+	Altitude  float64 `yaml:"altitude"` // meters above home
+}
+
+// SimMessageRates are the messages a sim endpoint generates and their default
+// rates in Hz
+var SimMessageRates = map[string]float64{
+	"HEARTBEAT":           1,
+	"SYS_STATUS":          1,
+	"GLOBAL_POSITION_INT": 5,
+	"ATTITUDE":            10,
+	"VFR_HUD":             4,
+}
+
+// SigningConfig enables MAVLink 2 message signing on an endpoint. The secret
+// key is read from key_file or from the environment variable named by
+// key_env, either as 64 hex characters or as a passphrase that is hashed with
+// SHA-256 the way MAVProxy and Mission Planner derive keys.
+type SigningConfig struct {
+	KeyFile    string            `yaml:"key_file,omitempty"`
+	KeyEnv     string            `yaml:"key_env,omitempty"`
+	Sign       bool              `yaml:"sign,omitempty"`   // sign outgoing frames
+	VerifyName string            `yaml:"verify,omitempty"` // off, permissive or strict; defaults to off
+	Verify     SigningVerifyMode `yaml:"-"`                // resolved at load time
+	Key        *frame.V2Key      `yaml:"-"`                // resolved at load time
+}
+
+// SigningVerifyMode controls which incoming frames are rejected
+type SigningVerifyMode string
+
+const (
+	SigningVerifyOff        SigningVerifyMode = "off"        // accept every frame
+	SigningVerifyPermissive SigningVerifyMode = "permissive" // reject bad or replayed signatures, accept unsigned frames
+	SigningVerifyStrict     SigningVerifyMode = "strict"     // also reject unsigned frames
+)
+
+// MAVLinkSystemMapping maps a MAVLink system/component on a multi mode
+// endpoint to a drone ID. A zero ComponentID matches every component of the
+// system.
+type MAVLinkSystemMapping struct {
+	SystemID    uint8  `yaml:"system_id"`
+	ComponentID uint8  `yaml:"component_id,omitempty"`
+	DroneID     string `yaml:"drone_id"`
 }
 
 // MAVLinkEndpointProtocol represents a MAVLink endpoint protocol
 type MAVLinkEndpointProtocol string
 
 const (
-	MAVLinkEndpointProtocolUDP    MAVLinkEndpointProtocol = "udp"
-	MAVLinkEndpointProtocolTCP    MAVLinkEndpointProtocol = "tcp"
-	MAVLinkEndpointProtocolSerial MAVLinkEndpointProtocol = "serial"
+	MAVLinkEndpointProtocolUDP          MAVLinkEndpointProtocol = "udp"
+	MAVLinkEndpointProtocolTCP          MAVLinkEndpointProtocol = "tcp"
+	MAVLinkEndpointProtocolUDPClient    MAVLinkEndpointProtocol = "udp_client"
+	MAVLinkEndpointProtocolTCPClient    MAVLinkEndpointProtocol = "tcp_client"
+	MAVLinkEndpointProtocolUDPBroadcast MAVLinkEndpointProtocol = "udp_broadcast"
+	MAVLinkEndpointProtocolSerial       MAVLinkEndpointProtocol = "serial"
+	MAVLinkEndpointProtocolReplay       MAVLinkEndpointProtocol = "replay"
+	MAVLinkEndpointProtocolSim          MAVLinkEndpointProtocol = "sim"
 )
 
+// NetworkAddress returns the host:port a network endpoint binds to (servers)
+// or talks to (clients and broadcast). A port embedded in Address takes
+// precedence over Port, and servers bind to all interfaces when no address is
+// configured.
+func (e MAVLinkEndpoint) NetworkAddress() string {
+	host := e.Address
+	if host == "" {
+		host = "0.0.0.0"
+	}
+	if hasPort(host) {
+		return host
+	}
+	return net.JoinHostPort(host, strconv.Itoa(e.Port))
+}
+
+// SerialDevice returns the device path of a serial endpoint. Endpoints without
+// a device fall back to /dev/ttyUSB<port> for compatibility with older configs.
+func (e MAVLinkEndpoint) SerialDevice() string {
+	if e.Device != "" {
+		return e.Device
+	}
+	return fmt.Sprintf("/dev/ttyUSB%d", e.Port)
+}
+
+func hasPort(address string) bool {
+	_, _, err := net.SplitHostPort(address)
+	return err == nil
+}
+
 // MAVLinkMode represents a MAVLink mode
 type MAVLinkMode string
 
 const (
 	MAVLinkMode1To1  MAVLinkMode = "1:1"
 	MAVLinkModeMulti MAVLinkMode = "multi"
+	MAVLinkModeRoute MAVLinkMode = "route" // routing only, frames are not sent to sinks
 )
 
 var (
 	MAVLinkModeNames = map[MAVLinkMode]string{
 		MAVLinkMode1To1:  "1:1",
 		MAVLinkModeMulti: "multi",
+		MAVLinkModeRoute: "route",
 	}
 )
 
@@ -197,13 +500,21 @@ type FileConfig struct {
 // NATSConfig contains NATS JetStream sink configuration
 type NATSConfig struct {
 	URL                string        `yaml:"url"`
-	Subject            string        `yaml:"subject"`              // Template: "{entity_id}.mavlink" or static "mavlink.telemetry"
-	Token              string        `yaml:"token,omitempty"`      // JWT token for auth
-	CredsFile          string        `yaml:"creds_file,omitempty"` // Path to credentials file
+	Subject            string        `yaml:"subject"`                 // Template: "{entity_id}.mavlink" or static "mavlink.telemetry"
+	EventSubject       string        `yaml:"event_subject,omitempty"` // Template for event envelopes such as StatusText, defaults to subject
+	Token              string        `yaml:"token,omitempty"`         // JWT token for auth
+	CredsFile          string        `yaml:"creds_file,omitempty"`    // Path to credentials file
 	QueueSize          int           `yaml:"queue_size"`
 	BackpressurePolicy string        `yaml:"backpressure_policy"`
-	Stream             *StreamConfig `yaml:"stream,omitempty"` // JetStream configuration
-	KV                 *KVConfig     `yaml:"kv,omitempty"`     // KeyValue store configuration
+	Stream             *StreamConfig `yaml:"stream,omitempty"`   // JetStream configuration
+	KV                 *KVConfig     `yaml:"kv,omitempty"`       // KeyValue store configuration
+	Commands           *NATSCommands `yaml:"commands,omitempty"` // Command subscription configuration
+}
+
+// NATSCommands configures receiving vehicle commands over NATS
+type NATSCommands struct {
+	Subject      string `yaml:"subject"`                 // Pattern with an {entity_id} token, e.g. "constellation.commands.{entity_id}"
+	ReplySubject string `yaml:"reply_subject,omitempty"` // Results for requests without a reply inbox, e.g. "constellation.commands.{entity_id}.result"
 }
 
 // StreamConfig contains NATS JetStream stream configuration
@@ -252,29 +563,38 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("%w: %w", ErrFailedToParseConfigFile, err)
 	}
 
+	config.Path = path
+	config.Admin.Changes = &EndpointChanges{}
+	if config.Admin.StateFile != "" {
+		changes, err := LoadEndpointChanges(config.Admin.StateFile)
+		if err != nil {
+			return nil, err
+		}
+		config.Admin.Changes = changes
+		config.MAVLink.Endpoints = changes.Apply(config.MAVLink.Endpoints)
+	}
+
 	if len(config.MAVLink.Endpoints) == 0 {
 		return nil, ErrNoEndpoints
 	}
 
-	processedEndpoints := []MAVLinkEndpoint{}
-	for _, endpoint := range config.MAVLink.Endpoints {
-		if err := validateEndpoint(&endpoint); err != nil {
-			slog.Warn("invalid MAVLink endpoint", "name", endpoint.Name, "error", err.Error())
-			continue
+	for i := range config.MAVLink.Endpoints {
+		endpoint := &config.MAVLink.Endpoints[i]
+		if err := validateEndpoint(endpoint); err != nil {
+			endpoint.Error = err
 		}
-		processedEndpoints = append(processedEndpoints, endpoint)
 	}
-
-	if len(processedEndpoints) == 0 {
-		return nil, ErrNoValidEndpoints
+	if err := checkValidEndpoints(&config.MAVLink); err != nil {
+		return nil, err
 	}
 
-	config.MAVLink.Endpoints = processedEndpoints
-
 	// Set defaults
 	if config.Relay.BufferSize == 0 {
 		config.Relay.BufferSize = 1000
 	}
+	if config.Relay.LinkStatusInterval <= 0 {
+		config.Relay.LinkStatusInterval = 10 * time.Second
+	}
 	if config.MAVLink.DialectName == "" {
 		config.MAVLink.DialectName = "common"
 	}
@@ -284,6 +604,84 @@ func Load(path string) (*Config, error) {
 		return nil, fmt.Errorf("invalid MAVLink dialect %q: %w", config.MAVLink.DialectName, err)
 	}
 
+	if err := validateMessageTypes(&config.MAVLink); err != nil {
+		return nil, err
+	}
+
+	if err := validateFilters(&config.MAVLink); err != nil {
+		return nil, err
+	}
+
+	if err := validateDownsampling(&config.MAVLink); err != nil {
+		return nil, err
+	}
+
+	if err := validateRouting(&config.MAVLink); err != nil {
+		return nil, err
+	}
+
+	if err := validateStreams(&config.MAVLink); err != nil {
+		return nil, err
+	}
+
+	if err := validateIdentities(&config.MAVLink); err != nil {
+		return nil, err
+	}
+
+	if err := validateSigning(&config.MAVLink); err != nil {
+		return nil, err
+	}
+	if err := checkValidEndpoints(&config.MAVLink); err != nil {
+		return nil, err
+	}
+
+	if config.Commands.Retries == nil || *config.Commands.Retries < 0 {
+		retries := 3
+		config.Commands.Retries = &retries
+	}
+	if config.Commands.AckTimeout == 0 {
+		config.Commands.AckTimeout = time.Second
+	}
+	if config.Commands.Timeout == 0 {
+		config.Commands.Timeout = 10 * time.Second
+	}
+	if config.Missions.Retries == nil || *config.Missions.Retries < 0 {
+		retries := 5
+		config.Missions.Retries = &retries
+	}
+	if config.Missions.ItemTimeout == 0 {
+		config.Missions.ItemTimeout = 1500 * time.Millisecond
+	}
+	if config.Missions.Timeout == 0 {
+		config.Missions.Timeout = time.Minute
+	}
+	if config.Params.Retries == nil || *config.Params.Retries < 0 {
+		retries := 3
+		config.Params.Retries = &retries
+	}
+	if config.Params.ItemTimeout == 0 {
+		config.Params.ItemTimeout = time.Second
+	}
+	if config.Params.Timeout == 0 {
+		config.Params.Timeout = 2 * time.Minute
+	}
+	if config.Liveness.Enabled == nil {
+		enabled := true
+		config.Liveness.Enabled = &enabled
+	}
+	if config.Liveness.Timeout <= 0 {
+		config.Liveness.Timeout = 5 * time.Second
+	}
+	if err := validateRecorder(&config.Recorder); err != nil {
+		return nil, err
+	}
+	if err := validateCommands(&config); err != nil {
+		return nil, err
+	}
+	if err := validateAdmin(&config.Admin); err != nil {
+		return nil, err
+	}
+
 	if config.Logging.Level == "" {
 		config.Logging.Level = "info"
 	}
@@ -297,6 +695,67 @@ func Load(path string) (*Config, error) {
 	return &config, nil
 }
 
+// checkValidEndpoints fails when every endpoint was rejected, wrapping the
+// reasons they were
+func checkValidEndpoints(mavLink *MAVLinkConfig) error {
+	var errs []error
+	for _, endpoint := range mavLink.Endpoints {
+		if endpoint.Error == nil {
+			return nil
+		}
+		errs = append(errs, endpoint.Error)
+	}
+
+	return fmt.Errorf("%w: %w", ErrNoValidEndpoints, errors.Join(errs...))
+}
+
+// validateRecorder checks the .tlog recorder settings
+func validateRecorder(recorder *RecorderConfig) error {
+	if !recorder.Enabled {
+		return nil
+	}
+	if recorder.Path == "" {
+		return fmt.Errorf("%w: path is required", ErrInvalidRecorder)
+	}
+	if recorder.MaxBytes < 0 {
+		return fmt.Errorf("%w: max_bytes must not be negative", ErrInvalidRecorder)
+	}
+	if recorder.RotationInterval < 0 {
+		return fmt.Errorf("%w: rotation_interval must not be negative", ErrInvalidRecorder)
+	}
+
+	return nil
+}
+
+// validateCommands checks the command, mission and parameter APIs, which
+// share the commands bearer token. They can fly drones and change their
+// settings, so they are never served without one.
+func validateCommands(config *Config) error {
+	if config.Commands.Token != "" {
+		return nil
+	}
+	switch {
+	case config.Commands.Enabled:
+		return fmt.Errorf("%w: token is required", ErrInvalidCommands)
+	case config.Missions.Enabled:
+		return fmt.Errorf("%w: missions require commands.token", ErrInvalidCommands)
+	case config.Params.Enabled:
+		return fmt.Errorf("%w: params require commands.token", ErrInvalidCommands)
+	}
+
+	return nil
+}
+
+// validateAdmin checks the endpoint admin API settings. The API can open
+// ports, devices and files, so it is never served without a token.
+func validateAdmin(admin *AdminConfig) error {
+	if admin.Enabled && admin.Token == "" {
+		return fmt.Errorf("%w: token is required", ErrInvalidAdmin)
+	}
+
+	return nil
+}
+
 // resolveDialect returns the gomavlib dialect for the provided name.
 func validateMavLinkDialect(mavLink *MAVLinkConfig) error {
 	switch strings.ToLower(mavLink.DialectName) {
@@ -327,6 +786,491 @@ func validateMavLinkDialect(mavLink *MAVLinkConfig) error {
 	}
 }
 
+// validateMessageTypes resolves the forwarded message list against the dialect.
+// Entries may be MAVLink names ("GLOBAL_POSITION_INT"), CamelCase names
+// ("GlobalPositionInt") or numeric message IDs.
+func validateMessageTypes(mavLink *MAVLinkConfig) error {
+	ids, err := resolveMessageIDs(mavLink, mavLink.Messages)
+	if err != nil {
+		return err
+	}
+	mavLink.MessageIDs = ids
+
+	return nil
+}
+
+// validateFilters resolves the global and per-endpoint ingest filters against
+// the dialect
+func validateFilters(mavLink *MAVLinkConfig) error {
+	if err := resolveFilter(mavLink, &mavLink.Filter); err != nil {
+		return fmt.Errorf("mavlink filter: %w", err)
+	}
+	for i := range mavLink.Endpoints {
+		if mavLink.Endpoints[i].Error != nil {
+			continue
+		}
+		if err := resolveEndpointFilter(mavLink, &mavLink.Endpoints[i]); err != nil {
+			mavLink.Endpoints[i].Error = err
+		}
+	}
+
+	return nil
+}
+
+func resolveEndpointFilter(mavLink *MAVLinkConfig, endpoint *MAVLinkEndpoint) error {
+	filter := &endpoint.Filter
+	if len(filter.Include) == 0 {
+		filter.Include = mavLink.Filter.Include
+	}
+	if len(filter.Exclude) == 0 {
+		filter.Exclude = mavLink.Filter.Exclude
+	}
+	if err := resolveFilter(mavLink, filter); err != nil {
+		return fmt.Errorf("endpoint %s filter: %w", endpoint.Name, err)
+	}
+
+	return nil
+}
+
+func resolveFilter(mavLink *MAVLinkConfig, filter *MessageFilter) error {
+	include, err := resolveMessagePatterns(mavLink, filter.Include)
+	if err != nil {
+		return err
+	}
+	exclude, err := resolveMessagePatterns(mavLink, filter.Exclude)
+	if err != nil {
+		return err
+	}
+	filter.IncludeIDs = include
+	filter.ExcludeIDs = exclude
+
+	return nil
+}
+
+// resolveMessagePatterns resolves message names, IDs and wildcard patterns to
+// message IDs. Wildcards match the definition names, e.g. GLOBAL_POSITION_INT,
+// and must match at least one message.
+func resolveMessagePatterns(mavLink *MAVLinkConfig, patterns []string) ([]uint32, error) {
+	var ids []uint32
+	for _, pattern := range patterns {
+		pattern = strings.ToUpper(strings.TrimSpace(pattern))
+		if !strings.ContainsAny(pattern, "*?[") {
+			id, ok := lookupMessageID(mavLink.Dialect, pattern)
+			if !ok {
+				return nil, fmt.Errorf("%w: %s is not in dialect %s", ErrInvalidMessageType, pattern, mavLink.DialectName)
+			}
+			ids = append(ids, id)
+			continue
+		}
+
+		matched := false
+		for _, msg := range mavLink.Dialect.Messages {
+			ok, err := path.Match(pattern, telemetry.MessageDefName(msg))
+			if err != nil {
+				return nil, fmt.Errorf("%w: %s: %w", ErrInvalidMessageType, pattern, err)
+			}
+			if ok {
+				ids = append(ids, msg.GetID())
+				matched = true
+			}
+		}
+		if !matched {
+			return nil, fmt.Errorf("%w: %s matches no message in dialect %s", ErrInvalidMessageType, pattern, mavLink.DialectName)
+		}
+	}
+
+	return ids, nil
+}
+
+// validateDownsampling resolves the global and per-endpoint downsampling rules
+// against the dialect
+func validateDownsampling(mavLink *MAVLinkConfig) error {
+	rules, err := resolveDownsampleRules(mavLink, mavLink.Downsample)
+	if err != nil {
+		return fmt.Errorf("mavlink downsample: %w", err)
+	}
+	mavLink.DownsampleRules = rules
+
+	for i := range mavLink.Endpoints {
+		if mavLink.Endpoints[i].Error != nil {
+			continue
+		}
+		if err := resolveEndpointDownsampling(mavLink, &mavLink.Endpoints[i]); err != nil {
+			mavLink.Endpoints[i].Error = err
+		}
+	}
+
+	return nil
+}
+
+func resolveEndpointDownsampling(mavLink *MAVLinkConfig, endpoint *MAVLinkEndpoint) error {
+	rules, err := resolveDownsampleRules(mavLink, endpoint.Downsample)
+	if err != nil {
+		return fmt.Errorf("endpoint %s downsample: %w", endpoint.Name, err)
+	}
+	for id, rule := range mavLink.DownsampleRules {
+		if _, ok := rules[id]; !ok {
+			if rules == nil {
+				rules = make(map[uint32]DownsampleRule, len(mavLink.DownsampleRules))
+			}
+			rules[id] = rule
+		}
+	}
+	endpoint.DownsampleRules = rules
+
+	return nil
+}
+
+func resolveDownsampleRules(mavLink *MAVLinkConfig, rules map[string]DownsampleRule) (map[uint32]DownsampleRule, error) {
+	if len(rules) == 0 {
+		return nil, nil
+	}
+
+	resolved := make(map[uint32]DownsampleRule, len(rules))
+	for name, rule := range rules {
+		id, ok := lookupMessageID(mavLink.Dialect, name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not in dialect %s", ErrInvalidMessageType, name, mavLink.DialectName)
+		}
+		if err := resolveDownsampleRule(&rule); err != nil {
+			return nil, fmt.Errorf("%s: %w", name, err)
+		}
+		resolved[id] = rule
+	}
+
+	return resolved, nil
+}
+
+func resolveDownsampleRule(rule *DownsampleRule) error {
+	switch DownsampleStrategy(strings.ToLower(rule.StrategyName)) {
+	case "":
+		rule.Strategy = DownsampleLatest
+		if rule.Every > 0 {
+			rule.Strategy = DownsampleEveryNth
+		}
+	case DownsampleLatest:
+		rule.Strategy = DownsampleLatest
+	case DownsampleEveryNth:
+		rule.Strategy = DownsampleEveryNth
+	case DownsampleAggregate:
+		rule.Strategy = DownsampleAggregate
+	default:
+		return fmt.Errorf("%w: unknown strategy %s", ErrInvalidDownsample, rule.StrategyName)
+	}
+
+	if rule.Strategy == DownsampleEveryNth {
+		if rule.Every < 1 {
+			return fmt.Errorf("%w: every_nth requires every to be at least 1", ErrInvalidDownsample)
+		}
+		return nil
+	}
+	if rule.Interval <= 0 {
+		return fmt.Errorf("%w: %s requires a positive interval", ErrInvalidDownsample, rule.Strategy)
+	}
+
+	return nil
+}
+
+// validateRouting resolves per-endpoint routing rules against the dialect.
+// Route mode endpoints are only useful when routing is enabled.
+func validateRouting(mavLink *MAVLinkConfig) error {
+	for i := range mavLink.Endpoints {
+		if mavLink.Endpoints[i].Error != nil {
+			continue
+		}
+		if err := resolveEndpointRouting(mavLink, &mavLink.Endpoints[i]); err != nil {
+			mavLink.Endpoints[i].Error = err
+		}
+	}
+
+	return nil
+}
+
+func resolveEndpointRouting(mavLink *MAVLinkConfig, endpoint *MAVLinkEndpoint) error {
+	if endpoint.Mode == MAVLinkModeRoute && !mavLink.Routing.Enabled {
+		return fmt.Errorf("%w: endpoint %s uses route mode but routing is not enabled", ErrInvalidMode, endpoint.Name)
+	}
+
+	allow, err := resolveMessageIDs(mavLink, endpoint.Routing.AllowMessages)
+	if err != nil {
+		return fmt.Errorf("endpoint %s routing: %w", endpoint.Name, err)
+	}
+	deny, err := resolveMessageIDs(mavLink, endpoint.Routing.DenyMessages)
+	if err != nil {
+		return fmt.Errorf("endpoint %s routing: %w", endpoint.Name, err)
+	}
+	endpoint.Routing.AllowMessageIDs = allow
+	endpoint.Routing.DenyMessageIDs = deny
+
+	return nil
+}
+
+// validateStreams resolves per-endpoint stream rates against the dialect
+func validateStreams(mavLink *MAVLinkConfig) error {
+	for i := range mavLink.Endpoints {
+		if mavLink.Endpoints[i].Error != nil {
+			continue
+		}
+		if err := resolveEndpointStreams(mavLink, &mavLink.Endpoints[i]); err != nil {
+			mavLink.Endpoints[i].Error = err
+		}
+	}
+
+	return nil
+}
+
+func resolveEndpointStreams(mavLink *MAVLinkConfig, endpoint *MAVLinkEndpoint) error {
+	if len(endpoint.Streams) == 0 {
+		return nil
+	}
+
+	endpoint.StreamRates = make(map[uint32]float64, len(endpoint.Streams))
+	for name, rate := range endpoint.Streams {
+		id, ok := lookupMessageID(mavLink.Dialect, name)
+		if !ok {
+			return fmt.Errorf("endpoint %s streams: %w: %s is not in dialect %s", endpoint.Name, ErrInvalidMessageType, name, mavLink.DialectName)
+		}
+		if rate < 0 {
+			return fmt.Errorf("endpoint %s streams: %w: %s rate %v", endpoint.Name, ErrInvalidStreamRate, name, rate)
+		}
+		endpoint.StreamRates[id] = rate
+	}
+
+	return nil
+}
+
+// validateIdentities applies the identity defaults to the global identity and
+// resolves each endpoint's identity by inheriting the fields it leaves unset
+func validateIdentities(mavLink *MAVLinkConfig) error {
+	global := &mavLink.Identity
+	if global.SystemID == 0 {
+		global.SystemID = 255
+	}
+	if global.ComponentID == 0 {
+		global.ComponentID = 190
+	}
+	if global.Version == 0 {
+		global.Version = 2
+	}
+	if global.Heartbeat.Enabled == nil {
+		enabled := true
+		global.Heartbeat.Enabled = &enabled
+	}
+	if global.Heartbeat.Rate == 0 {
+		global.Heartbeat.Rate = 1
+	}
+	if global.Heartbeat.TypeName == "" {
+		global.Heartbeat.TypeName = "MAV_TYPE_GCS"
+	}
+	if err := validateIdentity(global); err != nil {
+		return fmt.Errorf("identity: %w", err)
+	}
+
+	for i := range mavLink.Endpoints {
+		if mavLink.Endpoints[i].Error != nil {
+			continue
+		}
+		if err := resolveEndpointIdentity(global, &mavLink.Endpoints[i]); err != nil {
+			mavLink.Endpoints[i].Error = err
+		}
+	}
+
+	return nil
+}
+
+// resolveEndpointIdentity inherits the unset identity fields of an endpoint
+// from the global identity
+func resolveEndpointIdentity(global *IdentityConfig, endpoint *MAVLinkEndpoint) error {
+	identity := &endpoint.Identity
+	if identity.SystemID == 0 {
+		identity.SystemID = global.SystemID
+	}
+	if identity.ComponentID == 0 {
+		identity.ComponentID = global.ComponentID
+	}
+	if identity.Version == 0 {
+		identity.Version = global.Version
+	}
+	if identity.Heartbeat.Enabled == nil {
+		identity.Heartbeat.Enabled = global.Heartbeat.Enabled
+	}
+	if identity.Heartbeat.Rate == 0 {
+		identity.Heartbeat.Rate = global.Heartbeat.Rate
+	}
+	if identity.Heartbeat.TypeName == "" {
+		identity.Heartbeat.TypeName = global.Heartbeat.TypeName
+	}
+	if err := validateIdentity(identity); err != nil {
+		return fmt.Errorf("endpoint %s identity: %w", endpoint.Name, err)
+	}
+
+	return nil
+}
+
+// validateIdentity checks a resolved identity and resolves its heartbeat type
+func validateIdentity(identity *IdentityConfig) error {
+	if identity.Version != 1 && identity.Version != 2 {
+		return fmt.Errorf("%w: version must be 1 or 2, got %d", ErrInvalidIdentity, identity.Version)
+	}
+	if identity.Heartbeat.Rate < 0 {
+		return fmt.Errorf("%w: heartbeat rate %v", ErrInvalidIdentity, identity.Heartbeat.Rate)
+	}
+
+	name := strings.ToUpper(identity.Heartbeat.TypeName)
+	if !strings.HasPrefix(name, "MAV_TYPE_") {
+		name = "MAV_TYPE_" + name
+	}
+	var mavType common.MAV_TYPE
+	if err := mavType.UnmarshalText([]byte(name)); err != nil {
+		return fmt.Errorf("%w: unknown heartbeat type %s", ErrInvalidIdentity, identity.Heartbeat.TypeName)
+	}
+	identity.Heartbeat.Type = mavType
+
+	return nil
+}
+
+// validateSigning resolves the verify mode and loads the secret key of every
+// endpoint that signs or verifies frames. Signing needs MAVLink 2.
+func validateSigning(mavLink *MAVLinkConfig) error {
+	for i := range mavLink.Endpoints {
+		if mavLink.Endpoints[i].Error != nil {
+			continue
+		}
+		if err := resolveEndpointSigning(&mavLink.Endpoints[i]); err != nil {
+			mavLink.Endpoints[i].Error = err
+		}
+	}
+
+	return nil
+}
+
+func resolveEndpointSigning(endpoint *MAVLinkEndpoint) error {
+	signing := &endpoint.Signing
+	switch SigningVerifyMode(strings.ToLower(signing.VerifyName)) {
+	case "", SigningVerifyOff:
+		signing.Verify = SigningVerifyOff
+	case SigningVerifyPermissive:
+		signing.Verify = SigningVerifyPermissive
+	case SigningVerifyStrict:
+		signing.Verify = SigningVerifyStrict
+	default:
+		return fmt.Errorf("endpoint %s signing: %w: unknown verify mode %s", endpoint.Name, ErrInvalidSigning, signing.VerifyName)
+	}
+
+	if !signing.Sign && signing.Verify == SigningVerifyOff {
+		return nil
+	}
+	if endpoint.Identity.Version != 2 {
+		return fmt.Errorf("endpoint %s signing: %w: requires MAVLink version 2", endpoint.Name, ErrInvalidSigning)
+	}
+
+	key, err := loadSigningKey(signing)
+	if err != nil {
+		return fmt.Errorf("endpoint %s signing: %w", endpoint.Name, err)
+	}
+	signing.Key = key
+
+	return nil
+}
+
+// loadSigningKey reads the secret key from exactly one of key_file and key_env
+func loadSigningKey(signing *SigningConfig) (*frame.V2Key, error) {
+	var secret string
+	switch {
+	case signing.KeyFile != "" && signing.KeyEnv != "":
+		return nil, fmt.Errorf("%w: set only one of key_file and key_env", ErrInvalidSigning)
+	case signing.KeyFile != "":
+		data, err := os.ReadFile(signing.KeyFile)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidSigning, err)
+		}
+		secret = string(data)
+	case signing.KeyEnv != "":
+		secret = os.Getenv(signing.KeyEnv)
+	default:
+		return nil, fmt.Errorf("%w: key_file or key_env is required", ErrInvalidSigning)
+	}
+
+	secret = strings.TrimSpace(secret)
+	if secret == "" {
+		return nil, fmt.Errorf("%w: signing key is empty", ErrInvalidSigning)
+	}
+	key := parseSigningKey(secret)
+	return &key, nil
+}
+
+// parseSigningKey turns a secret into a signing key. 64 hex characters are
+// used as the key itself; anything else is a passphrase hashed with SHA-256.
+func parseSigningKey(secret string) frame.V2Key {
+	var key frame.V2Key
+	if raw, err := hex.DecodeString(secret); err == nil && len(raw) == len(key) {
+		copy(key[:], raw)
+		return key
+	}
+	return sha256.Sum256([]byte(secret))
+}
+
+func resolveMessageIDs(mavLink *MAVLinkConfig, names []string) ([]uint32, error) {
+	var ids []uint32
+	for _, name := range names {
+		id, ok := lookupMessageID(mavLink.Dialect, name)
+		if !ok {
+			return nil, fmt.Errorf("%w: %s is not in dialect %s", ErrInvalidMessageType, name, mavLink.DialectName)
+		}
+		ids = append(ids, id)
+	}
+
+	return ids, nil
+}
+
+func lookupMessageID(d *dialect.Dialect, name string) (uint32, bool) {
+	name = strings.TrimSpace(name)
+	if id, err := strconv.ParseUint(name, 10, 32); err == nil {
+		for _, msg := range d.Messages {
+			if msg.GetID() == uint32(id) {
+				return uint32(id), true
+			}
+		}
+		return 0, false
+	}
+
+	for _, msg := range d.Messages {
+		if strings.EqualFold(name, telemetry.MessageDefName(msg)) || strings.EqualFold(name, telemetry.MessageName(msg)) {
+			return msg.GetID(), true
+		}
+	}
+
+	return 0, false
+}
+
+// ResolveEndpoint validates an endpoint added while the relay runs and
+// resolves it against the loaded MAVLink settings, as Load does for the
+// configured endpoints
+func (m *MAVLinkConfig) ResolveEndpoint(endpoint *MAVLinkEndpoint) error {
+	if endpoint.Name == "" {
+		return fmt.Errorf("%w: name is required", ErrInvalidName)
+	}
+	if err := validateEndpoint(endpoint); err != nil {
+		return err
+	}
+	if err := resolveEndpointFilter(m, endpoint); err != nil {
+		return err
+	}
+	if err := resolveEndpointDownsampling(m, endpoint); err != nil {
+		return err
+	}
+	if err := resolveEndpointRouting(m, endpoint); err != nil {
+		return err
+	}
+	if err := resolveEndpointStreams(m, endpoint); err != nil {
+		return err
+	}
+	if err := resolveEndpointIdentity(&m.Identity, endpoint); err != nil {
+		return err
+	}
+	return resolveEndpointSigning(endpoint)
+}
+
 func validateEndpoint(endpoint *MAVLinkEndpoint) error {
 	if err := validateEndpointMode(endpoint); err != nil {
 		return err
@@ -336,6 +1280,164 @@ func validateEndpoint(endpoint *MAVLinkEndpoint) error {
 		return err
 	}
 
+	if err := validateEndpointAddress(endpoint); err != nil {
+		return err
+	}
+
+	if err := validateSerialEndpoint(endpoint); err != nil {
+		return err
+	}
+
+	if err := validateReplayEndpoint(endpoint); err != nil {
+		return err
+	}
+
+	if err := validateSimEndpoint(endpoint); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func validateSerialEndpoint(endpoint *MAVLinkEndpoint) error {
+	if endpoint.Protocol != MAVLinkEndpointProtocolSerial {
+		return nil
+	}
+
+	if endpoint.BaudRate < 0 {
+		return fmt.Errorf("%w: %d", ErrInvalidBaudRate, endpoint.BaudRate)
+	}
+	if endpoint.BaudRate == 0 {
+		endpoint.BaudRate = 57600 // telemetry radio default
+	}
+
+	return nil
+}
+
+func validateReplayEndpoint(endpoint *MAVLinkEndpoint) error {
+	if endpoint.Protocol != MAVLinkEndpointProtocolReplay {
+		return nil
+	}
+
+	replay := &endpoint.Replay
+	if replay.File == "" {
+		return fmt.Errorf("%w: file is required", ErrInvalidReplay)
+	}
+	if replay.StartOffset < 0 {
+		return fmt.Errorf("%w: start_offset must not be negative", ErrInvalidReplay)
+	}
+
+	switch strings.ToLower(strings.TrimSpace(replay.SpeedName)) {
+	case "":
+		replay.Speed = 1
+	case "max":
+		replay.Speed = 0
+	default:
+		speed, err := strconv.ParseFloat(strings.TrimSuffix(replay.SpeedName, "x"), 64)
+		if err != nil || speed <= 0 {
+			return fmt.Errorf("%w: speed must be a positive factor or max, got %q", ErrInvalidReplay, replay.SpeedName)
+		}
+		replay.Speed = speed
+	}
+
+	return nil
+}
+
+func validateSimEndpoint(endpoint *MAVLinkEndpoint) error {
+	if endpoint.Protocol != MAVLinkEndpointProtocolSim {
+		return nil
+	}
+
+	sim := &endpoint.Sim
+	if sim.Vehicles == 0 {
+		sim.Vehicles = 1
+	}
+	if sim.Vehicles < 0 || sim.Vehicles > 254 {
+		return fmt.Errorf("%w: vehicles must be between 1 and 254, got %d", ErrInvalidSim, sim.Vehicles)
+	}
+	if sim.Vehicles > 1 && endpoint.Mode == MAVLinkMode1To1 {
+		return fmt.Errorf("%w: %d vehicles need multi mode", ErrInvalidSim, sim.Vehicles)
+	}
+
+	sim.Autopilot = strings.ToLower(strings.TrimSpace(sim.Autopilot))
+	switch sim.Autopilot {
+	case "":
+		sim.Autopilot = "ardupilot"
+	case "ardupilot", "px4":
+	default:
+		return fmt.Errorf("%w: autopilot must be ardupilot or px4, got %q", ErrInvalidSim, sim.Autopilot)
+	}
+
+	if sim.Speed < 0 || sim.BatteryDrain < 0 {
+		return fmt.Errorf("%w: speed and battery_drain must not be negative", ErrInvalidSim)
+	}
+	if sim.Speed == 0 {
+		sim.Speed = 10
+	}
+	if sim.BatteryDrain == 0 {
+		sim.BatteryDrain = 2
+	}
+
+	if sim.Orbit.Latitude == 0 && sim.Orbit.Longitude == 0 {
+		sim.Orbit.Latitude, sim.Orbit.Longitude = 47.397742, 8.545594
+	}
+	if sim.Orbit.Radius < 0 || sim.Orbit.Altitude < 0 {
+		return fmt.Errorf("%w: orbit radius and altitude must not be negative", ErrInvalidSim)
+	}
+	if sim.Orbit.Radius == 0 {
+		sim.Orbit.Radius = 100
+	}
+	if sim.Orbit.Altitude == 0 {
+		sim.Orbit.Altitude = 50
+	}
+
+	if len(sim.Waypoints) == 1 {
+		return fmt.Errorf("%w: a path needs at least 2 waypoints", ErrInvalidSim)
+	}
+	for _, waypoint := range sim.Waypoints {
+		if math.Abs(waypoint.Latitude) > 90 || math.Abs(waypoint.Longitude) > 180 || waypoint.Altitude < 0 {
+			return fmt.Errorf("%w: waypoint %v, %v at %v m is out of range", ErrInvalidSim, waypoint.Latitude, waypoint.Longitude, waypoint.Altitude)
+		}
+	}
+
+	sim.MessageRates = maps.Clone(SimMessageRates)
+	for name, rate := range sim.Rates {
+		key := strings.ToUpper(strings.TrimSpace(name))
+		if _, ok := SimMessageRates[key]; !ok {
+			return fmt.Errorf("%w: cannot generate %s", ErrInvalidSim, name)
+		}
+		if rate < 0 {
+			return fmt.Errorf("%w: %s rate %v", ErrInvalidSim, name, rate)
+		}
+		sim.MessageRates[key] = rate
+	}
+
+	return nil
+}
+
+func validateEndpointAddress(endpoint *MAVLinkEndpoint) error {
+	switch endpoint.Protocol {
+	case MAVLinkEndpointProtocolUDP, MAVLinkEndpointProtocolTCP:
+		// servers may omit the address and bind to all interfaces
+	case MAVLinkEndpointProtocolUDPClient, MAVLinkEndpointProtocolTCPClient, MAVLinkEndpointProtocolUDPBroadcast:
+		if endpoint.Address == "" {
+			return fmt.Errorf("%w: address is required for %s endpoints", ErrInvalidAddress, endpoint.Protocol)
+		}
+	default:
+		return nil
+	}
+
+	if endpoint.LocalAddress != "" && !hasPort(endpoint.LocalAddress) {
+		return fmt.Errorf("%w: local_address %q must be host:port", ErrInvalidAddress, endpoint.LocalAddress)
+	}
+
+	if hasPort(endpoint.Address) {
+		return nil
+	}
+	if endpoint.Port <= 0 || endpoint.Port > 65535 {
+		return fmt.Errorf("%w: %d", ErrInvalidPort, endpoint.Port)
+	}
+
 	return nil
 }
 
@@ -349,12 +1451,42 @@ func validateEndpointMode(endpoint *MAVLinkEndpoint) error {
 		return nil
 	case "multi":
 		endpoint.Mode = MAVLinkModeMulti
-		return ErrMultiModeNotSupported
+		return validateSystemMappings(endpoint)
+	case "route":
+		endpoint.Mode = MAVLinkModeRoute
+		if endpoint.Routing.Disabled {
+			return fmt.Errorf("%w: route mode endpoints cannot disable routing", ErrInvalidMode)
+		}
+		return nil
 	default:
 		return fmt.Errorf("%w: %s", ErrInvalidMode, endpoint.ModeName)
 	}
 }
 
+func validateSystemMappings(endpoint *MAVLinkEndpoint) error {
+	if len(endpoint.Systems) == 0 && endpoint.DroneIDTemplate == "" {
+		return ErrMultiModeRequiresMapping
+	}
+
+	type systemKey struct{ systemID, componentID uint8 }
+	seen := make(map[systemKey]bool, len(endpoint.Systems))
+	for _, system := range endpoint.Systems {
+		if system.SystemID == 0 {
+			return fmt.Errorf("%w: system_id must be between 1 and 255", ErrInvalidSystemMapping)
+		}
+		if system.DroneID == "" {
+			return fmt.Errorf("%w: system %d has no drone_id", ErrInvalidSystemMapping, system.SystemID)
+		}
+		key := systemKey{system.SystemID, system.ComponentID}
+		if seen[key] {
+			return fmt.Errorf("%w: duplicate mapping for system %d component %d", ErrInvalidSystemMapping, system.SystemID, system.ComponentID)
+		}
+		seen[key] = true
+	}
+
+	return nil
+}
+
 func validateEndPointProtocol(endPoint *MAVLinkEndpoint) error {
 	switch endPoint.ProtocolName {
 	case "udp":
@@ -363,9 +1495,24 @@ func validateEndPointProtocol(endPoint *MAVLinkEndpoint) error {
 	case "tcp":
 		endPoint.Protocol = MAVLinkEndpointProtocolTCP
 		return nil
+	case "udp_client":
+		endPoint.Protocol = MAVLinkEndpointProtocolUDPClient
+		return nil
+	case "tcp_client":
+		endPoint.Protocol = MAVLinkEndpointProtocolTCPClient
+		return nil
+	case "udp_broadcast":
+		endPoint.Protocol = MAVLinkEndpointProtocolUDPBroadcast
+		return nil
 	case "serial":
 		endPoint.Protocol = MAVLinkEndpointProtocolSerial
 		return nil
+	case "replay":
+		endPoint.Protocol = MAVLinkEndpointProtocolReplay
+		return nil
+	case "sim":
+		endPoint.Protocol = MAVLinkEndpointProtocolSim
+		return nil
 	default:
 		return fmt.Errorf("%w: %s", ErrInvalidProtocol, endPoint.ProtocolName)
 	}
@@ -1,9 +1,18 @@
 package config
 
 import (
+	"crypto/sha256"
+	"errors"
 	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
 	"testing"
 	"time"
+
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v2/pkg/frame"
 )
 
 // TestConfigLoad tests loading configuration from YAML
@@ -234,6 +243,95 @@ sinks:
 	if cfg.Logging.Output != "stdout" {
 		t.Errorf("Expected default log output 'stdout', got '%s'", cfg.Logging.Output)
 	}
+
+	if cfg.Commands.Enabled {
+		t.Error("Expected command API to be disabled by default")
+	}
+
+	if *cfg.Commands.Retries != 3 || cfg.Commands.AckTimeout != time.Second || cfg.Commands.Timeout != 10*time.Second {
+		t.Errorf("Unexpected command defaults: %+v", cfg.Commands)
+	}
+
+	if *cfg.Missions.Retries != 5 || cfg.Missions.ItemTimeout != 1500*time.Millisecond || cfg.Missions.Timeout != time.Minute {
+		t.Errorf("Unexpected mission defaults: %+v", cfg.Missions)
+	}
+
+	if cfg.Params.Enabled || *cfg.Params.Retries != 3 || cfg.Params.ItemTimeout != time.Second || cfg.Params.Timeout != 2*time.Minute {
+		t.Errorf("Unexpected parameter defaults: %+v", cfg.Params)
+	}
+
+	if cfg.Relay.LinkStatusInterval != 10*time.Second {
+		t.Errorf("Expected link status interval 10s, got %v", cfg.Relay.LinkStatusInterval)
+	}
+
+	if !*cfg.Liveness.Enabled || cfg.Liveness.Timeout != 5*time.Second {
+		t.Errorf("Expected liveness enabled with timeout 5s, got %+v", cfg.Liveness)
+	}
+
+	cfg = loadTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+liveness:
+  enabled: false
+`)
+	if *cfg.Liveness.Enabled {
+		t.Error("Expected liveness to be disabled")
+	}
+
+	// zero retries are kept
+	cfg = loadTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+commands:
+  retries: 0
+missions:
+  retries: 0
+params:
+  retries: 0
+`)
+	if *cfg.Commands.Retries != 0 {
+		t.Errorf("Expected no command retries, got %d", *cfg.Commands.Retries)
+	}
+	if *cfg.Missions.Retries != 0 {
+		t.Errorf("Expected no mission retries, got %d", *cfg.Missions.Retries)
+	}
+	if *cfg.Params.Retries != 0 {
+		t.Errorf("Expected no parameter retries, got %d", *cfg.Params.Retries)
+	}
+
+	// the command, mission and parameter APIs are never served without a token
+	vehicle := `
+mavlink:
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+`
+	for _, api := range []string{
+		"commands:\n  enabled: true\n",
+		"missions:\n  enabled: true\n",
+		"params:\n  enabled: true\n",
+	} {
+		if _, err := Load(writeTestConfig(t, vehicle+api)); !errors.Is(err, ErrInvalidCommands) {
+			t.Errorf("Expected %v for %q, got %v", ErrInvalidCommands, api, err)
+		}
+	}
+	cfg = loadTestConfig(t, vehicle+"commands:\n  enabled: true\n  token: secret\nmissions:\n  enabled: true\nparams:\n  enabled: true\n")
+	if cfg.Commands.Token != "secret" {
+		t.Errorf("Expected the command token to load, got %q", cfg.Commands.Token)
+	}
 }
 
 // TestConfigValidation tests configuration validation
@@ -329,6 +427,17 @@ mavlink:
       protocol: "serial"
       mode: "1:1"
       baud_rate: 57600
+    - name: "tcp-client-endpoint"
+      drone_id: "tcp-client-endpoint"
+      protocol: "tcp_client"
+      mode: "1:1"
+      address: "127.0.0.1:5760"
+    - name: "udp-broadcast-endpoint"
+      drone_id: "udp-broadcast-endpoint"
+      protocol: "udp_broadcast"
+      mode: "1:1"
+      address: "192.168.1.255"
+      port: 14550
 
 sinks:
   file:
@@ -387,6 +496,104 @@ sinks:
 	if serialEndpoint.BaudRate != 57600 {
 		t.Errorf("Expected baud rate 57600, got %d", serialEndpoint.BaudRate)
 	}
+
+	// Test TCP client endpoint
+	tcpClientEndpoint := cfg.MAVLink.Endpoints[3]
+	if tcpClientEndpoint.Protocol != MAVLinkEndpointProtocolTCPClient {
+		t.Errorf("Expected TCP client protocol, got %s", tcpClientEndpoint.Protocol)
+	}
+	if tcpClientEndpoint.NetworkAddress() != "127.0.0.1:5760" {
+		t.Errorf("Expected address 127.0.0.1:5760, got %s", tcpClientEndpoint.NetworkAddress())
+	}
+
+	// Test UDP broadcast endpoint
+	broadcastEndpoint := cfg.MAVLink.Endpoints[4]
+	if broadcastEndpoint.Protocol != MAVLinkEndpointProtocolUDPBroadcast {
+		t.Errorf("Expected UDP broadcast protocol, got %s", broadcastEndpoint.Protocol)
+	}
+	if broadcastEndpoint.NetworkAddress() != "192.168.1.255:14550" {
+		t.Errorf("Expected address 192.168.1.255:14550, got %s", broadcastEndpoint.NetworkAddress())
+	}
+}
+
+// TestValidateEndpointAddress tests address and port validation for network endpoints
+func TestValidateEndpointAddress(t *testing.T) {
+	testCases := []struct {
+		name     string
+		endpoint MAVLinkEndpoint
+		wantErr  error
+	}{
+		{"udp server default bind", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolUDP, Port: 14550}, nil},
+		{"tcp server bind host", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolTCP, Address: "127.0.0.1", Port: 5760}, nil},
+		{"udp server missing port", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolUDP}, ErrInvalidPort},
+		{"tcp client inline port", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolTCPClient, Address: "127.0.0.1:5760"}, nil},
+		{"udp client separate port", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolUDPClient, Address: "10.0.0.5", Port: 14550}, nil},
+		{"tcp client missing address", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolTCPClient, Port: 5760}, ErrInvalidAddress},
+		{"udp client port out of range", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolUDPClient, Address: "10.0.0.5", Port: 70000}, ErrInvalidPort},
+		{"broadcast", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolUDPBroadcast, Address: "192.168.1.255:14550"}, nil},
+		{"broadcast bad local address", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolUDPBroadcast, Address: "192.168.1.255:14550", LocalAddress: "192.168.1.10"}, ErrInvalidAddress},
+		{"serial ignored", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolSerial}, nil},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateEndpointAddress(&tc.endpoint)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestSerialEndpoint tests serial device resolution and baud rate defaults
+func TestSerialEndpoint(t *testing.T) {
+	testCases := []struct {
+		name       string
+		endpoint   MAVLinkEndpoint
+		wantDevice string
+		wantBaud   int
+		wantErr    error
+	}{
+		{"explicit device", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolSerial, Device: "/dev/ttyACM0", BaudRate: 115200}, "/dev/ttyACM0", 115200, nil},
+		{"by-id symlink", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolSerial, Device: "/dev/serial/by-id/usb-radio-if00", BaudRate: 57600}, "/dev/serial/by-id/usb-radio-if00", 57600, nil},
+		{"legacy port number", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolSerial, Port: 1, BaudRate: 57600}, "/dev/ttyUSB1", 57600, nil},
+		{"default baud rate", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolSerial, Device: "/dev/ttyACM0"}, "/dev/ttyACM0", 57600, nil},
+		{"negative baud rate", MAVLinkEndpoint{Protocol: MAVLinkEndpointProtocolSerial, Device: "/dev/ttyACM0", BaudRate: -1}, "/dev/ttyACM0", -1, ErrInvalidBaudRate},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateSerialEndpoint(&tc.endpoint)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Expected error %v, got %v", tc.wantErr, err)
+			}
+			if got := tc.endpoint.SerialDevice(); got != tc.wantDevice {
+				t.Errorf("Expected device %s, got %s", tc.wantDevice, got)
+			}
+			if tc.endpoint.BaudRate != tc.wantBaud {
+				t.Errorf("Expected baud rate %d, got %d", tc.wantBaud, tc.endpoint.BaudRate)
+			}
+		})
+	}
+}
+
+// TestNetworkAddress tests resolving the host:port of a network endpoint
+func TestNetworkAddress(t *testing.T) {
+	testCases := []struct {
+		endpoint MAVLinkEndpoint
+		want     string
+	}{
+		{MAVLinkEndpoint{Port: 14550}, "0.0.0.0:14550"},
+		{MAVLinkEndpoint{Address: "192.168.1.10", Port: 14550}, "192.168.1.10:14550"},
+		{MAVLinkEndpoint{Address: "127.0.0.1:5760", Port: 14550}, "127.0.0.1:5760"},
+		{MAVLinkEndpoint{Address: "::1", Port: 5760}, "[::1]:5760"},
+	}
+
+	for _, tc := range testCases {
+		if got := tc.endpoint.NetworkAddress(); got != tc.want {
+			t.Errorf("Expected address %s, got %s", tc.want, got)
+		}
+	}
 }
 
 // TestConfigDialects tests all supported MAVLink dialects
@@ -481,3 +688,910 @@ sinks:
 		t.Fatal("Expected error for invalid dialect")
 	}
 }
+
+// TestConfigMultiMode tests multi mode endpoints with system ID mappings
+func TestConfigMultiMode(t *testing.T) {
+	configContent := `
+mavlink:
+  endpoints:
+    - name: "swarm"
+      protocol: "udp"
+      mode: "multi"
+      port: 14550
+      drone_id_template: "{endpoint}-{system_id}"
+      systems:
+        - system_id: 1
+          drone_id: "drone-alpha"
+        - system_id: 2
+          component_id: 154
+          drone_id: "drone-bravo-gimbal"
+    - name: "unmapped"
+      protocol: "udp"
+      mode: "multi"
+      port: 14551
+    - name: "bad-system"
+      protocol: "udp"
+      mode: "multi"
+      port: 14552
+      systems:
+        - system_id: 0
+          drone_id: "drone-zero"
+
+sinks:
+  file:
+    path: "/tmp/test"
+    format: "json"
+`
+	tmpFile, err := os.CreateTemp("", "test-config-multi-*.yaml")
+	if err != nil {
+		t.Fatalf("Failed to create temp file: %v", err)
+	}
+	defer os.Remove(tmpFile.Name())
+
+	if _, err := tmpFile.WriteString(configContent); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	tmpFile.Close()
+
+	cfg, err := Load(tmpFile.Name())
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+
+	// Endpoints without mappings or with invalid mappings are kept with the
+	// reason they are invalid, so the relay can report them as failed
+	if len(cfg.MAVLink.Endpoints) != 3 {
+		t.Fatalf("Expected 3 endpoints, got %d", len(cfg.MAVLink.Endpoints))
+	}
+	if err := cfg.MAVLink.Endpoints[1].Error; !errors.Is(err, ErrMultiModeRequiresMapping) {
+		t.Errorf("Expected %v for the unmapped endpoint, got %v", ErrMultiModeRequiresMapping, err)
+	}
+	if err := cfg.MAVLink.Endpoints[2].Error; !errors.Is(err, ErrInvalidSystemMapping) {
+		t.Errorf("Expected %v for the bad mapping, got %v", ErrInvalidSystemMapping, err)
+	}
+
+	endpoint := cfg.MAVLink.Endpoints[0]
+	if endpoint.Error != nil {
+		t.Fatalf("Expected the mapped endpoint to be valid, got %v", endpoint.Error)
+	}
+	if endpoint.Mode != MAVLinkModeMulti {
+		t.Errorf("Expected mode '%s', got '%s'", MAVLinkModeMulti, endpoint.Mode)
+	}
+	if endpoint.DroneIDTemplate != "{endpoint}-{system_id}" {
+		t.Errorf("Expected drone ID template '{endpoint}-{system_id}', got '%s'", endpoint.DroneIDTemplate)
+	}
+	if len(endpoint.Systems) != 2 {
+		t.Fatalf("Expected 2 system mappings, got %d", len(endpoint.Systems))
+	}
+	if endpoint.Systems[1].SystemID != 2 || endpoint.Systems[1].ComponentID != 154 || endpoint.Systems[1].DroneID != "drone-bravo-gimbal" {
+		t.Errorf("Unexpected second system mapping: %+v", endpoint.Systems[1])
+	}
+}
+
+// TestConfigEndpointResolveErrors tests that endpoints failing to resolve
+// against the dialect are kept with the reason and do not fail the load
+func TestConfigEndpointResolveErrors(t *testing.T) {
+	cfg := loadTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+    - name: "filtered"
+      drone_id: "drone-bravo"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14551
+      include: ["NOT_A_MESSAGE"]
+    - name: "streams"
+      drone_id: "drone-charlie"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14552
+      streams:
+        ATTITUDE: -1
+    - name: "signed"
+      drone_id: "drone-delta"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14553
+      signing:
+        sign: true
+`)
+	if err := cfg.MAVLink.Endpoints[0].Error; err != nil {
+		t.Errorf("Expected the valid endpoint to load, got %v", err)
+	}
+	if err := cfg.MAVLink.Endpoints[1].Error; !errors.Is(err, ErrInvalidMessageType) {
+		t.Errorf("Expected %v for the filter, got %v", ErrInvalidMessageType, err)
+	}
+	if err := cfg.MAVLink.Endpoints[2].Error; !errors.Is(err, ErrInvalidStreamRate) {
+		t.Errorf("Expected %v for the streams, got %v", ErrInvalidStreamRate, err)
+	}
+	if err := cfg.MAVLink.Endpoints[3].Error; !errors.Is(err, ErrInvalidSigning) {
+		t.Errorf("Expected %v for the signing, got %v", ErrInvalidSigning, err)
+	}
+
+	// the reasons are kept when no endpoint is left
+	_, err := Load(writeTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "filtered"
+      drone_id: "drone-bravo"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14551
+      include: ["NOT_A_MESSAGE"]
+`))
+	if !errors.Is(err, ErrNoValidEndpoints) || !errors.Is(err, ErrInvalidMessageType) {
+		t.Errorf("Expected %v wrapping %v, got %v", ErrNoValidEndpoints, ErrInvalidMessageType, err)
+	}
+}
+
+// TestValidateSystemMappings tests multi mode mapping validation errors
+func TestValidateSystemMappings(t *testing.T) {
+	testCases := []struct {
+		name     string
+		endpoint MAVLinkEndpoint
+		wantErr  error
+	}{
+		{"template only", MAVLinkEndpoint{DroneIDTemplate: "{endpoint}-{system_id}"}, nil},
+		{"no mapping", MAVLinkEndpoint{}, ErrMultiModeRequiresMapping},
+		{"missing drone id", MAVLinkEndpoint{Systems: []MAVLinkSystemMapping{{SystemID: 1}}}, ErrInvalidSystemMapping},
+		{"duplicate", MAVLinkEndpoint{Systems: []MAVLinkSystemMapping{
+			{SystemID: 1, DroneID: "a"},
+			{SystemID: 1, DroneID: "b"},
+		}}, ErrInvalidSystemMapping},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := validateSystemMappings(&tc.endpoint)
+			if !errors.Is(err, tc.wantErr) {
+				t.Errorf("Expected error %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestConfigMessageTypes tests resolving forwarded message types against the dialect
+func TestConfigMessageTypes(t *testing.T) {
+	mavLink := MAVLinkConfig{
+		DialectName: "common",
+		Messages:    []string{"HEARTBEAT", "GlobalPositionInt", "vfr_hud", "30"},
+	}
+	if err := validateMavLinkDialect(&mavLink); err != nil {
+		t.Fatalf("Failed to resolve dialect: %v", err)
+	}
+	if err := validateMessageTypes(&mavLink); err != nil {
+		t.Fatalf("Failed to resolve message types: %v", err)
+	}
+
+	expected := []uint32{0, 33, 74, 30}
+	if len(mavLink.MessageIDs) != len(expected) {
+		t.Fatalf("Expected %d message IDs, got %d", len(expected), len(mavLink.MessageIDs))
+	}
+	for i, id := range expected {
+		if mavLink.MessageIDs[i] != id {
+			t.Errorf("Message %d: expected ID %d, got %d", i, id, mavLink.MessageIDs[i])
+		}
+	}
+
+	mavLink.Messages = []string{"NOT_A_MESSAGE"}
+	if err := validateMessageTypes(&mavLink); !errors.Is(err, ErrInvalidMessageType) {
+		t.Errorf("Expected ErrInvalidMessageType, got %v", err)
+	}
+}
+
+// TestConfigFilters tests resolving ingest filters with wildcards and global
+// defaults
+func TestConfigFilters(t *testing.T) {
+	cfg := loadTestConfig(t, `
+mavlink:
+  exclude: ["RC_CHANNELS*", "SERVO_OUTPUT_RAW"]
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+    - name: "companion"
+      drone_id: "drone-beta"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14551
+      include: ["HEARTBEAT", "33", "*_QUATERNION"]
+      exclude: ["HIL_STATE_QUATERNION"]
+
+sinks:
+  file:
+    path: "/tmp/test"
+    format: "json"
+`)
+
+	vehicle := cfg.MAVLink.Endpoints[0].Filter
+	if len(vehicle.IncludeIDs) != 0 {
+		t.Errorf("Expected no include list, got %v", vehicle.IncludeIDs)
+	}
+	for _, id := range []uint32{35, 65, 36} { // RC_CHANNELS_RAW, RC_CHANNELS, SERVO_OUTPUT_RAW
+		if !slices.Contains(vehicle.ExcludeIDs, id) {
+			t.Errorf("Expected the inherited exclude list to contain %d, got %v", id, vehicle.ExcludeIDs)
+		}
+	}
+
+	companion := cfg.MAVLink.Endpoints[1].Filter
+	for _, id := range []uint32{0, 33, 31, 115} { // HEARTBEAT, GLOBAL_POSITION_INT, ATTITUDE_QUATERNION, HIL_STATE_QUATERNION
+		if !slices.Contains(companion.IncludeIDs, id) {
+			t.Errorf("Expected the include list to contain %d, got %v", id, companion.IncludeIDs)
+		}
+	}
+	if !reflect.DeepEqual(companion.ExcludeIDs, []uint32{115}) {
+		t.Errorf("Expected the endpoint exclude list to replace the global one, got %v", companion.ExcludeIDs)
+	}
+
+	for _, pattern := range []string{"NOT_A_MESSAGE", "NOTHING_*", "[A-"} {
+		path := writeTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+      include: ["`+pattern+`"]
+`)
+		if _, err := Load(path); !errors.Is(err, ErrInvalidMessageType) {
+			t.Errorf("%s: expected %v, got %v", pattern, ErrInvalidMessageType, err)
+		}
+	}
+}
+
+// TestConfigDownsampling tests resolving global and per-endpoint downsampling rules
+func TestConfigDownsampling(t *testing.T) {
+	cfg := loadTestConfig(t, `
+mavlink:
+  downsample:
+    ATTITUDE: {interval: 500ms}
+    GLOBAL_POSITION_INT: {every: 5}
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+    - name: "companion"
+      drone_id: "drone-beta"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14551
+      downsample:
+        ATTITUDE: {strategy: aggregate, interval: 1s}
+        "74": {strategy: every_nth, every: 2}
+
+sinks:
+  file:
+    path: "/tmp/test"
+    format: "json"
+`)
+
+	vehicle := cfg.MAVLink.Endpoints[0].DownsampleRules
+	want := map[uint32]DownsampleRule{
+		30: {Strategy: DownsampleLatest, Interval: 500 * time.Millisecond},
+		33: {Strategy: DownsampleEveryNth, Every: 5},
+	}
+	if !reflect.DeepEqual(vehicle, want) {
+		t.Errorf("Expected the global rules %v, got %v", want, vehicle)
+	}
+
+	companion := cfg.MAVLink.Endpoints[1].DownsampleRules
+	want = map[uint32]DownsampleRule{
+		30: {StrategyName: "aggregate", Strategy: DownsampleAggregate, Interval: time.Second},
+		33: {Strategy: DownsampleEveryNth, Every: 5},
+		74: {StrategyName: "every_nth", Strategy: DownsampleEveryNth, Every: 2},
+	}
+	if !reflect.DeepEqual(companion, want) {
+		t.Errorf("Expected the endpoint rules to override the global ones %v, got %v", want, companion)
+	}
+
+	for rule, wantErr := range map[string]error{
+		"NOT_A_MESSAGE: {every: 2}":                  ErrInvalidMessageType,
+		"ATTITUDE: {strategy: median, interval: 1s}": ErrInvalidDownsample,
+		"ATTITUDE: {strategy: aggregate}":            ErrInvalidDownsample,
+		"ATTITUDE: {strategy: every_nth}":            ErrInvalidDownsample,
+	} {
+		path := writeTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+      downsample:
+        `+rule+`
+`)
+		if _, err := Load(path); !errors.Is(err, wantErr) {
+			t.Errorf("%s: expected %v, got %v", rule, wantErr, err)
+		}
+	}
+}
+
+// TestConfigRouting tests loading routing settings and endpoint rules
+func TestConfigRouting(t *testing.T) {
+	configContent := `
+mavlink:
+  routing:
+    enabled: true
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "serial"
+      mode: "1:1"
+      device: "/dev/ttyACM0"
+    - name: "qgc"
+      protocol: "udp_client"
+      mode: "route"
+      address: "127.0.0.1:14550"
+      routing:
+        deny_messages: ["PARAM_VALUE", "22"]
+        allow_systems: [1]
+
+sinks:
+  file:
+    path: "/tmp/test"
+    format: "json"
+`
+	cfg := loadTestConfig(t, configContent)
+
+	if !cfg.MAVLink.Routing.Enabled {
+		t.Error("Expected routing to be enabled")
+	}
+	qgc := cfg.MAVLink.Endpoints[1]
+	if qgc.Mode != MAVLinkModeRoute {
+		t.Errorf("Expected mode '%s', got '%s'", MAVLinkModeRoute, qgc.Mode)
+	}
+	if len(qgc.Routing.DenyMessageIDs) != 2 || qgc.Routing.DenyMessageIDs[0] != 22 || qgc.Routing.DenyMessageIDs[1] != 22 {
+		t.Errorf("Expected deny message IDs [22 22], got %v", qgc.Routing.DenyMessageIDs)
+	}
+	if len(qgc.Routing.AllowSystems) != 1 || qgc.Routing.AllowSystems[0] != 1 {
+		t.Errorf("Expected allow systems [1], got %v", qgc.Routing.AllowSystems)
+	}
+
+	// Route mode needs routing enabled
+	path := writeTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "qgc"
+      protocol: "udp_client"
+      mode: "route"
+      address: "127.0.0.1:14550"
+`)
+	if _, err := Load(path); !errors.Is(err, ErrInvalidMode) {
+		t.Errorf("Expected ErrInvalidMode, got %v", err)
+	}
+
+	// Unknown message types in rules are rejected
+	path = writeTestConfig(t, `
+mavlink:
+  routing:
+    enabled: true
+  endpoints:
+    - name: "qgc"
+      protocol: "udp_client"
+      mode: "route"
+      address: "127.0.0.1:14550"
+      routing:
+        allow_messages: ["NOT_A_MESSAGE"]
+`)
+	if _, err := Load(path); !errors.Is(err, ErrInvalidMessageType) {
+		t.Errorf("Expected ErrInvalidMessageType, got %v", err)
+	}
+}
+
+// TestConfigStreams tests resolving per-endpoint stream rates
+func TestConfigStreams(t *testing.T) {
+	cfg := loadTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+      streams:
+        ATTITUDE: 10
+        GlobalPositionInt: 5
+        "74": 2
+        RAW_IMU: 0
+
+sinks:
+  file:
+    path: "/tmp/test"
+    format: "json"
+`)
+
+	expected := map[uint32]float64{30: 10, 33: 5, 74: 2, 27: 0}
+	if rates := cfg.MAVLink.Endpoints[0].StreamRates; !reflect.DeepEqual(rates, expected) {
+		t.Errorf("Expected stream rates %v, got %v", expected, rates)
+	}
+
+	testCases := []struct {
+		name    string
+		streams string
+		wantErr error
+	}{
+		{"unknown message", "NOT_A_MESSAGE: 1", ErrInvalidMessageType},
+		{"negative rate", "ATTITUDE: -1", ErrInvalidStreamRate},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+      streams:
+        `+tc.streams+`
+`)
+			if _, err := Load(path); !errors.Is(err, tc.wantErr) {
+				t.Errorf("Expected %v, got %v", tc.wantErr, err)
+			}
+		})
+	}
+}
+
+// TestConfigIdentity tests relay identity defaults and per-endpoint overrides
+func TestConfigIdentity(t *testing.T) {
+	cfg := loadTestConfig(t, `
+mavlink:
+  identity:
+    system_id: 250
+    heartbeat:
+      rate: 2
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+    - name: "companion"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14551
+      identity:
+        component_id: 191
+        version: 1
+        heartbeat:
+          enabled: false
+          type: "ONBOARD_CONTROLLER"
+
+sinks:
+  file:
+    path: "/tmp/test"
+    format: "json"
+`)
+
+	vehicle := cfg.MAVLink.Endpoints[0].Identity
+	if vehicle.SystemID != 250 || vehicle.ComponentID != 190 || vehicle.Version != 2 {
+		t.Errorf("Expected inherited identity 250/190 v2, got %d/%d v%d", vehicle.SystemID, vehicle.ComponentID, vehicle.Version)
+	}
+	if !*vehicle.Heartbeat.Enabled || vehicle.Heartbeat.Period() != 500*time.Millisecond || vehicle.Heartbeat.Type != common.MAV_TYPE_GCS {
+		t.Errorf("Expected a 2Hz GCS heartbeat, got %+v", vehicle.Heartbeat)
+	}
+
+	companion := cfg.MAVLink.Endpoints[1].Identity
+	if companion.SystemID != 250 || companion.ComponentID != 191 || companion.Version != 1 {
+		t.Errorf("Expected overridden identity 250/191 v1, got %d/%d v%d", companion.SystemID, companion.ComponentID, companion.Version)
+	}
+	if *companion.Heartbeat.Enabled || companion.Heartbeat.Type != common.MAV_TYPE_ONBOARD_CONTROLLER {
+		t.Errorf("Expected a disabled onboard controller heartbeat, got %+v", companion.Heartbeat)
+	}
+	if !*cfg.MAVLink.Identity.Heartbeat.Enabled {
+		t.Error("Expected the endpoint override to leave the global heartbeat enabled")
+	}
+
+	testCases := []struct {
+		name     string
+		identity string
+	}{
+		{"version", "version: 3"},
+		{"heartbeat rate", "heartbeat: {rate: -1}"},
+		{"heartbeat type", "heartbeat: {type: SPACESHIP}"},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeTestConfig(t, `
+mavlink:
+  identity:
+    `+tc.identity+`
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+`)
+			if _, err := Load(path); !errors.Is(err, ErrInvalidIdentity) {
+				t.Errorf("Expected %v, got %v", ErrInvalidIdentity, err)
+			}
+		})
+	}
+
+	// the relay sends its own heartbeat, so a zero type is advertised as it is
+	cfg = loadTestConfig(t, `
+mavlink:
+  identity:
+    heartbeat:
+      type: "GENERIC"
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+`)
+	if heartbeat := cfg.MAVLink.Endpoints[0].Identity.Heartbeat; heartbeat.Type != common.MAV_TYPE_GENERIC {
+		t.Errorf("Expected a generic heartbeat, got %v", heartbeat.Type)
+	}
+}
+
+// TestConfigSigning tests loading signing keys and verify modes
+func TestConfigSigning(t *testing.T) {
+	keyFile := filepath.Join(t.TempDir(), "signing.key")
+	hexKey := strings.Repeat("0a", 32)
+	if err := os.WriteFile(keyFile, []byte(hexKey+"\n"), 0o600); err != nil {
+		t.Fatalf("Failed to write key: %v", err)
+	}
+	t.Setenv("TEST_SIGNING_PASSPHRASE", "correct horse battery staple")
+
+	cfg := loadTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "file-key"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+      signing:
+        key_file: "`+keyFile+`"
+        sign: true
+        verify: "strict"
+    - name: "env-key"
+      drone_id: "drone-bravo"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14551
+      signing:
+        key_env: "TEST_SIGNING_PASSPHRASE"
+        verify: "permissive"
+    - name: "unsigned"
+      drone_id: "drone-charlie"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14552
+
+sinks:
+  file:
+    path: "/tmp/test"
+    format: "json"
+`)
+
+	var rawKey frame.V2Key
+	for i := range rawKey {
+		rawKey[i] = 0x0a
+	}
+	fileKey := cfg.MAVLink.Endpoints[0].Signing
+	if fileKey.Key == nil || *fileKey.Key != rawKey {
+		t.Errorf("Expected hex key from file, got %v", fileKey.Key)
+	}
+	if !fileKey.Sign || fileKey.Verify != SigningVerifyStrict {
+		t.Errorf("Expected signing with strict verification, got sign=%v verify=%s", fileKey.Sign, fileKey.Verify)
+	}
+
+	envKey := cfg.MAVLink.Endpoints[1].Signing
+	passphraseKey := frame.V2Key(sha256.Sum256([]byte("correct horse battery staple")))
+	if envKey.Key == nil || *envKey.Key != passphraseKey {
+		t.Errorf("Expected SHA-256 of the passphrase, got %v", envKey.Key)
+	}
+	if envKey.Verify != SigningVerifyPermissive {
+		t.Errorf("Expected permissive verification, got %s", envKey.Verify)
+	}
+
+	if unsigned := cfg.MAVLink.Endpoints[2].Signing; unsigned.Verify != SigningVerifyOff || unsigned.Key != nil {
+		t.Errorf("Expected signing off by default, got %+v", unsigned)
+	}
+
+	testCases := []struct {
+		name    string
+		signing string
+	}{
+		{"missing key", `{sign: true}`},
+		{"both key sources", `{sign: true, key_file: "` + keyFile + `", key_env: "TEST_SIGNING_PASSPHRASE"}`},
+		{"missing key file", `{verify: strict, key_file: "/nonexistent/signing.key"}`},
+		{"empty env key", `{verify: strict, key_env: "TEST_SIGNING_UNSET"}`},
+		{"unknown verify mode", `{verify: sometimes, key_env: "TEST_SIGNING_PASSPHRASE"}`},
+		{"mavlink 1", `{sign: true, key_env: "TEST_SIGNING_PASSPHRASE"}
+      identity: {version: 1}`},
+	}
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path := writeTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+      signing: `+tc.signing+`
+`)
+			if _, err := Load(path); !errors.Is(err, ErrInvalidSigning) {
+				t.Errorf("Expected %v, got %v", ErrInvalidSigning, err)
+			}
+		})
+	}
+}
+
+// TestConfigReplay tests loading and validating replay endpoints
+func TestConfigReplay(t *testing.T) {
+	endpoint := func(replay string) string {
+		return `
+mavlink:
+  endpoints:
+    - name: "flight"
+      drone_id: "drone-alpha"
+      protocol: "replay"
+      mode: "1:1"
+      replay: ` + replay + "\n"
+	}
+
+	for replay, want := range map[string]ReplayConfig{
+		"{file: flight.tlog}":                                           {File: "flight.tlog", Speed: 1},
+		"{file: flight.tlog, speed: 4}":                                 {File: "flight.tlog", SpeedName: "4", Speed: 4},
+		"{file: flight.tlog, speed: 2.5x}":                              {File: "flight.tlog", SpeedName: "2.5x", Speed: 2.5},
+		"{file: flight.tlog, speed: max, loop: true, start_offset: 5m}": {File: "flight.tlog", SpeedName: "max", Loop: true, StartOffset: 5 * time.Minute},
+	} {
+		cfg := loadTestConfig(t, endpoint(replay))
+		if got := cfg.MAVLink.Endpoints[0]; got.Protocol != MAVLinkEndpointProtocolReplay || got.Replay != want {
+			t.Errorf("%s: expected %+v, got %s %+v", replay, want, got.Protocol, got.Replay)
+		}
+	}
+
+	for _, replay := range []ReplayConfig{
+		{},
+		{File: "flight.tlog", SpeedName: "0"},
+		{File: "flight.tlog", SpeedName: "fast"},
+		{File: "flight.tlog", StartOffset: -time.Second},
+	} {
+		endpoint := MAVLinkEndpoint{Name: "flight", DroneID: "drone-alpha", ProtocolName: "replay", ModeName: "1:1", Replay: replay}
+		if err := validateEndpoint(&endpoint); !errors.Is(err, ErrInvalidReplay) {
+			t.Errorf("%+v: expected %v, got %v", replay, ErrInvalidReplay, err)
+		}
+	}
+}
+
+// TestConfigSim tests loading and validating sim endpoints
+func TestConfigSim(t *testing.T) {
+	cfg := loadTestConfig(t, `
+mavlink:
+  endpoints:
+    - name: "sim"
+      protocol: "sim"
+      mode: "multi"
+      drone_id_template: "sim-{system_id}"
+      sim:
+        vehicles: 3
+        autopilot: PX4
+        waypoints:
+          - {latitude: 47.3977, longitude: 8.5455, altitude: 30}
+          - {latitude: 47.3987, longitude: 8.5465, altitude: 60}
+        rates:
+          attitude: 20
+          vfr_hud: 0
+`)
+	sim := cfg.MAVLink.Endpoints[0].Sim
+	if cfg.MAVLink.Endpoints[0].Protocol != MAVLinkEndpointProtocolSim || sim.Vehicles != 3 || sim.Autopilot != "px4" || len(sim.Waypoints) != 2 {
+		t.Fatalf("Expected a px4 sim of 3 vehicles on 2 waypoints, got %+v", sim)
+	}
+	if sim.Speed != 10 || sim.BatteryDrain != 2 {
+		t.Errorf("Expected the default speed and battery drain, got %v m/s and %v %%/min", sim.Speed, sim.BatteryDrain)
+	}
+	wantRates := map[string]float64{"HEARTBEAT": 1, "SYS_STATUS": 1, "GLOBAL_POSITION_INT": 5, "ATTITUDE": 20, "VFR_HUD": 0}
+	if !reflect.DeepEqual(sim.MessageRates, wantRates) {
+		t.Errorf("Expected rates %v, got %v", wantRates, sim.MessageRates)
+	}
+
+	endpoint := MAVLinkEndpoint{Name: "sim", DroneID: "drone-alpha", ProtocolName: "sim", ModeName: "1:1"}
+	if err := validateEndpoint(&endpoint); err != nil {
+		t.Fatalf("Expected a single vehicle in 1:1 mode to be valid, got %v", err)
+	}
+	wantOrbit := SimOrbit{Latitude: 47.397742, Longitude: 8.545594, Radius: 100, Altitude: 50}
+	if endpoint.Sim.Vehicles != 1 || endpoint.Sim.Autopilot != "ardupilot" || endpoint.Sim.Orbit != wantOrbit {
+		t.Errorf("Expected one ardupilot vehicle on %+v, got %+v", wantOrbit, endpoint.Sim)
+	}
+
+	for _, sim := range []SimConfig{
+		{Vehicles: 2},
+		{Vehicles: 255},
+		{Autopilot: "inav"},
+		{Speed: -1},
+		{Orbit: SimOrbit{Radius: -10}},
+		{Waypoints: []SimWaypoint{{Latitude: 47.3977, Longitude: 8.5455}}},
+		{Waypoints: []SimWaypoint{{Latitude: 97, Longitude: 8.5455}, {Latitude: 47.3977, Longitude: 8.5455}}},
+		{Rates: map[string]float64{"SERVO_OUTPUT_RAW": 1}},
+		{Rates: map[string]float64{"ATTITUDE": -1}},
+	} {
+		endpoint := MAVLinkEndpoint{Name: "sim", DroneID: "drone-alpha", ProtocolName: "sim", ModeName: "1:1", Sim: sim}
+		if err := validateEndpoint(&endpoint); !errors.Is(err, ErrInvalidSim) {
+			t.Errorf("%+v: expected %v, got %v", sim, ErrInvalidSim, err)
+		}
+	}
+}
+
+// TestConfigRecorder tests loading and validating the .tlog recorder settings
+func TestConfigRecorder(t *testing.T) {
+	endpoints := `
+mavlink:
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+`
+	cfg := loadTestConfig(t, endpoints+`
+recorder:
+  enabled: true
+  path: "/var/lib/aero-arc-relay/tlogs"
+  max_bytes: 104857600
+  rotation_interval: 1h
+`)
+	want := RecorderConfig{Enabled: true, Path: "/var/lib/aero-arc-relay/tlogs", MaxBytes: 100 << 20, RotationInterval: time.Hour}
+	if cfg.Recorder != want {
+		t.Errorf("Expected %+v, got %+v", want, cfg.Recorder)
+	}
+
+	for _, recorder := range []string{
+		"{enabled: true}",
+		"{enabled: true, path: tlogs, max_bytes: -1}",
+		"{enabled: true, path: tlogs, rotation_interval: -1s}",
+	} {
+		if _, err := Load(writeTestConfig(t, endpoints+"recorder: "+recorder+"\n")); !errors.Is(err, ErrInvalidRecorder) {
+			t.Errorf("%s: expected %v, got %v", recorder, ErrInvalidRecorder, err)
+		}
+	}
+}
+
+// TestConfigAdminState tests applying endpoint changes saved by the admin API
+// and resolving endpoints added at runtime
+func TestConfigAdminState(t *testing.T) {
+	stateFile := filepath.Join(t.TempDir(), "endpoints.yaml")
+	changes := &EndpointChanges{}
+	changes.Add(MAVLinkEndpoint{Name: "bench", DroneID: "drone-bench", ProtocolName: "udp", ModeName: "1:1", Port: 14560})
+	changes.Add(MAVLinkEndpoint{Name: "companion", DroneID: "drone-beta", ProtocolName: "udp", ModeName: "1:1", Port: 14561})
+	changes.Remove("vehicle")
+	changes.Remove("companion")
+	if err := changes.Save(stateFile); err != nil {
+		t.Fatalf("Failed to save state: %v", err)
+	}
+
+	cfg := loadTestConfig(t, `
+mavlink:
+  identity:
+    system_id: 250
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+    - name: "gcs"
+      drone_id: "drone-gcs"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14551
+
+admin:
+  enabled: true
+  token: "admin-secret"
+  state_file: "`+stateFile+`"
+
+sinks:
+  file:
+    path: "/tmp/test"
+    format: "json"
+`)
+
+	var names []string
+	for _, endpoint := range cfg.MAVLink.Endpoints {
+		names = append(names, endpoint.Name)
+	}
+	if !reflect.DeepEqual(names, []string{"gcs", "bench"}) {
+		t.Fatalf("Expected endpoints [gcs bench], got %v", names)
+	}
+	bench := cfg.MAVLink.Endpoints[1]
+	if bench.Mode != MAVLinkMode1To1 || bench.Identity.SystemID != 250 {
+		t.Errorf("Expected the added endpoint to be resolved like configured ones, got %+v", bench)
+	}
+	if !reflect.DeepEqual(cfg.Admin.Changes.Removed, []string{"vehicle", "companion"}) {
+		t.Errorf("Expected the loaded changes to be kept, got %+v", cfg.Admin.Changes)
+	}
+
+	if cfg.Path == "" {
+		t.Error("Expected the config to record the file it was loaded from")
+	}
+
+	// Changes kept in memory are applied to a reloaded config the same way
+	mavLink := cfg.MAVLink
+	mavLink.Endpoints = []MAVLinkEndpoint{cfg.MAVLink.Endpoints[0]}
+	if err := mavLink.ApplyEndpointChanges(changes); err != nil {
+		t.Fatalf("Failed to apply endpoint changes: %v", err)
+	}
+	if len(mavLink.Endpoints) != 2 || mavLink.Endpoints[1].Name != "bench" || mavLink.Endpoints[1].Mode != MAVLinkMode1To1 {
+		t.Errorf("Expected gcs and a resolved bench endpoint, got %+v", mavLink.Endpoints)
+	}
+
+	endpoint := MAVLinkEndpoint{Name: "runtime", DroneID: "drone-gamma", ProtocolName: "tcp", ModeName: "1:1", Port: 5760}
+	if err := cfg.MAVLink.ResolveEndpoint(&endpoint); err != nil {
+		t.Fatalf("Failed to resolve endpoint: %v", err)
+	}
+	if endpoint.Protocol != MAVLinkEndpointProtocolTCP || endpoint.Identity.SystemID != 250 {
+		t.Errorf("Expected a resolved TCP endpoint inheriting the identity, got %+v", endpoint)
+	}
+	if err := cfg.MAVLink.ResolveEndpoint(&MAVLinkEndpoint{ProtocolName: "udp", ModeName: "1:1", DroneID: "x"}); !errors.Is(err, ErrInvalidName) {
+		t.Errorf("Expected %v for a nameless endpoint, got %v", ErrInvalidName, err)
+	}
+
+	if err := os.WriteFile(stateFile, []byte("added: {"), 0o644); err != nil {
+		t.Fatalf("Failed to write state: %v", err)
+	}
+	if _, err := LoadEndpointChanges(stateFile); !errors.Is(err, ErrInvalidStateFile) {
+		t.Errorf("Expected %v, got %v", ErrInvalidStateFile, err)
+	}
+
+	// the admin API is never served without a token
+	noToken := `
+mavlink:
+  endpoints:
+    - name: "vehicle"
+      drone_id: "drone-alpha"
+      protocol: "udp"
+      mode: "1:1"
+      port: 14550
+admin:
+  enabled: true
+`
+	if _, err := Load(writeTestConfig(t, noToken)); !errors.Is(err, ErrInvalidAdmin) {
+		t.Errorf("Expected %v, got %v", ErrInvalidAdmin, err)
+	}
+	if changes, err := LoadEndpointChanges(filepath.Join(t.TempDir(), "missing.yaml")); err != nil || len(changes.Added) != 0 {
+		t.Errorf("Expected a missing state file to hold no changes, got %+v, %v", changes, err)
+	}
+}
+
+// writeTestConfig writes config content to a temporary file and returns its path
+func writeTestConfig(t *testing.T, content string) string {
+	t.Helper()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	return path
+}
+
+// loadTestConfig writes config content to a temporary file and loads it
+func loadTestConfig(t *testing.T, content string) *Config {
+	t.Helper()
+
+	cfg, err := Load(writeTestConfig(t, content))
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	return cfg
+}
@@ -3,22 +3,34 @@ package config
 import "fmt"
 
 var (
-	ErrNoEndpoints             = fmt.Errorf("no MAVLink endpoints configured")
-	ErrInvalidProtocol         = fmt.Errorf("invalid MAVLink endpoint protocol")
-	ErrInvalidMode             = fmt.Errorf("invalid MAVLink endpoint mode")
-	ErrInvalidName             = fmt.Errorf("invalid MAVLink endpoint name")
-	ErrInvalidBaudRate         = fmt.Errorf("invalid MAVLink endpoint baud rate")
-	ErrInvalidPort             = fmt.Errorf("invalid MAVLink endpoint port")
-	ErrInvalidAddress          = fmt.Errorf("invalid MAVLink endpoint address")
-	ErrInvalidCredentials      = fmt.Errorf("invalid MAVLink endpoint credentials")
-	ErrInvalidPrefix           = fmt.Errorf("invalid MAVLink endpoint prefix")
-	ErrInvalidFlushInterval    = fmt.Errorf("invalid MAVLink endpoint flush interval")
-	ErrInvalidQueueSize        = fmt.Errorf("invalid MAVLink endpoint queue size")
-	Err1To1ModeRequiresDroneID = fmt.Errorf("1:1 mode requires DroneID to be configured")
-	ErrFailedToParseConfigFile = fmt.Errorf("failed to parse config file")
-	ErrFailedToReadConfigFile  = fmt.Errorf("failed to read config file")
-	ErrNoValidEndpoints        = fmt.Errorf("no valid MAVLink endpoints configured")
-	ErrInvalidDialect          = fmt.Errorf("invalid MAVLink dialect")
-	ErrDroneIDRequired         = fmt.Errorf("drone ID is required for 1:1 mode")
-	ErrMultiModeNotSupported   = fmt.Errorf("multi mode is not supported until agent is implemented")
+	ErrNoEndpoints              = fmt.Errorf("no MAVLink endpoints configured")
+	ErrInvalidProtocol          = fmt.Errorf("invalid MAVLink endpoint protocol")
+	ErrInvalidMode              = fmt.Errorf("invalid MAVLink endpoint mode")
+	ErrInvalidName              = fmt.Errorf("invalid MAVLink endpoint name")
+	ErrInvalidBaudRate          = fmt.Errorf("invalid MAVLink endpoint baud rate")
+	ErrInvalidPort              = fmt.Errorf("invalid MAVLink endpoint port")
+	ErrInvalidAddress           = fmt.Errorf("invalid MAVLink endpoint address")
+	ErrInvalidCredentials       = fmt.Errorf("invalid MAVLink endpoint credentials")
+	ErrInvalidPrefix            = fmt.Errorf("invalid MAVLink endpoint prefix")
+	ErrInvalidFlushInterval     = fmt.Errorf("invalid MAVLink endpoint flush interval")
+	ErrInvalidQueueSize         = fmt.Errorf("invalid MAVLink endpoint queue size")
+	Err1To1ModeRequiresDroneID  = fmt.Errorf("1:1 mode requires DroneID to be configured")
+	ErrFailedToParseConfigFile  = fmt.Errorf("failed to parse config file")
+	ErrFailedToReadConfigFile   = fmt.Errorf("failed to read config file")
+	ErrNoValidEndpoints         = fmt.Errorf("no valid MAVLink endpoints configured")
+	ErrInvalidDialect           = fmt.Errorf("invalid MAVLink dialect")
+	ErrDroneIDRequired          = fmt.Errorf("drone ID is required for 1:1 mode")
+	ErrMultiModeRequiresMapping = fmt.Errorf("multi mode requires systems or drone_id_template to be configured")
+	ErrInvalidSystemMapping     = fmt.Errorf("invalid MAVLink system mapping")
+	ErrInvalidMessageType       = fmt.Errorf("invalid MAVLink message type")
+	ErrInvalidStreamRate        = fmt.Errorf("invalid MAVLink stream rate")
+	ErrInvalidDownsample        = fmt.Errorf("invalid MAVLink downsampling rule")
+	ErrInvalidIdentity          = fmt.Errorf("invalid MAVLink relay identity")
+	ErrInvalidSigning           = fmt.Errorf("invalid MAVLink signing configuration")
+	ErrInvalidStateFile         = fmt.Errorf("invalid endpoint state file")
+	ErrInvalidCommands          = fmt.Errorf("invalid commands configuration")
+	ErrInvalidAdmin             = fmt.Errorf("invalid admin configuration")
+	ErrInvalidRecorder          = fmt.Errorf("invalid recorder configuration")
+	ErrInvalidReplay            = fmt.Errorf("invalid MAVLink replay endpoint")
+	ErrInvalidSim               = fmt.Errorf("invalid MAVLink sim endpoint")
 )
@@ -0,0 +1,113 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EndpointChanges are the endpoints added and removed through the admin API.
+// They are kept apart from the config file, which stays untouched, and are
+// applied on top of its endpoints at startup.
+type EndpointChanges struct {
+	Added   []MAVLinkEndpoint `yaml:"added,omitempty"`   // as submitted, before load time resolution
+	Removed []string          `yaml:"removed,omitempty"` // names of configured endpoints
+}
+
+// LoadEndpointChanges reads the endpoint changes from a state file. A missing
+// file holds no changes.
+func LoadEndpointChanges(path string) (*EndpointChanges, error) {
+	data, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return &EndpointChanges{}, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidStateFile, err)
+	}
+
+	var changes EndpointChanges
+	if err := yaml.Unmarshal(data, &changes); err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrInvalidStateFile, err)
+	}
+	return &changes, nil
+}
+
+// Save writes the changes to a state file, replacing it atomically
+func (c *EndpointChanges) Save(path string) error {
+	data, err := yaml.Marshal(c)
+	if err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
+
+// Apply returns the configured endpoints without the removed ones, followed
+// by the added ones. Added endpoints replace configured ones of the same name.
+func (c *EndpointChanges) Apply(endpoints []MAVLinkEndpoint) []MAVLinkEndpoint {
+	var result []MAVLinkEndpoint
+	for _, endpoint := range endpoints {
+		if slices.Contains(c.Removed, endpoint.Name) || c.added(endpoint.Name) >= 0 {
+			continue
+		}
+		result = append(result, endpoint)
+	}
+	return append(result, c.Added...)
+}
+
+// Add records an endpoint added at runtime, replacing any earlier change for
+// the same name
+func (c *EndpointChanges) Add(endpoint MAVLinkEndpoint) {
+	c.Removed = slices.DeleteFunc(c.Removed, func(name string) bool { return name == endpoint.Name })
+	if i := c.added(endpoint.Name); i >= 0 {
+		c.Added[i] = endpoint
+		return
+	}
+	c.Added = append(c.Added, endpoint)
+}
+
+// Remove records the removal of an endpoint. The name stays removed, so a
+// configured endpoint of that name is skipped until it is added again.
+func (c *EndpointChanges) Remove(name string) {
+	if i := c.added(name); i >= 0 {
+		c.Added = slices.Delete(c.Added, i, i+1)
+	}
+	if !slices.Contains(c.Removed, name) {
+		c.Removed = append(c.Removed, name)
+	}
+}
+
+// ApplyEndpointChanges applies endpoint changes made while the relay runs to
+// loaded endpoints, resolving the added endpoints as Load does
+func (m *MAVLinkConfig) ApplyEndpointChanges(changes *EndpointChanges) error {
+	resolved := &EndpointChanges{Removed: changes.Removed}
+	for _, endpoint := range changes.Added {
+		if err := m.ResolveEndpoint(&endpoint); err != nil {
+			return err
+		}
+		resolved.Added = append(resolved.Added, endpoint)
+	}
+	m.Endpoints = resolved.Apply(m.Endpoints)
+	return nil
+}
+
+func (c *EndpointChanges) added(name string) int {
+	return slices.IndexFunc(c.Added, func(endpoint MAVLinkEndpoint) bool { return endpoint.Name == name })
+}
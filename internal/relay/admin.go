@@ -0,0 +1,244 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"slices"
+
+	"github.com/makinje/aero-arc-relay/internal/config"
+	"gopkg.in/yaml.v3"
+)
+
+var (
+	errUnknownEndpoint = errors.New("endpoint does not exist")
+	errEndpointExists  = errors.New("endpoint already exists")
+	errInvalidEndpoint = errors.New("invalid endpoint")
+)
+
+// maxEndpointBody caps the size of an endpoint submitted to the admin API
+const maxEndpointBody = 64 << 10
+
+// endpointInfo describes an endpoint in admin API responses
+type endpointInfo struct {
+	Name     string        `json:"name"`
+	Protocol string        `json:"protocol"`
+	Mode     string        `json:"mode"`
+	DroneID  string        `json:"drone_id,omitempty"`
+	Address  string        `json:"address,omitempty"`
+	Port     int           `json:"port,omitempty"`
+	Device   string        `json:"device,omitempty"`
+	File     string        `json:"file,omitempty"`
+	State    endpointState `json:"state"`
+	Error    string        `json:"error,omitempty"` // why the endpoint failed validation
+}
+
+func (r *Relay) endpointInfo(endpoint config.MAVLinkEndpoint) endpointInfo {
+	info := endpointInfo{
+		Name:     endpoint.Name,
+		Protocol: endpoint.ProtocolName,
+		Mode:     string(endpoint.Mode),
+		DroneID:  endpoint.DroneID,
+		Address:  endpoint.Address,
+		Port:     endpoint.Port,
+		Device:   endpoint.Device,
+		File:     endpoint.Replay.File,
+	}
+	if state, ok := r.endpointStates.Load(endpoint.Name); ok {
+		info.State = state.(endpointState)
+	}
+	if endpoint.Error != nil {
+		info.Error = endpoint.Error.Error()
+	}
+	return info
+}
+
+// listEndpoints returns the endpoints the relay currently runs
+func (r *Relay) listEndpoints() []endpointInfo {
+	r.endpointsMu.Lock()
+	defer r.endpointsMu.Unlock()
+
+	infos := make([]endpointInfo, 0, len(r.config.MAVLink.Endpoints))
+	for _, endpoint := range r.config.MAVLink.Endpoints {
+		infos = append(infos, r.endpointInfo(endpoint))
+	}
+	return infos
+}
+
+// lookupEndpoint returns a running endpoint by name
+func (r *Relay) lookupEndpoint(name string) (endpointInfo, error) {
+	r.endpointsMu.Lock()
+	defer r.endpointsMu.Unlock()
+
+	i := r.endpointIndex(name)
+	if i < 0 {
+		return endpointInfo{}, fmt.Errorf("%w: %s", errUnknownEndpoint, name)
+	}
+	return r.endpointInfo(r.config.MAVLink.Endpoints[i]), nil
+}
+
+// addEndpoint resolves an endpoint the way the config loader does and starts
+// it. The endpoint is opened in the background and retried like configured
+// ones. The context bounds the endpoint's lifetime.
+func (r *Relay) addEndpoint(ctx context.Context, endpoint config.MAVLinkEndpoint) (endpointInfo, error) {
+	// the state file keeps the endpoint as submitted, so it inherits the
+	// global settings in effect when it is reloaded
+	submitted := endpoint
+
+	r.endpointsMu.Lock()
+	defer r.endpointsMu.Unlock()
+
+	if err := r.config.MAVLink.ResolveEndpoint(&endpoint); err != nil {
+		return endpointInfo{}, fmt.Errorf("%w: %w", errInvalidEndpoint, err)
+	}
+	if r.endpointIndex(endpoint.Name) >= 0 {
+		return endpointInfo{}, fmt.Errorf("%w: %s", errEndpointExists, endpoint.Name)
+	}
+	if err := r.saveEndpointChanges(func(changes *config.EndpointChanges) { changes.Add(submitted) }); err != nil {
+		return endpointInfo{}, err
+	}
+
+	r.config.MAVLink.Endpoints = append(r.config.MAVLink.Endpoints, endpoint)
+	if r.streams != nil {
+		r.streams.setRates(endpoint.Name, endpoint.StreamRates)
+	}
+	r.launchEndpoint(ctx, endpoint, r.config.MAVLink.Dialect)
+
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "MAVLink endpoint added",
+		slog.String("endpoint", endpoint.Name),
+		slog.String("protocol", endpoint.ProtocolName))
+	return r.endpointInfo(endpoint), nil
+}
+
+// removeEndpoint stops an endpoint and closes its node
+func (r *Relay) removeEndpoint(name string) error {
+	r.endpointsMu.Lock()
+	defer r.endpointsMu.Unlock()
+
+	i := r.endpointIndex(name)
+	if i < 0 {
+		return fmt.Errorf("%w: %s", errUnknownEndpoint, name)
+	}
+	if err := r.saveEndpointChanges(func(changes *config.EndpointChanges) { changes.Remove(name) }); err != nil {
+		return err
+	}
+
+	r.stopEndpoint(name)
+	r.config.MAVLink.Endpoints = slices.Delete(r.config.MAVLink.Endpoints, i, i+1)
+
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "MAVLink endpoint removed",
+		slog.String("endpoint", name))
+	return nil
+}
+
+// endpointIndex returns the position of an endpoint in the config, or -1.
+// The caller holds endpointsMu.
+func (r *Relay) endpointIndex(name string) int {
+	return slices.IndexFunc(r.config.MAVLink.Endpoints, func(endpoint config.MAVLinkEndpoint) bool {
+		return endpoint.Name == name
+	})
+}
+
+// saveEndpointChanges records a change and writes the changes to the state
+// file when one is configured. The change is dropped if it cannot be saved.
+// The caller holds endpointsMu.
+func (r *Relay) saveEndpointChanges(change func(*config.EndpointChanges)) error {
+	changes := &config.EndpointChanges{}
+	if r.config.Admin.Changes != nil {
+		changes.Added = slices.Clone(r.config.Admin.Changes.Added)
+		changes.Removed = slices.Clone(r.config.Admin.Changes.Removed)
+	}
+	change(changes)
+
+	if path := r.config.Admin.StateFile; path != "" {
+		if err := changes.Save(path); err != nil {
+			return fmt.Errorf("failed to save endpoint state: %w", err)
+		}
+	}
+	r.config.Admin.Changes = changes
+	return nil
+}
+
+// endpointAdminHandler serves /api/v1/endpoints[/{name}]: GET lists the
+// endpoints or returns one, POST adds one and DELETE removes one. Endpoints
+// added through the API run until they are removed or ctx is done.
+func (r *Relay) endpointAdminHandler(ctx context.Context) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		name := req.PathValue("name")
+
+		allowed := "GET, POST"
+		if name != "" {
+			allowed = "GET, DELETE"
+		}
+		switch {
+		case req.Method == http.MethodGet,
+			req.Method == http.MethodPost && name == "",
+			req.Method == http.MethodDelete && name != "":
+		default:
+			w.Header().Set("Allow", allowed)
+			http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+			return
+		}
+
+		if !r.authorizeAdmin(w, req) {
+			return
+		}
+
+		switch {
+		case req.Method == http.MethodPost:
+			// endpoints use the config file's keys, in JSON or YAML
+			data, err := io.ReadAll(io.LimitReader(req.Body, maxEndpointBody))
+			if err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+			var endpoint config.MAVLinkEndpoint
+			if err := yaml.Unmarshal(data, &endpoint); err != nil {
+				writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+				return
+			}
+
+			info, err := r.addEndpoint(ctx, endpoint)
+			if err != nil {
+				writeJSON(w, endpointHTTPStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusCreated, info)
+
+		case req.Method == http.MethodDelete:
+			if err := r.removeEndpoint(name); err != nil {
+				writeJSON(w, endpointHTTPStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			w.WriteHeader(http.StatusNoContent)
+
+		case name != "":
+			info, err := r.lookupEndpoint(name)
+			if err != nil {
+				writeJSON(w, endpointHTTPStatus(err), map[string]string{"error": err.Error()})
+				return
+			}
+			writeJSON(w, http.StatusOK, info)
+
+		default:
+			writeJSON(w, http.StatusOK, r.listEndpoints())
+		}
+	})
+}
+
+// endpointHTTPStatus maps an admin API error to an HTTP status code
+func endpointHTTPStatus(err error) int {
+	switch {
+	case errors.Is(err, errInvalidEndpoint):
+		return http.StatusBadRequest
+	case errors.Is(err, errUnknownEndpoint):
+		return http.StatusNotFound
+	case errors.Is(err, errEndpointExists):
+		return http.StatusConflict
+	default:
+		return http.StatusInternalServerError
+	}
+}
@@ -0,0 +1,496 @@
+package relay
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v2"
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
+	"github.com/makinje/aero-arc-relay/pkg/telemetry"
+)
+
+var (
+	errUnknownDrone      = errors.New("drone is not connected")
+	errInvalidCommand    = errors.New("invalid command")
+	errCommandInFlight   = errors.New("command already in flight for drone")
+	errCommandSendFailed = errors.New("failed to send command")
+	errCommandTimeout    = errors.New("timed out waiting for COMMAND_ACK")
+)
+
+// Command statuses reported alongside the MAV_RESULT
+const (
+	commandStatusAck      = "ack"      // a final COMMAND_ACK arrived, see Result
+	commandStatusTimeout  = "timeout"  // no final COMMAND_ACK before the deadline
+	commandStatusRejected = "rejected" // the relay refused the request
+	commandStatusError    = "error"    // the command could not be sent
+)
+
+// commandAliases maps friendly command names to MAVLink commands and the
+// params they fix, starting at param1. Params supplied with a request are
+// merged by index; at a fixed index they must match the alias, so "arm" with
+// a param1 of 0 is refused rather than sent as a disarm.
+var commandAliases = map[string]struct {
+	command common.MAV_CMD
+	params  []float64
+}{
+	"arm":      {common.MAV_CMD_COMPONENT_ARM_DISARM, []float64{1}},
+	"disarm":   {common.MAV_CMD_COMPONENT_ARM_DISARM, []float64{0}},
+	"set_mode": {common.MAV_CMD_DO_SET_MODE, []float64{float64(common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED)}},
+	"rtl":      {common.MAV_CMD_NAV_RETURN_TO_LAUNCH, nil},
+	"land":     {common.MAV_CMD_NAV_LAND, nil},
+	"takeoff":  {common.MAV_CMD_NAV_TAKEOFF, nil},
+}
+
+// CommandRequest is a command sent to a drone through the uplink API
+type CommandRequest struct {
+	DroneID         string    `json:"drone_id"`
+	Command         string    `json:"command"`                    // alias (arm, disarm, set_mode, rtl, land, takeoff), MAV_CMD name or number
+	Params          []float64 `json:"params,omitempty"`           // param1..param7
+	Frame           string    `json:"frame,omitempty"`            // MAV_FRAME name, sends COMMAND_INT instead of COMMAND_LONG
+	TargetComponent uint8     `json:"target_component,omitempty"` // defaults to the drone's autopilot
+}
+
+// CommandResult reports the outcome of a command
+type CommandResult struct {
+	DroneID      string `json:"drone_id"`
+	Command      string `json:"command"`
+	Status       string `json:"status"`
+	Result       string `json:"result,omitempty"` // MAV_RESULT of the final COMMAND_ACK
+	Progress     uint8  `json:"progress,omitempty"`
+	ResultParam2 int32  `json:"result_param2,omitempty"`
+	Attempts     int    `json:"attempts"`
+	Error        string `json:"error,omitempty"`
+}
+
+// droneLink records where a drone's autopilot was last heard from
+type droneLink struct {
+	endpoint    string
+	channel     *gomavlib.Channel
+	systemID    uint8
+	componentID uint8
+	autopilot   common.MAV_AUTOPILOT
+	vehicleType common.MAV_TYPE
+}
+
+// messageWriter is the part of a gomavlib node commands are written through
+type messageWriter interface {
+	WriteMessageTo(channel *gomavlib.Channel, m message.Message) error
+}
+
+// commandKey identifies an in-flight command awaiting its COMMAND_ACK
+type commandKey struct {
+	droneID string
+	command common.MAV_CMD
+}
+
+// commandTracker hands COMMAND_ACKs to the requests waiting for them
+type commandTracker struct {
+	mu      sync.Mutex
+	pending map[commandKey]chan *common.MessageCommandAck
+}
+
+func newCommandTracker() *commandTracker {
+	return &commandTracker{pending: make(map[commandKey]chan *common.MessageCommandAck)}
+}
+
+func (t *commandTracker) register(key commandKey) (chan *common.MessageCommandAck, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.pending[key]; ok {
+		return nil, false
+	}
+	acks := make(chan *common.MessageCommandAck, 1)
+	t.pending[key] = acks
+	return acks, true
+}
+
+func (t *commandTracker) unregister(key commandKey) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.pending, key)
+}
+
+// deliver passes an ack to the matching request, dropping it if nobody waits
+func (t *commandTracker) deliver(droneID string, ack *common.MessageCommandAck) {
+	t.mu.Lock()
+	acks, ok := t.pending[commandKey{droneID, ack.Command}]
+	t.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	select {
+	case acks <- ack:
+	default:
+	}
+}
+
+// autopilotHeartbeat returns the message as a heartbeat when it comes from an
+// autopilot rather than a GCS, gimbal or other component
+func autopilotHeartbeat(msg message.Message) (*common.MessageHeartbeat, bool) {
+	heartbeat, ok := msg.(*common.MessageHeartbeat)
+	if !ok || heartbeat.Autopilot == common.MAV_AUTOPILOT_INVALID {
+		return nil, false
+	}
+	return heartbeat, true
+}
+
+// trackDroneLink remembers the link and system a drone's autopilot
+// heartbeats arrive on, so commands can be addressed to it. It reports whether
+// the drone connected or moved to a new link.
+func (r *Relay) trackDroneLink(droneID, endpoint string, evt *gomavlib.EventFrame) bool {
+	heartbeat, ok := autopilotHeartbeat(evt.Message())
+	if !ok {
+		return false
+	}
+
+	link := droneLink{
+		endpoint:    endpoint,
+		channel:     evt.Channel,
+		systemID:    evt.SystemID(),
+		componentID: evt.ComponentID(),
+		autopilot:   heartbeat.Autopilot,
+		vehicleType: heartbeat.Type,
+	}
+	if current, ok := r.droneLinks.Load(droneID); ok && current.(droneLink) == link {
+		return false
+	}
+	r.droneLinks.Store(droneID, link)
+	return true
+}
+
+// resolveCommand turns a request into the MAVLink command and its params
+func resolveCommand(req CommandRequest) (common.MAV_CMD, [7]float64, error) {
+	var params [7]float64
+	if len(req.Params) > len(params) {
+		return 0, params, fmt.Errorf("%w: at most 7 params are allowed", errInvalidCommand)
+	}
+
+	var command common.MAV_CMD
+	if alias, ok := commandAliases[strings.ToLower(req.Command)]; ok {
+		command = alias.command
+		for i, fixed := range alias.params {
+			if i < len(req.Params) && req.Params[i] != fixed {
+				return 0, params, fmt.Errorf("%w: %s fixes param%d to %v", errInvalidCommand, strings.ToLower(req.Command), i+1, fixed)
+			}
+		}
+		copy(params[:], alias.params)
+	} else if err := command.UnmarshalText([]byte(strings.ToUpper(req.Command))); err != nil || req.Command == "" {
+		return 0, params, fmt.Errorf("%w: unknown command %q", errInvalidCommand, req.Command)
+	}
+
+	copy(params[:], req.Params)
+	return command, params, nil
+}
+
+// buildCommandMessage builds the COMMAND_LONG (or COMMAND_INT when a frame is
+// given) for one attempt. COMMAND_LONG retries increment the confirmation
+// field as the protocol requires.
+func buildCommandMessage(req CommandRequest, link droneLink, command common.MAV_CMD, params [7]float64, attempt int) (message.Message, error) {
+	component := req.TargetComponent
+	if component == 0 {
+		component = link.componentID
+	}
+
+	if req.Frame == "" {
+		return &common.MessageCommandLong{
+			TargetSystem:    link.systemID,
+			TargetComponent: component,
+			Command:         command,
+			Confirmation:    uint8(min(attempt, math.MaxUint8)),
+			Param1:          float32(params[0]),
+			Param2:          float32(params[1]),
+			Param3:          float32(params[2]),
+			Param4:          float32(params[3]),
+			Param5:          float32(params[4]),
+			Param6:          float32(params[5]),
+			Param7:          float32(params[6]),
+		}, nil
+	}
+
+	var frame common.MAV_FRAME
+	if err := frame.UnmarshalText([]byte(strings.ToUpper(req.Frame))); err != nil {
+		return nil, fmt.Errorf("%w: unknown frame %q", errInvalidCommand, req.Frame)
+	}
+
+	scale := positionScale(frame)
+	return &common.MessageCommandInt{
+		TargetSystem:    link.systemID,
+		TargetComponent: component,
+		Frame:           frame,
+		Command:         command,
+		Param1:          float32(params[0]),
+		Param2:          float32(params[1]),
+		Param3:          float32(params[2]),
+		Param4:          float32(params[3]),
+		X:               int32(math.Round(params[4] * scale)),
+		Y:               int32(math.Round(params[5] * scale)),
+		Z:               float32(params[6]),
+	}, nil
+}
+
+// positionScale returns the factor between params 5/6 (degrees or meters) and
+// the integer x/y fields of COMMAND_INT and MISSION_ITEM_INT: degrees * 1e7 in
+// global frames, meters * 1e4 in local frames, and raw values in the mission
+// frame.
+func positionScale(frame common.MAV_FRAME) float64 {
+	switch {
+	case strings.HasPrefix(frame.String(), "MAV_FRAME_GLOBAL"):
+		return 1e7
+	case frame == common.MAV_FRAME_MISSION:
+		return 1
+	default:
+		return 1e4
+	}
+}
+
+// droneTarget returns the link and writer used to address a drone
+func (r *Relay) droneTarget(droneID string) (droneLink, messageWriter, error) {
+	value, ok := r.droneLinks.Load(droneID)
+	if !ok {
+		return droneLink{}, nil, fmt.Errorf("%w: %s", errUnknownDrone, droneID)
+	}
+	link := value.(droneLink)
+
+	conn, ok := r.connections.Load(link.endpoint)
+	if !ok {
+		return link, nil, fmt.Errorf("%w: %s", errUnknownDrone, droneID)
+	}
+	writer, ok := conn.(messageWriter)
+	if !ok {
+		return link, nil, fmt.Errorf("%w: endpoint %s cannot send", errCommandSendFailed, link.endpoint)
+	}
+
+	return link, writer, nil
+}
+
+// sendCommand sends a command to a drone and waits for its final COMMAND_ACK,
+// resending while no ack arrives. Every command is recorded to the sinks.
+func (r *Relay) sendCommand(ctx context.Context, req CommandRequest) (CommandResult, error) {
+	result := CommandResult{DroneID: req.DroneID, Command: req.Command}
+
+	command, params, err := resolveCommand(req)
+	if err != nil {
+		return result, err
+	}
+	result.Command = command.String()
+
+	link, writer, err := r.droneTarget(req.DroneID)
+	if err != nil {
+		return result, err
+	}
+
+	key := commandKey{req.DroneID, command}
+	acks, ok := r.commands.register(key)
+	if !ok {
+		return result, fmt.Errorf("%w: %s", errCommandInFlight, command)
+	}
+	defer r.commands.unregister(key)
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.Commands.Timeout)
+	defer cancel()
+
+	var msg message.Message
+	err = r.awaitCommandAck(ctx, acks, &result, func(attempt int) error {
+		built, err := buildCommandMessage(req, link, command, params, attempt)
+		if err != nil {
+			return err
+		}
+		msg = built
+		if err := writer.WriteMessageTo(link.channel, built); err != nil {
+			return fmt.Errorf("%w: %w", errCommandSendFailed, err)
+		}
+		return nil
+	})
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	if msg != nil {
+		r.auditCommand(link, req.DroneID, msg, result)
+	}
+	return result, err
+}
+
+// awaitCommandAck drives the send/retry loop until a final ack arrives
+func (r *Relay) awaitCommandAck(ctx context.Context, acks <-chan *common.MessageCommandAck, result *CommandResult, send func(attempt int) error) error {
+	inProgress := false
+	for attempt := 0; ; attempt++ {
+		if !inProgress {
+			if attempt > *r.config.Commands.Retries {
+				return errCommandTimeout
+			}
+			if err := send(attempt); err != nil {
+				return err
+			}
+			result.Attempts++
+		}
+
+		timer := time.NewTimer(r.config.Commands.AckTimeout)
+		select {
+		case ack := <-acks:
+			timer.Stop()
+			result.Result = ack.Result.String()
+			result.Progress = ack.Progress
+			result.ResultParam2 = ack.ResultParam2
+			if ack.Result != common.MAV_RESULT_IN_PROGRESS {
+				return nil
+			}
+			// the vehicle is working on it; stop resending and wait for the
+			// final ack
+			inProgress = true
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return errCommandTimeout
+		}
+	}
+}
+
+// auditCommand records a sent command and its outcome to the sinks
+func (r *Relay) auditCommand(link droneLink, droneID string, msg message.Message, result CommandResult) {
+	envelope := telemetry.BuildEnvelope(link.endpoint, droneID, msg)
+	envelope.Fields["result"] = result.Result
+	envelope.Fields["attempts"] = result.Attempts
+	if result.Error != "" {
+		envelope.Fields["error"] = result.Error
+	}
+
+	r.handleTelemetryMessage(envelope)
+}
+
+// handleCommandRequest serves POST /api/v1/commands
+func (r *Relay) handleCommandRequest(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodPost {
+		w.Header().Set("Allow", http.MethodPost)
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !r.authorize(w, req) {
+		return
+	}
+
+	var cmd CommandRequest
+	if err := json.NewDecoder(req.Body).Decode(&cmd); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	result, err := r.runCommand(req.Context(), cmd)
+	writeJSON(w, commandHTTPStatus(err), result)
+}
+
+// authorize checks the bearer token shared by the command, mission and
+// parameter APIs, writing a 401 response when it does not match. Requests are
+// refused when no token is configured.
+func (r *Relay) authorize(w http.ResponseWriter, req *http.Request) bool {
+	return checkBearerToken(w, req, r.config.Commands.Token)
+}
+
+// authorizeAdmin checks the admin API bearer token. Requests are refused when
+// no token is configured.
+func (r *Relay) authorizeAdmin(w http.ResponseWriter, req *http.Request) bool {
+	return checkBearerToken(w, req, r.config.Admin.Token)
+}
+
+// checkBearerToken writes a 401 response unless the request carries the
+// token, which must not be empty
+func checkBearerToken(w http.ResponseWriter, req *http.Request, token string) bool {
+	got := strings.TrimPrefix(req.Header.Get("Authorization"), "Bearer ")
+	if token == "" || subtle.ConstantTimeCompare([]byte(got), []byte(token)) != 1 {
+		http.Error(w, `{"error":"unauthorized"}`, http.StatusUnauthorized)
+		return false
+	}
+	return true
+}
+
+// handleSinkCommand runs a command received through a sink subscription. The
+// entity ID from the subject wins over any drone_id in the payload. Commands
+// for drones this relay has never heard from are left unanswered so another
+// relay can own them.
+func (r *Relay) handleSinkCommand(ctx context.Context, entityID string, payload []byte) ([]byte, bool) {
+	var cmd CommandRequest
+	var result CommandResult
+	if err := json.Unmarshal(payload, &cmd); err != nil {
+		result = CommandResult{DroneID: entityID, Status: commandStatusRejected, Error: err.Error()}
+	} else {
+		cmd.DroneID = entityID
+
+		var err error
+		result, err = r.runCommand(ctx, cmd)
+		if errors.Is(err, errUnknownDrone) {
+			return nil, false
+		}
+	}
+
+	data, err := json.Marshal(result)
+	if err != nil {
+		return nil, false
+	}
+	return data, true
+}
+
+// runCommand sends a command and fills in the result status
+func (r *Relay) runCommand(ctx context.Context, cmd CommandRequest) (CommandResult, error) {
+	result, err := r.sendCommand(ctx, cmd)
+	result.Status = commandStatusName(err)
+	if err != nil {
+		slog.LogAttrs(context.Background(), slog.LevelWarn, "command failed",
+			slog.String("drone_id", cmd.DroneID),
+			slog.String("command", result.Command),
+			slog.String("error", err.Error()))
+		result.Error = err.Error()
+	}
+	return result, err
+}
+
+// commandStatusName maps a command error to the reported status
+func commandStatusName(err error) string {
+	switch {
+	case err == nil:
+		return commandStatusAck
+	case errors.Is(err, errCommandTimeout):
+		return commandStatusTimeout
+	case errors.Is(err, errInvalidCommand), errors.Is(err, errUnknownDrone), errors.Is(err, errCommandInFlight):
+		return commandStatusRejected
+	default:
+		return commandStatusError
+	}
+}
+
+// commandHTTPStatus maps a command error to an HTTP status code
+func commandHTTPStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, errInvalidCommand):
+		return http.StatusBadRequest
+	case errors.Is(err, errUnknownDrone):
+		return http.StatusNotFound
+	case errors.Is(err, errCommandInFlight):
+		return http.StatusConflict
+	case errors.Is(err, errCommandTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
@@ -0,0 +1,63 @@
+package relay
+
+import (
+	"strconv"
+	"strings"
+
+	"github.com/makinje/aero-arc-relay/internal/config"
+)
+
+// systemKey identifies a MAVLink system/component pair on a link
+type systemKey struct {
+	systemID    uint8
+	componentID uint8
+}
+
+// systemDemux resolves drone IDs for frames received on a multi mode endpoint,
+// where several vehicles share a single link and are told apart by the
+// MAVLink system (and optionally component) ID in the frame header.
+type systemDemux struct {
+	endpoint string
+	template string
+	systems  map[systemKey]string
+}
+
+// newSystemDemux builds the drone ID lookup table for a multi mode endpoint
+func newSystemDemux(endpoint config.MAVLinkEndpoint) *systemDemux {
+	demux := &systemDemux{
+		endpoint: endpoint.Name,
+		template: endpoint.DroneIDTemplate,
+		systems:  make(map[systemKey]string, len(endpoint.Systems)),
+	}
+
+	for _, system := range endpoint.Systems {
+		demux.systems[systemKey{system.SystemID, system.ComponentID}] = system.DroneID
+	}
+
+	return demux
+}
+
+// resolve returns the drone ID for a system/component pair. An exact
+// system/component mapping wins over a system-wide mapping, which in turn wins
+// over the fallback template. Frames from unknown systems are rejected when no
+// template is configured.
+func (d *systemDemux) resolve(systemID, componentID uint8) (string, bool) {
+	if droneID, ok := d.systems[systemKey{systemID, componentID}]; ok {
+		return droneID, true
+	}
+
+	if droneID, ok := d.systems[systemKey{systemID, 0}]; ok {
+		return droneID, true
+	}
+
+	if d.template == "" {
+		return "", false
+	}
+
+	droneID := d.template
+	droneID = strings.ReplaceAll(droneID, "{endpoint}", d.endpoint)
+	droneID = strings.ReplaceAll(droneID, "{system_id}", strconv.Itoa(int(systemID)))
+	droneID = strings.ReplaceAll(droneID, "{component_id}", strconv.Itoa(int(componentID)))
+
+	return droneID, true
+}
@@ -0,0 +1,259 @@
+package relay
+
+import (
+	"context"
+	"maps"
+	"math"
+	"reflect"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/makinje/aero-arc-relay/internal/config"
+	"github.com/makinje/aero-arc-relay/pkg/telemetry"
+)
+
+// downsampleFlushInterval is how often windows that no newer message closed
+// are checked and forwarded
+const downsampleFlushInterval = 100 * time.Millisecond
+
+// downsampleKey identifies a downsampled message stream
+type downsampleKey struct {
+	endpoint    string
+	droneID     string
+	componentID uint8
+	msgID       uint32
+}
+
+// fieldStats accumulates a numeric field over an aggregate window
+type fieldStats struct {
+	typ           reflect.Type
+	min, max, sum float64
+}
+
+// downsampleWindow is the state of a downsampled stream. every_nth windows
+// only count messages and never close.
+type downsampleWindow struct {
+	rule   config.DownsampleRule
+	end    time.Time                   // when a latest or aggregate window closes
+	count  int                         // messages in the window, or the every_nth position
+	latest telemetry.TelemetryEnvelope // latest message in the window
+	stats  map[string]*fieldStats      // aggregate only: numeric fields
+}
+
+// closed reports whether a latest or aggregate window is over
+func (w *downsampleWindow) closed(now time.Time) bool {
+	return w.rule.Strategy != config.DownsampleEveryNth && !now.Before(w.end)
+}
+
+// aggregate adds the numeric fields of a message to the window. Enums,
+// arrays and time_ fields are not aggregated and keep their latest value.
+func (w *downsampleWindow) aggregate(fields map[string]any) {
+	if w.stats == nil {
+		w.stats = make(map[string]*fieldStats)
+	}
+	for name, value := range fields {
+		if strings.HasPrefix(name, "time_") {
+			continue
+		}
+		v, ok := numericValue(value)
+		if !ok {
+			continue
+		}
+		stats, ok := w.stats[name]
+		if !ok {
+			w.stats[name] = &fieldStats{typ: reflect.TypeOf(value), min: v, max: v, sum: v}
+			continue
+		}
+		stats.min = min(stats.min, v)
+		stats.max = max(stats.max, v)
+		stats.sum += v
+	}
+}
+
+// result returns the message a window forwards when it closes. Aggregated
+// fields hold the average, with the minimum and maximum in <field>_min and
+// <field>_max, all in the field's own type.
+func (w *downsampleWindow) result() telemetry.TelemetryEnvelope {
+	envelope := w.latest
+	if w.rule.Strategy != config.DownsampleAggregate {
+		return envelope
+	}
+
+	fields := maps.Clone(envelope.Fields)
+	for name, stats := range w.stats {
+		fields[name] = numericAs(stats.sum/float64(w.count), stats.typ)
+		fields[name+"_min"] = numericAs(stats.min, stats.typ)
+		fields[name+"_max"] = numericAs(stats.max, stats.typ)
+	}
+	fields["samples"] = w.count
+	envelope.Fields = fields
+	// the raw frame is one of the samples, not the aggregate
+	envelope.Raw = nil
+	return envelope
+}
+
+func numericValue(value any) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	default:
+		return 0, false
+	}
+}
+
+// numericAs converts an aggregated value back to a field's type, rounding for
+// integer fields
+func numericAs(v float64, typ reflect.Type) any {
+	switch typ.Kind() {
+	case reflect.Float32, reflect.Float64:
+	default:
+		v = math.Round(v)
+	}
+	return reflect.ValueOf(v).Convert(typ).Interface()
+}
+
+// downsampler reduces message streams according to their downsampling rules
+type downsampler struct {
+	mu      sync.Mutex
+	windows map[downsampleKey]*downsampleWindow
+}
+
+func newDownsampler() *downsampler {
+	return &downsampler{windows: make(map[downsampleKey]*downsampleWindow)}
+}
+
+// add passes a message through its rule. It returns the messages to forward
+// now, and whether a message was suppressed: skipped by every_nth, replaced
+// by a newer one or merged into an aggregate.
+func (d *downsampler) add(key downsampleKey, rule config.DownsampleRule, envelope telemetry.TelemetryEnvelope, now time.Time) ([]telemetry.TelemetryEnvelope, bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var forward []telemetry.TelemetryEnvelope
+	w, ok := d.windows[key]
+	if ok && (w.rule != rule || w.closed(now)) {
+		// the window closed, or the rule was changed by a reload
+		if w.count > 0 && w.rule.Strategy != config.DownsampleEveryNth {
+			forward = append(forward, w.result())
+		}
+		ok = false
+	}
+	if !ok {
+		w = &downsampleWindow{rule: rule, end: now.Add(rule.Interval)}
+		d.windows[key] = w
+	}
+
+	if rule.Strategy == config.DownsampleEveryNth {
+		first := w.count == 0
+		w.count = (w.count + 1) % rule.Every
+		if first {
+			forward = append(forward, envelope)
+		}
+		return forward, !first
+	}
+
+	suppressed := w.count > 0
+	w.count++
+	w.latest = envelope
+	if rule.Strategy == config.DownsampleAggregate {
+		w.aggregate(envelope.Fields)
+	}
+	return forward, suppressed
+}
+
+// expire returns the results of the windows that closed without a newer
+// message arriving
+func (d *downsampler) expire(now time.Time) []telemetry.TelemetryEnvelope {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var expired []telemetry.TelemetryEnvelope
+	for key, w := range d.windows {
+		if !w.closed(now) {
+			continue
+		}
+		expired = append(expired, w.result())
+		delete(d.windows, key)
+	}
+	return expired
+}
+
+// remove forgets the streams of an endpoint, returning the results of its
+// open windows
+func (d *downsampler) remove(endpoint string) []telemetry.TelemetryEnvelope {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	var pending []telemetry.TelemetryEnvelope
+	for key, w := range d.windows {
+		if key.endpoint != endpoint {
+			continue
+		}
+		if w.rule.Strategy != config.DownsampleEveryNth {
+			pending = append(pending, w.result())
+		}
+		delete(d.windows, key)
+	}
+	return pending
+}
+
+// setEndpointDownsampling installs the downsampling rules of an endpoint,
+// replacing any previous ones
+func (r *Relay) setEndpointDownsampling(endpoint config.MAVLinkEndpoint) {
+	if len(endpoint.DownsampleRules) > 0 {
+		r.downsampleRules.Store(endpoint.Name, endpoint.DownsampleRules)
+		return
+	}
+	r.downsampleRules.Delete(endpoint.Name)
+}
+
+// downsample returns the messages to forward to the sinks once a message
+// passed the downsampling rule of its type, counting the suppressed ones
+func (r *Relay) downsample(endpoint, droneID string, envelope telemetry.TelemetryEnvelope) []telemetry.TelemetryEnvelope {
+	if r.downsampler == nil {
+		return []telemetry.TelemetryEnvelope{envelope}
+	}
+	value, ok := r.downsampleRules.Load(endpoint)
+	if !ok {
+		return []telemetry.TelemetryEnvelope{envelope}
+	}
+	rule, ok := value.(map[uint32]config.DownsampleRule)[envelope.MsgID]
+	if !ok {
+		return []telemetry.TelemetryEnvelope{envelope}
+	}
+
+	key := downsampleKey{endpoint, droneID, envelope.ComponentID, envelope.MsgID}
+	forward, suppressed := r.downsampler.add(key, rule, envelope, time.Now())
+	if suppressed {
+		relayDownsampledMessagesTotal.WithLabelValues(endpoint, envelope.MsgName, string(rule.Strategy)).Inc()
+	}
+	return forward
+}
+
+// flushDownsampled forwards the downsampling windows that closed without a
+// newer message arriving, until the context is done
+func (r *Relay) flushDownsampled(ctx context.Context) {
+	if r.downsampler == nil {
+		return
+	}
+
+	ticker := time.NewTicker(downsampleFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, envelope := range r.downsampler.expire(now) {
+				r.handleTelemetryMessage(envelope)
+			}
+		}
+	}
+}
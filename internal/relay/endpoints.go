@@ -0,0 +1,270 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v2"
+	"github.com/bluenviron/gomavlib/v2/pkg/dialect"
+	"github.com/makinje/aero-arc-relay/internal/config"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+const (
+	endpointRetryMinBackoff = time.Second
+	endpointRetryMaxBackoff = time.Minute
+)
+
+// endpointState is the lifecycle state of a MAVLink endpoint
+type endpointState string
+
+const (
+	endpointStarting endpointState = "starting" // not opened yet
+	endpointUp       endpointState = "up"       // open and processing messages
+	endpointRetrying endpointState = "retrying" // failed to open, waiting for the next attempt
+	endpointFailed   endpointState = "failed"   // cannot be opened with its configuration, not retried
+)
+
+var endpointStates = []endpointState{endpointStarting, endpointUp, endpointRetrying, endpointFailed}
+
+// errEndpointConfig marks endpoint failures that retrying cannot fix
+var errEndpointConfig = errors.New("invalid endpoint configuration")
+
+// newNode opens a gomavlib node, replaced in tests
+var newNode = gomavlib.NewNode
+
+// setEndpointState records the state of an endpoint and exports it as metrics
+func (r *Relay) setEndpointState(endpoint string, state endpointState) {
+	r.endpointStates.Store(endpoint, state)
+	for _, s := range endpointStates {
+		value := 0.0
+		if s == state {
+			value = 1
+		}
+		relayEndpointState.WithLabelValues(endpoint, string(s)).Set(value)
+	}
+}
+
+// endpointStatus returns the state of every configured endpoint
+func (r *Relay) endpointStatus() map[string]endpointState {
+	status := make(map[string]endpointState)
+	r.endpointStates.Range(func(key, value any) bool {
+		status[key.(string)] = value.(endpointState)
+		return true
+	})
+	return status
+}
+
+// startEndpoints opens every configured endpoint in the background. Endpoints
+// that fail to open are retried without holding up the others, and endpoints
+// that failed validation are reported as failed.
+func (r *Relay) startEndpoints(ctx context.Context, d *dialect.Dialect) error {
+	if len(r.config.MAVLink.Endpoints) == 0 {
+		return fmt.Errorf("no MAVLink endpoints configured")
+	}
+
+	for _, endpoint := range r.config.MAVLink.Endpoints {
+		r.launchEndpoint(ctx, endpoint, d)
+	}
+	return nil
+}
+
+// launchEndpoint starts opening an endpoint in the background. The endpoint
+// stops when the context is done or it is removed. An endpoint that failed
+// validation is marked failed and not opened.
+func (r *Relay) launchEndpoint(ctx context.Context, endpoint config.MAVLinkEndpoint, d *dialect.Dialect) {
+	if endpoint.Error != nil {
+		r.setEndpointState(endpoint.Name, endpointFailed)
+		slog.LogAttrs(context.Background(), slog.LevelError, "invalid MAVLink endpoint",
+			slog.String("endpoint", endpoint.Name),
+			slog.String("error", endpoint.Error.Error()))
+		return
+	}
+
+	endpointCtx, cancel := context.WithCancel(ctx)
+	r.endpointCancels.Store(endpoint.Name, cancel)
+	r.setEndpointFilter(endpoint)
+	r.setEndpointDownsampling(endpoint)
+	r.setEndpointState(endpoint.Name, endpointStarting)
+	go r.startEndpoint(endpointCtx, endpoint, d, endpointRetryMinBackoff)
+}
+
+// startEndpoint opens an endpoint, retrying with exponential backoff until it
+// opens or the context is done, then processes its messages
+func (r *Relay) startEndpoint(ctx context.Context, endpoint config.MAVLinkEndpoint, d *dialect.Dialect, backoff time.Duration) {
+	for attempt := 1; ; attempt++ {
+		node, err := r.openNode(endpoint, d)
+		if err == nil {
+			if !r.registerEndpoint(ctx, endpoint, node) {
+				node.Close()
+				return
+			}
+			r.processMessages(ctx, endpoint.Name)
+			return
+		}
+
+		if errors.Is(err, errEndpointConfig) {
+			r.setEndpointState(endpoint.Name, endpointFailed)
+			slog.LogAttrs(context.Background(), slog.LevelError, "MAVLink endpoint failed",
+				slog.String("endpoint", endpoint.Name),
+				slog.String("error", err.Error()))
+			return
+		}
+
+		relayEndpointOpenFailuresTotal.WithLabelValues(endpoint.Name).Inc()
+		r.setEndpointState(endpoint.Name, endpointRetrying)
+		slog.LogAttrs(context.Background(), slog.LevelWarn, "failed to open MAVLink endpoint, retrying",
+			slog.String("endpoint", endpoint.Name),
+			slog.Int("attempt", attempt),
+			slog.Duration("retry_in", backoff),
+			slog.String("error", err.Error()))
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(backoff):
+		}
+		backoff = min(backoff*2, endpointRetryMaxBackoff)
+	}
+}
+
+// openNode opens the gomavlib node of an endpoint
+func (r *Relay) openNode(endpoint config.MAVLinkEndpoint, d *dialect.Dialect) (*endpointNode, error) {
+	endpointConf, err := r.createEndpointConf(endpoint)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", errEndpointConfig, err)
+	}
+	node, err := newNode(nodeConf(endpoint, endpointConf))
+	if err != nil {
+		return nil, fmt.Errorf("failed to create MAVLink node: %w", err)
+	}
+	wrapped, err := newEndpointNode(node, endpoint, d, r.dialectRW)
+	if err != nil {
+		node.Close()
+		return nil, err
+	}
+	return wrapped, nil
+}
+
+// registerEndpoint makes an opened endpoint's node available to the relay. It
+// returns false when the endpoint was removed while it was being opened.
+func (r *Relay) registerEndpoint(ctx context.Context, endpoint config.MAVLinkEndpoint, node *endpointNode) bool {
+	r.endpointsMu.Lock()
+	defer r.endpointsMu.Unlock()
+
+	if ctx.Err() != nil {
+		return false
+	}
+
+	r.connections.Store(endpoint.Name, node)
+	// Store the drone_id (entity_id) mapping for this endpoint
+	switch endpoint.Mode {
+	case config.MAVLinkModeMulti:
+		r.endpointDemuxers.Store(endpoint.Name, newSystemDemux(endpoint))
+	case config.MAVLinkModeRoute:
+		// route mode endpoints have no drone
+	default:
+		r.endpointDroneIDs.Store(endpoint.Name, endpoint.DroneID)
+	}
+	if endpoint.Signing.Verify != config.SigningVerifyOff {
+		r.endpointVerifiers.Store(endpoint.Name, newSignatureVerifier(endpoint.Signing))
+	}
+	if r.router != nil && !endpoint.Routing.Disabled {
+		r.router.addLink(endpoint, node)
+	}
+
+	identity := endpoint.Identity
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "MAVLink endpoint opened",
+		slog.String("endpoint", endpoint.Name),
+		slog.Int("system_id", int(identity.SystemID)),
+		slog.Int("component_id", int(identity.ComponentID)),
+		slog.Int("mavlink_version", identity.Version),
+		slog.Bool("heartbeat", *identity.Heartbeat.Enabled),
+		slog.Float64("heartbeat_rate_hz", identity.Heartbeat.Rate),
+		slog.String("heartbeat_type", identity.Heartbeat.Type.String()),
+		slog.Bool("signing", endpoint.Signing.Sign),
+		slog.String("signature_verify", string(endpoint.Signing.Verify)))
+	r.setEndpointState(endpoint.Name, endpointUp)
+	return true
+}
+
+// stopEndpoint stops an endpoint and forgets everything the relay keeps about
+// it. The caller holds endpointsMu.
+func (r *Relay) stopEndpoint(name string) {
+	if cancel, ok := r.endpointCancels.LoadAndDelete(name); ok {
+		cancel.(context.CancelFunc)()
+	}
+	if conn, ok := r.connections.LoadAndDelete(name); ok {
+		conn.(*endpointNode).Close()
+	}
+
+	r.endpointDroneIDs.Delete(name)
+	r.endpointDemuxers.Delete(name)
+	r.endpointVerifiers.Delete(name)
+	r.endpointFilters.Delete(name)
+	r.downsampleRules.Delete(name)
+	r.endpointStates.Delete(name)
+	relayEndpointState.DeletePartialMatch(prometheus.Labels{"endpoint": name})
+	if r.router != nil {
+		r.router.removeLink(name)
+	}
+	if r.streams != nil {
+		r.streams.setRates(name, nil)
+	}
+	if r.recorder != nil {
+		r.recorder.closeEndpoint(name)
+	}
+	if r.links != nil {
+		r.links.remove(name)
+	}
+	if r.downsampler != nil {
+		for _, envelope := range r.downsampler.remove(name) {
+			r.handleTelemetryMessage(envelope)
+		}
+	}
+
+	// Drones last seen on the endpoint can no longer be reached through it
+	r.droneLinks.Range(func(key, value any) bool {
+		if value.(droneLink).endpoint == name {
+			r.droneLinks.CompareAndDelete(key, value)
+		}
+		return true
+	})
+}
+
+// readyStatus is the /readyz response body
+type readyStatus struct {
+	Status    string                   `json:"status"`
+	Endpoints map[string]endpointState `json:"endpoints"`
+}
+
+// handleReady reports readiness once the sinks are initialized and at least
+// one endpoint is up. Endpoints that are not up make the relay degraded but
+// still ready.
+func (r *Relay) handleReady(w http.ResponseWriter, req *http.Request) {
+	status := readyStatus{Status: "ok", Endpoints: r.endpointStatus()}
+	code := http.StatusOK
+
+	up := 0
+	for _, state := range status.Endpoints {
+		if state == endpointUp {
+			up++
+		}
+	}
+	switch {
+	case !r.ready() || up == 0:
+		status.Status = "not ready"
+		code = http.StatusServiceUnavailable
+	case up < len(status.Endpoints):
+		status.Status = "degraded"
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(status)
+}
@@ -0,0 +1,63 @@
+package relay
+
+import (
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
+	"github.com/makinje/aero-arc-relay/internal/config"
+	"github.com/makinje/aero-arc-relay/pkg/telemetry"
+)
+
+// messageFilter is the resolved ingest filter of an endpoint
+type messageFilter struct {
+	include map[uint32]bool // nil ingests all
+	exclude map[uint32]bool
+}
+
+// newMessageFilter returns the filter for an endpoint, or nil when it
+// ingests everything
+func newMessageFilter(filter config.MessageFilter) *messageFilter {
+	if len(filter.IncludeIDs) == 0 && len(filter.ExcludeIDs) == 0 {
+		return nil
+	}
+
+	f := &messageFilter{exclude: make(map[uint32]bool, len(filter.ExcludeIDs))}
+	if len(filter.IncludeIDs) > 0 {
+		f.include = make(map[uint32]bool, len(filter.IncludeIDs))
+		for _, id := range filter.IncludeIDs {
+			f.include[id] = true
+		}
+	}
+	for _, id := range filter.ExcludeIDs {
+		f.exclude[id] = true
+	}
+	return f
+}
+
+// allows reports whether a message type passes the filter; exclusions win
+func (f *messageFilter) allows(msgID uint32) bool {
+	if f.exclude[msgID] {
+		return false
+	}
+	return f.include == nil || f.include[msgID]
+}
+
+// setEndpointFilter installs the ingest filter of an endpoint, replacing any
+// previous one
+func (r *Relay) setEndpointFilter(endpoint config.MAVLinkEndpoint) {
+	if filter := newMessageFilter(endpoint.Filter); filter != nil {
+		r.endpointFilters.Store(endpoint.Name, filter)
+		return
+	}
+	r.endpointFilters.Delete(endpoint.Name)
+}
+
+// filtered reports whether an endpoint's filter drops a message, counting
+// the dropped ones
+func (r *Relay) filtered(endpoint string, msg message.Message) bool {
+	value, ok := r.endpointFilters.Load(endpoint)
+	if !ok || value.(*messageFilter).allows(msg.GetID()) {
+		return false
+	}
+
+	relayFilteredMessagesTotal.WithLabelValues(endpoint, telemetry.MessageName(msg)).Inc()
+	return true
+}
@@ -58,13 +58,13 @@ func TestRelayIntegration(t *testing.T) {
 
 	// Test that relay can handle messages from multiple sources
 	// Test drone-1 heartbeat
-	relay.handleHeartbeat(&common.MessageHeartbeat{CustomMode: 3}, "drone-1")
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{CustomMode: 3}), "drone-1", nil)
 	// Test drone-2 heartbeat
-	relay.handleHeartbeat(&common.MessageHeartbeat{CustomMode: 4}, "drone-2")
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{CustomMode: 4}), "drone-2", nil)
 	// Test drone-1 position
-	relay.handleGlobalPosition(&common.MessageGlobalPositionInt{Lat: 377749000, Lon: -122419400, Alt: 100500}, "drone-1")
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageGlobalPositionInt{Lat: 377749000, Lon: -122419400, Alt: 100500}), "drone-1", nil)
 	// Test drone-2 position
-	relay.handleGlobalPosition(&common.MessageGlobalPositionInt{Lat: 377750000, Lon: -122419500, Alt: 101000}, "drone-2")
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageGlobalPositionInt{Lat: 377750000, Lon: -122419500, Alt: 101000}), "drone-2", nil)
 
 	// Verify all sinks received all messages
 	for i, sink := range relay.sinks {
@@ -83,23 +83,23 @@ func TestRelayWithRealMAVLinkMessages(t *testing.T) {
 
 	// Simulate a complete flight sequence
 	// Initial heartbeat
-	relay.handleHeartbeat(&common.MessageHeartbeat{CustomMode: 0}, "test-drone") // STABILIZE
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{CustomMode: 0}), "test-drone", nil) // STABILIZE
 	// GPS lock
-	relay.handleGlobalPosition(&common.MessageGlobalPositionInt{Lat: 377749000, Lon: -122419400, Alt: 100500}, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageGlobalPositionInt{Lat: 377749000, Lon: -122419400, Alt: 100500}), "test-drone", nil)
 	// Attitude data
-	relay.handleAttitude(&common.MessageAttitude{Roll: 0.1, Pitch: -0.2, Yaw: 3.14}, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageAttitude{Roll: 0.1, Pitch: -0.2, Yaw: 3.14}), "test-drone", nil)
 	// VFR HUD data
-	relay.handleVfrHud(&common.MessageVfrHud{Groundspeed: 15.2, Alt: 100.5, Heading: 180}, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageVfrHud{Groundspeed: 15.2, Alt: 100.5, Heading: 180}), "test-drone", nil)
 	// System status
-	relay.handleSysStatus(&common.MessageSysStatus{BatteryRemaining: 85, VoltageBattery: 12600}, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageSysStatus{BatteryRemaining: 85, VoltageBattery: 12600}), "test-drone", nil)
 	// Mode change to AUTO
-	relay.handleHeartbeat(&common.MessageHeartbeat{CustomMode: 3}, "test-drone") // AUTO
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{CustomMode: 3}), "test-drone", nil) // AUTO
 	// Mission waypoint
-	relay.handleGlobalPosition(&common.MessageGlobalPositionInt{Lat: 377750000, Lon: -122419500, Alt: 101000}, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageGlobalPositionInt{Lat: 377750000, Lon: -122419500, Alt: 101000}), "test-drone", nil)
 	// Return to launch
-	relay.handleHeartbeat(&common.MessageHeartbeat{CustomMode: 6}, "test-drone") // RTL
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{CustomMode: 6}), "test-drone", nil) // RTL
 	// Landing
-	relay.handleHeartbeat(&common.MessageHeartbeat{CustomMode: 9}, "test-drone") // LAND
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{CustomMode: 9}), "test-drone", nil) // LAND
 
 	expectedMessages := 9
 
@@ -133,11 +133,11 @@ func TestRelayErrorHandling(t *testing.T) {
 
 	// Send a message - one sink should fail, one should succeed
 	heartbeat := &common.MessageHeartbeat{CustomMode: 3}
-	relay.handleHeartbeat(heartbeat, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(heartbeat), "test-drone", nil)
 
 	// The relay should continue to work despite one sink failing
 	position := &common.MessageGlobalPositionInt{Lat: 377749000, Lon: -122419400, Alt: 100500}
-	relay.handleGlobalPosition(position, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(position), "test-drone", nil)
 
 	// Verify the working sink received both messages
 	mockSink := relay.sinks[1].(*mock.MockSink)
@@ -175,7 +175,7 @@ func TestRelayPerformance(t *testing.T) {
 	// Send many messages
 	for i := 0; i < numMessages; i++ {
 		heartbeat := &common.MessageHeartbeat{CustomMode: uint32(i % 10)}
-		relay.handleHeartbeat(heartbeat, "test-drone")
+		relay.handleFrame(context.Background(), newFrameEvent(heartbeat), "test-drone", nil)
 	}
 
 	duration := time.Since(start)
@@ -211,7 +211,7 @@ func TestRelayConcurrentSources(t *testing.T) {
 			source := fmt.Sprintf("drone-%d", id)
 			for i := 0; i < messagesPerSource; i++ {
 				heartbeat := &common.MessageHeartbeat{CustomMode: uint32(i % 10)}
-				relay.handleHeartbeat(heartbeat, source)
+				relay.handleFrame(context.Background(), newFrameEvent(heartbeat), source, nil)
 			}
 			done <- true
 		}(sourceID)
@@ -267,7 +267,7 @@ func TestRelayMessageOrdering(t *testing.T) {
 
 	for _, msg := range messages {
 		heartbeat := &common.MessageHeartbeat{CustomMode: msg.mode}
-		relay.handleHeartbeat(heartbeat, "test-drone")
+		relay.handleFrame(context.Background(), newFrameEvent(heartbeat), "test-drone", nil)
 
 		// Small delay to ensure different timestamps
 		time.Sleep(1 * time.Millisecond)
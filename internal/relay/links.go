@@ -0,0 +1,221 @@
+package relay
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v2"
+	"github.com/makinje/aero-arc-relay/pkg/telemetry"
+	"github.com/prometheus/client_golang/prometheus"
+)
+
+// linkReorderWindow is how far behind the last sequence number a frame may
+// arrive and still count as out of order
+const linkReorderWindow = 16
+
+// linkKey identifies a sender whose frames arrive on an endpoint. Sequence
+// numbers are kept per sender.
+type linkKey struct {
+	endpoint    string
+	systemID    uint8
+	componentID uint8
+}
+
+// linkCounters counts the frames of a link. A frame that arrives late is
+// counted as lost when the gap is seen and as out of order when it arrives.
+type linkCounters struct {
+	received   uint64
+	lost       uint64
+	duplicate  uint64
+	outOfOrder uint64
+}
+
+func (c *linkCounters) add(o linkCounters) {
+	c.received += o.received
+	c.lost += o.lost
+	c.duplicate += o.duplicate
+	c.outOfOrder += o.outOfOrder
+}
+
+// lossPercent returns the share of frames lost on the link
+func (c linkCounters) lossPercent() float64 {
+	if c.received+c.lost == 0 {
+		return 0
+	}
+	return float64(c.lost) / float64(c.received+c.lost) * 100
+}
+
+// linkState is the sequence tracking state of one sender
+type linkState struct {
+	droneID string // empty when the sender maps to no drone
+	lastSeq uint8
+	window  linkCounters // since the last LinkStatus envelope
+
+	received, lost, duplicate, outOfOrder prometheus.Counter
+}
+
+// linkTracker tracks MAVLink sequence numbers to measure link quality
+type linkTracker struct {
+	mu    sync.Mutex
+	links map[linkKey]*linkState
+}
+
+func newLinkTracker() *linkTracker {
+	return &linkTracker{links: make(map[linkKey]*linkState)}
+}
+
+// observe records the sequence number of a frame received from a sender
+func (t *linkTracker) observe(key linkKey, droneID string, seq uint8) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	state, ok := t.links[key]
+	if !ok {
+		labels := []string{key.endpoint, strconv.Itoa(int(key.systemID)), strconv.Itoa(int(key.componentID))}
+		state = &linkState{
+			droneID:    droneID,
+			lastSeq:    seq,
+			received:   relayLinkFramesTotal.WithLabelValues(labels...),
+			lost:       relayLinkLostFramesTotal.WithLabelValues(labels...),
+			duplicate:  relayLinkDuplicateFramesTotal.WithLabelValues(labels...),
+			outOfOrder: relayLinkOutOfOrderFramesTotal.WithLabelValues(labels...),
+		}
+		t.links[key] = state
+		state.window.received++
+		state.received.Inc()
+		return
+	}
+
+	state.droneID = droneID
+	state.window.received++
+	state.received.Inc()
+
+	// Sequence numbers wrap at 256. Frames slightly behind the last one
+	// arrived late; anything further is a jump after lost frames or a sender
+	// restart, and the tracking resyncs to it.
+	gap := seq - state.lastSeq
+	switch {
+	case gap == 0:
+		state.window.duplicate++
+		state.duplicate.Inc()
+	case state.lastSeq-seq <= linkReorderWindow:
+		state.window.outOfOrder++
+		state.outOfOrder.Inc()
+	default:
+		state.window.lost += uint64(gap - 1)
+		state.lost.Add(float64(gap - 1))
+		state.lastSeq = seq
+	}
+}
+
+// remove forgets the senders of an endpoint and deletes their metric series
+func (t *linkTracker) remove(endpoint string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for key := range t.links {
+		if key.endpoint == endpoint {
+			delete(t.links, key)
+		}
+	}
+	labels := prometheus.Labels{"endpoint": endpoint}
+	relayLinkFramesTotal.DeletePartialMatch(labels)
+	relayLinkLostFramesTotal.DeletePartialMatch(labels)
+	relayLinkDuplicateFramesTotal.DeletePartialMatch(labels)
+	relayLinkOutOfOrderFramesTotal.DeletePartialMatch(labels)
+}
+
+// linkStatus is the link quality of a drone on an endpoint over an interval
+type linkStatus struct {
+	endpoint string
+	droneID  string
+	systemID uint8
+	linkCounters
+}
+
+// drain returns the counters of every drone that sent frames since the last
+// call, summed over its components, and starts a new interval
+func (t *linkTracker) drain() []linkStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	type statusKey struct{ endpoint, droneID string }
+	byDrone := make(map[statusKey]*linkStatus)
+	var statuses []*linkStatus
+	for key, state := range t.links {
+		if state.droneID == "" || state.window.received == 0 {
+			continue
+		}
+		sk := statusKey{key.endpoint, state.droneID}
+		status, ok := byDrone[sk]
+		if !ok {
+			status = &linkStatus{endpoint: key.endpoint, droneID: state.droneID, systemID: key.systemID}
+			byDrone[sk] = status
+			statuses = append(statuses, status)
+		}
+		status.add(state.window)
+		state.window = linkCounters{}
+	}
+
+	result := make([]linkStatus, len(statuses))
+	for i, status := range statuses {
+		result[i] = *status
+	}
+	return result
+}
+
+// trackLinkQuality records the sequence number of a frame received on an
+// endpoint. Senders on route mode endpoints have no drone and only show up in
+// the metrics.
+func (r *Relay) trackLinkQuality(endpoint string, evt *gomavlib.EventFrame) {
+	if r.links == nil {
+		return
+	}
+
+	droneID := ""
+	if r.router == nil || !r.router.routeOnly(endpoint) {
+		if id, ok := r.getDroneID(endpoint, evt.SystemID(), evt.ComponentID()); ok {
+			droneID = id
+		}
+	}
+	r.links.observe(linkKey{endpoint, evt.SystemID(), evt.ComponentID()}, droneID, telemetry.FrameSequence(evt.Frame))
+}
+
+// publishLinkStatus sends a LinkStatus envelope per drone and endpoint to the
+// sinks every interval until the context is done
+func (r *Relay) publishLinkStatus(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.sendLinkStatus(interval)
+		}
+	}
+}
+
+// sendLinkStatus sends the link quality measured over the last interval
+func (r *Relay) sendLinkStatus(interval time.Duration) {
+	for _, status := range r.links.drain() {
+		r.handleTelemetryMessage(telemetry.TelemetryEnvelope{
+			DroneID:        status.droneID,
+			Source:         status.endpoint,
+			TimestampRelay: time.Now().UTC(),
+			MsgName:        "LinkStatus",
+			SystemID:       status.systemID,
+			Fields: map[string]any{
+				"received":         status.received,
+				"lost":             status.lost,
+				"duplicate":        status.duplicate,
+				"out_of_order":     status.outOfOrder,
+				"loss_percent":     status.lossPercent(),
+				"interval_seconds": interval.Seconds(),
+			},
+		})
+	}
+}
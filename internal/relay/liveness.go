@@ -0,0 +1,146 @@
+package relay
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/makinje/aero-arc-relay/pkg/telemetry"
+)
+
+// droneLiveness is the last autopilot heartbeat seen from a drone
+type droneLiveness struct {
+	link          droneLink
+	lastHeartbeat time.Time
+	online        bool
+}
+
+// livenessTracker marks drones offline when their autopilot heartbeats stop
+// for longer than the timeout
+type livenessTracker struct {
+	timeout time.Duration
+
+	mu     sync.Mutex
+	drones map[string]*droneLiveness
+}
+
+func newLivenessTracker(timeout time.Duration) *livenessTracker {
+	return &livenessTracker{
+		timeout: timeout,
+		drones:  make(map[string]*droneLiveness),
+	}
+}
+
+// heartbeat records a heartbeat from a drone and reports whether the drone
+// just came online
+func (t *livenessTracker) heartbeat(droneID string, link droneLink, now time.Time) bool {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	drone, ok := t.drones[droneID]
+	if !ok {
+		drone = &droneLiveness{}
+		t.drones[droneID] = drone
+	}
+	drone.link = link
+	drone.lastHeartbeat = now
+	if drone.online {
+		return false
+	}
+	drone.online = true
+	return true
+}
+
+// expire marks the drones whose last heartbeat is older than the timeout as
+// offline and returns them
+func (t *livenessTracker) expire(now time.Time) map[string]droneLiveness {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	expired := make(map[string]droneLiveness)
+	for droneID, drone := range t.drones {
+		if drone.online && now.Sub(drone.lastHeartbeat) > t.timeout {
+			drone.online = false
+			expired[droneID] = *drone
+		}
+	}
+	return expired
+}
+
+// markAlive records an autopilot heartbeat and announces drones coming online
+func (r *Relay) markAlive(droneID string) {
+	value, ok := r.droneLinks.Load(droneID)
+	if !ok || r.liveness == nil {
+		return
+	}
+	link := value.(droneLink)
+
+	now := time.Now().UTC()
+	if !r.liveness.heartbeat(droneID, link, now) {
+		return
+	}
+
+	relayDroneOnline.WithLabelValues(droneID).Set(1)
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "vehicle online",
+		slog.String("drone_id", droneID),
+		slog.String("endpoint", link.endpoint))
+	r.publishLiveness(droneID, droneLiveness{link: link, lastHeartbeat: now, online: true})
+}
+
+// watchLiveness checks for drones that went silent until the context is done
+func (r *Relay) watchLiveness(ctx context.Context) {
+	if r.liveness == nil {
+		return
+	}
+
+	ticker := time.NewTicker(r.liveness.timeout / 4)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			r.checkLiveness(now.UTC())
+		}
+	}
+}
+
+// checkLiveness announces drones whose heartbeats stopped and forgets their
+// links, so a drone that comes back is handled as a new connection
+func (r *Relay) checkLiveness(now time.Time) {
+	for droneID, drone := range r.liveness.expire(now) {
+		r.droneLinks.CompareAndDelete(droneID, drone.link)
+
+		relayDroneOnline.WithLabelValues(droneID).Set(0)
+		slog.LogAttrs(context.Background(), slog.LevelWarn, "vehicle offline",
+			slog.String("drone_id", droneID),
+			slog.String("endpoint", drone.link.endpoint),
+			slog.Time("last_heartbeat", drone.lastHeartbeat))
+		r.publishLiveness(droneID, drone)
+	}
+}
+
+// publishLiveness sends a VehicleOnline or VehicleOffline envelope to the sinks
+func (r *Relay) publishLiveness(droneID string, drone droneLiveness) {
+	msgName := "VehicleOffline"
+	if drone.online {
+		msgName = "VehicleOnline"
+	}
+
+	r.handleTelemetryMessage(telemetry.TelemetryEnvelope{
+		DroneID:        droneID,
+		Source:         drone.link.endpoint,
+		TimestampRelay: time.Now().UTC(),
+		MsgName:        msgName,
+		SystemID:       drone.link.systemID,
+		ComponentID:    drone.link.componentID,
+		Fields: map[string]any{
+			"connected":      drone.online,
+			"last_heartbeat": drone.lastHeartbeat,
+			"autopilot":      drone.link.autopilot.String(),
+			"vehicle_type":   drone.link.vehicleType.String(),
+		},
+	})
+}
@@ -0,0 +1,399 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
+	"github.com/makinje/aero-arc-relay/pkg/telemetry"
+)
+
+var (
+	errInvalidMission  = errors.New("invalid mission plan")
+	errMissionInFlight = errors.New("mission transfer already in progress for drone")
+	errMissionTimeout  = errors.New("timed out waiting for mission protocol reply")
+	errMissionRejected = errors.New("mission rejected by vehicle")
+)
+
+// MissionResult reports the outcome of a mission upload, or of a failed
+// download
+type MissionResult struct {
+	DroneID string `json:"drone_id"`
+	Status  string `json:"status"`           // same statuses as commands
+	Result  string `json:"result,omitempty"` // MAV_MISSION_RESULT of the final MISSION_ACK
+	Items   int    `json:"items"`
+	Error   string `json:"error,omitempty"`
+}
+
+// missionTracker hands mission protocol replies to the transfer running for a
+// drone. Only one transfer per drone may run at a time.
+type missionTracker struct {
+	mu      sync.Mutex
+	pending map[string]chan message.Message
+}
+
+func newMissionTracker() *missionTracker {
+	return &missionTracker{pending: make(map[string]chan message.Message)}
+}
+
+func (t *missionTracker) register(droneID string) (chan message.Message, bool) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if _, ok := t.pending[droneID]; ok {
+		return nil, false
+	}
+	replies := make(chan message.Message, 16)
+	t.pending[droneID] = replies
+	return replies, true
+}
+
+func (t *missionTracker) unregister(droneID string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.pending, droneID)
+}
+
+// deliver passes a reply to the drone's transfer, dropping it if nobody waits
+func (t *missionTracker) deliver(droneID string, msg message.Message) {
+	t.mu.Lock()
+	replies, ok := t.pending[droneID]
+	t.mu.Unlock()
+
+	if !ok {
+		return
+	}
+	select {
+	case replies <- msg:
+	default:
+	}
+}
+
+// isMissionReply reports whether a message is part of the mission protocol
+// for the mission (not fence or rally) plan
+func isMissionReply(msg message.Message) bool {
+	switch m := msg.(type) {
+	case *common.MessageMissionCount:
+		return m.MissionType == common.MAV_MISSION_TYPE_MISSION
+	case *common.MessageMissionItemInt:
+		return m.MissionType == common.MAV_MISSION_TYPE_MISSION
+	case *common.MessageMissionRequestInt:
+		return m.MissionType == common.MAV_MISSION_TYPE_MISSION
+	case *common.MessageMissionRequest:
+		return m.MissionType == common.MAV_MISSION_TYPE_MISSION
+	case *common.MessageMissionAck:
+		return m.MissionType == common.MAV_MISSION_TYPE_MISSION
+	default:
+		return false
+	}
+}
+
+// missionTransfer is one upload or download in progress
+type missionTransfer struct {
+	droneID string
+	link    droneLink
+	writer  messageWriter
+	replies <-chan message.Message
+	retries int
+	timeout time.Duration
+
+	rejection common.MAV_MISSION_RESULT // result of the MISSION_ACK that aborted the transfer
+}
+
+// beginMissionTransfer claims the drone's mission protocol for one transfer.
+// The returned function releases it.
+func (r *Relay) beginMissionTransfer(droneID string) (*missionTransfer, func(), error) {
+	link, writer, err := r.droneTarget(droneID)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	replies, ok := r.missions.register(droneID)
+	if !ok {
+		return nil, nil, fmt.Errorf("%w: %s", errMissionInFlight, droneID)
+	}
+
+	transfer := &missionTransfer{
+		droneID: droneID,
+		link:    link,
+		writer:  writer,
+		replies: replies,
+		retries: *r.config.Missions.Retries,
+		timeout: r.config.Missions.ItemTimeout,
+	}
+	return transfer, func() { r.missions.unregister(droneID) }, nil
+}
+
+// exchange sends a message and waits for a reply accepted by match, resending
+// while none arrives. A MISSION_ACK with an error result aborts the transfer.
+func (t *missionTransfer) exchange(ctx context.Context, msg message.Message, match func(message.Message) bool) error {
+	for attempt := 0; attempt <= t.retries; attempt++ {
+		if err := t.send(msg); err != nil {
+			return err
+		}
+
+		timer := time.NewTimer(t.timeout)
+	wait:
+		for {
+			select {
+			case reply := <-t.replies:
+				if ack, ok := reply.(*common.MessageMissionAck); ok && ack.Type != common.MAV_MISSION_ACCEPTED {
+					timer.Stop()
+					t.rejection = ack.Type
+					return fmt.Errorf("%w: %s", errMissionRejected, ack.Type)
+				}
+				if match(reply) {
+					timer.Stop()
+					return nil
+				}
+			case <-timer.C:
+				break wait
+			case <-ctx.Done():
+				timer.Stop()
+				return errMissionTimeout
+			}
+		}
+	}
+
+	return errMissionTimeout
+}
+
+func (t *missionTransfer) send(msg message.Message) error {
+	if err := t.writer.WriteMessageTo(t.link.channel, msg); err != nil {
+		return fmt.Errorf("%w: %w", errCommandSendFailed, err)
+	}
+	return nil
+}
+
+// downloadMission reads the mission from a drone: MISSION_REQUEST_LIST, then
+// MISSION_REQUEST_INT for every item, closed by MISSION_ACK
+func (r *Relay) downloadMission(ctx context.Context, droneID string) (MissionPlan, error) {
+	transfer, done, err := r.beginMissionTransfer(droneID)
+	if err != nil {
+		return MissionPlan{}, err
+	}
+	defer done()
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.Missions.Timeout)
+	defer cancel()
+
+	link := transfer.link
+	var count uint16
+	err = transfer.exchange(ctx, &common.MessageMissionRequestList{
+		TargetSystem:    link.systemID,
+		TargetComponent: link.componentID,
+		MissionType:     common.MAV_MISSION_TYPE_MISSION,
+	}, func(reply message.Message) bool {
+		msg, ok := reply.(*common.MessageMissionCount)
+		if ok {
+			count = msg.Count
+		}
+		return ok
+	})
+	if err != nil {
+		return MissionPlan{}, err
+	}
+
+	items := make([]*common.MessageMissionItemInt, 0, count)
+	for seq := uint16(0); seq < count; seq++ {
+		err := transfer.exchange(ctx, &common.MessageMissionRequestInt{
+			TargetSystem:    link.systemID,
+			TargetComponent: link.componentID,
+			Seq:             seq,
+			MissionType:     common.MAV_MISSION_TYPE_MISSION,
+		}, func(reply message.Message) bool {
+			item, ok := reply.(*common.MessageMissionItemInt)
+			if !ok || item.Seq != seq {
+				return false
+			}
+			items = append(items, item)
+			return true
+		})
+		if err != nil {
+			return MissionPlan{}, err
+		}
+	}
+
+	// the ack is not answered, so a lost one only makes the vehicle time out
+	transfer.send(&common.MessageMissionAck{
+		TargetSystem:    link.systemID,
+		TargetComponent: link.componentID,
+		Type:            common.MAV_MISSION_ACCEPTED,
+		MissionType:     common.MAV_MISSION_TYPE_MISSION,
+	})
+
+	plan := missionItemsToPlan(items, link)
+	r.publishMission(link, droneID, "download", plan)
+	return plan, nil
+}
+
+// uploadMission writes a plan to a drone: MISSION_COUNT, then a
+// MISSION_ITEM_INT for every item the vehicle requests, until its MISSION_ACK
+func (r *Relay) uploadMission(ctx context.Context, droneID string, plan MissionPlan) (MissionResult, error) {
+	result := MissionResult{DroneID: droneID, Items: len(plan.Mission.Items)}
+
+	transfer, done, err := r.beginMissionTransfer(droneID)
+	if err != nil {
+		return result, err
+	}
+	defer done()
+
+	link := transfer.link
+	items, err := planToMissionItems(plan, link)
+	if err != nil {
+		return result, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, r.config.Missions.Timeout)
+	defer cancel()
+
+	// each reply decides what goes out next: the requested item, or nothing
+	// once the vehicle acknowledges the whole mission
+	var next message.Message = &common.MessageMissionCount{
+		TargetSystem:    link.systemID,
+		TargetComponent: link.componentID,
+		Count:           uint16(len(items)),
+		MissionType:     common.MAV_MISSION_TYPE_MISSION,
+	}
+	for next != nil {
+		err := transfer.exchange(ctx, next, func(reply message.Message) bool {
+			var seq uint16
+			switch msg := reply.(type) {
+			case *common.MessageMissionRequestInt:
+				seq = msg.Seq
+			case *common.MessageMissionRequest:
+				seq = msg.Seq
+			case *common.MessageMissionAck:
+				result.Result = msg.Type.String()
+				next = nil
+				return true
+			default:
+				return false
+			}
+			if int(seq) >= len(items) {
+				return false
+			}
+			next = items[seq]
+			return true
+		})
+		if errors.Is(err, errMissionRejected) {
+			result.Result = transfer.rejection.String()
+		}
+		if err != nil {
+			return result, err
+		}
+	}
+
+	r.publishMission(link, droneID, "upload", plan)
+	return result, nil
+}
+
+// publishMission records a transferred mission to the sinks
+func (r *Relay) publishMission(link droneLink, droneID, direction string, plan MissionPlan) {
+	r.handleTelemetryMessage(telemetry.TelemetryEnvelope{
+		DroneID:        droneID,
+		Source:         link.endpoint,
+		TimestampRelay: time.Now().UTC(),
+		MsgName:        "Mission",
+		SystemID:       link.systemID,
+		ComponentID:    link.componentID,
+		Fields: map[string]any{
+			"direction": direction,
+			"count":     len(plan.Mission.Items),
+			"mission":   plan.Mission,
+		},
+	})
+}
+
+// handleMissionRequest serves /api/v1/missions/{drone_id}: GET downloads the
+// drone's mission as a .plan file, PUT or POST uploads one
+func (r *Relay) handleMissionRequest(w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodPut && req.Method != http.MethodPost {
+		w.Header().Set("Allow", "GET, PUT, POST")
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !r.authorize(w, req) {
+		return
+	}
+
+	droneID := req.PathValue("drone_id")
+	if req.Method == http.MethodGet {
+		plan, err := r.downloadMission(req.Context(), droneID)
+		if err != nil {
+			result := r.missionFailed(droneID, "download", MissionResult{DroneID: droneID}, err)
+			writeJSON(w, missionHTTPStatus(err), result)
+			return
+		}
+		writeJSON(w, http.StatusOK, plan)
+		return
+	}
+
+	var plan MissionPlan
+	if err := json.NewDecoder(req.Body).Decode(&plan); err != nil {
+		writeJSON(w, http.StatusBadRequest, map[string]string{"error": err.Error()})
+		return
+	}
+
+	result, err := r.uploadMission(req.Context(), droneID, plan)
+	if err != nil {
+		result = r.missionFailed(droneID, "upload", result, err)
+	} else {
+		result.Status = commandStatusAck
+	}
+	writeJSON(w, missionHTTPStatus(err), result)
+}
+
+// missionFailed logs a failed transfer and fills in the result status
+func (r *Relay) missionFailed(droneID, direction string, result MissionResult, err error) MissionResult {
+	slog.LogAttrs(context.Background(), slog.LevelWarn, "mission transfer failed",
+		slog.String("drone_id", droneID),
+		slog.String("direction", direction),
+		slog.String("error", err.Error()))
+
+	result.Status = missionStatusName(err)
+	result.Error = err.Error()
+	return result
+}
+
+// missionStatusName maps a mission transfer error to the reported status
+func missionStatusName(err error) string {
+	switch {
+	case errors.Is(err, errMissionTimeout):
+		return commandStatusTimeout
+	case errors.Is(err, errInvalidMission), errors.Is(err, errUnknownDrone),
+		errors.Is(err, errMissionInFlight), errors.Is(err, errMissionRejected):
+		return commandStatusRejected
+	default:
+		return commandStatusError
+	}
+}
+
+// missionHTTPStatus maps a mission transfer error to an HTTP status code
+func missionHTTPStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, errInvalidMission):
+		return http.StatusBadRequest
+	case errors.Is(err, errUnknownDrone):
+		return http.StatusNotFound
+	case errors.Is(err, errMissionInFlight):
+		return http.StatusConflict
+	case errors.Is(err, errMissionRejected):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errMissionTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
@@ -0,0 +1,178 @@
+package relay
+
+import (
+	"crypto/rand"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v2"
+	"github.com/bluenviron/gomavlib/v2/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v2/pkg/frame"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
+	"github.com/makinje/aero-arc-relay/internal/config"
+)
+
+// signatureEpoch is the start of MAVLink 2 signature timestamps
+var signatureEpoch = time.Date(2015, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// endpointNode is the gomavlib node of an endpoint. The node runs without a
+// dialect so received frames keep the bytes they arrived with and the relay
+// decodes them itself. The messages the relay sends are encoded and signed
+// here rather than by gomavlib, which can stamp frames written within 10µs
+// with one signature timestamp, and the HEARTBEAT is sent here since gomavlib
+// takes a zero type as unset and advertises MAV_TYPE_GCS instead of
+// MAV_TYPE_GENERIC.
+type endpointNode struct {
+	node        *gomavlib.Node
+	dialectRW   *dialect.ReadWriter
+	version     int
+	systemID    uint8
+	componentID uint8
+	key         *frame.V2Key // nil when outgoing frames are not signed
+	linkID      uint8
+
+	mu        sync.Mutex
+	sequence  uint8
+	timestamp uint64 // last signature timestamp, kept increasing
+
+	terminate chan struct{}
+	done      chan struct{}
+}
+
+// newEndpointNode wraps an opened node with the endpoint's identity and
+// starts its heartbeat. d may be nil, in which case the relay cannot send.
+func newEndpointNode(node *gomavlib.Node, endpoint config.MAVLinkEndpoint, d *dialect.Dialect, rw *dialect.ReadWriter) (*endpointNode, error) {
+	var linkID [1]byte
+	if _, err := rand.Read(linkID[:]); err != nil {
+		return nil, fmt.Errorf("failed to generate signature link ID: %w", err)
+	}
+
+	identity := endpoint.Identity
+	n := &endpointNode{
+		node:        node,
+		dialectRW:   rw,
+		version:     identity.Version,
+		systemID:    identity.SystemID,
+		componentID: identity.ComponentID,
+		linkID:      linkID[0],
+		terminate:   make(chan struct{}),
+		done:        make(chan struct{}),
+	}
+	if endpoint.Signing.Sign {
+		n.key = endpoint.Signing.Key
+	}
+
+	if d == nil || rw == nil || !*identity.Heartbeat.Enabled {
+		close(n.done)
+		return n, nil
+	}
+	go n.runHeartbeat(identity.Heartbeat, uint8(d.Version))
+	return n, nil
+}
+
+// runHeartbeat sends the relay's HEARTBEAT on every channel until the node
+// is closed
+func (n *endpointNode) runHeartbeat(heartbeat config.HeartbeatConfig, mavlinkVersion uint8) {
+	defer close(n.done)
+
+	ticker := time.NewTicker(heartbeat.Period())
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-n.terminate:
+			return
+		case <-ticker.C:
+			fr, err := n.encode(&common.MessageHeartbeat{
+				Type:           heartbeat.Type,
+				SystemStatus:   common.MAV_STATE_ACTIVE,
+				MavlinkVersion: mavlinkVersion,
+			})
+			if err != nil {
+				continue
+			}
+			n.node.WriteFrameAll(fr)
+		}
+	}
+}
+
+// encode wraps a message into a frame from the endpoint's identity, with the
+// next sequence number and, when signing, a signature
+func (n *endpointNode) encode(m message.Message) (frame.Frame, error) {
+	if n.dialectRW == nil {
+		return nil, fmt.Errorf("dialect is nil")
+	}
+	mp := n.dialectRW.GetMessage(m.GetID())
+	if mp == nil {
+		return nil, fmt.Errorf("message %d is not in the dialect", m.GetID())
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	sequence := n.sequence
+	n.sequence++
+
+	if n.version == 1 {
+		fr := &frame.V1Frame{
+			SequenceID:  sequence,
+			SystemID:    n.systemID,
+			ComponentID: n.componentID,
+			Message:     mp.Write(m, false),
+		}
+		fr.Checksum = fr.GenerateChecksum(mp.CRCExtra())
+		return fr, nil
+	}
+
+	fr := &frame.V2Frame{
+		SequenceID:  sequence,
+		SystemID:    n.systemID,
+		ComponentID: n.componentID,
+		Message:     mp.Write(m, true),
+	}
+	if n.key != nil {
+		// Timestamps must increase per link, even for frames sent within 10µs
+		n.timestamp = max(uint64(time.Since(signatureEpoch))/10000, n.timestamp+1)
+		fr.IncompatibilityFlag |= frame.V2FlagSigned
+		fr.SignatureLinkID = n.linkID
+		fr.SignatureTimestamp = n.timestamp
+	}
+	fr.Checksum = fr.GenerateChecksum(mp.CRCExtra())
+	if n.key != nil {
+		fr.Signature = fr.GenerateSignature(n.key)
+	}
+	return fr, nil
+}
+
+// Events returns the node's events
+func (n *endpointNode) Events() chan gomavlib.Event {
+	return n.node.Events()
+}
+
+// WriteMessageTo encodes a message and writes it to a channel
+func (n *endpointNode) WriteMessageTo(channel *gomavlib.Channel, m message.Message) error {
+	fr, err := n.encode(m)
+	if err != nil {
+		return err
+	}
+	return n.node.WriteFrameTo(channel, fr)
+}
+
+// WriteFrameTo writes a frame to a channel as it is
+func (n *endpointNode) WriteFrameTo(channel *gomavlib.Channel, fr frame.Frame) error {
+	return n.node.WriteFrameTo(channel, fr)
+}
+
+// WriteFrameExcept writes a frame as it is to every channel but one
+func (n *endpointNode) WriteFrameExcept(exceptChannel *gomavlib.Channel, fr frame.Frame) error {
+	return n.node.WriteFrameExcept(exceptChannel, fr)
+}
+
+// Close stops the heartbeat and closes the node
+func (n *endpointNode) Close() {
+	close(n.terminate)
+	<-n.done
+	n.node.Close()
+}
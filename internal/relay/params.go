@@ -0,0 +1,578 @@
+package relay
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"math"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v2"
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
+	"github.com/makinje/aero-arc-relay/pkg/telemetry"
+)
+
+var (
+	errUnknownParam  = errors.New("unknown parameter")
+	errInvalidParam  = errors.New("invalid parameter request")
+	errParamInFlight = errors.New("parameter request already in flight for drone")
+	errParamTimeout  = errors.New("timed out waiting for PARAM_VALUE")
+	errParamRejected = errors.New("parameter value not accepted by vehicle")
+	errParamCount    = errors.New("parameter count exceeds the PARAM_REQUEST_READ index range")
+)
+
+// paramIndexUnknown is the index autopilots send with PARAM_VALUEs that are
+// not part of the list, e.g. the echo of a PARAM_SET
+const paramIndexUnknown = math.MaxUint16
+
+// paramReadBatch is the most PARAM_REQUEST_READs sent per round, so a
+// vehicle missing much of its list is not flooded on a slow link
+const paramReadBatch = 16
+
+// ParamValue is an autopilot parameter
+type ParamValue struct {
+	Name  string  `json:"name"`
+	Value float64 `json:"value"`
+	Type  string  `json:"type"` // MAV_PARAM_TYPE
+	Index uint16  `json:"index"`
+}
+
+// ParamSnapshot is the cached parameter set of a drone
+type ParamSnapshot struct {
+	DroneID  string       `json:"drone_id"`
+	Complete bool         `json:"complete"`
+	Count    int          `json:"count"` // parameters the vehicle reports having
+	Updated  time.Time    `json:"updated"`
+	Params   []ParamValue `json:"params"`
+}
+
+// paramCache holds the parameters received from one drone's autopilot
+type paramCache struct {
+	mu       sync.Mutex
+	values   map[string]ParamValue
+	types    map[string]common.MAV_PARAM_TYPE
+	indices  map[uint16]bool
+	count    int
+	updated  time.Time
+	fetching bool
+	received chan struct{}              // signalled on every PARAM_VALUE
+	sets     map[string]chan ParamValue // PARAM_SETs awaiting their echo, by name
+}
+
+func newParamCache() *paramCache {
+	return &paramCache{
+		values:   make(map[string]ParamValue),
+		types:    make(map[string]common.MAV_PARAM_TYPE),
+		indices:  make(map[uint16]bool),
+		received: make(chan struct{}, 1),
+		sets:     make(map[string]chan ParamValue),
+	}
+}
+
+// paramManager caches the parameters of every drone
+type paramManager struct {
+	mu     sync.Mutex
+	drones map[string]*paramCache
+}
+
+func newParamManager() *paramManager {
+	return &paramManager{drones: make(map[string]*paramCache)}
+}
+
+// cache returns the drone's parameter cache, creating it on first use
+func (m *paramManager) cache(droneID string) *paramCache {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cache, ok := m.drones[droneID]
+	if !ok {
+		cache = newParamCache()
+		m.drones[droneID] = cache
+	}
+	return cache
+}
+
+// lookup returns the drone's parameter cache if it has one
+func (m *paramManager) lookup(droneID string) (*paramCache, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	cache, ok := m.drones[droneID]
+	return cache, ok
+}
+
+// update stores a received PARAM_VALUE and hands it to a PARAM_SET waiting
+// for it
+func (c *paramCache) update(msg *common.MessageParamValue, bytewise bool) {
+	value := ParamValue{
+		Name:  msg.ParamId,
+		Value: decodeParamValue(msg.ParamValue, msg.ParamType, bytewise),
+		Type:  msg.ParamType.String(),
+		Index: msg.ParamIndex,
+	}
+
+	c.mu.Lock()
+	if msg.ParamIndex == paramIndexUnknown {
+		if previous, ok := c.values[value.Name]; ok {
+			value.Index = previous.Index
+		}
+	} else {
+		c.indices[msg.ParamIndex] = true
+		c.count = int(msg.ParamCount)
+	}
+	c.values[value.Name] = value
+	c.types[value.Name] = msg.ParamType
+	c.updated = time.Now().UTC()
+	echo := c.sets[value.Name]
+	c.mu.Unlock()
+
+	select {
+	case c.received <- struct{}{}:
+	default:
+	}
+	if echo != nil {
+		select {
+		case echo <- value:
+		default:
+		}
+	}
+}
+
+// beginFetch clears the cache for a new PARAM_REQUEST_LIST, returning false
+// when a fetch is already running
+func (c *paramCache) beginFetch() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.fetching {
+		return false
+	}
+	c.fetching = true
+	c.values = make(map[string]ParamValue)
+	c.indices = make(map[uint16]bool)
+	c.count = 0
+	return true
+}
+
+func (c *paramCache) endFetch() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.fetching = false
+}
+
+// missing returns the indices not received yet and the parameter count the
+// vehicle reported, 0 while it has not reported one
+func (c *paramCache) missing() ([]uint16, int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.count == 0 {
+		return nil, 0
+	}
+	var missing []uint16
+	for i := 0; i < c.count; i++ {
+		if !c.indices[uint16(i)] {
+			missing = append(missing, uint16(i))
+		}
+	}
+	return missing, c.count
+}
+
+// get returns a cached parameter and its type
+func (c *paramCache) get(name string) (ParamValue, common.MAV_PARAM_TYPE, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	value, ok := c.values[name]
+	return value, c.types[name], ok
+}
+
+// awaitSet registers a PARAM_SET waiting for the echo of a parameter
+func (c *paramCache) awaitSet(name string) (chan ParamValue, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.sets[name]; ok {
+		return nil, false
+	}
+	echo := make(chan ParamValue, 1)
+	c.sets[name] = echo
+	return echo, true
+}
+
+func (c *paramCache) cancelSet(name string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	delete(c.sets, name)
+}
+
+// snapshot returns the cached parameters sorted by name
+func (c *paramCache) snapshot(droneID string) ParamSnapshot {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	snapshot := ParamSnapshot{
+		DroneID:  droneID,
+		Complete: c.count > 0 && len(c.indices) >= c.count,
+		Count:    c.count,
+		Updated:  c.updated,
+		Params:   make([]ParamValue, 0, len(c.values)),
+	}
+	for _, value := range c.values {
+		snapshot.Params = append(snapshot.Params, value)
+	}
+	sort.Slice(snapshot.Params, func(i, j int) bool {
+		return snapshot.Params[i].Name < snapshot.Params[j].Name
+	})
+	return snapshot
+}
+
+// paramBytewise reports whether an autopilot packs integer parameters into
+// the bits of the PARAM_VALUE float (PX4) instead of casting them (ArduPilot)
+func paramBytewise(link droneLink) bool {
+	return link.autopilot == common.MAV_AUTOPILOT_PX4
+}
+
+// decodeParamValue converts the float carried by PARAM_VALUE to the value of
+// a parameter of the given type
+func decodeParamValue(raw float32, paramType common.MAV_PARAM_TYPE, bytewise bool) float64 {
+	if !bytewise {
+		return float64(raw)
+	}
+
+	bits := math.Float32bits(raw)
+	switch paramType {
+	case common.MAV_PARAM_TYPE_UINT8:
+		return float64(uint8(bits))
+	case common.MAV_PARAM_TYPE_INT8:
+		return float64(int8(bits))
+	case common.MAV_PARAM_TYPE_UINT16:
+		return float64(uint16(bits))
+	case common.MAV_PARAM_TYPE_INT16:
+		return float64(int16(bits))
+	case common.MAV_PARAM_TYPE_UINT32:
+		return float64(bits)
+	case common.MAV_PARAM_TYPE_INT32:
+		return float64(int32(bits))
+	default:
+		return float64(raw)
+	}
+}
+
+// encodeParamValue is the inverse of decodeParamValue, used for PARAM_SET
+func encodeParamValue(value float64, paramType common.MAV_PARAM_TYPE, bytewise bool) float32 {
+	if !bytewise {
+		return float32(value)
+	}
+
+	switch paramType {
+	case common.MAV_PARAM_TYPE_INT8, common.MAV_PARAM_TYPE_INT16, common.MAV_PARAM_TYPE_INT32:
+		return math.Float32frombits(uint32(int32(value)))
+	case common.MAV_PARAM_TYPE_UINT8, common.MAV_PARAM_TYPE_UINT16, common.MAV_PARAM_TYPE_UINT32:
+		return math.Float32frombits(uint32(value))
+	default:
+		return float32(value)
+	}
+}
+
+// handleParamValue caches a PARAM_VALUE sent by a drone's autopilot; values
+// from other components are ignored
+func (r *Relay) handleParamValue(droneID string, evt *gomavlib.EventFrame, msg *common.MessageParamValue) {
+	value, ok := r.droneLinks.Load(droneID)
+	if !ok {
+		return
+	}
+	link := value.(droneLink)
+	if evt.SystemID() != link.systemID || evt.ComponentID() != link.componentID {
+		return
+	}
+
+	r.params.cache(droneID).update(msg, paramBytewise(link))
+}
+
+// refreshParams fetches a drone's parameters after it connects, giving up
+// after the configured timeout or when ctx is done
+func (r *Relay) refreshParams(ctx context.Context, droneID string) {
+	ctx, cancel := context.WithTimeout(ctx, r.config.Params.Timeout)
+	defer cancel()
+
+	snapshot, err := r.fetchParams(ctx, droneID)
+	if err != nil {
+		slog.LogAttrs(context.Background(), slog.LevelWarn, "failed to fetch parameters",
+			slog.String("drone_id", droneID),
+			slog.Int("received", len(snapshot.Params)),
+			slog.Int("count", snapshot.Count),
+			slog.String("error", err.Error()))
+		return
+	}
+
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "fetched parameters",
+		slog.String("drone_id", droneID),
+		slog.Int("count", snapshot.Count))
+}
+
+// fetchParams requests a drone's full parameter list and waits until every
+// index has arrived. Whenever the stream goes quiet, up to paramReadBatch
+// missing indices are re-requested one by one with PARAM_REQUEST_READ, or the
+// whole list again if nothing arrived at all.
+func (r *Relay) fetchParams(ctx context.Context, droneID string) (ParamSnapshot, error) {
+	link, writer, err := r.droneTarget(droneID)
+	if err != nil {
+		return ParamSnapshot{DroneID: droneID}, err
+	}
+
+	cache := r.params.cache(droneID)
+	if !cache.beginFetch() {
+		return cache.snapshot(droneID), fmt.Errorf("%w: %s", errParamInFlight, droneID)
+	}
+	defer cache.endFetch()
+
+	send := func(msg message.Message) error {
+		if err := writer.WriteMessageTo(link.channel, msg); err != nil {
+			return fmt.Errorf("%w: %w", errCommandSendFailed, err)
+		}
+		return nil
+	}
+	requestList := &common.MessageParamRequestList{TargetSystem: link.systemID, TargetComponent: link.componentID}
+	if err := send(requestList); err != nil {
+		return cache.snapshot(droneID), err
+	}
+
+	// quiet counts consecutive timeouts without any PARAM_VALUE
+	quiet := 0
+	for {
+		missing, count := cache.missing()
+		if count > math.MaxInt16 {
+			// PARAM_REQUEST_READ indices are int16
+			return cache.snapshot(droneID), fmt.Errorf("%w: %d", errParamCount, count)
+		}
+		counted := count > 0
+		if counted && len(missing) == 0 {
+			break
+		}
+
+		timer := time.NewTimer(r.config.Params.ItemTimeout)
+		select {
+		case <-cache.received:
+			timer.Stop()
+			quiet = 0
+			continue
+		case <-ctx.Done():
+			timer.Stop()
+			return cache.snapshot(droneID), errParamTimeout
+		case <-timer.C:
+		}
+
+		quiet++
+		if quiet > *r.config.Params.Retries {
+			return cache.snapshot(droneID), errParamTimeout
+		}
+		if !counted {
+			if err := send(requestList); err != nil {
+				return cache.snapshot(droneID), err
+			}
+			continue
+		}
+		for _, index := range missing[:min(len(missing), paramReadBatch)] {
+			if err := send(&common.MessageParamRequestRead{
+				TargetSystem:    link.systemID,
+				TargetComponent: link.componentID,
+				ParamIndex:      int16(index),
+			}); err != nil {
+				return cache.snapshot(droneID), err
+			}
+		}
+	}
+
+	snapshot := cache.snapshot(droneID)
+	r.publishParams(link, snapshot)
+	return snapshot, nil
+}
+
+// setParam sends PARAM_SET and waits for the vehicle to echo the parameter
+// back, resending while no echo arrives. The parameter type comes from the
+// cache unless given.
+func (r *Relay) setParam(ctx context.Context, droneID, name string, value float64, typeName string) (ParamValue, error) {
+	if name == "" || len(name) > 16 {
+		return ParamValue{}, fmt.Errorf("%w: parameter names are 1 to 16 characters", errInvalidParam)
+	}
+
+	link, writer, err := r.droneTarget(droneID)
+	if err != nil {
+		return ParamValue{}, err
+	}
+
+	cache := r.params.cache(droneID)
+	_, paramType, known := cache.get(name)
+	if typeName != "" {
+		if err := paramType.UnmarshalText([]byte(strings.ToUpper(typeName))); err != nil {
+			return ParamValue{}, fmt.Errorf("%w: unknown type %q", errInvalidParam, typeName)
+		}
+	} else if !known {
+		return ParamValue{}, fmt.Errorf("%w: %s", errUnknownParam, name)
+	}
+
+	echo, ok := cache.awaitSet(name)
+	if !ok {
+		return ParamValue{}, fmt.Errorf("%w: %s", errParamInFlight, name)
+	}
+	defer cache.cancelSet(name)
+
+	msg := &common.MessageParamSet{
+		TargetSystem:    link.systemID,
+		TargetComponent: link.componentID,
+		ParamId:         name,
+		ParamValue:      encodeParamValue(value, paramType, paramBytewise(link)),
+		ParamType:       paramType,
+	}
+
+	for attempt := 0; attempt <= *r.config.Params.Retries; attempt++ {
+		if err := writer.WriteMessageTo(link.channel, msg); err != nil {
+			return ParamValue{}, fmt.Errorf("%w: %w", errCommandSendFailed, err)
+		}
+
+		timer := time.NewTimer(r.config.Params.ItemTimeout)
+		select {
+		case got := <-echo:
+			timer.Stop()
+			// the vehicle echoes the value it kept, which differs when the
+			// new one was refused or clamped
+			if float32(got.Value) != float32(value) {
+				return got, fmt.Errorf("%w: %s is %v", errParamRejected, name, got.Value)
+			}
+			r.publishParams(link, cache.snapshot(droneID))
+			return got, nil
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return ParamValue{}, errParamTimeout
+		}
+	}
+
+	return ParamValue{}, errParamTimeout
+}
+
+// publishParams records a parameter snapshot to the sinks, so configuration
+// changes can be diffed over time
+func (r *Relay) publishParams(link droneLink, snapshot ParamSnapshot) {
+	values := make(map[string]float64, len(snapshot.Params))
+	for _, param := range snapshot.Params {
+		values[param.Name] = param.Value
+	}
+
+	r.handleTelemetryMessage(telemetry.TelemetryEnvelope{
+		DroneID:        snapshot.DroneID,
+		Source:         link.endpoint,
+		TimestampRelay: time.Now().UTC(),
+		MsgName:        "ParamSnapshot",
+		SystemID:       link.systemID,
+		ComponentID:    link.componentID,
+		Fields: map[string]any{
+			"complete": snapshot.Complete,
+			"count":    snapshot.Count,
+			"params":   values,
+		},
+	})
+}
+
+// handleParamRequest serves /api/v1/params/{drone_id}[/{name}]: GET returns
+// the cached parameters (refetched first with ?refresh=true) or a single one,
+// PUT sets one
+func (r *Relay) handleParamRequest(w http.ResponseWriter, req *http.Request) {
+	droneID, name := req.PathValue("drone_id"), req.PathValue("name")
+
+	allowed := http.MethodGet
+	if name != "" {
+		allowed = "GET, PUT"
+	}
+	if req.Method != http.MethodGet && (req.Method != http.MethodPut || name == "") {
+		w.Header().Set("Allow", allowed)
+		http.Error(w, `{"error":"method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	if !r.authorize(w, req) {
+		return
+	}
+
+	switch {
+	case req.Method == http.MethodPut:
+		var body struct {
+			Value *float64 `json:"value"`
+			Type  string   `json:"type,omitempty"` // MAV_PARAM_TYPE, required for parameters not in the cache
+		}
+		if err := json.NewDecoder(req.Body).Decode(&body); err != nil || body.Value == nil {
+			writeJSON(w, http.StatusBadRequest, map[string]string{"error": "body must be {\"value\": <number>}"})
+			return
+		}
+
+		value, err := r.setParam(req.Context(), droneID, name, *body.Value, body.Type)
+		if err != nil {
+			slog.LogAttrs(context.Background(), slog.LevelWarn, "parameter set failed",
+				slog.String("drone_id", droneID),
+				slog.String("param", name),
+				slog.String("error", err.Error()))
+			writeJSON(w, paramHTTPStatus(err), map[string]any{"error": err.Error(), "param": value})
+			return
+		}
+		writeJSON(w, http.StatusOK, value)
+
+	case name != "":
+		cache, ok := r.params.lookup(droneID)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%s: %s", errUnknownDrone, droneID)})
+			return
+		}
+		value, _, ok := cache.get(name)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%s: %s", errUnknownParam, name)})
+			return
+		}
+		writeJSON(w, http.StatusOK, value)
+
+	case req.URL.Query().Get("refresh") == "true":
+		snapshot, err := r.fetchParams(req.Context(), droneID)
+		if err != nil {
+			writeJSON(w, paramHTTPStatus(err), map[string]any{"error": err.Error(), "snapshot": snapshot})
+			return
+		}
+		writeJSON(w, http.StatusOK, snapshot)
+
+	default:
+		cache, ok := r.params.lookup(droneID)
+		if !ok {
+			writeJSON(w, http.StatusNotFound, map[string]string{"error": fmt.Sprintf("%s: %s", errUnknownDrone, droneID)})
+			return
+		}
+		writeJSON(w, http.StatusOK, cache.snapshot(droneID))
+	}
+}
+
+// paramHTTPStatus maps a parameter error to an HTTP status code
+func paramHTTPStatus(err error) int {
+	switch {
+	case err == nil:
+		return http.StatusOK
+	case errors.Is(err, errInvalidParam):
+		return http.StatusBadRequest
+	case errors.Is(err, errUnknownDrone), errors.Is(err, errUnknownParam):
+		return http.StatusNotFound
+	case errors.Is(err, errParamInFlight):
+		return http.StatusConflict
+	case errors.Is(err, errParamRejected):
+		return http.StatusUnprocessableEntity
+	case errors.Is(err, errParamTimeout):
+		return http.StatusGatewayTimeout
+	default:
+		return http.StatusBadGateway
+	}
+}
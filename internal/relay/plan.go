@@ -0,0 +1,204 @@
+package relay
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+)
+
+// MissionPlan is a QGroundControl .plan file. Only the mission section is
+// transferred to and from vehicles; geofence and rally points are written
+// empty on download and ignored on upload.
+type MissionPlan struct {
+	FileType      string          `json:"fileType"`
+	Version       int             `json:"version"`
+	GroundStation string          `json:"groundStation"`
+	Mission       PlanMission     `json:"mission"`
+	GeoFence      json.RawMessage `json:"geoFence,omitempty"`
+	RallyPoints   json.RawMessage `json:"rallyPoints,omitempty"`
+}
+
+// PlanMission is the mission section of a .plan file
+type PlanMission struct {
+	Version             int        `json:"version"`
+	FirmwareType        int        `json:"firmwareType"` // MAV_AUTOPILOT
+	VehicleType         int        `json:"vehicleType"`  // MAV_TYPE
+	CruiseSpeed         float64    `json:"cruiseSpeed,omitempty"`
+	HoverSpeed          float64    `json:"hoverSpeed,omitempty"`
+	PlannedHomePosition []float64  `json:"plannedHomePosition"` // latitude, longitude, altitude
+	Items               []PlanItem `json:"items"`
+}
+
+// PlanItem is a mission item of a .plan file. Only SimpleItems are supported;
+// null params are sent as NaN, which vehicles read as "unchanged".
+type PlanItem struct {
+	Type            string     `json:"type"`
+	ComplexItemType string     `json:"complexItemType,omitempty"`
+	Command         int        `json:"command"` // MAV_CMD
+	Frame           int        `json:"frame"`   // MAV_FRAME
+	Params          []*float64 `json:"params"`  // param1..param7, latitude and longitude in degrees
+	AutoContinue    bool       `json:"autoContinue"`
+	DoJumpID        int        `json:"doJumpId"`
+}
+
+const (
+	planFileType      = "Plan"
+	planItemSimple    = "SimpleItem"
+	planGroundStation = "aero-arc-relay"
+)
+
+var (
+	emptyGeoFence    = json.RawMessage(`{"circles":[],"polygons":[],"version":2}`)
+	emptyRallyPoints = json.RawMessage(`{"points":[],"version":2}`)
+)
+
+// hasHomeItem reports whether the autopilot keeps the home position as
+// mission item 0, as ArduPilot does
+func hasHomeItem(link droneLink) bool {
+	return link.autopilot == common.MAV_AUTOPILOT_ARDUPILOTMEGA
+}
+
+// planToMissionItems converts a plan to the MISSION_ITEM_INTs uploaded to a
+// drone. ArduPilot vehicles get the planned home position as item 0.
+func planToMissionItems(plan MissionPlan, link droneLink) ([]*common.MessageMissionItemInt, error) {
+	if plan.FileType != planFileType {
+		return nil, fmt.Errorf("%w: fileType must be %q", errInvalidMission, planFileType)
+	}
+
+	var items []*common.MessageMissionItemInt
+	if hasHomeItem(link) {
+		var home [3]float64
+		copy(home[:], plan.Mission.PlannedHomePosition)
+		items = append(items, missionItem(link, 0, common.MAV_FRAME_GLOBAL, common.MAV_CMD_NAV_WAYPOINT,
+			[7]float64{0, 0, 0, 0, home[0], home[1], home[2]}, true))
+	}
+
+	for i, planItem := range plan.Mission.Items {
+		if planItem.Type != planItemSimple {
+			return nil, fmt.Errorf("%w: item %d: %s %s items are not supported", errInvalidMission, i, planItem.Type, planItem.ComplexItemType)
+		}
+		if len(planItem.Params) != 7 {
+			return nil, fmt.Errorf("%w: item %d: expected 7 params, got %d", errInvalidMission, i, len(planItem.Params))
+		}
+		if planItem.Frame < 0 || planItem.Frame > math.MaxUint8 || planItem.Command < 0 || planItem.Command > math.MaxUint16 {
+			return nil, fmt.Errorf("%w: item %d: invalid command or frame", errInvalidMission, i)
+		}
+
+		var params [7]float64
+		for p, value := range planItem.Params {
+			params[p] = math.NaN()
+			if value != nil {
+				params[p] = *value
+			}
+		}
+		items = append(items, missionItem(link, uint16(len(items)), common.MAV_FRAME(planItem.Frame),
+			common.MAV_CMD(planItem.Command), params, planItem.AutoContinue))
+	}
+
+	if len(items) > math.MaxUint16 {
+		return nil, fmt.Errorf("%w: too many items", errInvalidMission)
+	}
+	return items, nil
+}
+
+// missionItem builds one MISSION_ITEM_INT, scaling params 5 and 6 to the
+// integer x/y fields for the frame
+func missionItem(link droneLink, seq uint16, frame common.MAV_FRAME, command common.MAV_CMD, params [7]float64, autoContinue bool) *common.MessageMissionItemInt {
+	scale := positionScale(frame)
+	item := &common.MessageMissionItemInt{
+		TargetSystem:    link.systemID,
+		TargetComponent: link.componentID,
+		Seq:             seq,
+		Frame:           frame,
+		Command:         command,
+		Param1:          float32(params[0]),
+		Param2:          float32(params[1]),
+		Param3:          float32(params[2]),
+		Param4:          float32(params[3]),
+		X:               scaledPosition(params[4], scale),
+		Y:               scaledPosition(params[5], scale),
+		Z:               float32(params[6]),
+		MissionType:     common.MAV_MISSION_TYPE_MISSION,
+	}
+	if autoContinue {
+		item.Autocontinue = 1
+	}
+	return item
+}
+
+// scaledPosition converts a latitude/longitude or local position to its
+// integer field; x and y have no NaN, so unset values become 0
+func scaledPosition(value, scale float64) int32 {
+	if math.IsNaN(value) {
+		return 0
+	}
+	return int32(math.Round(value * scale))
+}
+
+// missionItemsToPlan converts the items downloaded from a drone to a plan.
+// ArduPilot's item 0 becomes the planned home position.
+func missionItemsToPlan(items []*common.MessageMissionItemInt, link droneLink) MissionPlan {
+	mission := PlanMission{
+		Version:             2,
+		FirmwareType:        int(link.autopilot),
+		VehicleType:         int(link.vehicleType),
+		PlannedHomePosition: []float64{0, 0, 0},
+		Items:               []PlanItem{},
+	}
+
+	if hasHomeItem(link) && len(items) > 0 {
+		home := items[0]
+		scale := positionScale(home.Frame)
+		altitude := float64(home.Z)
+		if math.IsNaN(altitude) {
+			altitude = 0
+		}
+		mission.PlannedHomePosition = []float64{float64(home.X) / scale, float64(home.Y) / scale, altitude}
+		items = items[1:]
+	}
+
+	for i, item := range items {
+		mission.Items = append(mission.Items, PlanItem{
+			Type:         planItemSimple,
+			Command:      int(item.Command),
+			Frame:        int(item.Frame),
+			Params:       missionItemParams(item),
+			AutoContinue: item.Autocontinue != 0,
+			DoJumpID:     i + 1,
+		})
+	}
+
+	return MissionPlan{
+		FileType:      planFileType,
+		Version:       1,
+		GroundStation: planGroundStation,
+		Mission:       mission,
+		GeoFence:      emptyGeoFence,
+		RallyPoints:   emptyRallyPoints,
+	}
+}
+
+// missionItemParams returns param1..param7 of an item in plan units, with NaN
+// params as nil
+func missionItemParams(item *common.MessageMissionItemInt) []*float64 {
+	scale := positionScale(item.Frame)
+	values := []float64{
+		float64(item.Param1),
+		float64(item.Param2),
+		float64(item.Param3),
+		float64(item.Param4),
+		float64(item.X) / scale,
+		float64(item.Y) / scale,
+		float64(item.Z),
+	}
+
+	params := make([]*float64, len(values))
+	for i := range values {
+		if !math.IsNaN(values[i]) {
+			params[i] = &values[i]
+		}
+	}
+	return params
+}
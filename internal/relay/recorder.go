@@ -0,0 +1,255 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log/slog"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/makinje/aero-arc-relay/internal/config"
+)
+
+const (
+	recorderFlushInterval = time.Second      // how often buffered frames are written to disk
+	recorderRetryInterval = 10 * time.Second // wait before opening a file again after a failure
+)
+
+// tlogFile is the .tlog file an endpoint is recorded to
+type tlogFile struct {
+	file   *os.File
+	w      *bufio.Writer
+	size   int64
+	opened time.Time
+}
+
+func (f *tlogFile) close() error {
+	flushErr := f.w.Flush()
+	if err := f.file.Close(); err != nil {
+		return err
+	}
+	return flushErr
+}
+
+// tlogRecorder writes the raw frames received on each endpoint to .tlog
+// files: every frame is preceded by the time it was received as a big-endian
+// count of microseconds since the Unix epoch, the format QGroundControl,
+// MAVExplorer and pymavlink read
+type tlogRecorder struct {
+	dir      string
+	maxBytes int64
+	interval time.Duration
+
+	mu      sync.Mutex
+	files   map[string]*tlogFile
+	retryAt map[string]time.Time // endpoints whose file failed to open
+	closed  bool
+}
+
+func newTLogRecorder(cfg config.RecorderConfig) *tlogRecorder {
+	return &tlogRecorder{
+		dir:      cfg.Path,
+		maxBytes: cfg.MaxBytes,
+		interval: cfg.RotationInterval,
+		files:    make(map[string]*tlogFile),
+		retryAt:  make(map[string]time.Time),
+	}
+}
+
+// tlogName returns the file name of a recording started at a time. Files
+// rotated within the same millisecond are numbered.
+func tlogName(endpoint string, started time.Time, n int) string {
+	safe := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.' {
+			return r
+		}
+		return '_'
+	}, endpoint)
+	name := safe + "_" + started.UTC().Format("2006-01-02_15-04-05.000")
+	if n > 0 {
+		name += fmt.Sprintf("-%d", n)
+	}
+	return name + ".tlog"
+}
+
+// write records a frame received on an endpoint, rotating the endpoint's file
+// when it is full or old enough. Frames are dropped while a file cannot be
+// opened.
+func (t *tlogRecorder) write(endpoint string, received time.Time, raw []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.closed {
+		return fmt.Errorf("recorder is closed")
+	}
+	record := int64(8 + len(raw))
+	f, ok := t.files[endpoint]
+	if ok && (t.maxBytes > 0 && f.size > 0 && f.size+record > t.maxBytes ||
+		t.interval > 0 && received.Sub(f.opened) >= t.interval) {
+		delete(t.files, endpoint)
+		if err := f.close(); err != nil {
+			return fmt.Errorf("failed to close tlog file: %w", err)
+		}
+		ok = false
+	}
+	if !ok {
+		if received.Before(t.retryAt[endpoint]) {
+			return fmt.Errorf("tlog file for endpoint %s is unavailable", endpoint)
+		}
+		opened, err := t.open(endpoint, received)
+		if err != nil {
+			t.retryAt[endpoint] = received.Add(recorderRetryInterval)
+			slog.LogAttrs(context.Background(), slog.LevelWarn, "cannot record MAVLink traffic, dropping frames",
+				slog.String("endpoint", endpoint),
+				slog.Duration("retry_in", recorderRetryInterval),
+				slog.String("error", err.Error()))
+			return err
+		}
+		delete(t.retryAt, endpoint)
+		f = opened
+		t.files[endpoint] = f
+	}
+
+	var timestamp [8]byte
+	binary.BigEndian.PutUint64(timestamp[:], uint64(received.UnixMicro()))
+	if _, err := f.w.Write(timestamp[:]); err != nil {
+		return t.fail(endpoint, f, err)
+	}
+	if _, err := f.w.Write(raw); err != nil {
+		return t.fail(endpoint, f, err)
+	}
+	f.size += record
+	return nil
+}
+
+func (t *tlogRecorder) open(endpoint string, started time.Time) (*tlogFile, error) {
+	if err := os.MkdirAll(t.dir, 0o755); err != nil {
+		return nil, fmt.Errorf("failed to create tlog directory: %w", err)
+	}
+	var path string
+	var file *os.File
+	for n := 0; ; n++ {
+		path = filepath.Join(t.dir, tlogName(endpoint, started, n))
+		var err error
+		file, err = os.OpenFile(path, os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o644)
+		if err == nil {
+			break
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			return nil, fmt.Errorf("failed to open tlog file: %w", err)
+		}
+	}
+
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "recording MAVLink traffic",
+		slog.String("endpoint", endpoint),
+		slog.String("path", path))
+	return &tlogFile{file: file, w: bufio.NewWriter(file), opened: started}, nil
+}
+
+// fail closes a file that could not be written, so the next frame opens a
+// new one
+func (t *tlogRecorder) fail(endpoint string, f *tlogFile, err error) error {
+	delete(t.files, endpoint)
+	f.close()
+	return fmt.Errorf("failed to write tlog file: %w", err)
+}
+
+// flush writes the buffered frames of every file to disk
+func (t *tlogRecorder) flush() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for endpoint, f := range t.files {
+		if err := f.w.Flush(); err != nil {
+			slog.LogAttrs(context.Background(), slog.LevelWarn, "failed to flush tlog file",
+				slog.String("endpoint", endpoint),
+				slog.String("error", err.Error()))
+			t.fail(endpoint, f, err)
+		}
+	}
+}
+
+// closeEndpoint closes the file of an endpoint. A later frame starts a new
+// file.
+func (t *tlogRecorder) closeEndpoint(endpoint string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closeFile(endpoint)
+	delete(t.retryAt, endpoint)
+}
+
+// close closes every file and stops recording
+func (t *tlogRecorder) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.closed = true
+	for endpoint := range t.files {
+		t.closeFile(endpoint)
+	}
+}
+
+// closeFile closes the file of an endpoint. The caller holds mu.
+func (t *tlogRecorder) closeFile(endpoint string) {
+	f, ok := t.files[endpoint]
+	if !ok {
+		return
+	}
+	delete(t.files, endpoint)
+	if err := f.close(); err != nil {
+		slog.LogAttrs(context.Background(), slog.LevelWarn, "failed to close tlog file",
+			slog.String("endpoint", endpoint),
+			slog.String("error", err.Error()))
+	}
+}
+
+// recordFrame writes the wire bytes of a received frame to the endpoint's
+// .tlog file. Frames are recorded before signature verification, so rejected
+// ones are kept too; nil bytes count as a dropped frame.
+func (r *Relay) recordFrame(endpoint string, raw []byte) {
+	if r.recorder == nil {
+		return
+	}
+
+	received := time.Now()
+	if raw == nil {
+		relayRecorderDroppedFramesTotal.WithLabelValues(endpoint).Inc()
+		return
+	}
+	if err := r.recorder.write(endpoint, received, raw); err != nil {
+		relayRecorderDroppedFramesTotal.WithLabelValues(endpoint).Inc()
+		slog.LogAttrs(context.Background(), slog.LevelDebug, "failed to record frame",
+			slog.String("endpoint", endpoint),
+			slog.String("error", err.Error()))
+		return
+	}
+	relayRecordedFramesTotal.WithLabelValues(endpoint).Inc()
+}
+
+// flushRecorder writes buffered frames to disk every recorderFlushInterval
+// until the context is done
+func (r *Relay) flushRecorder(ctx context.Context) {
+	if r.recorder == nil {
+		return
+	}
+
+	ticker := time.NewTicker(recorderFlushInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			r.recorder.flush()
+		}
+	}
+}
@@ -16,6 +16,8 @@ import (
 	"github.com/bluenviron/gomavlib/v2"
 	"github.com/bluenviron/gomavlib/v2/pkg/dialect"
 	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v2/pkg/frame"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
 	"github.com/makinje/aero-arc-relay/internal/config"
 	"github.com/makinje/aero-arc-relay/internal/sinks"
 	"github.com/makinje/aero-arc-relay/pkg/telemetry"
@@ -26,11 +28,35 @@ import (
 
 // Relay manages MAVLink connections and data forwarding to sinks
 type Relay struct {
-	config           *config.Config
-	sinks            []sinks.Sink
-	connections      sync.Map // map[string]*gomavlib.Node
-	endpointDroneIDs sync.Map // map[string]string - endpoint name -> drone_id (entity_id)
-	sinksInitialized bool
+	config            *config.Config
+	sinks             []sinks.Sink
+	sinkKinds         map[string]sinks.Sink // config section -> sink created from it
+	sinksMu           sync.RWMutex          // guards sinks, sinkKinds and forwardedMessages, which reloads replace
+	connections       sync.Map              // map[string]*endpointNode
+	endpointDroneIDs  sync.Map              // map[string]string - endpoint name -> drone_id (entity_id)
+	endpointDemuxers  sync.Map              // map[string]*systemDemux - multi mode endpoint name -> system ID resolver
+	endpointVerifiers sync.Map              // map[string]*signatureVerifier - endpoint name -> signature verification
+	endpointStates    sync.Map              // map[string]endpointState - endpoint name -> lifecycle state
+	endpointFilters   sync.Map              // map[string]*messageFilter - endpoint name -> ingest filter
+	downsampleRules   sync.Map              // map[string]map[uint32]config.DownsampleRule - endpoint name -> downsampling rules
+	endpointCancels   sync.Map              // map[string]context.CancelFunc - endpoint name -> stops the endpoint
+	endpointsMu       sync.Mutex            // serializes adding, registering and removing endpoints
+	sinksInitialized  bool
+
+	forwardedMessages map[uint32]bool         // message IDs forwarded to sinks, nil forwards all
+	dialectRW         *dialect.ReadWriter     // decodes and encodes messages of the configured dialect
+	frameEncoder      *telemetry.FrameEncoder // wire bytes of received frames for recordings and envelopes
+	router            *router                 // forwards frames between endpoints, nil when routing is disabled
+	droneLinks        sync.Map                // drone ID -> droneLink, where to send commands
+	commands          *commandTracker         // commands awaiting COMMAND_ACK
+	missions          *missionTracker         // mission transfers awaiting replies
+	params            *paramManager           // cached autopilot parameters, nil when disabled
+	streams           *streamManager          // requested stream rates, nil when no endpoint sets any
+	links             *linkTracker            // sequence number tracking for link quality
+	liveness          *livenessTracker        // online/offline state from heartbeats, nil when disabled
+	statusTexts       *statusTextAssembler    // chunked STATUSTEXT messages being reassembled
+	downsampler       *downsampler            // open downsampling windows
+	recorder          *tlogRecorder           // raw traffic .tlog files, nil when recording is disabled
 }
 
 var (
@@ -39,23 +65,169 @@ var (
 		Help: "Telemetry messages handled by the relay.",
 	}, []string{"source", "message_type"})
 
+	relayRejectedFramesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_rejected_frames_total",
+		Help: "Frames rejected by MAVLink 2 signature verification.",
+	}, []string{"endpoint", "reason"})
+
 	relaySinkWriteErrorsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
 		Name: "aero_relay_sink_errors_total",
 		Help: "Errors returned while forwarding telemetry to sinks.",
 	}, []string{"sink"})
+
+	relayUnmappedFramesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_unmapped_frames_total",
+		Help: "Frames dropped on multi mode endpoints because their system ID has no drone mapping.",
+	}, []string{"endpoint"})
+
+	relaySerialReopensTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_serial_reopens_total",
+		Help: "Serial devices reopened after being unplugged or unavailable.",
+	}, []string{"endpoint"})
+
+	relayRoutedFramesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_routed_frames_total",
+		Help: "Frames forwarded from one endpoint to another by the router.",
+	}, []string{"source", "destination"})
+
+	relayRouteFilteredTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_route_filtered_total",
+		Help: "Frames not forwarded to an endpoint because of its routing rules.",
+	}, []string{"endpoint"})
+
+	relayLinkFramesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_link_frames_total",
+		Help: "Frames received per sender on each endpoint.",
+	}, []string{"endpoint", "system_id", "component_id"})
+
+	relayLinkLostFramesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_link_lost_frames_total",
+		Help: "Frames lost per sender on each endpoint, from gaps in MAVLink sequence numbers.",
+	}, []string{"endpoint", "system_id", "component_id"})
+
+	relayLinkDuplicateFramesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_link_duplicate_frames_total",
+		Help: "Frames received twice in a row with the same MAVLink sequence number.",
+	}, []string{"endpoint", "system_id", "component_id"})
+
+	relayLinkOutOfOrderFramesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_link_out_of_order_frames_total",
+		Help: "Frames received with an older MAVLink sequence number than a previous frame.",
+	}, []string{"endpoint", "system_id", "component_id"})
+
+	relayDroneOnline = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aero_relay_drone_online",
+		Help: "Whether a drone's autopilot heartbeats are arriving (1) or have timed out (0).",
+	}, []string{"drone_id"})
+
+	relayStatusTextsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_status_texts_total",
+		Help: "STATUSTEXT messages received from autopilots, after reassembling chunks.",
+	}, []string{"drone_id", "severity"})
+
+	relayEndpointState = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aero_relay_endpoint_state",
+		Help: "State of each MAVLink endpoint: 1 for its current state (starting, up, retrying, failed), 0 otherwise.",
+	}, []string{"endpoint", "state"})
+
+	relayEndpointOpenFailuresTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_endpoint_open_failures_total",
+		Help: "Failed attempts to open a MAVLink endpoint.",
+	}, []string{"endpoint"})
+
+	relayStreamRateHz = promauto.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "aero_relay_stream_rate_hz",
+		Help: "Observed rate of messages with a configured stream rate.",
+	}, []string{"drone_id", "message_type"})
+
+	relayFilteredMessagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_filtered_messages_total",
+		Help: "Messages dropped at ingest by an endpoint's include/exclude filter.",
+	}, []string{"endpoint", "message_type"})
+
+	relayDownsampledMessagesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_downsampled_messages_total",
+		Help: "Messages suppressed by downsampling: skipped, replaced by a newer message or merged into an aggregate.",
+	}, []string{"endpoint", "message_type", "strategy"})
+
+	relayRecordedFramesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_recorded_frames_total",
+		Help: "Frames written to .tlog files by the recorder.",
+	}, []string{"endpoint"})
+
+	relayRecorderDroppedFramesTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_recorder_dropped_frames_total",
+		Help: "Frames the recorder could not write to a .tlog file.",
+	}, []string{"endpoint"})
+
+	relayConfigReloadsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "aero_relay_config_reloads_total",
+		Help: "Config reloads attempted, by result (success, failure).",
+	}, []string{"result"})
+
+	relayConfigLastReloadSuccess = promauto.NewGauge(prometheus.GaugeOpts{
+		Name: "aero_relay_config_last_reload_successful",
+		Help: "Whether the last config reload succeeded (1) or failed and the previous config is still running (0).",
+	})
 )
 
+// sinkCloseTimeout bounds how long a sink may take to flush and close
+const sinkCloseTimeout = 30 * time.Second
+
 // New creates a new relay instance
 func New(cfg *config.Config) (*Relay, error) {
 	relay := &Relay{
-		config: cfg,
-		sinks:  make([]sinks.Sink, 0),
+		config:      cfg,
+		sinks:       make([]sinks.Sink, 0),
+		commands:    newCommandTracker(),
+		missions:    newMissionTracker(),
+		links:       newLinkTracker(),
+		statusTexts: newStatusTextAssembler(statusTextTimeout),
+		downsampler: newDownsampler(),
+	}
+
+	if cfg.MAVLink.Dialect != nil {
+		dialectRW, err := dialect.NewReadWriter(cfg.MAVLink.Dialect)
+		if err != nil {
+			return nil, fmt.Errorf("failed to initialize dialect: %w", err)
+		}
+		relay.dialectRW = dialectRW
+	}
+	frameEncoder, err := telemetry.NewFrameEncoder(relay.dialectRW)
+	if err != nil {
+		return nil, fmt.Errorf("failed to initialize frame encoder: %w", err)
+	}
+	relay.frameEncoder = frameEncoder
+
+	relay.forwardedMessages = forwardedMessages(cfg.MAVLink.MessageIDs)
+
+	if cfg.MAVLink.Routing.Enabled {
+		relay.router = newRouter()
+	}
+
+	if cfg.Params.Enabled {
+		relay.params = newParamManager()
+	}
+
+	if cfg.Liveness.Enabled != nil && *cfg.Liveness.Enabled {
+		relay.liveness = newLivenessTracker(cfg.Liveness.Timeout)
+	}
+
+	if cfg.Recorder.Enabled {
+		relay.recorder = newTLogRecorder(cfg.Recorder)
+	}
+
+	relay.streams = newStreamManager(cfg.MAVLink.Endpoints, cfg.MAVLink.Dialect)
+	if relay.streams == nil {
+		// endpoints added at runtime or by a reload may request stream rates
+		relay.streams = newEmptyStreamManager(cfg.MAVLink.Dialect)
 	}
 
 	// Initialize sinks
 	if err := relay.initializeSinks(); err != nil {
 		return nil, fmt.Errorf("failed to initialize sinks: %w", err)
 	}
+	relayConfigLastReloadSuccess.Set(1)
 
 	return relay, nil
 }
@@ -64,22 +236,23 @@ func New(cfg *config.Config) (*Relay, error) {
 func (r *Relay) Start(ctx context.Context) error {
 	log.Println("Starting aero-arc-relay...")
 
-	// Initialize MAVLink node with all endpoints
-	processed, errs := r.initializeMAVLinkNode(r.config.MAVLink.Dialect)
-	if len(errs) > 0 {
-		return fmt.Errorf("failed to initialize one or more MAVLink nodes: %v", errs)
+	// Open the MAVLink endpoints; those that fail are retried in the background
+	if err := r.startEndpoints(ctx, r.config.MAVLink.Dialect); err != nil {
+		return err
 	}
 
-	// Start new goroutines for extracting messages from the nodes
-	for _, name := range processed {
-		go func(name string) {
-			r.processMessages(ctx, name)
-		}(name)
-	}
+	r.subscribeCommands(r.sinks)
+	go r.publishLinkStatus(ctx, r.config.Relay.LinkStatusInterval)
+	go r.watchLiveness(ctx)
+	go r.flushStatusTexts(ctx)
+	go r.flushDownsampled(ctx)
+	go r.flushRecorder(ctx)
+	go r.watchConfig(ctx, r.config.Relay.WatchConfig)
 
-	// Wait for context cancellation or signal to shut down
+	// Wait for context cancellation or signal to shut down; SIGHUP reloads
+	// the config
 	signals := make(chan os.Signal, 1)
-	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
 	defer signal.Stop(signals)
 
 	http.Handle("/metrics", promhttp.Handler())
@@ -88,15 +261,23 @@ func (r *Relay) Start(ctx context.Context) error {
 		w.WriteHeader(http.StatusOK)
 		w.Write([]byte(`{"status":"ok"}`))
 	}))
-	http.Handle("/readyz", http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
-		if !r.ready() {
-			http.Error(w, `{"status":"not ready"}`, http.StatusServiceUnavailable)
-			return
-		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		w.Write([]byte(`{"status":"ok"}`))
-	}))
+	http.Handle("/readyz", http.HandlerFunc(r.handleReady))
+
+	if r.config.Commands.Enabled {
+		http.Handle("/api/v1/commands", http.HandlerFunc(r.handleCommandRequest))
+	}
+	if r.config.Missions.Enabled {
+		http.Handle("/api/v1/missions/{drone_id}", http.HandlerFunc(r.handleMissionRequest))
+	}
+	if r.config.Admin.Enabled {
+		admin := r.endpointAdminHandler(ctx)
+		http.Handle("/api/v1/endpoints", admin)
+		http.Handle("/api/v1/endpoints/{name}", admin)
+	}
+	if r.params != nil {
+		http.Handle("/api/v1/params/{drone_id}", http.HandlerFunc(r.handleParamRequest))
+		http.Handle("/api/v1/params/{drone_id}/{name}", http.HandlerFunc(r.handleParamRequest))
+	}
 
 	metricsServer := &http.Server{
 		Addr:    ":2112",
@@ -106,7 +287,7 @@ func (r *Relay) Start(ctx context.Context) error {
 	shutdown := func() {
 		// Close MAVLink connections
 		r.connections.Range(func(key, value any) bool {
-			node, ok := value.(*gomavlib.Node)
+			node, ok := value.(*endpointNode)
 			if !ok {
 				return true
 			}
@@ -114,17 +295,15 @@ func (r *Relay) Start(ctx context.Context) error {
 			node.Close()
 			return true
 		})
+		if r.recorder != nil {
+			r.recorder.close()
+		}
 
 		// Shutdown sinks with timeout
 		baseCtx := context.Background()
-		for _, sink := range r.sinks {
-			sinkCtx, cancel := context.WithTimeout(baseCtx, 30*time.Second)
-			if err := sink.Close(sinkCtx); err != nil {
-				slog.LogAttrs(context.Background(), slog.LevelWarn,
-					"Error closing sink", slog.String("error", err.Error()))
-			}
-			cancel() // Release resources
-		}
+		r.sinksMu.RLock()
+		closeSinks(r.sinks)
+		r.sinksMu.RUnlock()
 
 		// Shutdown HTTP server
 		httpCtx, cancel := context.WithTimeout(baseCtx, 10*time.Second)
@@ -147,6 +326,10 @@ func (r *Relay) Start(ctx context.Context) error {
 	}()
 
 	for signal := range signals {
+		if signal == syscall.SIGHUP {
+			r.reload(ctx)
+			continue
+		}
 		if signal == os.Interrupt || signal == syscall.SIGTERM {
 			log.Println("Received signal to shut down relay...")
 			shutdown()
@@ -157,6 +340,38 @@ func (r *Relay) Start(ctx context.Context) error {
 	return nil
 }
 
+// subscribeCommands lets sinks that can receive commands (NATS) forward them
+// to the vehicles
+func (r *Relay) subscribeCommands(sinkList []sinks.Sink) {
+	for _, sink := range sinkList {
+		source, ok := sink.(sinks.CommandSource)
+		if !ok {
+			continue
+		}
+		if err := source.SubscribeCommands(r.handleSinkCommand); err != nil {
+			slog.LogAttrs(context.Background(), slog.LevelWarn, "failed to subscribe to commands",
+				slog.String("sink", sinkNameForMetrics(sink)),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
+// unsubscribeCommands stops sinks from forwarding commands, before they are
+// replaced by sinks subscribing to the same subjects
+func unsubscribeCommands(sinkList []sinks.Sink) {
+	for _, sink := range sinkList {
+		source, ok := sink.(sinks.CommandSource)
+		if !ok {
+			continue
+		}
+		if err := source.UnsubscribeCommands(); err != nil {
+			slog.LogAttrs(context.Background(), slog.LevelWarn, "failed to unsubscribe from commands",
+				slog.String("sink", sinkNameForMetrics(sink)),
+				slog.String("error", err.Error()))
+		}
+	}
+}
+
 func (r *Relay) ready() bool {
 	return r.sinksInitialized
 }
@@ -170,66 +385,98 @@ func (r *Relay) initializeSinks() error {
 		return fmt.Errorf("failed to create sinks: %w", err)
 	}
 
-	r.sinks = configuredSinks
+	r.sinkKinds = configuredSinks
+	r.sinks = orderedSinks(factory, r.config, configuredSinks)
 	r.sinksInitialized = true
 
 	slog.LogAttrs(context.Background(), slog.LevelInfo, "Sinks initialized", slog.Int("count", len(r.sinks)))
 	return nil
 }
 
-// initializeMAVLinkNode sets up a single MAVLink node with all endpoints
-func (r *Relay) initializeMAVLinkNode(dialect *dialect.Dialect) ([]string, []error) {
-	var errs []error
-	if len(r.config.MAVLink.Endpoints) == 0 {
-		return nil, []error{fmt.Errorf("no MAVLink endpoints configured")}
+// orderedSinks lists sinks created per config section in creation order
+func orderedSinks(factory *sinks.SinkFactory, cfg *config.Config, byKind map[string]sinks.Sink) []sinks.Sink {
+	var ordered []sinks.Sink
+	for _, kind := range factory.ConfiguredKinds(cfg) {
+		if sink, ok := byKind[kind]; ok {
+			ordered = append(ordered, sink)
+		}
 	}
+	return ordered
+}
 
-	// Convert all endpoints to gomavlib endpoint configurations
-	processed := []string{}
-	for _, endpoint := range r.config.MAVLink.Endpoints {
-		endpointConf, err := r.createEndpointConf(endpoint)
-		if err != nil {
-			return nil, []error{fmt.Errorf("failed to create endpoint config for %s: %w", endpoint.Name, err)}
-		}
-		node, err := gomavlib.NewNode(gomavlib.NodeConf{
-			Endpoints:   []gomavlib.EndpointConf{endpointConf},
-			Dialect:     dialect,
-			OutVersion:  gomavlib.V2,
-			OutSystemID: 255,
-		})
-		// TODO handle failures but don't return and jump to the next endpoint.
-		if err != nil {
-			errs = append(errs, fmt.Errorf("failed to create MAVLink node: %w", err))
-			continue
+// closeSinks flushes and closes sinks, giving each up to sinkCloseTimeout
+func closeSinks(sinkList []sinks.Sink) {
+	for _, sink := range sinkList {
+		ctx, cancel := context.WithTimeout(context.Background(), sinkCloseTimeout)
+		if err := sink.Close(ctx); err != nil {
+			slog.LogAttrs(context.Background(), slog.LevelWarn,
+				"Error closing sink", slog.String("error", err.Error()))
 		}
-		r.connections.Store(endpoint.Name, node)
-		// Store the drone_id (entity_id) mapping for this endpoint
-		r.endpointDroneIDs.Store(endpoint.Name, endpoint.DroneID)
-		processed = append(processed, endpoint.Name)
+		cancel() // Release resources
 	}
+}
 
-	return processed, errs
+// nodeConf returns the gomavlib node configuration for an endpoint, carrying
+// the relay identity resolved for it at load time. The node has no dialect, so
+// frames arrive undecoded, and the heartbeat and signing are left to the
+// endpointNode wrapping it.
+func nodeConf(endpoint config.MAVLinkEndpoint, endpointConf gomavlib.EndpointConf) gomavlib.NodeConf {
+	identity := endpoint.Identity
+	version := gomavlib.V2
+	if identity.Version == 1 {
+		version = gomavlib.V1
+	}
+
+	return gomavlib.NodeConf{
+		Endpoints:        []gomavlib.EndpointConf{endpointConf},
+		OutVersion:       version,
+		OutSystemID:      identity.SystemID,
+		OutComponentID:   identity.ComponentID,
+		HeartbeatDisable: true,
+	}
 }
 
 // createEndpointConf converts a config endpoint to gomavlib endpoint configuration
 func (r *Relay) createEndpointConf(endpoint config.MAVLinkEndpoint) (gomavlib.EndpointConf, error) {
 	switch endpoint.Protocol {
 	case config.MAVLinkEndpointProtocolUDP:
-		address := fmt.Sprintf("%s:%d", "0.0.0.0", endpoint.Port)
 		return &gomavlib.EndpointUDPServer{
-			Address: address,
+			Address: endpoint.NetworkAddress(),
 		}, nil
 
 	case config.MAVLinkEndpointProtocolTCP:
-		address := fmt.Sprintf("%s:%d", "0.0.0.0", endpoint.Port)
 		return &gomavlib.EndpointTCPServer{
-			Address: address,
+			Address: endpoint.NetworkAddress(),
+		}, nil
+	case config.MAVLinkEndpointProtocolUDPClient:
+		return &gomavlib.EndpointUDPClient{
+			Address: endpoint.NetworkAddress(),
+		}, nil
+	case config.MAVLinkEndpointProtocolTCPClient:
+		return &gomavlib.EndpointTCPClient{
+			Address: endpoint.NetworkAddress(),
+		}, nil
+	case config.MAVLinkEndpointProtocolUDPBroadcast:
+		return &gomavlib.EndpointUDPBroadcast{
+			BroadcastAddress: endpoint.NetworkAddress(),
+			LocalAddress:     endpoint.LocalAddress,
 		}, nil
 	case config.MAVLinkEndpointProtocolSerial:
-		return &gomavlib.EndpointSerial{
-			Device: fmt.Sprintf("/dev/ttyUSB%d", endpoint.Port),
-			Baud:   endpoint.BaudRate,
+		return &gomavlib.EndpointCustom{
+			ReadWriteCloser: newSerialPort(endpoint.Name, endpoint.SerialDevice(), endpoint.BaudRate),
 		}, nil
+	case config.MAVLinkEndpointProtocolReplay:
+		replay := newReplayFile(endpoint.Name, endpoint.Replay)
+		if err := replay.open(); err != nil {
+			return nil, err
+		}
+		return &gomavlib.EndpointCustom{ReadWriteCloser: replay}, nil
+	case config.MAVLinkEndpointProtocolSim:
+		sim, err := newSimLink(endpoint.Sim)
+		if err != nil {
+			return nil, err
+		}
+		return &gomavlib.EndpointCustom{ReadWriteCloser: sim}, nil
 	default:
 		return nil, fmt.Errorf("%w: %s", config.ErrInvalidProtocol, endpoint.Protocol)
 	}
@@ -243,7 +490,7 @@ func (r *Relay) processMessages(ctx context.Context, endpoint string) {
 		slog.LogAttrs(context.Background(), slog.LevelError, "endpoint connection not found. returning from processMessages", slog.String("endpoint", endpoint))
 		return
 	}
-	node, ok := conn.(*gomavlib.Node)
+	node, ok := conn.(*endpointNode)
 	if !ok {
 		slog.LogAttrs(context.Background(), slog.LevelError, "endpoint connection is not a valid MAVLink node. returning from processMessages", slog.String("endpoint", endpoint))
 		return
@@ -255,16 +502,43 @@ func (r *Relay) processMessages(ctx context.Context, endpoint string) {
 			return
 		default:
 			if frameEvt, ok := evt.(*gomavlib.EventFrame); ok {
-				r.handleFrame(frameEvt, endpoint)
+				// the node has no dialect, so the frame is as received and
+				// is recorded before anything can reject it
+				raw := r.encodeFrame(frameEvt.Frame, endpoint)
+				r.recordFrame(endpoint, raw)
+				decoded, err := r.decodeFrame(frameEvt)
+				if err != nil {
+					slog.LogAttrs(context.Background(), slog.LevelWarn, "MAVLink parse error",
+						slog.String("endpoint", endpoint),
+						slog.String("error", err.Error()))
+					continue
+				}
+				if !r.verifySignature(endpoint, frameEvt) {
+					continue
+				}
+				r.trackLinkQuality(endpoint, decoded)
+				if r.router != nil {
+					r.router.route(endpoint, decoded, frameEvt.Frame)
+					if r.router.routeOnly(endpoint) {
+						continue
+					}
+				}
+				r.handleFrame(ctx, decoded, endpoint, raw)
 				continue
 			}
 
-			if _, ok := evt.(*gomavlib.EventChannelOpen); ok {
+			if openEvt, ok := evt.(*gomavlib.EventChannelOpen); ok {
+				if r.router != nil {
+					r.router.openChannel(endpoint, openEvt.Channel)
+				}
 				slog.LogAttrs(context.Background(), slog.LevelInfo, "channel open for endpoint", slog.String("endpoint", endpoint))
 				continue
 			}
 
-			if _, ok := evt.(*gomavlib.EventChannelClose); ok {
+			if closeEvt, ok := evt.(*gomavlib.EventChannelClose); ok {
+				if r.router != nil {
+					r.router.closeChannel(closeEvt.Channel)
+				}
 				slog.LogAttrs(context.Background(), slog.LevelInfo, "channel closed for endpoint", slog.String("endpoint", endpoint))
 				continue
 			}
@@ -281,134 +555,171 @@ func (r *Relay) processMessages(ctx context.Context, endpoint string) {
 	}
 }
 
-// getDroneID returns the configured drone_id (entity_id) for an endpoint name
-func (r *Relay) getDroneID(endpointName string) string {
+// getDroneID returns the drone_id (entity_id) for a frame received on an
+// endpoint. Multi mode endpoints resolve it from the frame's system and
+// component IDs; false means the frame belongs to no known drone.
+func (r *Relay) getDroneID(endpointName string, systemID, componentID uint8) (string, bool) {
+	if demux, ok := r.endpointDemuxers.Load(endpointName); ok {
+		return demux.(*systemDemux).resolve(systemID, componentID)
+	}
 	if droneID, ok := r.endpointDroneIDs.Load(endpointName); ok {
-		return droneID.(string)
+		return droneID.(string), true
 	}
 	// Fallback to endpoint name if not found (shouldn't happen in 1:1 mode)
-	return endpointName
+	return endpointName, true
 }
 
-// handleFrame processes a MAVLink frame
-func (r *Relay) handleFrame(evt *gomavlib.EventFrame, endpoint string) {
-	// Get the configured drone_id (entity_id) for this endpoint
-	droneID := r.getDroneID(endpoint)
-
-	// Determine source endpoint name from the frame
-	switch msg := evt.Frame.GetMessage().(type) {
-	case *common.MessageHeartbeat:
-		r.handleHeartbeat(msg, endpoint, droneID)
-	case *common.MessageGlobalPositionInt:
-		r.handleGlobalPosition(msg, endpoint, droneID)
-	case *common.MessageAttitude:
-		r.handleAttitude(msg, endpoint, droneID)
-	case *common.MessageVfrHud:
-		r.handleVfrHud(msg, endpoint, droneID)
-	case *common.MessageSysStatus:
-		r.handleSysStatus(msg, endpoint, droneID)
+// handleFrame processes a MAVLink frame. raw holds the frame's wire bytes for
+// the envelopes, nil leaves them out. ctx is the endpoint's run context, which
+// bounds the work started for the drone.
+func (r *Relay) handleFrame(ctx context.Context, evt *gomavlib.EventFrame, endpoint string, raw []byte) {
+	// Get the drone_id (entity_id) this frame belongs to
+	droneID, ok := r.getDroneID(endpoint, evt.SystemID(), evt.ComponentID())
+	if !ok {
+		relayUnmappedFramesTotal.WithLabelValues(endpoint).Inc()
+		return
 	}
-}
 
-// handleHeartbeat processes heartbeat messages
-func (r *Relay) handleHeartbeat(msg *common.MessageHeartbeat, endpoint string, droneID string) {
-	envelope := telemetry.BuildHeartbeatEnvelope(endpoint, droneID, msg)
-	r.handleTelemetryMessage(envelope)
-}
+	// messages outside the dialect stay undecoded and have no fields to use
+	msg := evt.Message()
+	if _, ok := msg.(*message.MessageRaw); ok {
+		return
+	}
+
+	// Filtered messages only reach the relay's own protocol handling:
+	// heartbeats for link tracking and liveness, the stream rate check, and
+	// the COMMAND_ACKs, mission replies and PARAM_VALUEs requests wait on.
+	filtered := r.filtered(endpoint, msg)
+
+	connected := r.trackDroneLink(droneID, endpoint, evt)
+	if _, ok := autopilotHeartbeat(evt.Message()); ok {
+		r.markAlive(droneID)
+	}
+	if connected && r.params != nil {
+		go r.refreshParams(ctx, droneID)
+	}
+	if connected && r.streams != nil {
+		go r.applyStreams(droneID, endpoint)
+	}
+
+	if r.streams != nil {
+		r.streams.observe(endpoint, droneID, msg.GetID())
+	}
+	if ack, ok := msg.(*common.MessageCommandAck); ok && r.commands != nil {
+		r.commands.deliver(droneID, ack)
+	}
+	if r.missions != nil && isMissionReply(msg) {
+		r.missions.deliver(droneID, msg)
+	}
+	if value, ok := msg.(*common.MessageParamValue); ok && r.params != nil {
+		r.handleParamValue(droneID, evt, value)
+	}
+	if filtered {
+		return
+	}
+
+	if text, ok := msg.(*common.MessageStatustext); ok && r.statusTexts != nil {
+		r.handleStatusText(droneID, endpoint, evt.SystemID(), evt.ComponentID(), text)
+	}
+	if !r.shouldForward(msg.GetID()) {
+		return
+	}
 
-// handleGlobalPosition processes global position messages
-func (r *Relay) handleGlobalPosition(msg *common.MessageGlobalPositionInt, endpoint string, droneID string) {
-	envelope := telemetry.BuildGlobalPositionIntEnvelope(endpoint, droneID, msg)
-	r.handleTelemetryMessage(envelope)
+	envelope := telemetry.BuildEnvelope(endpoint, droneID, msg)
+	envelope.SetFrame(evt.Frame, raw)
+	for _, envelope := range r.downsample(endpoint, droneID, envelope) {
+		r.handleTelemetryMessage(envelope)
+	}
 }
 
-// handleAttitude processes attitude messages
-func (r *Relay) handleAttitude(msg *common.MessageAttitude, endpoint string, droneID string) {
-	envelope := telemetry.BuildAttitudeEnvelope(endpoint, droneID, msg)
-	r.handleTelemetryMessage(envelope)
+// decodeFrame decodes the message of a frame received undecoded, checking its
+// checksum the way gomavlib does with a dialect. Messages outside the dialect
+// stay raw.
+func (r *Relay) decodeFrame(evt *gomavlib.EventFrame) (*gomavlib.EventFrame, error) {
+	raw, ok := evt.Message().(*message.MessageRaw)
+	if !ok || r.dialectRW == nil {
+		return evt, nil
+	}
+	mp := r.dialectRW.GetMessage(raw.ID)
+	if mp == nil {
+		return evt, nil
+	}
+
+	if sum := evt.Frame.GenerateChecksum(mp.CRCExtra()); sum != evt.Frame.GetChecksum() {
+		return nil, fmt.Errorf("wrong checksum, expected %.4x, got %.4x, message id is %d",
+			sum, evt.Frame.GetChecksum(), raw.ID)
+	}
+	_, isV2 := evt.Frame.(*frame.V2Frame)
+	msg, err := mp.Read(raw, isV2)
+	if err != nil {
+		return nil, fmt.Errorf("unable to decode message: %w", err)
+	}
+
+	// the received frame stays raw for recording, routing and verification
+	var fr frame.Frame
+	switch f := evt.Frame.(type) {
+	case *frame.V1Frame:
+		c := *f
+		c.Message = msg
+		fr = &c
+	case *frame.V2Frame:
+		c := *f
+		c.Message = msg
+		fr = &c
+	}
+	return &gomavlib.EventFrame{Frame: fr, Channel: evt.Channel}, nil
 }
 
-// handleVfrHud processes VFR HUD messages
-func (r *Relay) handleVfrHud(msg *common.MessageVfrHud, endpoint string, droneID string) {
-	envelope := telemetry.BuildVfrHudEnvelope(endpoint, droneID, msg)
-	r.handleTelemetryMessage(envelope)
+// encodeFrame returns the wire bytes of a received frame, or nil when the
+// frame cannot be encoded
+func (r *Relay) encodeFrame(fr frame.Frame, endpoint string) []byte {
+	if r.frameEncoder == nil {
+		return nil
+	}
+
+	raw, err := r.frameEncoder.Encode(fr)
+	if err != nil {
+		slog.LogAttrs(context.Background(), slog.LevelDebug, "failed to encode raw frame",
+			slog.String("endpoint", endpoint),
+			slog.String("error", err.Error()))
+		return nil
+	}
+
+	return raw
 }
 
-// handleSysStatus processes system status messages
-func (r *Relay) handleSysStatus(msg *common.MessageSysStatus, endpoint string, droneID string) {
-	envelope := telemetry.BuildSysStatusEnvelope(endpoint, droneID, msg)
-	r.handleTelemetryMessage(envelope)
+// forwardedMessages returns the set of message IDs forwarded to sinks, nil
+// when all are
+func forwardedMessages(ids []uint32) map[uint32]bool {
+	if len(ids) == 0 {
+		return nil
+	}
+	forwarded := make(map[uint32]bool, len(ids))
+	for _, id := range ids {
+		forwarded[id] = true
+	}
+	return forwarded
 }
 
-// getFlightMode converts custom mode to flight mode string
-func (r *Relay) getFlightMode(customMode uint32) string {
-	// This is a simplified mapping - in practice, you'd need to check
-	// the specific autopilot type and mode definitions
-	switch customMode {
-	case 0:
-		return "STABILIZE"
-	case 1:
-		return "ACRO"
-	case 2:
-		return "ALT_HOLD"
-	case 3:
-		return "AUTO"
-	case 4:
-		return "GUIDED"
-	case 5:
-		return "LOITER"
-	case 6:
-		return "RTL"
-	case 7:
-		return "CIRCLE"
-	case 8:
-		return "POSITION"
-	case 9:
-		return "LAND"
-	case 10:
-		return "OF_LOITER"
-	case 11:
-		return "DRIFT"
-	case 13:
-		return "SPORT"
-	case 14:
-		return "FLIP"
-	case 15:
-		return "AUTOTUNE"
-	case 16:
-		return "POSHOLD"
-	case 17:
-		return "BRAKE"
-	case 18:
-		return "THROW"
-	case 19:
-		return "AVOID_ADSB"
-	case 20:
-		return "GUIDED_NOGPS"
-	case 21:
-		return "SMART_RTL"
-	case 22:
-		return "FLOWHOLD"
-	case 23:
-		return "FOLLOW"
-	case 24:
-		return "ZIGZAG"
-	case 25:
-		return "SYSTEMID"
-	case 26:
-		return "AUTOROTATE"
-	case 27:
-		return "AUTO_RTL"
-	default:
-		return "UNKNOWN"
+// shouldForward reports whether a message type is configured to reach the sinks
+func (r *Relay) shouldForward(msgID uint32) bool {
+	r.sinksMu.RLock()
+	defer r.sinksMu.RUnlock()
+
+	if r.forwardedMessages == nil {
+		return true
 	}
+	return r.forwardedMessages[msgID]
 }
 
 // handleTelemetryMessage processes incoming telemetry messages
 func (r *Relay) handleTelemetryMessage(msg telemetry.TelemetryEnvelope) {
 	relayMessagesTotal.WithLabelValues(msg.DroneID, msg.MsgName).Inc()
 
-	// Forward to all sinks
+	// Forward to all sinks; a reload waits for writes in progress before
+	// closing the sinks it replaces
+	r.sinksMu.RLock()
+	defer r.sinksMu.RUnlock()
 	for _, sink := range r.sinks {
 		if err := sink.WriteMessage(msg); err != nil {
 			relaySinkWriteErrorsTotal.WithLabelValues(sinkNameForMetrics(sink)).Inc()
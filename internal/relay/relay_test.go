@@ -1,17 +1,49 @@
 package relay
 
 import (
+	"bufio"
+	"bytes"
 	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
 	"testing"
 	"time"
 
+	"github.com/bluenviron/gomavlib/v2"
+	"github.com/bluenviron/gomavlib/v2/pkg/dialect"
 	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v2/pkg/frame"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
 	"github.com/makinje/aero-arc-relay/internal/config"
 	"github.com/makinje/aero-arc-relay/internal/mock"
 	"github.com/makinje/aero-arc-relay/internal/sinks"
 	"github.com/makinje/aero-arc-relay/pkg/telemetry"
 )
 
+// newFrameEvent wraps a message in a frame event as it would arrive from gomavlib
+func newFrameEvent(msg message.Message) *gomavlib.EventFrame {
+	return &gomavlib.EventFrame{
+		Frame: &frame.V2Frame{
+			SystemID:    1,
+			ComponentID: 1,
+			Message:     msg,
+		},
+	}
+}
+
 // TestRelayCreation tests the creation of a new relay instance
 func TestRelayCreation(t *testing.T) {
 	cfg := &config.Config{
@@ -95,7 +127,7 @@ func TestRelayWithMockSink(t *testing.T) {
 	}
 
 	// Test message handling
-	heartbeatMsg := telemetry.BuildHeartbeatEnvelope("test-drone", &common.MessageHeartbeat{
+	heartbeatMsg := telemetry.BuildHeartbeatEnvelope("test-drone", "test-drone", &common.MessageHeartbeat{
 		CustomMode: 3,
 	})
 
@@ -116,52 +148,6 @@ func TestRelayWithMockSink(t *testing.T) {
 	}
 }
 
-// TestFlightModeConversion tests the flight mode conversion function
-func TestFlightModeConversion(t *testing.T) {
-	relay := &Relay{}
-
-	testCases := []struct {
-		mode     uint32
-		expected string
-	}{
-		{0, "STABILIZE"},
-		{1, "ACRO"},
-		{2, "ALT_HOLD"},
-		{3, "AUTO"},
-		{4, "GUIDED"},
-		{5, "LOITER"},
-		{6, "RTL"},
-		{7, "CIRCLE"},
-		{8, "POSITION"},
-		{9, "LAND"},
-		{10, "OF_LOITER"},
-		{11, "DRIFT"},
-		{13, "SPORT"},
-		{14, "FLIP"},
-		{15, "AUTOTUNE"},
-		{16, "POSHOLD"},
-		{17, "BRAKE"},
-		{18, "THROW"},
-		{19, "AVOID_ADSB"},
-		{20, "GUIDED_NOGPS"},
-		{21, "SMART_RTL"},
-		{22, "FLOWHOLD"},
-		{23, "FOLLOW"},
-		{24, "ZIGZAG"},
-		{25, "SYSTEMID"},
-		{26, "AUTOROTATE"},
-		{27, "AUTO_RTL"},
-		{999, "UNKNOWN"},
-	}
-
-	for _, tc := range testCases {
-		result := relay.getFlightMode(tc.mode)
-		if result != tc.expected {
-			t.Errorf("For mode %d, expected '%s', got '%s'", tc.mode, tc.expected, result)
-		}
-	}
-}
-
 // TestMessageHandlers tests individual message handlers
 func TestMessageHandlers(t *testing.T) {
 	relay := &Relay{
@@ -172,7 +158,7 @@ func TestMessageHandlers(t *testing.T) {
 	heartbeat := &common.MessageHeartbeat{
 		CustomMode: 3, // AUTO mode
 	}
-	relay.handleHeartbeat(heartbeat, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(heartbeat), "test-drone", nil)
 
 	mockSink := relay.sinks[0].(*mock.MockSink)
 	if mockSink.GetMessageCount() != 1 {
@@ -193,7 +179,7 @@ func TestMessageHandlers(t *testing.T) {
 		Lon: -122419400, // -122.4194 degrees
 		Alt: 100500,     // 100.5 meters
 	}
-	relay.handleGlobalPosition(position, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(position), "test-drone", nil)
 
 	if mockSink.GetMessageCount() != 2 {
 		t.Errorf("Expected 2 messages after position, got %d", mockSink.GetMessageCount())
@@ -213,7 +199,7 @@ func TestMessageHandlers(t *testing.T) {
 		Pitch: -0.2, // ~-11.5 degrees
 		Yaw:   3.14, // ~180 degrees
 	}
-	relay.handleAttitude(attitude, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(attitude), "test-drone", nil)
 
 	if mockSink.GetMessageCount() != 3 {
 		t.Errorf("Expected 3 messages after attitude, got %d", mockSink.GetMessageCount())
@@ -233,7 +219,7 @@ func TestMessageHandlers(t *testing.T) {
 		Alt:         100.5,
 		Heading:     180,
 	}
-	relay.handleVfrHud(vfrHud, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(vfrHud), "test-drone", nil)
 
 	if mockSink.GetMessageCount() != 4 {
 		t.Errorf("Expected 4 messages after VFR HUD, got %d", mockSink.GetMessageCount())
@@ -252,7 +238,7 @@ func TestMessageHandlers(t *testing.T) {
 		BatteryRemaining: 85,
 		VoltageBattery:   12600, // 12.6V in mV
 	}
-	relay.handleSysStatus(sysStatus, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(sysStatus), "test-drone", nil)
 
 	if mockSink.GetMessageCount() != 5 {
 		t.Errorf("Expected 5 messages after sys status, got %d", mockSink.GetMessageCount())
@@ -277,7 +263,7 @@ func TestMessageTypeSpecificData(t *testing.T) {
 	heartbeat := &common.MessageHeartbeat{
 		CustomMode: 3,
 	}
-	relay.handleHeartbeat(heartbeat, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(heartbeat), "test-drone", nil)
 
 	mockSink := relay.sinks[0].(*mock.MockSink)
 	msg := mockSink.GetMessages()[0]
@@ -298,7 +284,7 @@ func TestMessageTypeSpecificData(t *testing.T) {
 		Lon: -122419400,
 		Alt: 100500,
 	}
-	relay.handleGlobalPosition(position, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(position), "test-drone", nil)
 
 	msg = mockSink.GetMessages()[1]
 	if msg.MsgName != "GlobalPositionInt" {
@@ -328,7 +314,7 @@ func TestMultipleSinks(t *testing.T) {
 	heartbeat := &common.MessageHeartbeat{
 		CustomMode: 3,
 	}
-	relay.handleHeartbeat(heartbeat, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(heartbeat), "test-drone", nil)
 
 	// Check that all sinks received the message
 	for i, sink := range relay.sinks {
@@ -397,7 +383,7 @@ func TestConcurrentMessageHandling(t *testing.T) {
 			heartbeat := &common.MessageHeartbeat{
 				CustomMode: uint32(id % 10),
 			}
-			relay.handleHeartbeat(heartbeat, "test-drone")
+			relay.handleFrame(context.Background(), newFrameEvent(heartbeat), "test-drone", nil)
 			done <- true
 		}(i)
 	}
@@ -423,7 +409,7 @@ func TestMessageTimestamp(t *testing.T) {
 	heartbeat := &common.MessageHeartbeat{
 		CustomMode: 3,
 	}
-	relay.handleHeartbeat(heartbeat, "test-drone")
+	relay.handleFrame(context.Background(), newFrameEvent(heartbeat), "test-drone", nil)
 	after := time.Now()
 
 	mockSink := relay.sinks[0].(*mock.MockSink)
@@ -434,3 +420,2961 @@ func TestMessageTimestamp(t *testing.T) {
 		t.Errorf("Message timestamp %v is not within expected range [%v, %v]", timestamp, before, after)
 	}
 }
+
+// TestMultiModeDroneIDResolution tests that frames on a multi mode endpoint are demultiplexed by system ID
+func TestMultiModeDroneIDResolution(t *testing.T) {
+	relay := &Relay{
+		sinks: []sinks.Sink{mock.NewMockSink()},
+	}
+	relay.endpointDemuxers.Store("swarm", newSystemDemux(config.MAVLinkEndpoint{
+		Name:            "swarm",
+		Mode:            config.MAVLinkModeMulti,
+		DroneIDTemplate: "{endpoint}-{system_id}",
+		Systems: []config.MAVLinkSystemMapping{
+			{SystemID: 1, DroneID: "drone-alpha"},
+			{SystemID: 2, ComponentID: 154, DroneID: "drone-bravo-gimbal"},
+		},
+	}))
+	relay.endpointDemuxers.Store("strict", newSystemDemux(config.MAVLinkEndpoint{
+		Name:    "strict",
+		Mode:    config.MAVLinkModeMulti,
+		Systems: []config.MAVLinkSystemMapping{{SystemID: 1, DroneID: "drone-charlie"}},
+	}))
+
+	testCases := []struct {
+		endpoint    string
+		systemID    uint8
+		componentID uint8
+		expected    string
+	}{
+		{"swarm", 1, 1, "drone-alpha"},
+		{"swarm", 1, 154, "drone-alpha"},
+		{"swarm", 2, 154, "drone-bravo-gimbal"},
+		{"swarm", 2, 1, "swarm-2"},
+		{"swarm", 42, 1, "swarm-42"},
+		{"strict", 1, 1, "drone-charlie"},
+		{"strict", 7, 1, ""}, // unmapped, dropped
+	}
+
+	mockSink := relay.sinks[0].(*mock.MockSink)
+	for _, tc := range testCases {
+		mockSink.Clear()
+		relay.handleFrame(context.Background(), &gomavlib.EventFrame{
+			Frame: &frame.V2Frame{
+				SystemID:    tc.systemID,
+				ComponentID: tc.componentID,
+				Message:     &common.MessageHeartbeat{},
+			},
+		}, tc.endpoint, nil)
+
+		if tc.expected == "" {
+			if mockSink.GetMessageCount() != 0 {
+				t.Errorf("%s sys %d: expected frame to be dropped, got %d messages", tc.endpoint, tc.systemID, mockSink.GetMessageCount())
+			}
+			continue
+		}
+
+		if mockSink.GetMessageCount() != 1 {
+			t.Fatalf("%s sys %d: expected 1 message, got %d", tc.endpoint, tc.systemID, mockSink.GetMessageCount())
+		}
+		if got := mockSink.GetLastMessage().DroneID; got != tc.expected {
+			t.Errorf("%s sys %d comp %d: expected drone ID '%s', got '%s'", tc.endpoint, tc.systemID, tc.componentID, tc.expected, got)
+		}
+	}
+}
+
+// TestGenericMessageForwarding tests that messages without a hand-written builder are forwarded
+func TestGenericMessageForwarding(t *testing.T) {
+	relay := &Relay{
+		sinks: []sinks.Sink{mock.NewMockSink()},
+	}
+
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageGpsRawInt{
+		FixType:           common.GPS_FIX_TYPE_3D_FIX,
+		SatellitesVisible: 12,
+	}), "test-drone", nil)
+
+	mockSink := relay.sinks[0].(*mock.MockSink)
+	if mockSink.GetMessageCount() != 1 {
+		t.Fatalf("Expected 1 message, got %d", mockSink.GetMessageCount())
+	}
+
+	msg := mockSink.GetLastMessage()
+	if msg.MsgName != "GpsRawInt" {
+		t.Errorf("Expected GpsRawInt message, got %s", msg.MsgName)
+	}
+	if fixType, ok := msg.Fields["fix_type"].(string); !ok || fixType != "GPS_FIX_TYPE_3D_FIX" {
+		t.Errorf("Expected fix_type 'GPS_FIX_TYPE_3D_FIX', got %v", msg.Fields["fix_type"])
+	}
+	if satellites, ok := msg.Fields["satellites_visible"].(uint8); !ok || satellites != 12 {
+		t.Errorf("Expected satellites_visible 12, got %v", msg.Fields["satellites_visible"])
+	}
+}
+
+// TestUnknownMessageDropped tests that messages outside the dialect produce no
+// envelope
+func TestUnknownMessageDropped(t *testing.T) {
+	relay := &Relay{
+		sinks: []sinks.Sink{mock.NewMockSink()},
+	}
+
+	// an ArduPilot vendor message received on a common dialect relay
+	relay.handleFrame(context.Background(), newFrameEvent(&message.MessageRaw{ID: 150, Payload: []byte{1, 2, 3}}), "test-drone", nil)
+
+	mockSink := relay.sinks[0].(*mock.MockSink)
+	if mockSink.GetMessageCount() != 0 {
+		t.Errorf("Expected no envelope, got %+v", mockSink.GetMessages())
+	}
+}
+
+// TestForwardedMessageFilter tests that only configured message types reach the sinks
+func TestForwardedMessageFilter(t *testing.T) {
+	relay := &Relay{
+		sinks:             []sinks.Sink{mock.NewMockSink()},
+		forwardedMessages: map[uint32]bool{(&common.MessageHeartbeat{}).GetID(): true},
+	}
+
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{}), "test-drone", nil)
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageAttitude{}), "test-drone", nil)
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageGpsRawInt{}), "test-drone", nil)
+
+	mockSink := relay.sinks[0].(*mock.MockSink)
+	if mockSink.GetMessageCount() != 1 {
+		t.Fatalf("Expected 1 message, got %d", mockSink.GetMessageCount())
+	}
+	if msg := mockSink.GetLastMessage(); msg.MsgName != "Heartbeat" {
+		t.Errorf("Expected Heartbeat message, got %s", msg.MsgName)
+	}
+}
+
+// TestEndpointMessageFilter tests that endpoint include/exclude filters drop
+// messages before they reach the sinks
+func TestEndpointMessageFilter(t *testing.T) {
+	relay := &Relay{sinks: []sinks.Sink{mock.NewMockSink()}}
+	relay.setEndpointFilter(config.MAVLinkEndpoint{
+		Name: "test-drone",
+		Filter: config.MessageFilter{
+			IncludeIDs: []uint32{(&common.MessageHeartbeat{}).GetID(), (&common.MessageAttitude{}).GetID()},
+			ExcludeIDs: []uint32{(&common.MessageAttitude{}).GetID()},
+		},
+	})
+
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{}), "test-drone", nil)
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageAttitude{}), "test-drone", nil)
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageGpsRawInt{}), "test-drone", nil)
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageGpsRawInt{}), "other-drone", nil)
+
+	mockSink := relay.sinks[0].(*mock.MockSink)
+	if mockSink.GetMessageCount() != 2 {
+		t.Fatalf("Expected 2 messages, got %d", mockSink.GetMessageCount())
+	}
+	for _, msg := range mockSink.GetMessages() {
+		if msg.MsgName == "Attitude" || (msg.MsgName == "GpsRawInt" && msg.Source == "test-drone") {
+			t.Errorf("Expected %s from %s to be filtered", msg.MsgName, msg.Source)
+		}
+	}
+
+	// An empty filter removes the endpoint's filter
+	relay.setEndpointFilter(config.MAVLinkEndpoint{Name: "test-drone"})
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageAttitude{}), "test-drone", nil)
+	if mockSink.GetMessageCount() != 3 {
+		t.Errorf("Expected the filter to be removed, got %d messages", mockSink.GetMessageCount())
+	}
+}
+
+// TestDownsampler tests the latest, every_nth and aggregate strategies
+func TestDownsampler(t *testing.T) {
+	d := newDownsampler()
+	start := time.Now()
+	attitude := func(roll float32, alt int32) telemetry.TelemetryEnvelope {
+		return telemetry.TelemetryEnvelope{
+			MsgName: "Attitude",
+			Fields:  map[string]any{"roll": roll, "alt": alt, "time_boot_ms": uint32(roll * 1000), "mode": "GUIDED"},
+			Raw:     []byte{1},
+		}
+	}
+
+	// latest keeps the newest message of each window
+	latest := config.DownsampleRule{Strategy: config.DownsampleLatest, Interval: time.Second}
+	key := downsampleKey{endpoint: "test-drone", msgID: 30}
+	for i := range 5 {
+		forward, suppressed := d.add(key, latest, attitude(float32(i), 0), start.Add(time.Duration(i)*100*time.Millisecond))
+		if len(forward) != 0 {
+			t.Fatalf("Expected nothing forwarded inside the window, got %v", forward)
+		}
+		if suppressed != (i > 0) {
+			t.Errorf("Message %d: expected suppressed %v, got %v", i, i > 0, suppressed)
+		}
+	}
+	forward, _ := d.add(key, latest, attitude(9, 0), start.Add(time.Second))
+	if len(forward) != 1 || forward[0].Fields["roll"] != float32(4) {
+		t.Fatalf("Expected the latest message of the closed window, got %v", forward)
+	}
+	if expired := d.expire(start.Add(1500 * time.Millisecond)); len(expired) != 0 {
+		t.Errorf("Expected the open window to be kept, got %v", expired)
+	}
+	expired := d.expire(start.Add(2 * time.Second))
+	if len(expired) != 1 || expired[0].Fields["roll"] != float32(9) {
+		t.Fatalf("Expected the expired window to be forwarded, got %v", expired)
+	}
+
+	// every_nth forwards the first of every N messages right away
+	everyThird := config.DownsampleRule{Strategy: config.DownsampleEveryNth, Every: 3}
+	key = downsampleKey{endpoint: "test-drone", msgID: 33}
+	var forwarded []float32
+	for i := range 7 {
+		forward, _ := d.add(key, everyThird, attitude(float32(i), 0), start)
+		for _, envelope := range forward {
+			forwarded = append(forwarded, envelope.Fields["roll"].(float32))
+		}
+	}
+	if !reflect.DeepEqual(forwarded, []float32{0, 3, 6}) {
+		t.Errorf("Expected messages 0, 3 and 6, got %v", forwarded)
+	}
+
+	// aggregate forwards min, max and average in the field's type
+	aggregate := config.DownsampleRule{Strategy: config.DownsampleAggregate, Interval: time.Second}
+	key = downsampleKey{endpoint: "test-drone", msgID: 74}
+	d.add(key, aggregate, attitude(1, 10), start)
+	d.add(key, aggregate, attitude(2, 15), start)
+	d.add(key, aggregate, attitude(6, 20), start)
+	expired = d.expire(start.Add(time.Second))
+	if len(expired) != 1 {
+		t.Fatalf("Expected 1 aggregate, got %d", len(expired))
+	}
+	fields := expired[0].Fields
+	for name, want := range map[string]any{
+		"roll": float32(3), "roll_min": float32(1), "roll_max": float32(6),
+		"alt": int32(15), "alt_min": int32(10), "alt_max": int32(20),
+		"time_boot_ms": uint32(6000), "mode": "GUIDED", "samples": 3,
+	} {
+		if fields[name] != want {
+			t.Errorf("Expected %s to be %v (%T), got %v (%T)", name, want, want, fields[name], fields[name])
+		}
+	}
+	if expired[0].Raw != nil {
+		t.Errorf("Expected no raw frame on an aggregate")
+	}
+
+	// a changed rule closes the window kept under the previous one
+	d.add(key, aggregate, attitude(1, 10), start)
+	forward, _ = d.add(key, latest, attitude(2, 10), start)
+	if len(forward) != 1 || forward[0].Fields["samples"] != 1 {
+		t.Errorf("Expected the aggregate to be forwarded when the rule changed, got %v", forward)
+	}
+	if pending := d.remove("test-drone"); len(pending) != 1 || pending[0].Fields["roll"] != float32(2) {
+		t.Errorf("Expected the open window to be forwarded when the endpoint is removed, got %v", pending)
+	}
+	if len(d.windows) != 0 {
+		t.Errorf("Expected no windows after removing the endpoint, got %d", len(d.windows))
+	}
+}
+
+// TestEndpointDownsampling tests that frames pass their endpoint's downsampling rules
+func TestEndpointDownsampling(t *testing.T) {
+	relay := &Relay{sinks: []sinks.Sink{mock.NewMockSink()}, downsampler: newDownsampler()}
+	relay.setEndpointDownsampling(config.MAVLinkEndpoint{
+		Name: "test-drone",
+		DownsampleRules: map[uint32]config.DownsampleRule{
+			(&common.MessageAttitude{}).GetID(): {Strategy: config.DownsampleEveryNth, Every: 5},
+		},
+	})
+
+	for range 10 {
+		relay.handleFrame(context.Background(), newFrameEvent(&common.MessageAttitude{}), "test-drone", nil)
+		relay.handleFrame(context.Background(), newFrameEvent(&common.MessageAttitude{}), "other-drone", nil)
+	}
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{}), "test-drone", nil)
+
+	counts := make(map[string]int)
+	for _, msg := range relay.sinks[0].(*mock.MockSink).GetMessages() {
+		counts[msg.Source+"/"+msg.MsgName]++
+	}
+	want := map[string]int{"test-drone/Attitude": 2, "other-drone/Attitude": 10, "test-drone/Heartbeat": 1}
+	if !reflect.DeepEqual(counts, want) {
+		t.Errorf("Expected %v, got %v", want, counts)
+	}
+}
+
+// TestTLogRecorder tests that received frames are written to rotated .tlog files
+func TestTLogRecorder(t *testing.T) {
+	dialectRW, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		t.Fatalf("Failed to create dialect read writer: %v", err)
+	}
+
+	dir := t.TempDir()
+	relay := &Relay{
+		// room for two heartbeats per file: 8 byte timestamp and 21 byte frame
+		recorder: newTLogRecorder(config.RecorderConfig{Enabled: true, Path: dir, MaxBytes: 60}),
+	}
+
+	// frames as they arrive on a link, with valid checksums
+	var link bytes.Buffer
+	writer, err := frame.NewWriter(frame.WriterConf{Writer: &link, DialectRW: dialectRW, OutVersion: frame.V2, OutSystemID: 1, OutComponentID: 1})
+	if err != nil {
+		t.Fatalf("Failed to create frame writer: %v", err)
+	}
+
+	start := time.Now()
+	for range 5 {
+		if err := writer.WriteMessage(&common.MessageHeartbeat{Type: common.MAV_TYPE_QUADROTOR, MavlinkVersion: 3}); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+		relay.recordFrame("drone/1", bytes.Clone(link.Bytes()))
+		link.Reset()
+	}
+	relay.recorder.close()
+
+	files, err := filepath.Glob(filepath.Join(dir, "drone_1_*.tlog"))
+	if err != nil || len(files) != 3 {
+		t.Fatalf("Expected 3 rotated files, got %v (%v)", files, err)
+	}
+
+	var sequences []uint8
+	for _, file := range files {
+		data, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", file, err)
+		}
+		for len(data) > 0 {
+			received := time.UnixMicro(int64(binary.BigEndian.Uint64(data[:8])))
+			if received.Before(start.Truncate(time.Microsecond)) || received.After(time.Now()) {
+				t.Errorf("Expected the receive time, got %v", received)
+			}
+
+			reader, err := frame.NewReader(frame.ReaderConf{Reader: bytes.NewReader(data[8:]), DialectRW: dialectRW})
+			if err != nil {
+				t.Fatalf("Failed to create frame reader: %v", err)
+			}
+			fr, err := reader.Read()
+			if err != nil {
+				t.Fatalf("Failed to parse recorded frame: %v", err)
+			}
+			if _, ok := fr.GetMessage().(*common.MessageHeartbeat); !ok {
+				t.Errorf("Expected a heartbeat, got %T", fr.GetMessage())
+			}
+			sequences = append(sequences, telemetry.FrameSequence(fr))
+
+			raw, err := telemetry.EncodeFrame(dialectRW, fr)
+			if err != nil {
+				t.Fatalf("Failed to encode frame: %v", err)
+			}
+			data = data[8+len(raw):]
+		}
+	}
+	slices.Sort(sequences)
+	if !reflect.DeepEqual(sequences, []uint8{0, 1, 2, 3, 4}) {
+		t.Errorf("Expected every frame to be recorded once, got sequences %v", sequences)
+	}
+
+	relay.recordFrame("drone/1", []byte{frame.V2MagicByte})
+	if files, _ := filepath.Glob(filepath.Join(dir, "*.tlog")); len(files) != 3 {
+		t.Errorf("Expected no recording after the recorder closed, got %d files", len(files))
+	}
+}
+
+// writeTestTLog writes messages to a .tlog file as if they were received from
+// system 1 at the given offsets from a fixed start time
+func writeTestTLog(t *testing.T, path string, msgs []message.Message, offsets []time.Duration) {
+	t.Helper()
+
+	dialectRW, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		t.Fatalf("Failed to create dialect read writer: %v", err)
+	}
+	var raw bytes.Buffer
+	writer, err := frame.NewWriter(frame.WriterConf{Writer: &raw, DialectRW: dialectRW, OutVersion: frame.V2, OutSystemID: 1, OutComponentID: 1})
+	if err != nil {
+		t.Fatalf("Failed to create frame writer: %v", err)
+	}
+
+	var tlog bytes.Buffer
+	start := time.Date(2024, 1, 15, 10, 30, 0, 0, time.UTC)
+	for i, msg := range msgs {
+		if err := writer.WriteMessage(msg); err != nil {
+			t.Fatalf("Failed to write frame: %v", err)
+		}
+		binary.Write(&tlog, binary.BigEndian, uint64(start.Add(offsets[i]).UnixMicro()))
+		tlog.Write(raw.Bytes())
+		raw.Reset()
+	}
+	if err := os.WriteFile(path, tlog.Bytes(), 0o644); err != nil {
+		t.Fatalf("Failed to write tlog: %v", err)
+	}
+}
+
+// TestReplayFile tests playing a .tlog file back with its timing, offset and looping
+func TestReplayFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "flight.tlog")
+	msgs := []message.Message{
+		&common.MessageHeartbeat{},
+		&common.MessageAttitude{Roll: 1},
+		&common.MessageAttitude{Roll: 2},
+		&common.MessageAttitude{Roll: 3},
+	}
+	writeTestTLog(t, path, msgs, []time.Duration{0, time.Second, 2 * time.Second, 3 * time.Second})
+
+	dialectRW, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		t.Fatalf("Failed to create dialect read writer: %v", err)
+	}
+	play := func(cfg config.ReplayConfig, frames int) ([]message.Message, time.Duration) {
+		t.Helper()
+		replay := newReplayFile("flight", cfg)
+		if err := replay.open(); err != nil {
+			t.Fatalf("Failed to open replay: %v", err)
+		}
+		defer replay.Close()
+		reader, err := frame.NewReader(frame.ReaderConf{Reader: replay, DialectRW: dialectRW})
+		if err != nil {
+			t.Fatalf("Failed to create frame reader: %v", err)
+		}
+
+		start := time.Now()
+		var played []message.Message
+		for range frames {
+			fr, err := reader.Read()
+			if err != nil {
+				t.Fatalf("Failed to read replayed frame: %v", err)
+			}
+			played = append(played, fr.GetMessage())
+		}
+		return played, time.Since(start)
+	}
+
+	played, elapsed := play(config.ReplayConfig{File: path, Speed: 0}, 4)
+	if !reflect.DeepEqual(played, msgs) {
+		t.Errorf("Expected the recorded messages, got %v", played)
+	}
+	if elapsed > 500*time.Millisecond {
+		t.Errorf("Expected max speed to ignore the recorded timing, took %v", elapsed)
+	}
+
+	// 3 seconds of recording at 20x speed
+	_, elapsed = play(config.ReplayConfig{File: path, Speed: 20}, 4)
+	if elapsed < 140*time.Millisecond || elapsed > time.Second {
+		t.Errorf("Expected playback to take about 150ms, took %v", elapsed)
+	}
+
+	played, _ = play(config.ReplayConfig{File: path, StartOffset: 2 * time.Second, Loop: true}, 4)
+	want := []message.Message{msgs[2], msgs[3], msgs[2], msgs[3]}
+	if !reflect.DeepEqual(played, want) {
+		t.Errorf("Expected the offset to apply on every loop, got %v", played)
+	}
+
+	// a truncated file plays up to the last complete record, then the link
+	// stays idle until it is closed
+	data, _ := os.ReadFile(path)
+	truncated := filepath.Join(t.TempDir(), "truncated.tlog")
+	os.WriteFile(truncated, data[:len(data)-5], 0o644)
+	replay := newReplayFile("flight", config.ReplayConfig{File: truncated})
+	if err := replay.open(); err != nil {
+		t.Fatalf("Failed to open replay: %v", err)
+	}
+	done := make(chan error, 1)
+	go func() {
+		replay.config.Speed = 0
+		_, err := io.ReadAll(replay)
+		done <- err
+	}()
+	select {
+	case <-done:
+		t.Fatal("Expected the link to stay open after the end of the file")
+	case <-time.After(50 * time.Millisecond):
+	}
+	replay.Close()
+	if err := <-done; err != nil {
+		t.Errorf("Expected a clean end after closing, got %v", err)
+	}
+}
+
+// TestReplayEndpoint tests that a replay endpoint feeds recorded frames
+// through the relay to the sinks
+func TestReplayEndpoint(t *testing.T) {
+	dialectRW, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		t.Fatalf("Failed to create dialect read writer: %v", err)
+	}
+	frameEncoder, err := telemetry.NewFrameEncoder(dialectRW)
+	if err != nil {
+		t.Fatalf("Failed to create frame encoder: %v", err)
+	}
+
+	path := filepath.Join(t.TempDir(), "flight.tlog")
+	writeTestTLog(t, path, []message.Message{
+		&common.MessageHeartbeat{Type: common.MAV_TYPE_QUADROTOR, Autopilot: common.MAV_AUTOPILOT_PX4},
+		&common.MessageGlobalPositionInt{Lat: 377749000, Lon: -122419400},
+		&common.MessageAttitude{Roll: 0.5},
+	}, []time.Duration{0, 100 * time.Millisecond, 200 * time.Millisecond})
+
+	// the file starts with a copy of the heartbeat whose checksum was damaged
+	// on the link
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Failed to read tlog: %v", err)
+	}
+	size := 10 + int(data[8+1]) + 2
+	damaged := bytes.Clone(data[:8+size])
+	damaged[8+size-2] ^= 0xFF
+	if err := os.WriteFile(path, append(damaged, data...), 0o644); err != nil {
+		t.Fatalf("Failed to write tlog: %v", err)
+	}
+
+	enabled := false
+	endpoint := config.MAVLinkEndpoint{
+		Name:     "flight",
+		DroneID:  "drone-replay",
+		Protocol: config.MAVLinkEndpointProtocolReplay,
+		Mode:     config.MAVLinkMode1To1,
+		Replay:   config.ReplayConfig{File: path, Speed: 0},
+		Identity: config.IdentityConfig{SystemID: 255, ComponentID: 190, Version: 2, Heartbeat: config.HeartbeatConfig{Enabled: &enabled, Rate: 1}},
+	}
+	recordings := t.TempDir()
+	relay := &Relay{
+		config:       &config.Config{MAVLink: config.MAVLinkConfig{Dialect: common.Dialect, Endpoints: []config.MAVLinkEndpoint{endpoint}}},
+		sinks:        []sinks.Sink{mock.NewMockSink()},
+		dialectRW:    dialectRW,
+		frameEncoder: frameEncoder,
+		recorder:     newTLogRecorder(config.RecorderConfig{Enabled: true, Path: recordings}),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay.launchEndpoint(ctx, endpoint, common.Dialect)
+	defer func() {
+		relay.endpointsMu.Lock()
+		relay.stopEndpoint("flight")
+		relay.endpointsMu.Unlock()
+	}()
+
+	mockSink := relay.sinks[0].(*mock.MockSink)
+	deadline := time.Now().Add(2 * time.Second)
+	for mockSink.GetMessageCount() < 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected 3 replayed messages, got %d", mockSink.GetMessageCount())
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	var names []string
+	for _, msg := range mockSink.GetMessages() {
+		if msg.DroneID != "drone-replay" || msg.Source != "flight" {
+			t.Errorf("Expected messages from drone-replay on flight, got %s on %s", msg.DroneID, msg.Source)
+		}
+		names = append(names, msg.MsgName)
+	}
+	if !reflect.DeepEqual(names, []string{"Heartbeat", "GlobalPositionInt", "Attitude"}) {
+		t.Errorf("Expected the recorded order, got %v", names)
+	}
+
+	// every frame is recorded as received, including the damaged one
+	relay.recorder.closeEndpoint("flight")
+	recorded, err := filepath.Glob(filepath.Join(recordings, "flight_*.tlog"))
+	if err != nil || len(recorded) != 1 {
+		t.Fatalf("Expected one recording, got %v (%v)", recorded, err)
+	}
+	frames := func(path string) [][]byte {
+		t.Helper()
+		data, err := os.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Failed to read %s: %v", path, err)
+		}
+		var frames [][]byte
+		r := bufio.NewReader(bytes.NewReader(data))
+		for {
+			_, raw, err := readTLogRecord(r)
+			if errors.Is(err, io.EOF) {
+				return frames
+			}
+			if err != nil {
+				t.Fatalf("Failed to read %s: %v", path, err)
+			}
+			frames = append(frames, raw)
+		}
+	}
+	if got, want := frames(recorded[0]), frames(path); !reflect.DeepEqual(got, want) {
+		t.Errorf("Expected the frames as received\n%x\ngot\n%x", want, got)
+	}
+
+	// a missing file fails the endpoint instead of retrying it
+	endpoint.Name, endpoint.Replay.File = "missing", filepath.Join(t.TempDir(), "missing.tlog")
+	relay.launchEndpoint(ctx, endpoint, common.Dialect)
+	deadline = time.Now().Add(2 * time.Second)
+	for relay.endpointStatus()["missing"] != endpointFailed {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected the endpoint to fail, state %s", relay.endpointStatus()["missing"])
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+// TestFrameMetadata tests that frame header values and raw bytes are copied into envelopes
+func TestFrameMetadata(t *testing.T) {
+	dialectRW, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		t.Fatalf("Failed to create dialect read writer: %v", err)
+	}
+
+	frameEncoder, err := telemetry.NewFrameEncoder(dialectRW)
+	if err != nil {
+		t.Fatalf("Failed to create frame encoder: %v", err)
+	}
+	relay := &Relay{
+		sinks:        []sinks.Sink{mock.NewMockSink()},
+		dialectRW:    dialectRW,
+		frameEncoder: frameEncoder,
+	}
+
+	evt := &gomavlib.EventFrame{
+		Frame: &frame.V2Frame{
+			SequenceID:  42,
+			SystemID:    3,
+			ComponentID: 1,
+			Message:     &common.MessageGlobalPositionInt{TimeBootMs: 60000, Lat: 377749000},
+		},
+	}
+	relay.handleFrame(context.Background(), evt, "test-drone", relay.encodeFrame(evt.Frame, "test-drone"))
+
+	mockSink := relay.sinks[0].(*mock.MockSink)
+	msg := mockSink.GetLastMessage()
+	if msg.SystemID != 3 {
+		t.Errorf("Expected system ID 3, got %d", msg.SystemID)
+	}
+	if msg.ComponentID != 1 {
+		t.Errorf("Expected component ID 1, got %d", msg.ComponentID)
+	}
+	if msg.Sequence != 42 {
+		t.Errorf("Expected sequence 42, got %d", msg.Sequence)
+	}
+	if msg.TimestampDevice != 60 {
+		t.Errorf("Expected device timestamp 60, got %v", msg.TimestampDevice)
+	}
+	if len(msg.Raw) == 0 || msg.Raw[0] != frame.V2MagicByte {
+		t.Errorf("Expected raw MAVLink v2 frame, got %x", msg.Raw)
+	}
+}
+
+// TestCreateEndpointConf tests mapping configured endpoints to gomavlib endpoints
+func TestCreateEndpointConf(t *testing.T) {
+	relay := &Relay{}
+
+	testCases := []struct {
+		name     string
+		endpoint config.MAVLinkEndpoint
+		want     gomavlib.EndpointConf
+	}{
+		{
+			name:     "udp server",
+			endpoint: config.MAVLinkEndpoint{Protocol: config.MAVLinkEndpointProtocolUDP, Port: 14550},
+			want:     &gomavlib.EndpointUDPServer{Address: "0.0.0.0:14550"},
+		},
+		{
+			name:     "tcp server bound to interface",
+			endpoint: config.MAVLinkEndpoint{Protocol: config.MAVLinkEndpointProtocolTCP, Address: "192.168.1.10", Port: 5760},
+			want:     &gomavlib.EndpointTCPServer{Address: "192.168.1.10:5760"},
+		},
+		{
+			name:     "udp client",
+			endpoint: config.MAVLinkEndpoint{Protocol: config.MAVLinkEndpointProtocolUDPClient, Address: "10.0.0.5:14550"},
+			want:     &gomavlib.EndpointUDPClient{Address: "10.0.0.5:14550"},
+		},
+		{
+			name:     "tcp client",
+			endpoint: config.MAVLinkEndpoint{Protocol: config.MAVLinkEndpointProtocolTCPClient, Address: "127.0.0.1", Port: 5760},
+			want:     &gomavlib.EndpointTCPClient{Address: "127.0.0.1:5760"},
+		},
+		{
+			name: "udp broadcast",
+			endpoint: config.MAVLinkEndpoint{
+				Protocol:     config.MAVLinkEndpointProtocolUDPBroadcast,
+				Address:      "192.168.1.255:14550",
+				LocalAddress: "192.168.1.10:14550",
+			},
+			want: &gomavlib.EndpointUDPBroadcast{BroadcastAddress: "192.168.1.255:14550", LocalAddress: "192.168.1.10:14550"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			got, err := relay.createEndpointConf(tc.endpoint)
+			if err != nil {
+				t.Fatalf("Failed to create endpoint conf: %v", err)
+			}
+			if !reflect.DeepEqual(got, tc.want) {
+				t.Errorf("Expected %#v, got %#v", tc.want, got)
+			}
+		})
+	}
+
+	if _, err := relay.createEndpointConf(config.MAVLinkEndpoint{Protocol: "bogus"}); !errors.Is(err, config.ErrInvalidProtocol) {
+		t.Errorf("Expected ErrInvalidProtocol, got %v", err)
+	}
+}
+
+// TestNodeConf tests carrying the endpoint identity into the node configuration
+func TestNodeConf(t *testing.T) {
+	enabled := true
+	endpointConf := &gomavlib.EndpointUDPServer{Address: "0.0.0.0:14550"}
+
+	conf := nodeConf(config.MAVLinkEndpoint{
+		Identity: config.IdentityConfig{
+			SystemID:    250,
+			ComponentID: 190,
+			Version:     2,
+			Heartbeat:   config.HeartbeatConfig{Enabled: &enabled, Rate: 1, Type: common.MAV_TYPE_GCS},
+		},
+		Signing: config.SigningConfig{Sign: true, Key: &frame.V2Key{1}},
+	}, endpointConf)
+	if conf.OutSystemID != 250 || conf.OutComponentID != 190 || conf.OutVersion != gomavlib.V2 {
+		t.Errorf("Expected identity 250/190 v2, got %d/%d %v", conf.OutSystemID, conf.OutComponentID, conf.OutVersion)
+	}
+	if conf.Dialect != nil || !conf.HeartbeatDisable || conf.OutKey != nil {
+		t.Error("Expected decoding, heartbeats and signing to be left to the relay")
+	}
+
+	conf = nodeConf(config.MAVLinkEndpoint{Identity: config.IdentityConfig{
+		SystemID:    1,
+		ComponentID: 191,
+		Version:     1,
+		Heartbeat:   config.HeartbeatConfig{Enabled: &enabled, Rate: 1},
+	}}, endpointConf)
+	if conf.OutVersion != gomavlib.V1 {
+		t.Errorf("Expected MAVLink 1, got %v", conf.OutVersion)
+	}
+}
+
+// TestEndpointNode tests the heartbeat and messages the relay sends through an
+// endpoint's node
+func TestEndpointNode(t *testing.T) {
+	dialectRW, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		t.Fatalf("Failed to create dialect read writer: %v", err)
+	}
+
+	enabled := true
+	key := &frame.V2Key{1}
+	endpoint := config.MAVLinkEndpoint{
+		Identity: config.IdentityConfig{
+			SystemID:    250,
+			ComponentID: 190,
+			Version:     2,
+			Heartbeat:   config.HeartbeatConfig{Enabled: &enabled, Rate: 100, Type: common.MAV_TYPE_GENERIC},
+		},
+		Signing: config.SigningConfig{Sign: true, Key: key},
+	}
+	local, remote := net.Pipe()
+	node, err := gomavlib.NewNode(nodeConf(endpoint, &gomavlib.EndpointCustom{ReadWriteCloser: local}))
+	if err != nil {
+		t.Fatalf("Failed to create node: %v", err)
+	}
+	n, err := newEndpointNode(node, endpoint, common.Dialect, dialectRW)
+	if err != nil {
+		t.Fatalf("Failed to wrap node: %v", err)
+	}
+	defer n.Close()
+	// closed first so a write in progress does not hold up Close
+	defer remote.Close()
+
+	open, ok := (<-n.Events()).(*gomavlib.EventChannelOpen)
+	if !ok {
+		t.Fatal("Expected the channel to open")
+	}
+
+	// the vehicle side checks checksums and signatures
+	reader, err := frame.NewReader(frame.ReaderConf{Reader: remote, DialectRW: dialectRW, InKey: key})
+	if err != nil {
+		t.Fatalf("Failed to create frame reader: %v", err)
+	}
+	read := func() *frame.V2Frame {
+		t.Helper()
+		fr, err := reader.Read()
+		if err != nil {
+			t.Fatalf("Failed to read frame: %v", err)
+		}
+		return fr.(*frame.V2Frame)
+	}
+
+	// a zero type is advertised as it is
+	fr := read()
+	heartbeat, ok := fr.Message.(*common.MessageHeartbeat)
+	if !ok || heartbeat.Type != common.MAV_TYPE_GENERIC || heartbeat.SystemStatus != common.MAV_STATE_ACTIVE ||
+		heartbeat.MavlinkVersion != uint8(common.Dialect.Version) {
+		t.Errorf("Expected an active generic heartbeat, got %+v", fr.Message)
+	}
+	if fr.SystemID != 250 || fr.ComponentID != 190 {
+		t.Errorf("Expected frames from 250/190, got %d/%d", fr.SystemID, fr.ComponentID)
+	}
+
+	if err := n.WriteMessageTo(open.Channel, &common.MessageCommandLong{TargetSystem: 1, Command: common.MAV_CMD_COMPONENT_ARM_DISARM}); err != nil {
+		t.Fatalf("Failed to write message: %v", err)
+	}
+	sequence, timestamp := fr.SequenceID, fr.SignatureTimestamp
+	for {
+		fr = read()
+		if fr.SequenceID != sequence+1 || fr.SignatureTimestamp <= timestamp {
+			t.Errorf("Expected sequence %d after timestamp %d, got %d at %d", sequence+1, timestamp, fr.SequenceID, fr.SignatureTimestamp)
+		}
+		sequence, timestamp = fr.SequenceID, fr.SignatureTimestamp
+		if _, ok := fr.Message.(*common.MessageCommandLong); ok {
+			break
+		}
+	}
+}
+
+// fakeSerialDevice is an in-memory serial device that can be unplugged
+type fakeSerialDevice struct {
+	data chan []byte
+	done chan struct{}
+	once sync.Once
+}
+
+func newFakeSerialDevice() *fakeSerialDevice {
+	return &fakeSerialDevice{data: make(chan []byte, 1), done: make(chan struct{})}
+}
+
+func (d *fakeSerialDevice) Read(p []byte) (int, error) {
+	select {
+	case b := <-d.data:
+		return copy(p, b), nil
+	case <-d.done:
+		return 0, io.EOF
+	}
+}
+
+func (d *fakeSerialDevice) Write(p []byte) (int, error) { return len(p), nil }
+
+func (d *fakeSerialDevice) Close() error {
+	d.once.Do(func() { close(d.done) })
+	return nil
+}
+
+// TestSerialPortHotplug tests reopening a serial device after it is unplugged
+func TestSerialPortHotplug(t *testing.T) {
+	first, second := newFakeSerialDevice(), newFakeSerialDevice()
+
+	var mu sync.Mutex
+	opens := 0
+	origOpen := openSerialDevice
+	openSerialDevice = func(device string, baud int) (io.ReadWriteCloser, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		opens++
+		switch opens {
+		case 1, 3:
+			return nil, errors.New("no such device")
+		case 2:
+			return first, nil
+		default:
+			return second, nil
+		}
+	}
+	defer func() { openSerialDevice = origOpen }()
+
+	port := newSerialPort("radio", "/dev/ttyACM0", 57600)
+	port.minBackoff = time.Millisecond
+	port.maxBackoff = 5 * time.Millisecond
+	defer port.Close()
+
+	if _, err := port.Write([]byte{1}); !errors.Is(err, errSerialDisconnected) {
+		t.Errorf("Expected errSerialDisconnected before open, got %v", err)
+	}
+
+	buf := make([]byte, 8)
+	first.data <- []byte("one")
+	n, err := port.Read(buf)
+	if err != nil || string(buf[:n]) != "one" {
+		t.Fatalf("Expected 'one', got %q (%v)", buf[:n], err)
+	}
+
+	// unplug the device; the next read reopens it and resumes
+	first.Close()
+	second.data <- []byte("two")
+	n, err = port.Read(buf)
+	if err != nil || string(buf[:n]) != "two" {
+		t.Fatalf("Expected 'two', got %q (%v)", buf[:n], err)
+	}
+
+	mu.Lock()
+	if opens != 4 {
+		t.Errorf("Expected 4 open attempts, got %d", opens)
+	}
+	mu.Unlock()
+
+	port.Close()
+	if _, err := port.Read(buf); err == nil {
+		t.Error("Expected read to fail after close")
+	}
+}
+
+// fakeFrameWriter records the frames the router writes to an endpoint
+type fakeFrameWriter struct {
+	to     []*gomavlib.Channel
+	except []*gomavlib.Channel
+	frames []frame.Frame
+}
+
+func (w *fakeFrameWriter) WriteFrameTo(ch *gomavlib.Channel, fr frame.Frame) error {
+	w.to = append(w.to, ch)
+	w.frames = append(w.frames, fr)
+	return nil
+}
+
+func (w *fakeFrameWriter) WriteFrameExcept(ch *gomavlib.Channel, fr frame.Frame) error {
+	w.except = append(w.except, ch)
+	w.frames = append(w.frames, fr)
+	return nil
+}
+
+func (w *fakeFrameWriter) reset() {
+	w.to, w.except, w.frames = nil, nil, nil
+}
+
+func (w *fakeFrameWriter) writes() int {
+	return len(w.to) + len(w.except)
+}
+
+// TestRouter tests forwarding frames between endpoints
+func TestRouter(t *testing.T) {
+	rt := newRouter()
+	vehicle, gcs, cloud := &fakeFrameWriter{}, &fakeFrameWriter{}, &fakeFrameWriter{}
+	rt.addLink(config.MAVLinkEndpoint{Name: "vehicle", Mode: config.MAVLinkMode1To1}, vehicle)
+	rt.addLink(config.MAVLinkEndpoint{Name: "gcs", Mode: config.MAVLinkModeRoute}, gcs)
+	rt.addLink(config.MAVLinkEndpoint{
+		Name: "cloud",
+		Mode: config.MAVLinkModeRoute,
+		Routing: config.MAVLinkEndpointRouting{
+			AllowMessageIDs: []uint32{(&common.MessageHeartbeat{}).GetID()},
+			DenySystems:     []uint8{255},
+		},
+	}, cloud)
+
+	vehicleCh, gcsCh, cloudCh := &gomavlib.Channel{}, &gomavlib.Channel{}, &gomavlib.Channel{}
+	rt.openChannel("gcs", gcsCh)
+	rt.openChannel("cloud", cloudCh)
+
+	event := func(ch *gomavlib.Channel, systemID uint8, msg message.Message) *gomavlib.EventFrame {
+		return &gomavlib.EventFrame{
+			Frame:   &frame.V2Frame{SystemID: systemID, ComponentID: 1, Message: msg},
+			Channel: ch,
+		}
+	}
+	// the decoded frame stands in for the one received
+	route := func(endpoint string, evt *gomavlib.EventFrame) {
+		rt.route(endpoint, evt, evt.Frame)
+	}
+	reset := func() {
+		vehicle.reset()
+		gcs.reset()
+		cloud.reset()
+	}
+
+	if !rt.routeOnly("gcs") || rt.routeOnly("vehicle") {
+		t.Error("Expected only gcs and cloud to be route only")
+	}
+
+	// Broadcasts from the vehicle reach every other link
+	heartbeat := event(vehicleCh, 1, &common.MessageHeartbeat{})
+	raw := &frame.V2Frame{SystemID: 1, ComponentID: 1, Message: &message.MessageRaw{ID: 0, Payload: []byte{1}}}
+	rt.route("vehicle", heartbeat, raw)
+	if vehicle.writes() != 0 {
+		t.Errorf("Expected no writes back to the source link, got %d", vehicle.writes())
+	}
+	if len(gcs.except) != 1 || gcs.except[0] != vehicleCh {
+		t.Errorf("Expected broadcast to gcs excluding source channel, got %v", gcs.except)
+	}
+	if len(cloud.except) != 1 {
+		t.Errorf("Expected broadcast to cloud, got %d writes", cloud.writes())
+	}
+	if gcs.frames[0] != raw || cloud.frames[0] != raw {
+		t.Error("Expected the router to forward the frame as received")
+	}
+	reset()
+
+	// Allow rules keep other messages away from cloud
+	route("vehicle", event(vehicleCh, 1, &common.MessageAttitude{}))
+	if gcs.writes() != 1 || cloud.writes() != 0 {
+		t.Errorf("Expected attitude to reach gcs only, got gcs=%d cloud=%d", gcs.writes(), cloud.writes())
+	}
+	reset()
+
+	// Targeted messages go only to the link the target system was seen on;
+	// deny rules drop the GCS heartbeat for cloud
+	route("gcs", event(gcsCh, 255, &common.MessageHeartbeat{}))
+	if vehicle.writes() != 1 || cloud.writes() != 0 {
+		t.Errorf("Expected GCS heartbeat to reach vehicle only, got vehicle=%d cloud=%d", vehicle.writes(), cloud.writes())
+	}
+	reset()
+
+	route("gcs", event(gcsCh, 255, &common.MessageCommandLong{TargetSystem: 1, TargetComponent: 1}))
+	if len(vehicle.to) != 1 || vehicle.to[0] != vehicleCh {
+		t.Errorf("Expected command to be routed to the vehicle channel, got %v", vehicle.to)
+	}
+	if cloud.writes() != 0 || gcs.writes() != 0 {
+		t.Errorf("Expected command to reach vehicle only, got gcs=%d cloud=%d", gcs.writes(), cloud.writes())
+	}
+	reset()
+
+	// Unknown targets and closed channels are not routed
+	route("gcs", event(gcsCh, 255, &common.MessageCommandLong{TargetSystem: 7}))
+	rt.closeChannel(vehicleCh)
+	route("gcs", event(gcsCh, 255, &common.MessageCommandLong{TargetSystem: 1}))
+	if vehicle.writes() != 0 || cloud.writes() != 0 || gcs.writes() != 0 {
+		t.Errorf("Expected no writes, got vehicle=%d gcs=%d cloud=%d", vehicle.writes(), gcs.writes(), cloud.writes())
+	}
+
+	// Removed links receive nothing and forget their channels
+	rt.removeLink("cloud")
+	route("vehicle", event(vehicleCh, 1, &common.MessageHeartbeat{}))
+	if cloud.writes() != 0 || gcs.writes() != 1 {
+		t.Errorf("Expected heartbeat to reach gcs only, got gcs=%d cloud=%d", gcs.writes(), cloud.writes())
+	}
+	if _, ok := rt.channels[cloudCh]; ok {
+		t.Error("Expected the removed link's channel to be forgotten")
+	}
+}
+
+// TestRouteRules tests endpoint allow and deny rules
+func TestRouteRules(t *testing.T) {
+	rules := newRouteRules(config.MAVLinkEndpointRouting{
+		AllowMessageIDs: []uint32{0, 33},
+		DenyMessageIDs:  []uint32{33},
+		AllowSystems:    []uint8{1, 2},
+	})
+
+	testCases := []struct {
+		msgID    uint32
+		systemID uint8
+		want     bool
+	}{
+		{0, 1, true},
+		{33, 1, false}, // deny wins over allow
+		{30, 1, false}, // not in allow list
+		{0, 3, false},  // system not allowed
+	}
+
+	for _, tc := range testCases {
+		if got := rules.allows(tc.msgID, tc.systemID); got != tc.want {
+			t.Errorf("allows(%d, %d) = %v, want %v", tc.msgID, tc.systemID, got, tc.want)
+		}
+	}
+
+	if !(routeRules{}).allows(42, 9) {
+		t.Error("Expected empty rules to allow everything")
+	}
+}
+
+// recordingVehicle is embedded by the fake vehicles of the command, mission,
+// parameter and stream tests. It records what the relay writes to the vehicle
+// and replays the vehicle's replies through the relay.
+type recordingVehicle struct {
+	relay *Relay
+
+	mu   sync.Mutex
+	sent []message.Message
+}
+
+// record appends a message written by the relay and returns how many were
+// written so far
+func (v *recordingVehicle) record(m message.Message) int {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	v.sent = append(v.sent, m)
+	return len(v.sent)
+}
+
+func (v *recordingVehicle) messages() []message.Message {
+	v.mu.Lock()
+	defer v.mu.Unlock()
+	return append([]message.Message(nil), v.sent...)
+}
+
+// reply hands messages to the relay as if received from the test drone.
+// Fakes run it on its own goroutine so the write causing the replies returns
+// first, as it would on a real link.
+func (v *recordingVehicle) reply(msgs ...message.Message) {
+	for _, m := range msgs {
+		v.relay.handleFrame(context.Background(), newFrameEvent(m), "test-drone", nil)
+	}
+}
+
+func (v *recordingVehicle) attach(relay *Relay) {
+	v.relay = relay
+}
+
+// testVehicle is a fake vehicle the relay writes to
+type testVehicle interface {
+	messageWriter
+	attach(relay *Relay)
+}
+
+// connectTestVehicle connects a fake vehicle to the relay as the test drone.
+// A non-nil heartbeat is received from it so the drone's link is known.
+func connectTestVehicle(relay *Relay, vehicle testVehicle, heartbeat *common.MessageHeartbeat) {
+	vehicle.attach(relay)
+	relay.endpointDroneIDs.Store("test-drone", "test-drone")
+	relay.connections.Store("test-drone", vehicle)
+	if heartbeat != nil {
+		relay.handleFrame(context.Background(), newFrameEvent(heartbeat), "test-drone", nil)
+	}
+}
+
+// fakeVehicle records commands written to it and answers the first one by
+// replaying COMMAND_ACKs through the relay
+type fakeVehicle struct {
+	recordingVehicle
+	acks []common.MAV_RESULT // results acknowledged after the first write
+}
+
+func (v *fakeVehicle) WriteMessageTo(ch *gomavlib.Channel, m message.Message) error {
+	if v.record(m) > 1 || len(v.acks) == 0 {
+		return nil
+	}
+	command := m.(*common.MessageCommandLong).Command
+	replies := make([]message.Message, len(v.acks))
+	for i, result := range v.acks {
+		replies[i] = &common.MessageCommandAck{Command: command, Result: result}
+	}
+	go v.reply(replies...)
+	return nil
+}
+
+// newCommandTestRelay returns a relay with a connected vehicle that answers
+// commands through the fake
+func newCommandTestRelay(vehicle *fakeVehicle) (*Relay, *mock.MockSink) {
+	sink := mock.NewMockSink()
+	retries := 2
+	relay := &Relay{
+		config: &config.Config{Commands: config.CommandsConfig{
+			Enabled:    true,
+			Retries:    &retries,
+			AckTimeout: 20 * time.Millisecond,
+			Timeout:    time.Second,
+		}},
+		sinks:    []sinks.Sink{sink},
+		commands: newCommandTracker(),
+	}
+	connectTestVehicle(relay, vehicle, &common.MessageHeartbeat{Autopilot: common.MAV_AUTOPILOT_ARDUPILOTMEGA})
+	sink.Clear()
+	return relay, sink
+}
+
+// TestResolveCommand tests resolving command aliases, names and params
+func TestResolveCommand(t *testing.T) {
+	testCases := []struct {
+		name        string
+		req         CommandRequest
+		wantCommand common.MAV_CMD
+		wantParams  [7]float64
+		wantErr     error
+	}{
+		{"arm alias", CommandRequest{Command: "arm"}, common.MAV_CMD_COMPONENT_ARM_DISARM, [7]float64{1}, nil},
+		{"force disarm", CommandRequest{Command: "DISARM", Params: []float64{0, 21196}}, common.MAV_CMD_COMPONENT_ARM_DISARM, [7]float64{0, 21196}, nil},
+		{"force arm", CommandRequest{Command: "arm", Params: []float64{1, 21196}}, common.MAV_CMD_COMPONENT_ARM_DISARM, [7]float64{1, 21196}, nil},
+		{"arm overriding param1", CommandRequest{Command: "arm", Params: []float64{0, 21196}}, 0, [7]float64{}, errInvalidCommand},
+		{"set mode", CommandRequest{Command: "set_mode", Params: []float64{1, 6}}, common.MAV_CMD_DO_SET_MODE, [7]float64{1, 6}, nil},
+		{"set mode without base mode", CommandRequest{Command: "set_mode", Params: []float64{0, 6}}, 0, [7]float64{}, errInvalidCommand},
+		{"takeoff", CommandRequest{Command: "takeoff", Params: []float64{0, 0, 0, 0, 0, 0, 10}}, common.MAV_CMD_NAV_TAKEOFF, [7]float64{6: 10}, nil},
+		{"mavlink name", CommandRequest{Command: "MAV_CMD_NAV_RETURN_TO_LAUNCH"}, common.MAV_CMD_NAV_RETURN_TO_LAUNCH, [7]float64{}, nil},
+		{"numeric", CommandRequest{Command: "21"}, common.MAV_CMD_NAV_LAND, [7]float64{}, nil},
+		{"unknown", CommandRequest{Command: "barrel_roll"}, 0, [7]float64{}, errInvalidCommand},
+		{"empty", CommandRequest{}, 0, [7]float64{}, errInvalidCommand},
+		{"too many params", CommandRequest{Command: "arm", Params: make([]float64, 8)}, 0, [7]float64{}, errInvalidCommand},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			command, params, err := resolveCommand(tc.req)
+			if !errors.Is(err, tc.wantErr) {
+				t.Fatalf("Expected error %v, got %v", tc.wantErr, err)
+			}
+			if err != nil {
+				return
+			}
+			if command != tc.wantCommand {
+				t.Errorf("Expected command %s, got %s", tc.wantCommand, command)
+			}
+			if params != tc.wantParams {
+				t.Errorf("Expected params %v, got %v", tc.wantParams, params)
+			}
+		})
+	}
+}
+
+// TestBuildCommandInt tests building COMMAND_INT messages from global positions
+func TestBuildCommandInt(t *testing.T) {
+	link := droneLink{systemID: 3, componentID: 1}
+	req := CommandRequest{Frame: "MAV_FRAME_GLOBAL_RELATIVE_ALT", TargetComponent: 2}
+	params := [7]float64{-1, 1, 0, 0, 47.3977419, 8.5455938, 20}
+
+	msg, err := buildCommandMessage(req, link, common.MAV_CMD_DO_REPOSITION, params, 0)
+	if err != nil {
+		t.Fatalf("Failed to build command: %v", err)
+	}
+
+	cmd, ok := msg.(*common.MessageCommandInt)
+	if !ok {
+		t.Fatalf("Expected COMMAND_INT, got %T", msg)
+	}
+	if cmd.TargetSystem != 3 || cmd.TargetComponent != 2 {
+		t.Errorf("Expected target 3/2, got %d/%d", cmd.TargetSystem, cmd.TargetComponent)
+	}
+	if cmd.X != 473977419 || cmd.Y != 85455938 || cmd.Z != 20 {
+		t.Errorf("Unexpected position x=%d y=%d z=%f", cmd.X, cmd.Y, cmd.Z)
+	}
+
+	if _, err := buildCommandMessage(CommandRequest{Frame: "MAV_FRAME_NOWHERE"}, link, common.MAV_CMD_DO_REPOSITION, params, 0); !errors.Is(err, errInvalidCommand) {
+		t.Errorf("Expected errInvalidCommand for unknown frame, got %v", err)
+	}
+}
+
+// TestSendCommand tests sending commands and waiting for COMMAND_ACK
+func TestSendCommand(t *testing.T) {
+	t.Run("accepted after retry", func(t *testing.T) {
+		// the first write goes unanswered, the retry is acknowledged
+		vehicle := &fakeVehicle{}
+		relay, sink := newCommandTestRelay(vehicle)
+
+		done := make(chan struct{})
+		var result CommandResult
+		var err error
+		go func() {
+			result, err = relay.sendCommand(context.Background(), CommandRequest{DroneID: "test-drone", Command: "arm"})
+			close(done)
+		}()
+
+		// acknowledge once the retry has gone out
+		deadline := time.After(time.Second)
+		for len(vehicle.messages()) < 2 {
+			select {
+			case <-deadline:
+				t.Fatal("Expected the command to be resent")
+			case <-time.After(time.Millisecond):
+			}
+		}
+		relay.handleFrame(context.Background(), newFrameEvent(&common.MessageCommandAck{
+			Command: common.MAV_CMD_COMPONENT_ARM_DISARM,
+			Result:  common.MAV_RESULT_ACCEPTED,
+		}), "test-drone", nil)
+		<-done
+
+		if err != nil {
+			t.Fatalf("Expected command to succeed, got %v", err)
+		}
+		if result.Result != "MAV_RESULT_ACCEPTED" {
+			t.Errorf("Expected MAV_RESULT_ACCEPTED, got %s", result.Result)
+		}
+		if result.Attempts < 2 {
+			t.Errorf("Expected at least 2 attempts, got %d", result.Attempts)
+		}
+
+		sent := vehicle.messages()
+		first, second := sent[0].(*common.MessageCommandLong), sent[1].(*common.MessageCommandLong)
+		if first.Confirmation != 0 || second.Confirmation != 1 {
+			t.Errorf("Expected confirmations 0 and 1, got %d and %d", first.Confirmation, second.Confirmation)
+		}
+		if first.TargetSystem != 1 || first.TargetComponent != 1 || first.Param1 != 1 {
+			t.Errorf("Unexpected command: %+v", first)
+		}
+
+		// the command is recorded for the audit trail
+		var audit telemetry.TelemetryEnvelope
+		found := false
+		for _, msg := range sink.GetMessages() {
+			if env := msg.ToEnvelope(); env.MsgName == "CommandLong" {
+				audit, found = env, true
+			}
+		}
+		if !found {
+			t.Fatal("Expected a CommandLong audit envelope")
+		}
+		if audit.DroneID != "test-drone" || audit.Fields["result"] != "MAV_RESULT_ACCEPTED" || audit.Fields["command"] != "MAV_CMD_COMPONENT_ARM_DISARM" {
+			t.Errorf("Unexpected audit envelope: %+v", audit)
+		}
+	})
+
+	t.Run("in progress then denied", func(t *testing.T) {
+		vehicle := &fakeVehicle{acks: []common.MAV_RESULT{common.MAV_RESULT_IN_PROGRESS}}
+		relay, _ := newCommandTestRelay(vehicle)
+
+		go func() {
+			time.Sleep(60 * time.Millisecond)
+			relay.handleFrame(context.Background(), newFrameEvent(&common.MessageCommandAck{
+				Command: common.MAV_CMD_NAV_TAKEOFF,
+				Result:  common.MAV_RESULT_DENIED,
+			}), "test-drone", nil)
+		}()
+
+		result, err := relay.sendCommand(context.Background(), CommandRequest{DroneID: "test-drone", Command: "takeoff"})
+		if err != nil {
+			t.Fatalf("Expected final ack, got %v", err)
+		}
+		if result.Result != "MAV_RESULT_DENIED" {
+			t.Errorf("Expected MAV_RESULT_DENIED, got %s", result.Result)
+		}
+		if result.Attempts != 1 {
+			t.Errorf("Expected no resends after IN_PROGRESS, got %d attempts", result.Attempts)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		vehicle := &fakeVehicle{}
+		relay, sink := newCommandTestRelay(vehicle)
+
+		result, err := relay.sendCommand(context.Background(), CommandRequest{DroneID: "test-drone", Command: "rtl"})
+		if !errors.Is(err, errCommandTimeout) {
+			t.Fatalf("Expected errCommandTimeout, got %v", err)
+		}
+		if result.Attempts != 3 {
+			t.Errorf("Expected 3 attempts, got %d", result.Attempts)
+		}
+		if sink.GetMessageCount() != 1 || sink.GetLastMessage().ToEnvelope().Fields["error"] == nil {
+			t.Error("Expected the failed command to be audited")
+		}
+
+		// resends can be turned off
+		*relay.config.Commands.Retries = 0
+		result, err = relay.sendCommand(context.Background(), CommandRequest{DroneID: "test-drone", Command: "rtl"})
+		if !errors.Is(err, errCommandTimeout) || result.Attempts != 1 {
+			t.Errorf("Expected a single attempt without retries, got %d attempts and %v", result.Attempts, err)
+		}
+	})
+
+	t.Run("unknown drone", func(t *testing.T) {
+		relay, _ := newCommandTestRelay(&fakeVehicle{})
+		_, err := relay.sendCommand(context.Background(), CommandRequest{DroneID: "ghost", Command: "arm"})
+		if !errors.Is(err, errUnknownDrone) {
+			t.Errorf("Expected errUnknownDrone, got %v", err)
+		}
+	})
+}
+
+// TestCommandAPI tests the HTTP command endpoint
+func TestCommandAPI(t *testing.T) {
+	relay, _ := newCommandTestRelay(&fakeVehicle{acks: []common.MAV_RESULT{common.MAV_RESULT_ACCEPTED}})
+	relay.config.Commands.Token = "secret"
+
+	testCases := []struct {
+		name       string
+		method     string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"accepted", http.MethodPost, "secret", `{"drone_id":"test-drone","command":"arm"}`, http.StatusOK},
+		{"unauthorized", http.MethodPost, "wrong", `{"drone_id":"test-drone","command":"arm"}`, http.StatusUnauthorized},
+		{"bad method", http.MethodGet, "secret", ``, http.StatusMethodNotAllowed},
+		{"bad json", http.MethodPost, "secret", `{`, http.StatusBadRequest},
+		{"unknown command", http.MethodPost, "secret", `{"drone_id":"test-drone","command":"loop"}`, http.StatusBadRequest},
+		{"unknown drone", http.MethodPost, "secret", `{"drone_id":"ghost","command":"arm"}`, http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/v1/commands", strings.NewReader(tc.body))
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+
+			relay.handleCommandRequest(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+// TestHandleSinkCommand tests running commands received over a sink subscription
+func TestHandleSinkCommand(t *testing.T) {
+	relay, _ := newCommandTestRelay(&fakeVehicle{acks: []common.MAV_RESULT{common.MAV_RESULT_ACCEPTED}})
+
+	// the entity ID from the subject wins over the payload
+	reply, ok := relay.handleSinkCommand(context.Background(), "test-drone", []byte(`{"drone_id":"other","command":"arm"}`))
+	if !ok {
+		t.Fatal("Expected a reply for a known drone")
+	}
+	var result CommandResult
+	if err := json.Unmarshal(reply, &result); err != nil {
+		t.Fatalf("Failed to decode reply: %v", err)
+	}
+	if result.DroneID != "test-drone" || result.Status != commandStatusAck || result.Result != "MAV_RESULT_ACCEPTED" {
+		t.Errorf("Unexpected result: %+v", result)
+	}
+
+	// malformed payloads are rejected
+	reply, ok = relay.handleSinkCommand(context.Background(), "test-drone", []byte(`not json`))
+	if !ok {
+		t.Fatal("Expected a reply for a malformed payload")
+	}
+	result = CommandResult{}
+	json.Unmarshal(reply, &result)
+	if result.Status != commandStatusRejected || result.Error == "" {
+		t.Errorf("Expected rejected result, got %+v", result)
+	}
+
+	// drones owned by another relay are not answered
+	if _, ok := relay.handleSinkCommand(context.Background(), "ghost", []byte(`{"command":"arm"}`)); ok {
+		t.Error("Expected no reply for an unknown drone")
+	}
+}
+
+// commandSourceSink records command subscriptions in a log shared between sinks
+type commandSourceSink struct {
+	mock.MockSink
+	name string
+	log  *[]string
+}
+
+func (s *commandSourceSink) SubscribeCommands(handler sinks.CommandHandler) error {
+	*s.log = append(*s.log, "subscribe "+s.name)
+	return nil
+}
+
+func (s *commandSourceSink) UnsubscribeCommands() error {
+	*s.log = append(*s.log, "unsubscribe "+s.name)
+	return nil
+}
+
+// TestCommandSourceHandOver tests that a replaced sink stops receiving
+// commands before its replacement subscribes
+func TestCommandSourceHandOver(t *testing.T) {
+	var log []string
+	retired := &commandSourceSink{name: "old", log: &log}
+	created := &commandSourceSink{name: "new", log: &log}
+	relay := &Relay{}
+
+	unsubscribeCommands([]sinks.Sink{retired, mock.NewMockSink()})
+	relay.subscribeCommands([]sinks.Sink{created})
+	if want := []string{"unsubscribe old", "subscribe new"}; !reflect.DeepEqual(log, want) {
+		t.Errorf("Expected %v, got %v", want, log)
+	}
+}
+
+// fakeMissionVehicle answers the mission protocol from an in-memory mission,
+// replaying its replies through the relay
+type fakeMissionVehicle struct {
+	recordingVehicle
+	items     []*common.MessageMissionItemInt
+	dropFirst bool                      // ignore the first message to force a resend
+	reject    common.MAV_MISSION_RESULT // result acked for uploaded items, 0 accepts
+	silent    bool                      // never answer
+}
+
+func (v *fakeMissionVehicle) WriteMessageTo(ch *gomavlib.Channel, m message.Message) error {
+	if n := v.record(m); v.silent || (v.dropFirst && n == 1) {
+		return nil
+	}
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var reply message.Message
+	switch msg := m.(type) {
+	case *common.MessageMissionRequestList:
+		reply = &common.MessageMissionCount{Count: uint16(len(v.items))}
+	case *common.MessageMissionRequestInt:
+		reply = v.items[msg.Seq]
+	case *common.MessageMissionCount:
+		v.items = make([]*common.MessageMissionItemInt, msg.Count)
+		reply = &common.MessageMissionRequestInt{Seq: 0}
+		if msg.Count == 0 {
+			reply = &common.MessageMissionAck{}
+		}
+	case *common.MessageMissionItemInt:
+		v.items[msg.Seq] = msg
+		switch {
+		case v.reject != common.MAV_MISSION_ACCEPTED:
+			reply = &common.MessageMissionAck{Type: v.reject}
+		case int(msg.Seq)+1 < len(v.items):
+			reply = &common.MessageMissionRequestInt{Seq: msg.Seq + 1}
+		default:
+			reply = &common.MessageMissionAck{Type: common.MAV_MISSION_ACCEPTED}
+		}
+	}
+
+	if reply != nil {
+		go v.reply(reply)
+	}
+	return nil
+}
+
+// newMissionTestRelay returns a relay with a connected vehicle running the
+// given autopilot that answers the mission protocol through the fake
+func newMissionTestRelay(vehicle *fakeMissionVehicle, autopilot common.MAV_AUTOPILOT) (*Relay, *mock.MockSink) {
+	sink := mock.NewMockSink()
+	retries := 2
+	relay := &Relay{
+		config: &config.Config{Missions: config.MissionsConfig{
+			Enabled:     true,
+			Retries:     &retries,
+			ItemTimeout: 20 * time.Millisecond,
+			Timeout:     time.Second,
+		}},
+		sinks:    []sinks.Sink{sink},
+		missions: newMissionTracker(),
+	}
+	connectTestVehicle(relay, vehicle, &common.MessageHeartbeat{Autopilot: autopilot, Type: common.MAV_TYPE_QUADROTOR})
+	sink.Clear()
+	return relay, sink
+}
+
+func planParams(values ...float64) []*float64 {
+	params := make([]*float64, len(values))
+	for i := range values {
+		if !math.IsNaN(values[i]) {
+			params[i] = &values[i]
+		}
+	}
+	return params
+}
+
+// testPlan returns a takeoff and waypoint plan
+func testPlan() MissionPlan {
+	return MissionPlan{
+		FileType: "Plan",
+		Version:  1,
+		Mission: PlanMission{
+			Version:             2,
+			PlannedHomePosition: []float64{47.3977419, 8.5455938, 488},
+			Items: []PlanItem{
+				{Type: "SimpleItem", Command: 22, Frame: 3, Params: planParams(0, 0, 0, math.NaN(), 47.3977419, 8.5455938, 20), AutoContinue: true, DoJumpID: 1},
+				{Type: "SimpleItem", Command: 16, Frame: 3, Params: planParams(0, 0, 0, math.NaN(), 47.398, 8.546, 30), AutoContinue: true, DoJumpID: 2},
+			},
+		},
+	}
+}
+
+// TestMissionPlanConversion tests converting .plan files to mission items and back
+func TestMissionPlanConversion(t *testing.T) {
+	t.Run("px4", func(t *testing.T) {
+		link := droneLink{systemID: 1, componentID: 1, autopilot: common.MAV_AUTOPILOT_PX4}
+		items, err := planToMissionItems(testPlan(), link)
+		if err != nil {
+			t.Fatalf("Failed to convert plan: %v", err)
+		}
+		if len(items) != 2 {
+			t.Fatalf("Expected 2 items, got %d", len(items))
+		}
+		takeoff := items[0]
+		if takeoff.Command != common.MAV_CMD_NAV_TAKEOFF || takeoff.Frame != common.MAV_FRAME_GLOBAL_RELATIVE_ALT || takeoff.Autocontinue != 1 {
+			t.Errorf("Unexpected takeoff item: %+v", takeoff)
+		}
+		if takeoff.X != 473977419 || takeoff.Y != 85455938 || takeoff.Z != 20 || !math.IsNaN(float64(takeoff.Param4)) {
+			t.Errorf("Unexpected takeoff params: %+v", takeoff)
+		}
+		if items[1].Seq != 1 {
+			t.Errorf("Expected seq 1, got %d", items[1].Seq)
+		}
+
+		plan := missionItemsToPlan(items, link)
+		if len(plan.Mission.Items) != 2 || plan.Mission.FirmwareType != int(common.MAV_AUTOPILOT_PX4) {
+			t.Fatalf("Unexpected plan: %+v", plan.Mission)
+		}
+		params := plan.Mission.Items[0].Params
+		if params[3] != nil || math.Abs(*params[4]-47.3977419) > 1e-7 || *params[6] != 20 {
+			t.Errorf("Unexpected round-tripped params: %v", params)
+		}
+		if _, err := json.Marshal(plan); err != nil {
+			t.Errorf("Failed to encode plan: %v", err)
+		}
+	})
+
+	t.Run("ardupilot home", func(t *testing.T) {
+		link := droneLink{autopilot: common.MAV_AUTOPILOT_ARDUPILOTMEGA}
+		items, err := planToMissionItems(testPlan(), link)
+		if err != nil {
+			t.Fatalf("Failed to convert plan: %v", err)
+		}
+		if len(items) != 3 || items[0].Command != common.MAV_CMD_NAV_WAYPOINT || items[0].Z != 488 || items[2].Seq != 2 {
+			t.Fatalf("Expected home as item 0, got %+v", items)
+		}
+
+		plan := missionItemsToPlan(items, link)
+		if len(plan.Mission.Items) != 2 || plan.Mission.Items[0].DoJumpID != 1 {
+			t.Errorf("Expected home to be dropped from the items, got %+v", plan.Mission.Items)
+		}
+		if home := plan.Mission.PlannedHomePosition; math.Abs(home[0]-47.3977419) > 1e-7 || home[2] != 488 {
+			t.Errorf("Unexpected planned home position: %v", home)
+		}
+	})
+
+	t.Run("invalid", func(t *testing.T) {
+		complexPlan := testPlan()
+		complexPlan.Mission.Items[1] = PlanItem{Type: "ComplexItem", ComplexItemType: "survey"}
+		shortPlan := testPlan()
+		shortPlan.Mission.Items[0].Params = planParams(0, 0)
+
+		for name, plan := range map[string]MissionPlan{"file type": {}, "complex item": complexPlan, "params": shortPlan} {
+			if _, err := planToMissionItems(plan, droneLink{}); !errors.Is(err, errInvalidMission) {
+				t.Errorf("%s: expected errInvalidMission, got %v", name, err)
+			}
+		}
+	})
+}
+
+// TestMissionTransfer tests downloading and uploading missions over the
+// mission protocol
+func TestMissionTransfer(t *testing.T) {
+	t.Run("download with resend", func(t *testing.T) {
+		vehicle := &fakeMissionVehicle{dropFirst: true}
+		relay, sink := newMissionTestRelay(vehicle, common.MAV_AUTOPILOT_ARDUPILOTMEGA)
+		vehicle.items, _ = planToMissionItems(testPlan(), droneLink{autopilot: common.MAV_AUTOPILOT_ARDUPILOTMEGA})
+
+		plan, err := relay.downloadMission(context.Background(), "test-drone")
+		if err != nil {
+			t.Fatalf("Expected download to succeed, got %v", err)
+		}
+		if len(plan.Mission.Items) != 2 || plan.Mission.Items[1].Command != 16 || plan.Mission.PlannedHomePosition[2] != 488 {
+			t.Errorf("Unexpected plan: %+v", plan.Mission)
+		}
+
+		sent := vehicle.messages()
+		if _, ok := sent[1].(*common.MessageMissionRequestList); !ok {
+			t.Errorf("Expected MISSION_REQUEST_LIST to be resent, got %T", sent[1])
+		}
+		if ack, ok := sent[len(sent)-1].(*common.MessageMissionAck); !ok || ack.Type != common.MAV_MISSION_ACCEPTED {
+			t.Errorf("Expected a final MISSION_ACK, got %T", sent[len(sent)-1])
+		}
+
+		var envelope telemetry.TelemetryEnvelope
+		for _, msg := range sink.GetMessages() {
+			if env := msg.ToEnvelope(); env.MsgName == "Mission" {
+				envelope = env
+			}
+		}
+		if envelope.MsgName != "Mission" || envelope.Fields["direction"] != "download" || envelope.Fields["count"] != 2 {
+			t.Errorf("Unexpected mission envelope: %+v", envelope)
+		}
+	})
+
+	t.Run("upload", func(t *testing.T) {
+		vehicle := &fakeMissionVehicle{}
+		relay, _ := newMissionTestRelay(vehicle, common.MAV_AUTOPILOT_PX4)
+
+		result, err := relay.uploadMission(context.Background(), "test-drone", testPlan())
+		if err != nil {
+			t.Fatalf("Expected upload to succeed, got %v", err)
+		}
+		if result.Result != "MAV_MISSION_ACCEPTED" || result.Items != 2 {
+			t.Errorf("Unexpected result: %+v", result)
+		}
+		if len(vehicle.items) != 2 || vehicle.items[1] == nil || vehicle.items[1].Z != 30 {
+			t.Errorf("Unexpected uploaded items: %+v", vehicle.items)
+		}
+	})
+
+	t.Run("upload rejected", func(t *testing.T) {
+		vehicle := &fakeMissionVehicle{reject: common.MAV_MISSION_INVALID_PARAM1}
+		relay, _ := newMissionTestRelay(vehicle, common.MAV_AUTOPILOT_PX4)
+
+		result, err := relay.uploadMission(context.Background(), "test-drone", testPlan())
+		if !errors.Is(err, errMissionRejected) {
+			t.Fatalf("Expected errMissionRejected, got %v", err)
+		}
+		if result.Result != "MAV_MISSION_INVALID_PARAM1" {
+			t.Errorf("Expected the rejection result, got %q", result.Result)
+		}
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		vehicle := &fakeMissionVehicle{silent: true}
+		relay, _ := newMissionTestRelay(vehicle, common.MAV_AUTOPILOT_PX4)
+
+		if _, err := relay.downloadMission(context.Background(), "test-drone"); !errors.Is(err, errMissionTimeout) {
+			t.Fatalf("Expected errMissionTimeout, got %v", err)
+		}
+		if sent := len(vehicle.messages()); sent != 3 {
+			t.Errorf("Expected 3 attempts, got %d", sent)
+		}
+
+		// resends can be turned off
+		*relay.config.Missions.Retries = 0
+		if _, err := relay.downloadMission(context.Background(), "test-drone"); !errors.Is(err, errMissionTimeout) {
+			t.Fatalf("Expected errMissionTimeout, got %v", err)
+		}
+		if sent := len(vehicle.messages()); sent != 4 {
+			t.Errorf("Expected a single attempt without retries, got %d", sent-3)
+		}
+	})
+
+	t.Run("in flight", func(t *testing.T) {
+		relay, _ := newMissionTestRelay(&fakeMissionVehicle{}, common.MAV_AUTOPILOT_PX4)
+		relay.missions.register("test-drone")
+
+		if _, err := relay.downloadMission(context.Background(), "test-drone"); !errors.Is(err, errMissionInFlight) {
+			t.Errorf("Expected errMissionInFlight, got %v", err)
+		}
+	})
+}
+
+// TestMissionAPI tests the HTTP mission endpoint
+func TestMissionAPI(t *testing.T) {
+	relay, _ := newMissionTestRelay(&fakeMissionVehicle{}, common.MAV_AUTOPILOT_PX4)
+	relay.config.Commands.Token = "secret"
+
+	plan, _ := json.Marshal(testPlan())
+	testCases := []struct {
+		name       string
+		method     string
+		droneID    string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"upload", http.MethodPut, "test-drone", "secret", string(plan), http.StatusOK},
+		{"download", http.MethodGet, "test-drone", "secret", ``, http.StatusOK},
+		{"unauthorized", http.MethodGet, "test-drone", "wrong", ``, http.StatusUnauthorized},
+		{"bad method", http.MethodDelete, "test-drone", "secret", ``, http.StatusMethodNotAllowed},
+		{"bad json", http.MethodPut, "test-drone", "secret", `{`, http.StatusBadRequest},
+		{"invalid plan", http.MethodPut, "test-drone", "secret", `{"fileType":"Fence"}`, http.StatusBadRequest},
+		{"unknown drone", http.MethodGet, "ghost", "secret", ``, http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/v1/missions/"+tc.droneID, strings.NewReader(tc.body))
+			req.SetPathValue("drone_id", tc.droneID)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+
+			relay.handleMissionRequest(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+// fakeParamVehicle answers the parameter protocol from an in-memory set of
+// REAL32 parameters, replaying its replies through the relay
+type fakeParamVehicle struct {
+	recordingVehicle
+	names    []string
+	values   map[string]float32
+	drop     map[uint16]bool // indices left out of the first list, sent only when read
+	clamp    float32         // upper bound applied to PARAM_SET values, 0 disables
+	count    uint16          // reported ParamCount, 0 reports len(names)
+	reads    int             // PARAM_REQUEST_READs not answered yet
+	maxReads int
+}
+
+func (v *fakeParamVehicle) WriteMessageTo(ch *gomavlib.Channel, m message.Message) error {
+	v.record(m)
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	var replies []message.Message
+	switch msg := m.(type) {
+	case *common.MessageParamRequestList:
+		for i := range v.names {
+			if !v.drop[uint16(i)] {
+				replies = append(replies, v.paramValue(uint16(i)))
+			}
+		}
+	case *common.MessageParamRequestRead:
+		replies = append(replies, v.paramValue(uint16(msg.ParamIndex)))
+		v.reads++
+		v.maxReads = max(v.maxReads, v.reads)
+	case *common.MessageParamSet:
+		value := msg.ParamValue
+		if v.clamp != 0 {
+			value = min(value, v.clamp)
+		}
+		v.values[msg.ParamId] = value
+		echo := v.paramValue(0)
+		for i, name := range v.names {
+			if name == msg.ParamId {
+				echo = v.paramValue(uint16(i))
+			}
+		}
+		replies = append(replies, echo)
+	}
+
+	_, read := m.(*common.MessageParamRequestRead)
+	go func() {
+		v.reply(replies...)
+		if read {
+			v.mu.Lock()
+			v.reads--
+			v.mu.Unlock()
+		}
+	}()
+	return nil
+}
+
+func (v *fakeParamVehicle) paramValue(index uint16) *common.MessageParamValue {
+	name := v.names[index]
+	count := v.count
+	if count == 0 {
+		count = uint16(len(v.names))
+	}
+	return &common.MessageParamValue{
+		ParamId:    name,
+		ParamValue: v.values[name],
+		ParamType:  common.MAV_PARAM_TYPE_REAL32,
+		ParamCount: count,
+		ParamIndex: index,
+	}
+}
+
+// newParamTestRelay returns a relay with parameters enabled and a connected
+// vehicle answering the parameter protocol through the fake. The fetch
+// started on connect is waited for.
+func newParamTestRelay(t *testing.T, vehicle *fakeParamVehicle) (*Relay, *mock.MockSink) {
+	sink := mock.NewMockSink()
+	retries := 2
+	relay := &Relay{
+		config: &config.Config{Params: config.ParamsConfig{
+			Enabled:     true,
+			Retries:     &retries,
+			ItemTimeout: 20 * time.Millisecond,
+			Timeout:     time.Second,
+		}},
+		sinks:  []sinks.Sink{sink},
+		params: newParamManager(),
+	}
+	connectTestVehicle(relay, vehicle, &common.MessageHeartbeat{Autopilot: common.MAV_AUTOPILOT_ARDUPILOTMEGA})
+
+	deadline := time.After(time.Second)
+	for !relay.params.cache("test-drone").snapshot("test-drone").Complete {
+		select {
+		case <-deadline:
+			t.Fatal("Expected parameters to be fetched on connect")
+		case <-time.After(time.Millisecond):
+		}
+	}
+	return relay, sink
+}
+
+// TestParamValueEncoding tests bytewise and cast parameter value encoding
+func TestParamValueEncoding(t *testing.T) {
+	testCases := []struct {
+		paramType common.MAV_PARAM_TYPE
+		value     float64
+	}{
+		{common.MAV_PARAM_TYPE_INT32, -42},
+		{common.MAV_PARAM_TYPE_UINT8, 200},
+		{common.MAV_PARAM_TYPE_INT16, -1000},
+		{common.MAV_PARAM_TYPE_UINT32, 3000000000},
+		{common.MAV_PARAM_TYPE_REAL32, 0.25},
+	}
+
+	for _, tc := range testCases {
+		for _, bytewise := range []bool{true, false} {
+			raw := encodeParamValue(tc.value, tc.paramType, bytewise)
+			if got := decodeParamValue(raw, tc.paramType, bytewise); float32(got) != float32(tc.value) {
+				t.Errorf("%s bytewise=%v: expected %v, got %v", tc.paramType, bytewise, tc.value, got)
+			}
+		}
+	}
+
+	// PX4 packs integers into the float bits
+	if raw := encodeParamValue(1, common.MAV_PARAM_TYPE_INT32, true); math.Float32bits(raw) != 1 {
+		t.Errorf("Expected bytewise encoding, got bits %x", math.Float32bits(raw))
+	}
+}
+
+// TestParamFetch tests fetching the parameter list on connect and
+// re-requesting missing indices
+func TestParamFetch(t *testing.T) {
+	vehicle := &fakeParamVehicle{
+		names:  []string{"ARMING_CHECK", "RTL_ALT", "WPNAV_SPEED"},
+		values: map[string]float32{"ARMING_CHECK": 1, "RTL_ALT": 1500, "WPNAV_SPEED": 500},
+		drop:   map[uint16]bool{1: true},
+	}
+	relay, sink := newParamTestRelay(t, vehicle)
+
+	snapshot := relay.params.cache("test-drone").snapshot("test-drone")
+	if snapshot.Count != 3 || len(snapshot.Params) != 3 || snapshot.Params[1].Name != "RTL_ALT" || snapshot.Params[1].Value != 1500 {
+		t.Errorf("Unexpected snapshot: %+v", snapshot)
+	}
+
+	read := false
+	for _, msg := range vehicle.messages() {
+		if req, ok := msg.(*common.MessageParamRequestRead); ok && req.ParamIndex == 1 {
+			read = true
+		}
+	}
+	if !read {
+		t.Error("Expected the missing index to be re-requested")
+	}
+
+	// long gaps are re-requested in batches
+	large := &fakeParamVehicle{values: make(map[string]float32), drop: make(map[uint16]bool)}
+	for i := range 3 * paramReadBatch {
+		name := fmt.Sprintf("PARAM_%d", i)
+		large.names = append(large.names, name)
+		large.values[name] = float32(i)
+		large.drop[uint16(i)] = i > 0
+	}
+	newParamTestRelay(t, large)
+	large.mu.Lock()
+	if large.maxReads > paramReadBatch {
+		t.Errorf("Expected at most %d outstanding reads, got %d", paramReadBatch, large.maxReads)
+	}
+	large.mu.Unlock()
+
+	// the complete set is published as a snapshot
+	deadline := time.After(time.Second)
+	for {
+		var published telemetry.TelemetryEnvelope
+		for _, msg := range sink.GetMessages() {
+			if env := msg.ToEnvelope(); env.MsgName == "ParamSnapshot" {
+				published = env
+			}
+		}
+		if published.MsgName != "" {
+			if params := published.Fields["params"].(map[string]float64); params["WPNAV_SPEED"] != 500 || published.Fields["complete"] != true {
+				t.Errorf("Unexpected snapshot envelope: %+v", published)
+			}
+			break
+		}
+		select {
+		case <-deadline:
+			t.Fatal("Expected a ParamSnapshot envelope")
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// TestParamFetchLimits tests that fetching gives up after the configured
+// timeout, when the endpoint stops, and on counts beyond the int16 indices
+func TestParamFetchLimits(t *testing.T) {
+	retries := 3
+	relay := &Relay{
+		config: &config.Config{Params: config.ParamsConfig{
+			Enabled:     true,
+			Retries:     &retries,
+			ItemTimeout: time.Second,
+			Timeout:     20 * time.Millisecond,
+		}},
+		sinks: []sinks.Sink{mock.NewMockSink()},
+	}
+	connectTestVehicle(relay, &fakeParamVehicle{}, &common.MessageHeartbeat{Autopilot: common.MAV_AUTOPILOT_ARDUPILOTMEGA})
+	relay.params = newParamManager()
+
+	start := time.Now()
+	relay.refreshParams(context.Background(), "test-drone")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the fetch to stop at the timeout, took %v", elapsed)
+	}
+
+	relay.config.Params.Timeout = time.Minute
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	start = time.Now()
+	relay.refreshParams(ctx, "test-drone")
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("Expected the fetch to stop with the endpoint, took %v", elapsed)
+	}
+
+	connectTestVehicle(relay, &fakeParamVehicle{names: []string{"RTL_ALT"}, count: math.MaxInt16 + 1}, nil)
+	if _, err := relay.fetchParams(context.Background(), "test-drone"); !errors.Is(err, errParamCount) {
+		t.Errorf("Expected %v, got %v", errParamCount, err)
+	}
+}
+
+// TestParamSet tests setting parameters with PARAM_SET confirmation
+func TestParamSet(t *testing.T) {
+	vehicle := &fakeParamVehicle{
+		names:  []string{"RTL_ALT", "WPNAV_SPEED"},
+		values: map[string]float32{"RTL_ALT": 1500, "WPNAV_SPEED": 500},
+		clamp:  2000,
+	}
+	relay, _ := newParamTestRelay(t, vehicle)
+
+	value, err := relay.setParam(context.Background(), "test-drone", "RTL_ALT", 1800, "")
+	if err != nil {
+		t.Fatalf("Expected set to succeed, got %v", err)
+	}
+	if value.Value != 1800 || value.Index != 0 {
+		t.Errorf("Unexpected value: %+v", value)
+	}
+	if cached, _, _ := relay.params.cache("test-drone").get("RTL_ALT"); cached.Value != 1800 {
+		t.Errorf("Expected the cache to be updated, got %+v", cached)
+	}
+
+	// the vehicle keeps a clamped value
+	value, err = relay.setParam(context.Background(), "test-drone", "WPNAV_SPEED", 5000, "")
+	if !errors.Is(err, errParamRejected) || value.Value != 2000 {
+		t.Errorf("Expected errParamRejected with the kept value, got %v %+v", err, value)
+	}
+
+	if _, err := relay.setParam(context.Background(), "test-drone", "NOPE", 1, ""); !errors.Is(err, errUnknownParam) {
+		t.Errorf("Expected errUnknownParam, got %v", err)
+	}
+	if _, err := relay.setParam(context.Background(), "test-drone", "NOPE", 1, "MAV_PARAM_TYPE_BOGUS"); !errors.Is(err, errInvalidParam) {
+		t.Errorf("Expected errInvalidParam, got %v", err)
+	}
+}
+
+// TestParamAPI tests the HTTP parameter endpoint
+func TestParamAPI(t *testing.T) {
+	relay, _ := newParamTestRelay(t, &fakeParamVehicle{
+		names:  []string{"RTL_ALT"},
+		values: map[string]float32{"RTL_ALT": 1500},
+	})
+	relay.config.Commands.Token = "secret"
+
+	testCases := []struct {
+		name       string
+		method     string
+		path       string
+		droneID    string
+		param      string
+		token      string
+		body       string
+		wantStatus int
+	}{
+		{"list", http.MethodGet, "", "test-drone", "", "secret", ``, http.StatusOK},
+		{"refresh", http.MethodGet, "?refresh=true", "test-drone", "", "secret", ``, http.StatusOK},
+		{"get", http.MethodGet, "/RTL_ALT", "test-drone", "RTL_ALT", "secret", ``, http.StatusOK},
+		{"set", http.MethodPut, "/RTL_ALT", "test-drone", "RTL_ALT", "secret", `{"value": 1200}`, http.StatusOK},
+		{"unauthorized", http.MethodGet, "", "test-drone", "", "wrong", ``, http.StatusUnauthorized},
+		{"set list", http.MethodPut, "", "test-drone", "", "secret", `{"value": 1}`, http.StatusMethodNotAllowed},
+		{"missing value", http.MethodPut, "/RTL_ALT", "test-drone", "RTL_ALT", "secret", `{}`, http.StatusBadRequest},
+		{"unknown param", http.MethodGet, "/NOPE", "test-drone", "NOPE", "secret", ``, http.StatusNotFound},
+		{"unknown drone", http.MethodGet, "", "ghost", "", "secret", ``, http.StatusNotFound},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req := httptest.NewRequest(tc.method, "/api/v1/params/"+tc.droneID+tc.path, strings.NewReader(tc.body))
+			req.SetPathValue("drone_id", tc.droneID)
+			req.SetPathValue("name", tc.param)
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+			rec := httptest.NewRecorder()
+
+			relay.handleParamRequest(rec, req)
+			if rec.Code != tc.wantStatus {
+				t.Errorf("Expected status %d, got %d: %s", tc.wantStatus, rec.Code, rec.Body.String())
+			}
+		})
+	}
+}
+
+// fakeStreamVehicle acknowledges every command with a fixed result and
+// records what the relay sends it
+type fakeStreamVehicle struct {
+	recordingVehicle
+	result common.MAV_RESULT
+	silent bool // never acknowledge
+}
+
+func (v *fakeStreamVehicle) WriteMessageTo(ch *gomavlib.Channel, m message.Message) error {
+	v.record(m)
+	if command, ok := m.(*common.MessageCommandLong); ok && !v.silent {
+		go v.reply(&common.MessageCommandAck{Command: command.Command, Result: v.result})
+	}
+	return nil
+}
+
+// newStreamTestRelay returns a relay with stream rates configured for the
+// test endpoint and a vehicle answering commands through the fake
+func newStreamTestRelay(vehicle *fakeStreamVehicle, rates map[uint32]float64) *Relay {
+	retries := 1
+	relay := &Relay{
+		config: &config.Config{Commands: config.CommandsConfig{
+			Retries:    &retries,
+			AckTimeout: 20 * time.Millisecond,
+			Timeout:    time.Second,
+		}},
+		sinks:    []sinks.Sink{mock.NewMockSink()},
+		commands: newCommandTracker(),
+		streams:  newStreamManager([]config.MAVLinkEndpoint{{Name: "test-drone", StreamRates: rates}}, common.Dialect),
+	}
+	relay.streams.checkWindow = 10 * time.Millisecond
+	connectTestVehicle(relay, vehicle, nil)
+	return relay
+}
+
+// waitForMessages polls the vehicle until it has received n messages
+func waitForMessages(t *testing.T, vehicle *fakeStreamVehicle, n int) []message.Message {
+	t.Helper()
+	deadline := time.After(2 * time.Second)
+	for {
+		if sent := vehicle.messages(); len(sent) >= n {
+			return sent
+		}
+		select {
+		case <-deadline:
+			t.Fatalf("Expected %d messages, got %d", n, len(vehicle.messages()))
+		case <-time.After(time.Millisecond):
+		}
+	}
+}
+
+// TestStreamRateCheck tests comparing observed and requested stream rates
+func TestStreamRateCheck(t *testing.T) {
+	attitude := (&common.MessageAttitude{}).GetID()
+	vfrHud := (&common.MessageVfrHud{}).GetID()
+	rates := map[uint32]float64{attitude: 10, vfrHud: 4}
+	streams := newStreamManager([]config.MAVLinkEndpoint{{Name: "test-drone", StreamRates: rates}}, common.Dialect)
+
+	for range 9 {
+		streams.observe("test-drone", "drone-1", attitude)
+	}
+	streams.observe("test-drone", "drone-1", vfrHud)
+	streams.observe("other", "drone-1", vfrHud)
+	streams.observe("test-drone", "drone-1", (&common.MessageHeartbeat{}).GetID())
+
+	mismatched := streams.check("drone-1", rates, time.Second)
+	if len(mismatched) != 1 || mismatched[vfrHud] != 4 {
+		t.Errorf("Expected only VFR_HUD to mismatch, got %v", mismatched)
+	}
+	if name := streams.name(vfrHud); name != "VFR_HUD" {
+		t.Errorf("Expected dialect name for VFR_HUD, got %q", name)
+	}
+
+	streams.reset("drone-1")
+	if mismatched := streams.check("drone-1", map[uint32]float64{attitude: 0}, time.Second); len(mismatched) != 0 {
+		t.Errorf("Expected a stopped stream with no frames to match, got %v", mismatched)
+	}
+
+	if newStreamManager([]config.MAVLinkEndpoint{{Name: "test-drone"}}, common.Dialect) != nil {
+		t.Error("Expected no stream manager without stream rates")
+	}
+}
+
+// TestApplyStreams tests requesting stream rates on connect
+func TestApplyStreams(t *testing.T) {
+	attitude := (&common.MessageAttitude{}).GetID()
+	position := (&common.MessageGlobalPositionInt{}).GetID()
+	localPosition := (&common.MessageLocalPositionNed{}).GetID()
+
+	t.Run("set message interval", func(t *testing.T) {
+		vehicle := &fakeStreamVehicle{result: common.MAV_RESULT_ACCEPTED}
+		relay := newStreamTestRelay(vehicle, map[uint32]float64{attitude: 50, position: 0})
+		relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{Autopilot: common.MAV_AUTOPILOT_PX4}), "test-drone", nil)
+
+		// No frames arrive, so the attitude rate is re-applied on every check
+		sent := waitForMessages(t, vehicle, 2+streamCheckAttempts-1)
+		intervals := make(map[float32]float32)
+		for _, m := range sent[:2] {
+			command := m.(*common.MessageCommandLong)
+			if command.Command != common.MAV_CMD_SET_MESSAGE_INTERVAL {
+				t.Fatalf("Expected MAV_CMD_SET_MESSAGE_INTERVAL, got %s", command.Command)
+			}
+			intervals[command.Param1] = command.Param2
+		}
+		if intervals[float32(attitude)] != 20000 || intervals[float32(position)] != -1 {
+			t.Errorf("Expected 20000us for ATTITUDE and -1 for GLOBAL_POSITION_INT, got %v", intervals)
+		}
+		if sent[2].(*common.MessageCommandLong).Param1 != float32(attitude) {
+			t.Errorf("Expected only the mismatched ATTITUDE rate to be re-applied, got %v", sent[2])
+		}
+	})
+
+	t.Run("request data stream fallback", func(t *testing.T) {
+		vehicle := &fakeStreamVehicle{result: common.MAV_RESULT_UNSUPPORTED}
+		relay := newStreamTestRelay(vehicle, map[uint32]float64{attitude: 10, position: 2, localPosition: 5})
+		relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{Autopilot: common.MAV_AUTOPILOT_ARDUPILOTMEGA}), "test-drone", nil)
+
+		sent := waitForMessages(t, vehicle, 3)
+		streams := make(map[common.MAV_DATA_STREAM]uint16)
+		for _, m := range sent[1:3] {
+			request, ok := m.(*common.MessageRequestDataStream)
+			if !ok {
+				t.Fatalf("Expected REQUEST_DATA_STREAM after the unsupported command, got %T", m)
+			}
+			if request.StartStop != 1 {
+				t.Errorf("Expected stream %d to be started", request.ReqStreamId)
+			}
+			streams[common.MAV_DATA_STREAM(request.ReqStreamId)] = request.ReqMessageRate
+		}
+		if streams[common.MAV_DATA_STREAM_EXTRA1] != 10 || streams[common.MAV_DATA_STREAM_POSITION] != 5 {
+			t.Errorf("Expected EXTRA1 at 10Hz and POSITION at 5Hz, got %v", streams)
+		}
+
+		// grouped messages are not checked against their requested rate
+		time.Sleep(5 * relay.streams.checkWindow)
+		if sent := vehicle.messages(); len(sent) != 3 {
+			t.Errorf("Expected no rates to be re-applied, got %d messages", len(sent))
+		}
+	})
+
+	t.Run("fallback after repeated timeouts", func(t *testing.T) {
+		vehicle := &fakeStreamVehicle{silent: true}
+		relay := newStreamTestRelay(vehicle, map[uint32]float64{attitude: 10, position: 2, localPosition: 5})
+		relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{Autopilot: common.MAV_AUTOPILOT_ARDUPILOTMEGA}), "test-drone", nil)
+
+		deadline := time.After(2 * time.Second)
+		for {
+			if sent := vehicle.messages(); len(sent) > 0 {
+				if _, ok := sent[len(sent)-1].(*common.MessageRequestDataStream); ok {
+					break
+				}
+			}
+			select {
+			case <-deadline:
+				t.Fatal("Expected REQUEST_DATA_STREAM once commands kept timing out")
+			case <-time.After(time.Millisecond):
+			}
+		}
+		commanded := make(map[float32]bool)
+		for _, m := range vehicle.messages() {
+			if command, ok := m.(*common.MessageCommandLong); ok {
+				commanded[command.Param1] = true
+			}
+		}
+		if len(commanded) != streamFallbackTimeouts {
+			t.Errorf("Expected %d messages to time out before the fallback, got %v", streamFallbackTimeouts, commanded)
+		}
+	})
+}
+
+// signedFrame encodes a message into a signed frame and reads it back the way
+// the endpoint node delivers it, undecoded. A raw message is sent as it is.
+func signedFrame(t *testing.T, key *frame.V2Key, msg message.Message, linkID uint8, timestamp uint64) frame.Frame {
+	t.Helper()
+	rw, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		t.Fatalf("Failed to create dialect: %v", err)
+	}
+
+	mrw := rw.GetMessage(msg.GetID())
+	payload, ok := msg.(*message.MessageRaw)
+	if !ok {
+		payload = mrw.Write(msg, true)
+	}
+	fr := &frame.V2Frame{
+		IncompatibilityFlag: frame.V2FlagSigned,
+		SystemID:            1,
+		ComponentID:         1,
+		Message:             payload,
+		SignatureLinkID:     linkID,
+		SignatureTimestamp:  timestamp,
+	}
+	fr.Checksum = fr.GenerateChecksum(mrw.CRCExtra())
+	fr.Signature = fr.GenerateSignature(key)
+
+	var buf bytes.Buffer
+	w, err := frame.NewWriter(frame.WriterConf{Writer: &buf, DialectRW: rw, OutVersion: frame.V2, OutSystemID: 1})
+	if err != nil {
+		t.Fatalf("Failed to create writer: %v", err)
+	}
+	if err := w.WriteFrame(fr); err != nil {
+		t.Fatalf("Failed to write frame: %v", err)
+	}
+	r, err := frame.NewReader(frame.ReaderConf{Reader: &buf})
+	if err != nil {
+		t.Fatalf("Failed to create reader: %v", err)
+	}
+	read, err := r.Read()
+	if err != nil {
+		t.Fatalf("Failed to read frame: %v", err)
+	}
+	return read
+}
+
+// TestSignatureVerifier tests accepting and rejecting signed and unsigned frames
+func TestSignatureVerifier(t *testing.T) {
+	rw, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		t.Fatalf("Failed to create dialect: %v", err)
+	}
+	key := &frame.V2Key{1, 2, 3}
+	wrongKey := &frame.V2Key{4, 5, 6}
+	// Trailing zero fields are truncated on the wire and must still verify
+	position := &common.MessageGlobalPositionInt{Lat: 473977418, Lon: 85455939, Alt: 488000}
+	unsigned := newFrameEvent(position).Frame
+	radioStatus := newFrameEvent(&common.MessageRadioStatus{Rssi: 200}).Frame
+	// and senders that keep them sign the bytes they send
+	untruncated := rw.GetMessage(position.GetID()).Write(position, true)
+	untruncated.Payload = append(untruncated.Payload, make([]byte, 28-len(untruncated.Payload))...)
+
+	permissive := newSignatureVerifier(config.SigningConfig{Key: key, Verify: config.SigningVerifyPermissive})
+	strict := newSignatureVerifier(config.SigningConfig{Key: key, Verify: config.SigningVerifyStrict})
+
+	steps := []struct {
+		name     string
+		verifier *signatureVerifier
+		frame    frame.Frame
+		want     string
+	}{
+		{"permissive unsigned", permissive, unsigned, ""},
+		{"permissive signed", permissive, signedFrame(t, key, position, 0, 10_000_000), ""},
+		{"permissive wrong key", permissive, signedFrame(t, wrongKey, position, 0, 10_000_001), rejectBadSignature},
+		{"permissive untruncated", permissive, signedFrame(t, key, untruncated, 0, 10_000_002), ""},
+		{"strict unsigned", strict, unsigned, rejectUnsigned},
+		{"strict unsigned radio status", strict, radioStatus, ""},
+		{"strict signed", strict, signedFrame(t, key, position, 0, 10_000_000), ""},
+		{"replay", strict, signedFrame(t, key, position, 0, 10_000_000), rejectReplay},
+		{"older timestamp", strict, signedFrame(t, key, position, 0, 9_999_999), rejectReplay},
+		{"same timestamp new frame", strict, signedFrame(t, key, &common.MessageGlobalPositionInt{Lat: 1}, 0, 10_000_000), ""},
+		{"newer timestamp", strict, signedFrame(t, key, position, 0, 10_000_100), ""},
+		{"new link", strict, signedFrame(t, key, position, 1, 9_999_000), ""},
+		{"stale new link", strict, signedFrame(t, key, position, 2, 10_000_100-signingTimestampWindow-1), rejectStale},
+	}
+	for _, step := range steps {
+		if got := step.verifier.verify(step.frame); got != step.want {
+			t.Errorf("%s: expected %q, got %q", step.name, step.want, got)
+		}
+	}
+}
+
+// TestVerifySignature tests dropping rejected frames before they are routed or
+// forwarded to sinks
+func TestVerifySignature(t *testing.T) {
+	relay := &Relay{}
+	relay.endpointVerifiers.Store("secure", newSignatureVerifier(config.SigningConfig{
+		Key:    &frame.V2Key{1},
+		Verify: config.SigningVerifyStrict,
+	}))
+
+	evt := newFrameEvent(&common.MessageHeartbeat{})
+	if relay.verifySignature("secure", evt) {
+		t.Error("Expected unsigned frame to be rejected on a strict endpoint")
+	}
+	if !relay.verifySignature("open", evt) {
+		t.Error("Expected frames on endpoints without verification to be accepted")
+	}
+}
+
+// TestLinkTracker tests counting lost, duplicate and out-of-order frames
+func TestLinkTracker(t *testing.T) {
+	tracker := newLinkTracker()
+	autopilot := linkKey{"radio", 1, 1}
+	gimbal := linkKey{"radio", 1, 154}
+
+	// 3 and 4 are lost, 5 is duplicated, 4 arrives late, a burst is lost and
+	// the sequence wraps
+	for _, seq := range []uint8{0, 1, 2, 5, 5, 4, 6, 200, 255, 0, 2} {
+		tracker.observe(autopilot, "drone-alpha", seq)
+	}
+	for _, seq := range []uint8{10, 11} {
+		tracker.observe(gimbal, "drone-alpha", seq)
+	}
+	tracker.observe(linkKey{"gcs", 255, 190}, "", 0)
+
+	statuses := tracker.drain()
+	if len(statuses) != 1 {
+		t.Fatalf("Expected one status for the drone, got %+v", statuses)
+	}
+	// lost: 3, 4 (gap 2->5), 7..199 (gap 6->200), 201..254 (gap 200->255), 1 (gap 0->2)
+	want := linkCounters{received: 13, lost: 2 + 193 + 54 + 1, duplicate: 1, outOfOrder: 1}
+	if got := statuses[0].linkCounters; got != want {
+		t.Errorf("Expected %+v, got %+v", want, got)
+	}
+	if statuses[0].endpoint != "radio" || statuses[0].droneID != "drone-alpha" {
+		t.Errorf("Expected radio/drone-alpha, got %s/%s", statuses[0].endpoint, statuses[0].droneID)
+	}
+
+	if statuses := tracker.drain(); len(statuses) != 0 {
+		t.Errorf("Expected no status without new frames, got %+v", statuses)
+	}
+	tracker.observe(autopilot, "drone-alpha", 3)
+	if statuses := tracker.drain(); len(statuses) != 1 || statuses[0].lost != 0 || statuses[0].received != 1 {
+		t.Errorf("Expected a fresh interval, got %+v", statuses)
+	}
+
+	// removing an endpoint forgets its senders and their metric series
+	tracker.remove("radio")
+	if _, ok := tracker.links[autopilot]; ok || len(tracker.links) != 1 {
+		t.Errorf("Expected only the gcs link to be kept, got %+v", tracker.links)
+	}
+	if relayLinkFramesTotal.DeleteLabelValues("radio", "1", "1") || relayLinkLostFramesTotal.DeleteLabelValues("radio", "1", "154") {
+		t.Error("Expected the radio link series to be deleted")
+	}
+	if !relayLinkFramesTotal.DeleteLabelValues("gcs", "255", "190") {
+		t.Error("Expected the gcs link series to be kept")
+	}
+}
+
+// TestSendLinkStatus tests sending LinkStatus envelopes to sinks
+func TestSendLinkStatus(t *testing.T) {
+	sink := mock.NewMockSink()
+	relay := &Relay{sinks: []sinks.Sink{sink}, links: newLinkTracker()}
+	relay.endpointDroneIDs.Store("radio", "drone-alpha")
+
+	for _, seq := range []uint8{0, 1, 3} {
+		evt := newFrameEvent(&common.MessageHeartbeat{})
+		evt.Frame.(*frame.V2Frame).SequenceID = seq
+		relay.trackLinkQuality("radio", evt)
+	}
+	relay.sendLinkStatus(10 * time.Second)
+
+	messages := sink.GetMessages()
+	if len(messages) != 1 || messages[0].MsgName != "LinkStatus" {
+		t.Fatalf("Expected one LinkStatus envelope, got %+v", messages)
+	}
+	fields := messages[0].Fields
+	if messages[0].DroneID != "drone-alpha" || messages[0].Source != "radio" {
+		t.Errorf("Expected drone-alpha on radio, got %s on %s", messages[0].DroneID, messages[0].Source)
+	}
+	if fields["received"] != uint64(3) || fields["lost"] != uint64(1) || fields["loss_percent"] != 25.0 {
+		t.Errorf("Expected 3 received, 1 lost and 25%% loss, got %v", fields)
+	}
+}
+
+// TestLiveness tests marking drones online and offline from autopilot heartbeats
+func TestLiveness(t *testing.T) {
+	sink := mock.NewMockSink()
+	relay := &Relay{sinks: []sinks.Sink{sink}, liveness: newLivenessTracker(time.Second)}
+	relay.endpointDroneIDs.Store("test-drone", "test-drone")
+	heartbeat := newFrameEvent(&common.MessageHeartbeat{Autopilot: common.MAV_AUTOPILOT_PX4, Type: common.MAV_TYPE_QUADROTOR})
+
+	livenessEvents := func() []telemetry.TelemetryEnvelope {
+		var events []telemetry.TelemetryEnvelope
+		for _, msg := range sink.GetMessages() {
+			if msg.MsgName == "VehicleOnline" || msg.MsgName == "VehicleOffline" {
+				events = append(events, msg)
+			}
+		}
+		return events
+	}
+
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageHeartbeat{Autopilot: common.MAV_AUTOPILOT_INVALID}), "test-drone", nil)
+	if events := livenessEvents(); len(events) != 0 {
+		t.Fatalf("Expected non-autopilot heartbeats to be ignored, got %+v", events)
+	}
+
+	relay.handleFrame(context.Background(), heartbeat, "test-drone", nil)
+	relay.handleFrame(context.Background(), heartbeat, "test-drone", nil)
+	events := livenessEvents()
+	if len(events) != 1 || events[0].MsgName != "VehicleOnline" || events[0].Fields["connected"] != true {
+		t.Fatalf("Expected one VehicleOnline envelope, got %+v", events)
+	}
+	if events[0].Fields["vehicle_type"] != common.MAV_TYPE_QUADROTOR.String() {
+		t.Errorf("Expected vehicle type in envelope, got %v", events[0].Fields)
+	}
+
+	relay.checkLiveness(time.Now())
+	if events := livenessEvents(); len(events) != 1 {
+		t.Fatalf("Expected the drone to stay online within the timeout, got %+v", events)
+	}
+
+	relay.checkLiveness(time.Now().Add(2 * time.Second))
+	events = livenessEvents()
+	if len(events) != 2 || events[1].MsgName != "VehicleOffline" || events[1].Fields["connected"] != false {
+		t.Fatalf("Expected a VehicleOffline envelope, got %+v", events)
+	}
+	if _, ok := relay.droneLinks.Load("test-drone"); ok {
+		t.Error("Expected the link of an offline drone to be forgotten")
+	}
+	relay.checkLiveness(time.Now().Add(3 * time.Second))
+	if events := livenessEvents(); len(events) != 2 {
+		t.Errorf("Expected a single VehicleOffline envelope, got %+v", events)
+	}
+
+	relay.handleFrame(context.Background(), heartbeat, "test-drone", nil)
+	events = livenessEvents()
+	if len(events) != 3 || events[2].MsgName != "VehicleOnline" {
+		t.Fatalf("Expected the drone to come back online, got %+v", events)
+	}
+	if _, ok := relay.droneLinks.Load("test-drone"); !ok {
+		t.Error("Expected the link to be tracked again")
+	}
+}
+
+// TestStatusText tests STATUSTEXT reassembly into StatusText events
+func TestStatusText(t *testing.T) {
+	sink := mock.NewMockSink()
+	relay := &Relay{sinks: []sinks.Sink{sink}, statusTexts: newStatusTextAssembler(time.Second)}
+	relay.endpointDroneIDs.Store("test-drone", "test-drone")
+
+	statusEvents := func() []telemetry.TelemetryEnvelope {
+		var events []telemetry.TelemetryEnvelope
+		for _, msg := range sink.GetMessages() {
+			if msg.MsgName == "StatusText" {
+				events = append(events, msg)
+			}
+		}
+		return events
+	}
+
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageStatustext{
+		Severity: common.MAV_SEVERITY_CRITICAL,
+		Text:     "PreArm: Compass not calibrated",
+	}), "test-drone", nil)
+	events := statusEvents()
+	if len(events) != 1 || !events[0].IsEvent() {
+		t.Fatalf("Expected one StatusText event, got %+v", events)
+	}
+	if events[0].Fields["text"] != "PreArm: Compass not calibrated" || events[0].Fields["severity"] != "critical" {
+		t.Errorf("Unexpected StatusText fields %v", events[0].Fields)
+	}
+
+	// A long message split in chunks, the last one arriving first
+	first := strings.Repeat("a", statusTextChunkLen)
+	second := strings.Repeat("b", statusTextChunkLen)
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageStatustext{Severity: common.MAV_SEVERITY_WARNING, Text: "c", Id: 7, ChunkSeq: 2}), "test-drone", nil)
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageStatustext{Severity: common.MAV_SEVERITY_WARNING, Text: first, Id: 7, ChunkSeq: 0}), "test-drone", nil)
+	if events := statusEvents(); len(events) != 1 {
+		t.Fatalf("Expected the chunked message to wait for its missing chunk, got %+v", events)
+	}
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageStatustext{Severity: common.MAV_SEVERITY_WARNING, Text: second, Id: 7, ChunkSeq: 1}), "test-drone", nil)
+	events = statusEvents()
+	if len(events) != 2 {
+		t.Fatalf("Expected the chunked message once complete, got %+v", events)
+	}
+	if events[1].Fields["text"] != first+second+"c" || events[1].Fields["chunks"] != 3 || events[1].Fields["complete"] != true {
+		t.Errorf("Unexpected reassembled fields %v", events[1].Fields)
+	}
+
+	// Chunks that never complete are published once they time out
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageStatustext{Severity: common.MAV_SEVERITY_INFO, Text: first, Id: 8}), "test-drone", nil)
+	if expired := relay.statusTexts.expire(time.Now()); len(expired) != 0 {
+		t.Fatalf("Expected pending chunks to be kept within the timeout, got %+v", expired)
+	}
+	expired := relay.statusTexts.expire(time.Now().Add(2 * time.Second))
+	if len(expired) != 1 || expired[0].text != first || expired[0].complete {
+		t.Errorf("Expected the incomplete message to expire, got %+v", expired)
+	}
+
+	// An excluded STATUSTEXT produces no event
+	relay.setEndpointFilter(config.MAVLinkEndpoint{
+		Name:   "test-drone",
+		Filter: config.MessageFilter{ExcludeIDs: []uint32{(&common.MessageStatustext{}).GetID()}},
+	})
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageStatustext{Severity: common.MAV_SEVERITY_CRITICAL, Text: "filtered"}), "test-drone", nil)
+	relay.handleFrame(context.Background(), newFrameEvent(&common.MessageStatustext{Severity: common.MAV_SEVERITY_INFO, Text: first, Id: 9}), "test-drone", nil)
+	if events := statusEvents(); len(events) != 2 {
+		t.Errorf("Expected no event for an excluded STATUSTEXT, got %+v", events[2:])
+	}
+	if expired := relay.statusTexts.expire(time.Now().Add(2 * time.Second)); len(expired) != 0 {
+		t.Errorf("Expected excluded chunks to be dropped, got %+v", expired)
+	}
+}
+
+// TestStartEndpointRetry tests that an endpoint failing to open is retried in
+// the background while its state is tracked
+func TestStartEndpointRetry(t *testing.T) {
+	enabled := true
+	endpoint := config.MAVLinkEndpoint{
+		Name:     "flaky",
+		DroneID:  "drone-1",
+		Protocol: config.MAVLinkEndpointProtocolUDP,
+		Address:  "127.0.0.1",
+		Identity: config.IdentityConfig{
+			SystemID:    255,
+			ComponentID: 190,
+			Version:     2,
+			Heartbeat:   config.HeartbeatConfig{Enabled: &enabled, Rate: 1, Type: common.MAV_TYPE_GCS},
+		},
+	}
+
+	var mu sync.Mutex
+	attempts := 0
+	origNewNode := newNode
+	newNode = func(conf gomavlib.NodeConf) (*gomavlib.Node, error) {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts++
+		if attempts < 3 {
+			return nil, errors.New("address already in use")
+		}
+		return origNewNode(conf)
+	}
+	defer func() { newNode = origNewNode }()
+
+	relay := &Relay{config: &config.Config{}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	relay.setEndpointState(endpoint.Name, endpointStarting)
+	done := make(chan struct{})
+	go func() {
+		relay.startEndpoint(ctx, endpoint, common.Dialect, time.Millisecond)
+		close(done)
+	}()
+
+	deadline := time.Now().Add(2 * time.Second)
+	for relay.endpointStatus()[endpoint.Name] != endpointUp {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected endpoint to come up, state %s", relay.endpointStatus()[endpoint.Name])
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	mu.Lock()
+	if attempts != 3 {
+		t.Errorf("Expected 3 open attempts, got %d", attempts)
+	}
+	mu.Unlock()
+	if droneID, ok := relay.endpointDroneIDs.Load(endpoint.Name); !ok || droneID != "drone-1" {
+		t.Errorf("Expected drone mapping once open, got %v", droneID)
+	}
+
+	conn, _ := relay.connections.Load(endpoint.Name)
+	conn.(*endpointNode).Close()
+	<-done
+
+	// an endpoint that cannot be configured fails without retrying
+	relay.startEndpoint(ctx, config.MAVLinkEndpoint{Name: "bogus", Protocol: "bogus"}, common.Dialect, time.Millisecond)
+	if state := relay.endpointStatus()["bogus"]; state != endpointFailed {
+		t.Errorf("Expected bogus endpoint to fail, got %s", state)
+	}
+
+	// an endpoint that failed validation is reported as failed and never opened
+	relay.launchEndpoint(ctx, config.MAVLinkEndpoint{Name: "invalid", Error: config.ErrDroneIDRequired}, common.Dialect)
+	if state := relay.endpointStatus()["invalid"]; state != endpointFailed {
+		t.Errorf("Expected invalid endpoint to fail, got %s", state)
+	}
+	if _, ok := relay.endpointCancels.Load("invalid"); ok {
+		t.Error("Expected the invalid endpoint not to be started")
+	}
+}
+
+// TestReadyEndpointStates tests the /readyz response for endpoint states
+func TestReadyEndpointStates(t *testing.T) {
+	relay := &Relay{sinksInitialized: true}
+	relay.setEndpointState("radio", endpointRetrying)
+	relay.setEndpointState("wifi", endpointStarting)
+
+	check := func(wantCode int, wantStatus string) {
+		t.Helper()
+		rec := httptest.NewRecorder()
+		relay.handleReady(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+
+		var status readyStatus
+		if err := json.NewDecoder(rec.Body).Decode(&status); err != nil {
+			t.Fatalf("Failed to decode response: %v", err)
+		}
+		if rec.Code != wantCode || status.Status != wantStatus {
+			t.Errorf("Expected %d %s, got %d %s", wantCode, wantStatus, rec.Code, status.Status)
+		}
+		if len(status.Endpoints) != 2 {
+			t.Errorf("Expected both endpoints in the response, got %v", status.Endpoints)
+		}
+	}
+
+	check(http.StatusServiceUnavailable, "not ready")
+	relay.setEndpointState("wifi", endpointUp)
+	check(http.StatusOK, "degraded")
+	relay.setEndpointState("radio", endpointUp)
+	check(http.StatusOK, "ok")
+}
+
+// TestEndpointAdminAPI tests adding, listing and removing endpoints at runtime
+func TestEndpointAdminAPI(t *testing.T) {
+	port := freeUDPPort(t)
+	enabled := true
+	stateFile := filepath.Join(t.TempDir(), "endpoints.yaml")
+	cfg := &config.Config{
+		MAVLink: config.MAVLinkConfig{
+			Dialect: common.Dialect,
+			Identity: config.IdentityConfig{
+				SystemID:    255,
+				ComponentID: 190,
+				Version:     2,
+				Heartbeat:   config.HeartbeatConfig{Enabled: &enabled, Rate: 1, TypeName: "GCS"},
+			},
+		},
+		Admin: config.AdminConfig{Enabled: true, Token: "admin-secret", StateFile: stateFile},
+	}
+	relay := &Relay{config: cfg}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	handler := relay.endpointAdminHandler(ctx)
+
+	doAs := func(token, method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		req := httptest.NewRequest(method, path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer "+token)
+		if name, ok := strings.CutPrefix(path, "/api/v1/endpoints/"); ok {
+			req.SetPathValue("name", name)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		return rec
+	}
+	do := func(method, path, body string) *httptest.ResponseRecorder {
+		t.Helper()
+		return doAs("admin-secret", method, path, body)
+	}
+
+	body := fmt.Sprintf(`{"name": "bench", "mode": "1:1", "drone_id": "drone-9", "protocol": "udp", "address": "127.0.0.1", "port": %d}`, port)
+	if rec := doAs("wrong", http.MethodPost, "/api/v1/endpoints", body); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 for a wrong token, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/endpoints", body); rec.Code != http.StatusCreated {
+		t.Fatalf("Expected 201, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodPost, "/api/v1/endpoints", body); rec.Code != http.StatusConflict {
+		t.Errorf("Expected 409 for a duplicate endpoint, got %d", rec.Code)
+	}
+	if rec := do(http.MethodPost, "/api/v1/endpoints", `{"name": "bad", "protocol": "carrier_pigeon"}`); rec.Code != http.StatusBadRequest {
+		t.Errorf("Expected 400 for an invalid endpoint, got %d", rec.Code)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for relay.endpointStatus()["bench"] != endpointUp {
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected endpoint to come up, state %s", relay.endpointStatus()["bench"])
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if droneID, ok := relay.endpointDroneIDs.Load("bench"); !ok || droneID != "drone-9" {
+		t.Errorf("Expected drone mapping for the added endpoint, got %v", droneID)
+	}
+
+	rec := do(http.MethodGet, "/api/v1/endpoints", "")
+	var infos []endpointInfo
+	if err := json.NewDecoder(rec.Body).Decode(&infos); err != nil {
+		t.Fatalf("Failed to decode response: %v", err)
+	}
+	if len(infos) != 1 || infos[0].Name != "bench" || infos[0].State != endpointUp {
+		t.Errorf("Expected bench to be listed as up, got %+v", infos)
+	}
+
+	changes, err := config.LoadEndpointChanges(stateFile)
+	if err != nil || len(changes.Added) != 1 || changes.Added[0].Name != "bench" {
+		t.Errorf("Expected the added endpoint in the state file, got %+v, %v", changes, err)
+	}
+
+	if rec := do(http.MethodDelete, "/api/v1/endpoints/bench", ""); rec.Code != http.StatusNoContent {
+		t.Fatalf("Expected 204, got %d: %s", rec.Code, rec.Body)
+	}
+	if rec := do(http.MethodGet, "/api/v1/endpoints/bench", ""); rec.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 after removal, got %d", rec.Code)
+	}
+	if _, ok := relay.connections.Load("bench"); ok {
+		t.Error("Expected the node to be closed and forgotten")
+	}
+	if _, ok := relay.endpointDroneIDs.Load("bench"); ok {
+		t.Error("Expected the drone mapping to be removed")
+	}
+
+	changes, err = config.LoadEndpointChanges(stateFile)
+	if err != nil || len(changes.Added) != 0 || !reflect.DeepEqual(changes.Removed, []string{"bench"}) {
+		t.Errorf("Expected the removal in the state file, got %+v, %v", changes, err)
+	}
+
+	// without a token every request is refused, even one without credentials
+	cfg.Admin.Token = ""
+	if rec := doAs("", http.MethodGet, "/api/v1/endpoints", ""); rec.Code != http.StatusUnauthorized {
+		t.Errorf("Expected 401 without an admin token, got %d", rec.Code)
+	}
+}
+
+// freeUDPPort returns a local UDP port nothing is listening on
+func freeUDPPort(t *testing.T) int {
+	t.Helper()
+
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatalf("Failed to find a free port: %v", err)
+	}
+	defer conn.Close()
+	return conn.LocalAddr().(*net.UDPAddr).Port
+}
+
+// TestConfigReload tests applying a changed config file to a running relay
+func TestConfigReload(t *testing.T) {
+	dir := t.TempDir()
+	path := filepath.Join(dir, "config.yaml")
+	vehiclePort, benchPort, sparePort := freeUDPPort(t), freeUDPPort(t), freeUDPPort(t)
+	writeConfig := func(endpoints, format string, mavlink ...string) {
+		t.Helper()
+		content := fmt.Sprintf(`
+mavlink:
+%s
+  endpoints:
+%s
+sinks:
+  file:
+    path: %q
+    format: %q
+`, strings.Join(mavlink, "\n"), endpoints, dir, format)
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatalf("Failed to write config: %v", err)
+		}
+	}
+	endpoint := func(name string, port int) string {
+		return fmt.Sprintf(`    - {name: %q, drone_id: %q, mode: "1:1", protocol: "udp", address: "127.0.0.1", port: %d}`, name, name, port)
+	}
+
+	writeConfig(endpoint("vehicle", vehiclePort)+"\n"+endpoint("bench", benchPort), "json")
+	cfg, err := config.Load(path)
+	if err != nil {
+		t.Fatalf("Failed to load config: %v", err)
+	}
+	relay, err := New(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create relay: %v", err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	defer func() { closeSinks(relay.sinks) }()
+
+	if err := relay.startEndpoints(ctx, cfg.MAVLink.Dialect); err != nil {
+		t.Fatalf("Failed to start endpoints: %v", err)
+	}
+	waitEndpoint := func(name string) any {
+		t.Helper()
+		deadline := time.Now().Add(2 * time.Second)
+		for relay.endpointStatus()[name] != endpointUp {
+			if time.Now().After(deadline) {
+				t.Fatalf("Expected endpoint %s to come up, state %s", name, relay.endpointStatus()[name])
+			}
+			time.Sleep(time.Millisecond)
+		}
+		conn, _ := relay.connections.Load(name)
+		return conn
+	}
+	vehicleNode := waitEndpoint("vehicle")
+	waitEndpoint("bench")
+	fileSink := relay.sinks[0]
+
+	// bench is replaced by spare, the file sink switches to CSV and the
+	// global filter changes
+	writeConfig(endpoint("vehicle", vehiclePort)+"\n"+endpoint("spare", sparePort), "csv", `  exclude: ["ATTITUDE"]`, `  downsample:
+    GLOBAL_POSITION_INT: {strategy: every_nth, every: 2}`)
+	if err := relay.reload(ctx); err != nil {
+		t.Fatalf("Failed to reload: %v", err)
+	}
+	// endpoints added through the admin API are resolved against the new
+	// global settings
+	added := config.MAVLinkEndpoint{Name: "added", DroneID: "added", ProtocolName: "udp", ModeName: "1:1", Port: 1}
+	if err := relay.config.MAVLink.ResolveEndpoint(&added); err != nil {
+		t.Fatalf("Failed to resolve endpoint: %v", err)
+	}
+	if !reflect.DeepEqual(added.Filter.ExcludeIDs, []uint32{30}) {
+		t.Errorf("Expected the reloaded global filter, got %+v", added.Filter)
+	}
+	if rule, ok := added.DownsampleRules[33]; !ok || rule.Every != 2 {
+		t.Errorf("Expected the reloaded global downsampling rule, got %+v", added.DownsampleRules)
+	}
+	waitEndpoint("spare")
+	if conn, _ := relay.connections.Load("vehicle"); conn != vehicleNode {
+		t.Error("Expected the unchanged endpoint to keep running")
+	}
+	if _, ok := relay.connections.Load("bench"); ok {
+		t.Error("Expected the removed endpoint to be closed")
+	}
+	if len(relay.sinks) != 1 || relay.sinks[0] == fileSink {
+		t.Error("Expected the file sink to be rebuilt")
+	}
+	fileSink = relay.sinks[0]
+
+	// An invalid config leaves everything running
+	if err := os.WriteFile(path, []byte("mavlink: {endpoints: []}"), 0o644); err != nil {
+		t.Fatalf("Failed to write config: %v", err)
+	}
+	if err := relay.reload(ctx); !errors.Is(err, config.ErrNoEndpoints) {
+		t.Errorf("Expected %v, got %v", config.ErrNoEndpoints, err)
+	}
+	if relay.sinks[0] != fileSink || len(relay.config.MAVLink.Endpoints) != 2 {
+		t.Error("Expected the relay to keep the current config")
+	}
+	if status := relay.endpointStatus(); status["vehicle"] != endpointUp || status["spare"] != endpointUp {
+		t.Errorf("Expected the endpoints to keep running, got %v", status)
+	}
+}
+
+// TestSimVehicle tests the flight model of simulated vehicles: the orbit,
+// battery drain and the return home on a low battery
+func TestSimVehicle(t *testing.T) {
+	cfg := config.SimConfig{
+		Vehicles:     2,
+		Autopilot:    "px4",
+		Speed:        10,
+		BatteryDrain: 60,
+		Orbit:        config.SimOrbit{Latitude: 47.397742, Longitude: 8.545594, Radius: 100, Altitude: 50},
+	}
+	path := newSimPath(cfg)
+	start := time.Now()
+	first, second := newSimVehicle(cfg, path, 0, start), newSimVehicle(cfg, path, 1, start)
+
+	// the vehicles fly opposite sides of the orbit at its radius and altitude
+	now := start
+	for range 100 {
+		now = now.Add(100 * time.Millisecond)
+		first.step(now)
+		second.step(now)
+		for _, v := range []*simVehicle{first, second} {
+			if radius := math.Hypot(v.pos.north, v.pos.east); math.Abs(radius-100) > 1e-6 || v.pos.alt != 50 {
+				t.Fatalf("Expected the vehicle on the orbit, got radius %v at %v m", radius, v.pos.alt)
+			}
+		}
+	}
+	if gap := math.Hypot(first.pos.north-second.pos.north, first.pos.east-second.pos.east); math.Abs(gap-200) > 1e-6 {
+		t.Errorf("Expected the vehicles 200 m apart, got %v", gap)
+	}
+	if math.Abs(first.battery-90) > 1e-6 {
+		t.Errorf("Expected 10%% drained in 10 s, got %v%% left", first.battery)
+	}
+
+	heartbeat := first.message("HEARTBEAT", now).(*common.MessageHeartbeat)
+	if mode := telemetry.FlightMode(heartbeat.Autopilot, heartbeat.Type, heartbeat.CustomMode); mode != "AUTO.MISSION" {
+		t.Errorf("Expected AUTO.MISSION, got %s", mode)
+	}
+	position := first.message("GLOBAL_POSITION_INT", now).(*common.MessageGlobalPositionInt)
+	if lat, lon := float64(position.Lat)/1e7, float64(position.Lon)/1e7; math.Abs(lat-47.397742) > 0.002 || math.Abs(lon-8.545594) > 0.002 {
+		t.Errorf("Expected a position near the orbit center, got %v, %v", lat, lon)
+	}
+
+	// on a low battery the vehicle flies home, lands and recharges
+	var modes []string
+	for range 1500 {
+		now = now.Add(100 * time.Millisecond)
+		heartbeat := second.message("HEARTBEAT", now).(*common.MessageHeartbeat)
+		mode := telemetry.FlightMode(heartbeat.Autopilot, heartbeat.Type, heartbeat.CustomMode)
+		if len(modes) == 0 || modes[len(modes)-1] != mode {
+			modes = append(modes, mode)
+		}
+	}
+	want := []string{"AUTO.MISSION", "AUTO.RTL", "AUTO.LAND", "AUTO.TAKEOFF", "AUTO.MISSION"}
+	if !reflect.DeepEqual(modes, want) {
+		t.Errorf("Expected modes %v, got %v", want, modes)
+	}
+}
+
+// TestSimEndpoint tests that a sim endpoint feeds the telemetry of every
+// simulated vehicle through the relay
+func TestSimEndpoint(t *testing.T) {
+	enabled := false
+	endpoint := config.MAVLinkEndpoint{
+		Name:            "sim",
+		Protocol:        config.MAVLinkEndpointProtocolSim,
+		Mode:            config.MAVLinkModeMulti,
+		DroneIDTemplate: "sim-{system_id}",
+		Sim: config.SimConfig{
+			Vehicles:     2,
+			Autopilot:    "ardupilot",
+			Speed:        10,
+			BatteryDrain: 2,
+			Orbit:        config.SimOrbit{Latitude: 47.397742, Longitude: 8.545594, Radius: 100, Altitude: 50},
+			// VFR_HUD disabled
+			MessageRates: map[string]float64{"HEARTBEAT": 1, "SYS_STATUS": 1, "GLOBAL_POSITION_INT": 5, "ATTITUDE": 50, "VFR_HUD": 0},
+		},
+		Identity: config.IdentityConfig{SystemID: 255, ComponentID: 190, Version: 2, Heartbeat: config.HeartbeatConfig{Enabled: &enabled, Rate: 1}},
+	}
+	dialectRW, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		t.Fatalf("Failed to create dialect read writer: %v", err)
+	}
+	relay := &Relay{
+		config:    &config.Config{MAVLink: config.MAVLinkConfig{Dialect: common.Dialect, Endpoints: []config.MAVLinkEndpoint{endpoint}}},
+		sinks:     []sinks.Sink{mock.NewMockSink()},
+		dialectRW: dialectRW,
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	relay.launchEndpoint(ctx, endpoint, common.Dialect)
+	defer func() {
+		relay.endpointsMu.Lock()
+		relay.stopEndpoint("sim")
+		relay.endpointsMu.Unlock()
+	}()
+
+	mockSink := relay.sinks[0].(*mock.MockSink)
+	want := map[string]bool{}
+	for _, droneID := range []string{"sim-1", "sim-2"} {
+		for _, name := range []string{"Heartbeat", "SystemStatus", "GlobalPositionInt", "Attitude"} {
+			want[droneID+" "+name] = true
+		}
+	}
+	deadline := time.Now().Add(3 * time.Second)
+	for {
+		got := map[string]bool{}
+		for _, msg := range mockSink.GetMessages() {
+			got[msg.DroneID+" "+msg.MsgName] = true
+		}
+		if reflect.DeepEqual(got, want) {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("Expected %v, got %v", want, got)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,263 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log/slog"
+	"maps"
+	"os"
+	"reflect"
+	"slices"
+	"time"
+
+	"github.com/makinje/aero-arc-relay/internal/config"
+	"github.com/makinje/aero-arc-relay/internal/sinks"
+)
+
+var (
+	errNoConfigFile  = errors.New("config was not loaded from a file")
+	errDialectChange = errors.New("changing the MAVLink dialect requires a restart")
+)
+
+// reload loads the config file again and applies it. An invalid config is
+// reported and the relay keeps running on the current one.
+func (r *Relay) reload(ctx context.Context) error {
+	err := r.reloadConfig(ctx)
+	if err != nil {
+		relayConfigReloadsTotal.WithLabelValues("failure").Inc()
+		relayConfigLastReloadSuccess.Set(0)
+		slog.LogAttrs(context.Background(), slog.LevelError, "config reload failed, keeping the current config",
+			slog.String("path", r.config.Path),
+			slog.String("error", err.Error()))
+		return err
+	}
+
+	relayConfigReloadsTotal.WithLabelValues("success").Inc()
+	relayConfigLastReloadSuccess.Set(1)
+	slog.LogAttrs(context.Background(), slog.LevelInfo, "config reloaded", slog.String("path", r.config.Path))
+	return nil
+}
+
+func (r *Relay) reloadConfig(ctx context.Context) error {
+	if r.config.Path == "" {
+		return errNoConfigFile
+	}
+	next, err := config.Load(r.config.Path)
+	if err != nil {
+		return err
+	}
+	return r.applyConfig(ctx, next)
+}
+
+// watchConfig reloads the config file whenever its modification time or size
+// changes, checking at the interval until the context is done
+func (r *Relay) watchConfig(ctx context.Context, interval time.Duration) {
+	if interval <= 0 || r.config.Path == "" {
+		return
+	}
+
+	last, err := os.Stat(r.config.Path)
+	if err != nil {
+		slog.LogAttrs(context.Background(), slog.LevelWarn, "cannot watch config file",
+			slog.String("path", r.config.Path),
+			slog.String("error", err.Error()))
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			info, err := os.Stat(r.config.Path)
+			if err != nil {
+				// editors may replace the file; wait for it to come back
+				continue
+			}
+			if last != nil && info.ModTime().Equal(last.ModTime()) && info.Size() == last.Size() {
+				continue
+			}
+			last = info
+			r.reload(ctx)
+		}
+	}
+}
+
+// applyConfig switches the relay to a new config. Sinks whose section changed
+// are rebuilt and the old ones drained and closed, endpoints are added,
+// removed or restarted when their settings changed, and message filters and
+// downsampling rules are replaced. Nothing is changed if a new sink cannot be created.
+func (r *Relay) applyConfig(ctx context.Context, next *config.Config) error {
+	r.endpointsMu.Lock()
+	defer r.endpointsMu.Unlock()
+
+	if next.MAVLink.DialectName != r.config.MAVLink.DialectName {
+		return fmt.Errorf("%w: %s to %s", errDialectChange, r.config.MAVLink.DialectName, next.MAVLink.DialectName)
+	}
+
+	// Without a state file, endpoint changes made through the admin API only
+	// live in memory; keep them
+	if r.config.Admin.StateFile == "" && r.config.Admin.Changes != nil {
+		if err := next.MAVLink.ApplyEndpointChanges(r.config.Admin.Changes); err != nil {
+			return fmt.Errorf("failed to apply endpoint changes: %w", err)
+		}
+		next.Admin.Changes = r.config.Admin.Changes
+	}
+
+	byKind, created, err := r.buildSinks(next)
+	if err != nil {
+		return err
+	}
+
+	factory := sinks.NewSinkFactory()
+	var retired []sinks.Sink
+	r.sinksMu.Lock()
+	for kind, sink := range r.sinkKinds {
+		if byKind[kind] != sink {
+			retired = append(retired, sink)
+		}
+	}
+	r.sinkKinds = byKind
+	r.sinks = orderedSinks(factory, next, byKind)
+	r.forwardedMessages = forwardedMessages(next.MAVLink.MessageIDs)
+	r.sinksMu.Unlock()
+
+	// No writes to the retired sinks are in progress once the lock is
+	// released, so closing them flushes everything they were given. Their
+	// command subscriptions go first, or both would run every command.
+	unsubscribeCommands(retired)
+	r.subscribeCommands(created)
+	closeSinks(retired)
+
+	r.applyEndpoints(ctx, next.MAVLink.Endpoints)
+
+	for _, setting := range restartSettings(r.config, next) {
+		slog.LogAttrs(context.Background(), slog.LevelWarn, "config setting changed, restart the relay to apply it",
+			slog.String("setting", setting))
+	}
+
+	// The global MAVLink settings are defaults for endpoints added later
+	// through the admin API. The running endpoints were applied above and
+	// routing needs a restart; the dialect is unchanged and stays shared.
+	mavlink := next.MAVLink
+	mavlink.Dialect = r.config.MAVLink.Dialect
+	mavlink.Endpoints = r.config.MAVLink.Endpoints
+	mavlink.Routing = r.config.MAVLink.Routing
+	r.config.MAVLink = mavlink
+	r.config.Sinks = next.Sinks
+	r.config.Admin.Changes = next.Admin.Changes
+	return nil
+}
+
+// buildSinks returns the sinks for a new config: running sinks whose section
+// did not change are kept, the others are created. Sinks created before a
+// failure are closed again.
+func (r *Relay) buildSinks(next *config.Config) (map[string]sinks.Sink, []sinks.Sink, error) {
+	factory := sinks.NewSinkFactory()
+
+	r.sinksMu.RLock()
+	current := maps.Clone(r.sinkKinds)
+	r.sinksMu.RUnlock()
+
+	byKind := make(map[string]sinks.Sink)
+	var created []sinks.Sink
+	for _, kind := range factory.ConfiguredKinds(next) {
+		changed := factory.ConfigChanged(kind, r.config, next)
+		if sink, ok := current[kind]; ok && !changed {
+			byKind[kind] = sink
+			continue
+		}
+
+		sink, err := factory.CreateSink(kind, next)
+		if err != nil {
+			if changed {
+				closeSinks(created)
+				return nil, nil, err
+			}
+			// an unchanged sink that failed at startup is retried, but
+			// does not hold up the reload
+			slog.LogAttrs(context.Background(), slog.LevelWarn, "failed to create sink",
+				slog.String("sink", kind),
+				slog.String("error", err.Error()))
+			continue
+		}
+		byKind[kind] = sink
+		created = append(created, sink)
+	}
+
+	if len(byKind) == 0 {
+		closeSinks(created)
+		return nil, nil, fmt.Errorf("no sinks could be created - check configuration and dependencies")
+	}
+	return byKind, created, nil
+}
+
+// applyEndpoints stops the endpoints that were removed or whose settings
+// changed and starts the new and changed ones. Unchanged endpoints keep
+// running with their new filters and downsampling rules. The caller holds endpointsMu.
+func (r *Relay) applyEndpoints(ctx context.Context, endpoints []config.MAVLinkEndpoint) {
+	running := r.config.MAVLink.Endpoints
+	// filters and downsampling rules are swapped in place, so changing them
+	// does not restart the endpoint
+	unchanged := func(endpoint config.MAVLinkEndpoint, others []config.MAVLinkEndpoint) bool {
+		i := slices.IndexFunc(others, func(other config.MAVLinkEndpoint) bool { return other.Name == endpoint.Name })
+		if i < 0 {
+			return false
+		}
+		other := others[i]
+		endpoint.Filter, other.Filter = config.MessageFilter{}, config.MessageFilter{}
+		endpoint.Downsample, other.Downsample = nil, nil
+		endpoint.DownsampleRules, other.DownsampleRules = nil, nil
+		return reflect.DeepEqual(endpoint, other)
+	}
+
+	for _, endpoint := range running {
+		if !unchanged(endpoint, endpoints) {
+			r.stopEndpoint(endpoint.Name)
+			slog.LogAttrs(context.Background(), slog.LevelInfo, "MAVLink endpoint stopped by reload",
+				slog.String("endpoint", endpoint.Name))
+		}
+	}
+	for _, endpoint := range endpoints {
+		if unchanged(endpoint, running) {
+			r.setEndpointFilter(endpoint)
+			r.setEndpointDownsampling(endpoint)
+			continue
+		}
+		if r.streams != nil {
+			r.streams.setRates(endpoint.Name, endpoint.StreamRates)
+		}
+		r.launchEndpoint(ctx, endpoint, r.config.MAVLink.Dialect)
+	}
+
+	r.config.MAVLink.Endpoints = endpoints
+}
+
+// restartSettings names the changed config sections a reload does not apply
+func restartSettings(current, next *config.Config) []string {
+	var changed []string
+	for _, setting := range []struct {
+		name          string
+		current, next any
+	}{
+		{"relay", current.Relay, next.Relay},
+		{"logging", current.Logging, next.Logging},
+		{"commands", current.Commands, next.Commands},
+		{"missions", current.Missions, next.Missions},
+		{"params", current.Params, next.Params},
+		{"liveness", current.Liveness, next.Liveness},
+		{"admin.enabled", current.Admin.Enabled, next.Admin.Enabled},
+		{"admin.state_file", current.Admin.StateFile, next.Admin.StateFile},
+		{"admin.token", current.Admin.Token, next.Admin.Token},
+		{"recorder", current.Recorder, next.Recorder},
+		{"mavlink.routing", current.MAVLink.Routing, next.MAVLink.Routing},
+	} {
+		if !reflect.DeepEqual(setting.current, setting.next) {
+			changed = append(changed, setting.name)
+		}
+	}
+	return changed
+}
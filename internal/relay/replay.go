@@ -0,0 +1,210 @@
+package relay
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/makinje/aero-arc-relay/internal/config"
+)
+
+const (
+	mavlinkV1Magic = 0xFE
+	mavlinkV2Magic = 0xFD
+
+	mavlinkV2SignedFlag  = 0x01 // incompat flag of signed MAVLink 2 frames
+	mavlinkSignatureSize = 13
+)
+
+var errBadTLogRecord = errors.New("not a MAVLink frame")
+
+// readTLogRecord reads the next record of a .tlog file: the time the frame was
+// received and its raw bytes
+func readTLogRecord(r *bufio.Reader) (time.Time, []byte, error) {
+	var timestamp [8]byte
+	if _, err := io.ReadFull(r, timestamp[:]); err != nil {
+		return time.Time{}, nil, err
+	}
+	received := time.UnixMicro(int64(binary.BigEndian.Uint64(timestamp[:])))
+
+	header, err := r.Peek(3)
+	if err != nil {
+		return time.Time{}, nil, noEOF(err)
+	}
+	var size int
+	switch header[0] {
+	case mavlinkV1Magic:
+		size = 6 + int(header[1]) + 2
+	case mavlinkV2Magic:
+		size = 10 + int(header[1]) + 2
+		if header[2]&mavlinkV2SignedFlag != 0 {
+			size += mavlinkSignatureSize
+		}
+	default:
+		return time.Time{}, nil, fmt.Errorf("%w: magic byte 0x%02X", errBadTLogRecord, header[0])
+	}
+
+	raw := make([]byte, size)
+	if _, err := io.ReadFull(r, raw); err != nil {
+		return time.Time{}, nil, noEOF(err)
+	}
+	return received, raw, nil
+}
+
+// noEOF reports a file that ends within a record as truncated
+func noEOF(err error) error {
+	if errors.Is(err, io.EOF) {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// replayFile plays a .tlog file back as the link of a replay endpoint. Frames
+// are returned to the node when they are due according to the recorded
+// timing and the playback speed. Writes to the link are discarded.
+type replayFile struct {
+	endpoint string
+	config   config.ReplayConfig
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu      sync.Mutex
+	file    *os.File
+	reader  *bufio.Reader
+	pending []byte    // rest of the frame being read
+	first   time.Time // recorded time of the first frame in the file
+	origin  time.Time // recorded time of the first frame played
+	started time.Time // when the first frame was played
+	played  int       // frames played since the file was opened
+}
+
+func newReplayFile(endpoint string, cfg config.ReplayConfig) *replayFile {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &replayFile{endpoint: endpoint, config: cfg, ctx: ctx, cancel: cancel}
+}
+
+// open opens the file, so a missing file fails the endpoint before it is up
+func (p *replayFile) open() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.file != nil {
+		p.file.Close()
+	}
+	file, err := os.Open(p.config.File)
+	if err != nil {
+		return fmt.Errorf("failed to open replay file: %w", err)
+	}
+	p.file = file
+	p.reader = bufio.NewReader(file)
+	p.first, p.origin, p.started, p.played = time.Time{}, time.Time{}, time.Time{}, 0
+	return nil
+}
+
+// Read returns the bytes of the next frame once it is due. At the end of the
+// file it starts over when looping, or blocks until the link is closed.
+func (p *replayFile) Read(buf []byte) (int, error) {
+	if len(p.pending) == 0 {
+		raw, err := p.next()
+		if err != nil {
+			return 0, err
+		}
+		p.pending = raw
+	}
+
+	n := copy(buf, p.pending)
+	p.pending = p.pending[n:]
+	return n, nil
+}
+
+func (p *replayFile) next() ([]byte, error) {
+	for {
+		if p.ctx.Err() != nil {
+			return nil, io.EOF
+		}
+
+		received, raw, err := readTLogRecord(p.reader)
+		if err != nil {
+			if p.ctx.Err() != nil {
+				return nil, io.EOF
+			}
+			if err := p.finish(err); err != nil {
+				return nil, err
+			}
+			continue
+		}
+
+		if p.first.IsZero() {
+			p.first = received
+		}
+		if received.Sub(p.first) < p.config.StartOffset {
+			continue
+		}
+		if p.origin.IsZero() {
+			p.origin, p.started = received, time.Now()
+		}
+		if p.config.Speed > 0 {
+			due := p.started.Add(time.Duration(float64(received.Sub(p.origin)) / p.config.Speed))
+			if wait := time.Until(due); wait > 0 {
+				select {
+				case <-p.ctx.Done():
+					return nil, io.EOF
+				case <-time.After(wait):
+				}
+			}
+		}
+
+		p.played++
+		return raw, nil
+	}
+}
+
+// finish handles the end of the file, or a record that cannot be read. The
+// file is played again when looping over a file that had frames to play;
+// otherwise the link stays idle until it is closed.
+func (p *replayFile) finish(err error) error {
+	attrs := []slog.Attr{
+		slog.String("endpoint", p.endpoint),
+		slog.String("file", p.config.File),
+		slog.Int("frames", p.played),
+	}
+	if errors.Is(err, io.EOF) {
+		slog.LogAttrs(context.Background(), slog.LevelInfo, "replay reached the end of the file", attrs...)
+	} else {
+		slog.LogAttrs(context.Background(), slog.LevelWarn, "replay stopped at an unreadable record",
+			append(attrs, slog.String("error", err.Error()))...)
+	}
+
+	if p.config.Loop && p.played > 0 {
+		return p.open()
+	}
+
+	<-p.ctx.Done()
+	return io.EOF
+}
+
+// Write discards frames the relay sends to the replayed link
+func (p *replayFile) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+// Close stops the playback
+func (p *replayFile) Close() error {
+	p.cancel()
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.file == nil {
+		return nil
+	}
+	return p.file.Close()
+}
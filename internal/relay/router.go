@@ -0,0 +1,292 @@
+package relay
+
+import (
+	"context"
+	"log/slog"
+	"reflect"
+	"slices"
+	"sync"
+
+	"github.com/bluenviron/gomavlib/v2"
+	"github.com/bluenviron/gomavlib/v2/pkg/frame"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
+	"github.com/makinje/aero-arc-relay/internal/config"
+)
+
+// frameWriter is the part of a gomavlib node the router writes through
+type frameWriter interface {
+	WriteFrameTo(channel *gomavlib.Channel, fr frame.Frame) error
+	WriteFrameExcept(exceptChannel *gomavlib.Channel, fr frame.Frame) error
+}
+
+// router forwards frames between endpoints following the MAVLink routing
+// rules: messages addressed to a system go to the channels that system has
+// been seen on, and broadcasts go to every channel except the one they
+// arrived on.
+type router struct {
+	mu       sync.RWMutex
+	links    []*routeLink
+	byName   map[string]*routeLink
+	channels map[*gomavlib.Channel]*routeLink
+	systems  map[uint8]map[*gomavlib.Channel]struct{} // channels each system has been seen on
+}
+
+// routeLink is a single endpoint taking part in routing
+type routeLink struct {
+	endpoint  string
+	writer    frameWriter
+	rules     routeRules
+	routeOnly bool
+	channels  map[*gomavlib.Channel]struct{}
+}
+
+// routeRules filter the frames sent out of an endpoint
+type routeRules struct {
+	allowMessages map[uint32]bool // nil allows all
+	denyMessages  map[uint32]bool
+	allowSystems  map[uint8]bool // nil allows all
+	denySystems   map[uint8]bool
+}
+
+// targetFieldCache caches the index of the target_system field per message
+// type, -1 when the message is not targeted
+var targetFieldCache sync.Map // map[reflect.Type]int
+
+func newRouter() *router {
+	return &router{
+		byName:   make(map[string]*routeLink),
+		channels: make(map[*gomavlib.Channel]*routeLink),
+		systems:  make(map[uint8]map[*gomavlib.Channel]struct{}),
+	}
+}
+
+func newRouteRules(routing config.MAVLinkEndpointRouting) routeRules {
+	var rules routeRules
+	if len(routing.AllowMessageIDs) > 0 {
+		rules.allowMessages = make(map[uint32]bool, len(routing.AllowMessageIDs))
+		for _, id := range routing.AllowMessageIDs {
+			rules.allowMessages[id] = true
+		}
+	}
+	if len(routing.DenyMessageIDs) > 0 {
+		rules.denyMessages = make(map[uint32]bool, len(routing.DenyMessageIDs))
+		for _, id := range routing.DenyMessageIDs {
+			rules.denyMessages[id] = true
+		}
+	}
+	if len(routing.AllowSystems) > 0 {
+		rules.allowSystems = make(map[uint8]bool, len(routing.AllowSystems))
+		for _, id := range routing.AllowSystems {
+			rules.allowSystems[id] = true
+		}
+	}
+	if len(routing.DenySystems) > 0 {
+		rules.denySystems = make(map[uint8]bool, len(routing.DenySystems))
+		for _, id := range routing.DenySystems {
+			rules.denySystems[id] = true
+		}
+	}
+	return rules
+}
+
+// allows reports whether a message from a source system may leave the link
+func (r routeRules) allows(msgID uint32, systemID uint8) bool {
+	if r.denyMessages[msgID] || r.denySystems[systemID] {
+		return false
+	}
+	if r.allowMessages != nil && !r.allowMessages[msgID] {
+		return false
+	}
+	if r.allowSystems != nil && !r.allowSystems[systemID] {
+		return false
+	}
+	return true
+}
+
+// addLink registers an endpoint with the router
+func (rt *router) addLink(endpoint config.MAVLinkEndpoint, writer frameWriter) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	link := &routeLink{
+		endpoint:  endpoint.Name,
+		writer:    writer,
+		rules:     newRouteRules(endpoint.Routing),
+		routeOnly: endpoint.Mode == config.MAVLinkModeRoute,
+		channels:  make(map[*gomavlib.Channel]struct{}),
+	}
+	rt.links = append(rt.links, link)
+	rt.byName[endpoint.Name] = link
+}
+
+// removeLink unregisters an endpoint and forgets its channels and the systems
+// seen on them
+func (rt *router) removeLink(endpoint string) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	link, ok := rt.byName[endpoint]
+	if !ok {
+		return
+	}
+	delete(rt.byName, endpoint)
+	rt.links = slices.DeleteFunc(rt.links, func(l *routeLink) bool { return l == link })
+	for ch := range link.channels {
+		delete(rt.channels, ch)
+		for systemID, channels := range rt.systems {
+			delete(channels, ch)
+			if len(channels) == 0 {
+				delete(rt.systems, systemID)
+			}
+		}
+	}
+}
+
+// routeOnly reports whether an endpoint only routes frames and never feeds sinks
+func (rt *router) routeOnly(endpoint string) bool {
+	rt.mu.RLock()
+	defer rt.mu.RUnlock()
+
+	link, ok := rt.byName[endpoint]
+	return ok && link.routeOnly
+}
+
+// openChannel records a channel opened on an endpoint
+func (rt *router) openChannel(endpoint string, ch *gomavlib.Channel) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	rt.addChannelLocked(endpoint, ch)
+}
+
+// closeChannel forgets a channel and every system seen on it
+func (rt *router) closeChannel(ch *gomavlib.Channel) {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	if link, ok := rt.channels[ch]; ok {
+		delete(link.channels, ch)
+		delete(rt.channels, ch)
+	}
+	for systemID, channels := range rt.systems {
+		delete(channels, ch)
+		if len(channels) == 0 {
+			delete(rt.systems, systemID)
+		}
+	}
+}
+
+func (rt *router) addChannelLocked(endpoint string, ch *gomavlib.Channel) *routeLink {
+	if link, ok := rt.channels[ch]; ok {
+		return link
+	}
+
+	link, ok := rt.byName[endpoint]
+	if !ok {
+		return nil
+	}
+	link.channels[ch] = struct{}{}
+	rt.channels[ch] = link
+	return link
+}
+
+// route learns where the frame's sender lives and forwards the frame to the
+// other links. Routing follows the decoded message in evt, while raw, the
+// frame as received, is what gets forwarded so it leaves byte for byte.
+func (rt *router) route(endpoint string, evt *gomavlib.EventFrame, raw frame.Frame) {
+	systemID := evt.SystemID()
+	msg := evt.Message()
+	msgID := msg.GetID()
+	targetSystem := messageTargetSystem(msg)
+
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+
+	source := rt.addChannelLocked(endpoint, evt.Channel)
+	if source == nil {
+		return
+	}
+	if _, ok := rt.systems[systemID]; !ok {
+		rt.systems[systemID] = make(map[*gomavlib.Channel]struct{})
+	}
+	rt.systems[systemID][evt.Channel] = struct{}{}
+
+	for _, link := range rt.links {
+		var targets []*gomavlib.Channel
+		broadcast := targetSystem == 0
+		if broadcast {
+			if link == source && len(link.channels) < 2 {
+				continue
+			}
+		} else {
+			for ch := range rt.systems[targetSystem] {
+				if ch != evt.Channel && rt.channels[ch] == link {
+					targets = append(targets, ch)
+				}
+			}
+			if len(targets) == 0 {
+				continue
+			}
+		}
+
+		if !link.rules.allows(msgID, systemID) {
+			relayRouteFilteredTotal.WithLabelValues(link.endpoint).Inc()
+			continue
+		}
+
+		var err error
+		if broadcast {
+			err = link.writer.WriteFrameExcept(evt.Channel, raw)
+		} else {
+			for _, ch := range targets {
+				if err = link.writer.WriteFrameTo(ch, raw); err != nil {
+					break
+				}
+			}
+		}
+		if err != nil {
+			slog.LogAttrs(context.Background(), slog.LevelDebug, "failed to route frame",
+				slog.String("source", endpoint),
+				slog.String("destination", link.endpoint),
+				slog.String("error", err.Error()))
+			continue
+		}
+
+		relayRoutedFramesTotal.WithLabelValues(endpoint, link.endpoint).Inc()
+	}
+}
+
+// messageTargetSystem returns the target system of a message. Zero means
+// broadcast, which is also returned for messages without a target_system field.
+func messageTargetSystem(msg message.Message) uint8 {
+	v := reflect.ValueOf(msg)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return 0
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0
+	}
+
+	index := targetSystemField(v.Type())
+	if index < 0 {
+		return 0
+	}
+	return uint8(v.Field(index).Uint())
+}
+
+func targetSystemField(t reflect.Type) int {
+	if cached, ok := targetFieldCache.Load(t); ok {
+		return cached.(int)
+	}
+
+	index := -1
+	if f, ok := t.FieldByName("TargetSystem"); ok && f.Type.Kind() == reflect.Uint8 {
+		index = f.Index[0]
+	}
+
+	targetFieldCache.Store(t, index)
+	return index
+}
@@ -0,0 +1,188 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"io"
+	"log/slog"
+	"sync"
+	"time"
+
+	"github.com/tarm/serial"
+)
+
+const (
+	serialReopenMinBackoff = 500 * time.Millisecond
+	serialReopenMaxBackoff = 30 * time.Second
+)
+
+var errSerialDisconnected = errors.New("serial device disconnected")
+
+// openSerialDevice opens a serial device, replaced in tests
+var openSerialDevice = func(device string, baud int) (io.ReadWriteCloser, error) {
+	return serial.OpenPort(&serial.Config{
+		Name: device,
+		Baud: baud,
+	})
+}
+
+// serialPort is a serial link that survives the device being unplugged. When
+// a read fails the device is closed and reopened with exponential backoff, so
+// the endpoint resumes as soon as the device (or its udev symlink) reappears.
+// The device does not need to exist when the relay starts.
+type serialPort struct {
+	endpoint string
+	device   string
+	baud     int
+
+	minBackoff time.Duration
+	maxBackoff time.Duration
+
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	mu   sync.Mutex
+	port io.ReadWriteCloser
+}
+
+func newSerialPort(endpoint, device string, baud int) *serialPort {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &serialPort{
+		endpoint:   endpoint,
+		device:     device,
+		baud:       baud,
+		minBackoff: serialReopenMinBackoff,
+		maxBackoff: serialReopenMaxBackoff,
+		ctx:        ctx,
+		cancel:     cancel,
+	}
+}
+
+// Read reads from the device, transparently reopening it after a disconnect.
+// It only returns an error once the port has been closed.
+func (s *serialPort) Read(p []byte) (int, error) {
+	for {
+		port, err := s.current()
+		if err != nil {
+			return 0, err
+		}
+
+		n, err := port.Read(p)
+		if n > 0 {
+			return n, nil
+		}
+
+		s.drop(port, err)
+	}
+}
+
+// Write writes to the device. Writes issued while the device is unplugged
+// fail with errSerialDisconnected.
+func (s *serialPort) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	port := s.port
+	s.mu.Unlock()
+
+	if port == nil {
+		return 0, errSerialDisconnected
+	}
+
+	return port.Write(p)
+}
+
+// Close closes the device and stops any pending reopen attempts
+func (s *serialPort) Close() error {
+	s.cancel()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.port == nil {
+		return nil
+	}
+	err := s.port.Close()
+	s.port = nil
+	return err
+}
+
+// current returns the open device, opening it with backoff if needed
+func (s *serialPort) current() (io.ReadWriteCloser, error) {
+	s.mu.Lock()
+	port := s.port
+	s.mu.Unlock()
+
+	if port != nil {
+		return port, nil
+	}
+
+	backoff := s.minBackoff
+	for attempt := 0; ; attempt++ {
+		if s.ctx.Err() != nil {
+			return nil, io.EOF
+		}
+
+		port, err := openSerialDevice(s.device, s.baud)
+		if err == nil {
+			s.mu.Lock()
+			if s.ctx.Err() != nil {
+				s.mu.Unlock()
+				port.Close()
+				return nil, io.EOF
+			}
+			s.port = port
+			s.mu.Unlock()
+
+			if attempt > 0 {
+				relaySerialReopensTotal.WithLabelValues(s.endpoint).Inc()
+				slog.LogAttrs(context.Background(), slog.LevelInfo, "Serial device reopened",
+					slog.String("endpoint", s.endpoint),
+					slog.String("device", s.device),
+					slog.Int("attempts", attempt+1),
+				)
+			}
+			return port, nil
+		}
+
+		if attempt == 0 {
+			slog.LogAttrs(context.Background(), slog.LevelWarn, "Serial device unavailable, retrying",
+				slog.String("endpoint", s.endpoint),
+				slog.String("device", s.device),
+				slog.String("error", err.Error()),
+			)
+		}
+
+		select {
+		case <-s.ctx.Done():
+			return nil, io.EOF
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > s.maxBackoff {
+			backoff = s.maxBackoff
+		}
+	}
+}
+
+// drop closes a device that stopped returning data
+func (s *serialPort) drop(port io.ReadWriteCloser, err error) {
+	s.mu.Lock()
+	if s.port == port {
+		port.Close()
+		s.port = nil
+	}
+	s.mu.Unlock()
+
+	if s.ctx.Err() != nil {
+		return
+	}
+
+	if err == nil {
+		err = io.EOF
+	}
+	slog.LogAttrs(context.Background(), slog.LevelWarn, "Serial device lost",
+		slog.String("endpoint", s.endpoint),
+		slog.String("device", s.device),
+		slog.String("error", err.Error()),
+	)
+}
@@ -0,0 +1,129 @@
+package relay
+
+import (
+	"context"
+	"log/slog"
+	"sync"
+
+	"github.com/bluenviron/gomavlib/v2"
+	"github.com/bluenviron/gomavlib/v2/pkg/frame"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
+	"github.com/makinje/aero-arc-relay/internal/config"
+)
+
+// Reasons a frame is rejected by signature verification, used as metric labels
+const (
+	rejectUnsigned     = "unsigned"
+	rejectBadSignature = "bad_signature"
+	rejectReplay       = "replay"
+	rejectStale        = "stale"
+)
+
+// signingTimestampWindow is how far behind the newest timestamp seen on an
+// endpoint a new signing stream may start, in 10µs units (one minute)
+const signingTimestampWindow = 60 * 100000
+
+// radioStatusID is accepted unsigned in strict mode, since telemetry radios
+// inject RADIO_STATUS frames they cannot sign
+const radioStatusID = 109
+
+// signingStream identifies a signed stream; timestamps must increase within
+// each stream
+type signingStream struct {
+	systemID    uint8
+	componentID uint8
+	linkID      uint8
+}
+
+// streamState is the last frame accepted on a signing stream
+type streamState struct {
+	timestamp uint64
+	signature frame.V2Signature
+}
+
+// signatureVerifier checks MAVLink 2 signatures on the frames received on an
+// endpoint and rejects replayed timestamps. gomavlib's own InKey check is not
+// used because it cannot let unsigned frames through.
+type signatureVerifier struct {
+	key    *frame.V2Key
+	strict bool
+
+	mu      sync.Mutex
+	streams map[signingStream]streamState
+	latest  uint64 // newest timestamp accepted on the endpoint
+}
+
+func newSignatureVerifier(signing config.SigningConfig) *signatureVerifier {
+	return &signatureVerifier{
+		key:     signing.Key,
+		strict:  signing.Verify == config.SigningVerifyStrict,
+		streams: make(map[signingStream]streamState),
+	}
+}
+
+// verify returns the reason a frame is rejected, or "" when it is accepted
+func (v *signatureVerifier) verify(fr frame.Frame) string {
+	v2, ok := fr.(*frame.V2Frame)
+	if !ok || !v2.IsSigned() {
+		if v.strict && fr.GetMessage().GetID() != radioStatusID {
+			return rejectUnsigned
+		}
+		return ""
+	}
+
+	signature, ok := frameSignature(v2, v.key)
+	if !ok || signature != *v2.Signature {
+		return rejectBadSignature
+	}
+
+	v.mu.Lock()
+	defer v.mu.Unlock()
+
+	// Senders such as gomavlib may stamp frames written within the same 10µs
+	// with one timestamp, so only an identical frame counts as a replay there
+	stream := signingStream{v2.SystemID, v2.ComponentID, v2.SignatureLinkID}
+	last, known := v.streams[stream]
+	switch {
+	case known && v2.SignatureTimestamp < last.timestamp:
+		return rejectReplay
+	case known && v2.SignatureTimestamp == last.timestamp && signature == last.signature:
+		return rejectReplay
+	case !known && v2.SignatureTimestamp+signingTimestampWindow < v.latest:
+		return rejectStale
+	}
+	v.streams[stream] = streamState{v2.SignatureTimestamp, signature}
+	v.latest = max(v.latest, v2.SignatureTimestamp)
+	return ""
+}
+
+// frameSignature computes the signature of a frame as it was received, over
+// the payload exactly as the sender signed it. Decoded frames cannot be
+// verified, since re-encoding them need not give back those bytes.
+func frameSignature(fr *frame.V2Frame, key *frame.V2Key) (frame.V2Signature, bool) {
+	if _, ok := fr.Message.(*message.MessageRaw); !ok {
+		return frame.V2Signature{}, false
+	}
+	return *fr.GenerateSignature(key), true
+}
+
+// verifySignature reports whether a frame received on an endpoint passes the
+// endpoint's signature verification, counting the frames it rejects
+func (r *Relay) verifySignature(endpoint string, evt *gomavlib.EventFrame) bool {
+	value, ok := r.endpointVerifiers.Load(endpoint)
+	if !ok {
+		return true
+	}
+
+	reason := value.(*signatureVerifier).verify(evt.Frame)
+	if reason == "" {
+		return true
+	}
+
+	relayRejectedFramesTotal.WithLabelValues(endpoint, reason).Inc()
+	slog.LogAttrs(context.Background(), slog.LevelDebug, "rejected MAVLink frame",
+		slog.String("endpoint", endpoint),
+		slog.String("reason", reason),
+		slog.Int("system_id", int(evt.SystemID())),
+		slog.Int("component_id", int(evt.ComponentID())))
+	return false
+}
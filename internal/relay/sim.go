@@ -0,0 +1,496 @@
+package relay
+
+import (
+	"bytes"
+	"container/heap"
+	"context"
+	"fmt"
+	"io"
+	"math"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v2/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v2/pkg/frame"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
+	"github.com/makinje/aero-arc-relay/internal/config"
+)
+
+const (
+	simEarthRadius   = 6378137.0 // meters, WGS84 equatorial radius
+	simGravity       = 9.80665
+	simClimbRate     = 2.5              // m/s during takeoff
+	simDescentRate   = 1.5              // m/s during landing
+	simReturnBattery = 20.0             // percent at which a vehicle returns home
+	simRechargeTime  = 10 * time.Second // time on the ground before a vehicle takes off again
+
+	simCellCount     = 4   // 4S battery
+	simCellFull      = 4.2 // volts
+	simCellEmpty     = 3.3 // volts
+	simFlightCurrent = 15.0
+	simGroundCurrent = 0.5
+)
+
+// Flight modes the simulated vehicles report, numbered like ArduCopter and
+// like the sub modes of PX4's AUTO main mode
+const (
+	simArduCopterAuto   = 3
+	simArduCopterGuided = 4
+	simArduCopterRTL    = 6
+
+	simPX4MainModeAuto = 4
+	simPX4Takeoff      = 2
+	simPX4Mission      = 4
+	simPX4RTL          = 5
+	simPX4Land         = 6
+)
+
+// simPhase is where a simulated vehicle is in its flight
+type simPhase int
+
+const (
+	simTakeoff simPhase = iota // climbing at home
+	simMission                 // flying the path
+	simReturn                  // flying home on a low battery
+	simLanding                 // descending at home
+	simLanded                  // disarmed, recharging
+)
+
+// simPoint is a position in meters north and east of the path origin and
+// above home
+type simPoint struct {
+	north, east, alt float64
+}
+
+// simPath is the closed path simulated vehicles fly: an orbit or a polygon
+// through waypoints
+type simPath struct {
+	lat, lon float64    // origin, the orbit center or the first waypoint
+	radius   float64    // orbit only
+	points   []simPoint // waypoints only
+	length   float64
+}
+
+func newSimPath(cfg config.SimConfig) *simPath {
+	if len(cfg.Waypoints) < 2 {
+		orbit := cfg.Orbit
+		return &simPath{
+			lat:    orbit.Latitude,
+			lon:    orbit.Longitude,
+			radius: orbit.Radius,
+			points: []simPoint{{alt: orbit.Altitude}},
+			length: 2 * math.Pi * orbit.Radius,
+		}
+	}
+
+	path := &simPath{lat: cfg.Waypoints[0].Latitude, lon: cfg.Waypoints[0].Longitude}
+	for _, waypoint := range cfg.Waypoints {
+		north, east := path.offset(waypoint.Latitude, waypoint.Longitude)
+		path.points = append(path.points, simPoint{north, east, waypoint.Altitude})
+	}
+	for i, p := range path.points {
+		next := path.points[(i+1)%len(path.points)]
+		path.length += math.Hypot(next.north-p.north, next.east-p.east)
+	}
+	return path
+}
+
+// offset returns the distance of a position from the origin, on a flat earth
+// around it
+func (p *simPath) offset(lat, lon float64) (north, east float64) {
+	north = (lat - p.lat) * math.Pi / 180 * simEarthRadius
+	east = (lon - p.lon) * math.Pi / 180 * simEarthRadius * math.Cos(p.lat*math.Pi/180)
+	return north, east
+}
+
+// position returns the latitude and longitude of a point
+func (p *simPath) position(point simPoint) (lat, lon float64) {
+	lat = p.lat + point.north/simEarthRadius*180/math.Pi
+	lon = p.lon + point.east/(simEarthRadius*math.Cos(p.lat*math.Pi/180))*180/math.Pi
+	return lat, lon
+}
+
+// at returns the point a distance along the path and the heading there, in
+// radians clockwise from north. Orbits are flown clockwise.
+func (p *simPath) at(distance float64) (simPoint, float64) {
+	if p.length == 0 {
+		return p.points[0], 0
+	}
+	distance = math.Mod(distance, p.length)
+
+	if p.radius > 0 {
+		angle := distance / p.radius
+		point := simPoint{p.radius * math.Cos(angle), p.radius * math.Sin(angle), p.points[0].alt}
+		return point, angle + math.Pi/2
+	}
+
+	for i, from := range p.points {
+		to := p.points[(i+1)%len(p.points)]
+		leg := math.Hypot(to.north-from.north, to.east-from.east)
+		if distance > leg {
+			distance -= leg
+			continue
+		}
+		f := 0.0
+		if leg > 0 {
+			f = distance / leg
+		}
+		point := simPoint{
+			from.north + f*(to.north-from.north),
+			from.east + f*(to.east-from.east),
+			from.alt + f*(to.alt-from.alt),
+		}
+		return point, math.Atan2(to.east-from.east, to.north-from.north)
+	}
+	return p.points[0], 0
+}
+
+// simVehicle is the flight model of a simulated vehicle. Vehicles fly the
+// path until the battery runs low, return home, land, recharge and take off
+// again.
+type simVehicle struct {
+	autopilot common.MAV_AUTOPILOT
+	speed     float64 // m/s
+	drain     float64 // percent per second of flight
+	path      *simPath
+	start     float64  // distance along the path the vehicle launches from
+	home      simPoint // on the ground below the start
+
+	booted  time.Time
+	updated time.Time
+	landed  time.Time
+
+	phase    simPhase
+	along    float64 // distance flown along the path
+	pos      simPoint
+	heading  float64 // radians clockwise from north
+	speedNow float64 // ground speed in m/s
+	climb    float64 // m/s
+	roll     float64 // radians, right wing down positive
+	yawRate  float64 // radians per second
+	battery  float64 // percent
+}
+
+// newSimVehicle places vehicle i of n on its share of the path, already in
+// the air. Batteries start at different levels so the vehicles do not all
+// return home at once.
+func newSimVehicle(cfg config.SimConfig, path *simPath, i int, now time.Time) *simVehicle {
+	v := &simVehicle{
+		autopilot: common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+		speed:     cfg.Speed,
+		drain:     cfg.BatteryDrain / 60,
+		path:      path,
+		start:     path.length * float64(i) / float64(cfg.Vehicles),
+		booted:    now,
+		updated:   now,
+		phase:     simMission,
+		battery:   100 - 60*float64(i)/float64(cfg.Vehicles),
+	}
+	if cfg.Autopilot == "px4" {
+		v.autopilot = common.MAV_AUTOPILOT_PX4
+	}
+	v.along = v.start
+	v.pos, v.heading = path.at(v.start)
+	v.home = simPoint{north: v.pos.north, east: v.pos.east}
+	return v
+}
+
+// step advances the vehicle to a time
+func (v *simVehicle) step(now time.Time) {
+	dt := now.Sub(v.updated).Seconds()
+	if dt <= 0 {
+		return
+	}
+	v.updated = now
+	v.speedNow, v.climb, v.roll, v.yawRate = 0, 0, 0, 0
+
+	switch v.phase {
+	case simTakeoff:
+		target, _ := v.path.at(v.start)
+		v.climb = simClimbRate
+		v.pos.alt = min(v.pos.alt+simClimbRate*dt, target.alt)
+		if v.pos.alt >= target.alt {
+			v.phase, v.along = simMission, v.start
+		}
+	case simMission:
+		v.along += v.speed * dt
+		pos, heading := v.path.at(v.along)
+		v.speedNow, v.climb = v.speed, (pos.alt-v.pos.alt)/dt
+		v.pos, v.heading = pos, heading
+		if v.path.radius > 0 {
+			// coordinated turn around the orbit
+			v.roll = math.Atan(v.speed * v.speed / (simGravity * v.path.radius))
+			v.yawRate = v.speed / v.path.radius
+		}
+		if v.battery <= simReturnBattery {
+			v.phase = simReturn
+		}
+	case simReturn:
+		north, east := v.home.north-v.pos.north, v.home.east-v.pos.east
+		distance := math.Hypot(north, east)
+		if distance <= v.speed*dt {
+			v.pos.north, v.pos.east = v.home.north, v.home.east
+			v.phase = simLanding
+			break
+		}
+		v.heading, v.speedNow = math.Atan2(east, north), v.speed
+		v.pos.north += north / distance * v.speed * dt
+		v.pos.east += east / distance * v.speed * dt
+	case simLanding:
+		v.climb = -simDescentRate
+		v.pos.alt = max(v.pos.alt-simDescentRate*dt, 0)
+		if v.pos.alt == 0 {
+			v.phase, v.landed = simLanded, now
+		}
+	case simLanded:
+		if now.Sub(v.landed) >= simRechargeTime {
+			v.phase, v.battery = simTakeoff, 100
+		}
+	}
+
+	if v.phase != simLanded {
+		v.battery = max(v.battery-v.drain*dt, 0)
+	}
+}
+
+// customMode encodes the flight mode of the current phase the way the
+// vehicle's autopilot does
+func (v *simVehicle) customMode() uint32 {
+	if v.autopilot == common.MAV_AUTOPILOT_PX4 {
+		sub := simPX4RTL
+		switch v.phase {
+		case simTakeoff:
+			sub = simPX4Takeoff
+		case simMission:
+			sub = simPX4Mission
+		case simLanding, simLanded:
+			sub = simPX4Land
+		}
+		return simPX4MainModeAuto<<16 | uint32(sub)<<24
+	}
+
+	switch v.phase {
+	case simTakeoff:
+		return simArduCopterGuided
+	case simMission:
+		return simArduCopterAuto
+	default:
+		return simArduCopterRTL
+	}
+}
+
+func (v *simVehicle) timeBootMs(now time.Time) uint32 {
+	return uint32(now.Sub(v.booted).Milliseconds())
+}
+
+// wrapAngle returns an angle in radians between -π and π
+func wrapAngle(angle float64) float64 {
+	return math.Remainder(angle, 2*math.Pi)
+}
+
+// message returns the current state of the vehicle as a MAVLink message
+func (v *simVehicle) message(name string, now time.Time) message.Message {
+	v.step(now)
+
+	switch name {
+	case "HEARTBEAT":
+		baseMode := common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED
+		status := common.MAV_STATE_STANDBY
+		if v.phase != simLanded {
+			baseMode |= common.MAV_MODE_FLAG_SAFETY_ARMED
+			status = common.MAV_STATE_ACTIVE
+		}
+		return &common.MessageHeartbeat{
+			Type:           common.MAV_TYPE_QUADROTOR,
+			Autopilot:      v.autopilot,
+			BaseMode:       baseMode,
+			CustomMode:     v.customMode(),
+			SystemStatus:   status,
+			MavlinkVersion: 3,
+		}
+	case "SYS_STATUS":
+		sensors := common.MAV_SYS_STATUS_SENSOR_3D_GYRO | common.MAV_SYS_STATUS_SENSOR_3D_ACCEL |
+			common.MAV_SYS_STATUS_SENSOR_3D_MAG | common.MAV_SYS_STATUS_SENSOR_ABSOLUTE_PRESSURE |
+			common.MAV_SYS_STATUS_SENSOR_GPS | common.MAV_SYS_STATUS_SENSOR_BATTERY
+		current := simFlightCurrent
+		if v.phase == simLanded {
+			current = simGroundCurrent
+		}
+		cell := simCellEmpty + (simCellFull-simCellEmpty)*v.battery/100
+		return &common.MessageSysStatus{
+			OnboardControlSensorsPresent: sensors,
+			OnboardControlSensorsEnabled: sensors,
+			OnboardControlSensorsHealth:  sensors,
+			Load:                         250,
+			VoltageBattery:               uint16(math.Round(cell * simCellCount * 1000)),
+			CurrentBattery:               int16(math.Round(current * 100)),
+			BatteryRemaining:             int8(math.Ceil(v.battery)),
+		}
+	case "GLOBAL_POSITION_INT":
+		lat, lon := v.path.position(v.pos)
+		heading := math.Mod(v.heading*180/math.Pi+360, 360)
+		return &common.MessageGlobalPositionInt{
+			TimeBootMs: v.timeBootMs(now),
+			Lat:        int32(math.Round(lat * 1e7)),
+			Lon:        int32(math.Round(lon * 1e7)),
+			// home is at sea level
+			Alt:         int32(math.Round(v.pos.alt * 1000)),
+			RelativeAlt: int32(math.Round(v.pos.alt * 1000)),
+			Vx:          int16(math.Round(v.speedNow * math.Cos(v.heading) * 100)),
+			Vy:          int16(math.Round(v.speedNow * math.Sin(v.heading) * 100)),
+			Vz:          int16(math.Round(-v.climb * 100)),
+			Hdg:         uint16(math.Round(heading*100)) % 36000,
+		}
+	case "ATTITUDE":
+		return &common.MessageAttitude{
+			TimeBootMs: v.timeBootMs(now),
+			Roll:       float32(v.roll),
+			// nose down to hold the ground speed
+			Pitch:    float32(-math.Atan(v.speedNow / (4 * simGravity))),
+			Yaw:      float32(wrapAngle(v.heading)),
+			Yawspeed: float32(v.yawRate),
+		}
+	case "VFR_HUD":
+		throttle := 0.0
+		if v.phase != simLanded {
+			throttle = min(max(50+10*v.climb, 0), 100)
+		}
+		heading := math.Mod(v.heading*180/math.Pi+360, 360)
+		return &common.MessageVfrHud{
+			Airspeed:    float32(v.speedNow),
+			Groundspeed: float32(v.speedNow),
+			Heading:     int16(math.Round(heading)) % 360,
+			Throttle:    uint16(math.Round(throttle)),
+			Alt:         float32(v.pos.alt),
+			Climb:       float32(v.climb),
+		}
+	default:
+		return nil
+	}
+}
+
+// simEmission is the next time a vehicle sends a message
+type simEmission struct {
+	due      time.Time
+	vehicle  int
+	name     string
+	interval time.Duration
+}
+
+// simSchedule orders the emissions of a sim link by due time
+type simSchedule []simEmission
+
+func (s simSchedule) Len() int           { return len(s) }
+func (s simSchedule) Less(i, j int) bool { return s[i].due.Before(s[j].due) }
+func (s simSchedule) Swap(i, j int)      { s[i], s[j] = s[j], s[i] }
+func (s *simSchedule) Push(x any)        { *s = append(*s, x.(simEmission)) }
+func (s *simSchedule) Pop() any {
+	old := *s
+	last := old[len(old)-1]
+	*s = old[:len(old)-1]
+	return last
+}
+
+// simLink is the link of a sim endpoint. Reads return the frames of the
+// simulated vehicles as they are due; writes to the link are discarded.
+type simLink struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+
+	vehicles []*simVehicle
+	writers  []*frame.Writer
+	schedule simSchedule
+	buf      bytes.Buffer // frames of the emission being read
+}
+
+func newSimLink(cfg config.SimConfig) (*simLink, error) {
+	dialectRW, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create dialect read writer: %w", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	link := &simLink{ctx: ctx, cancel: cancel}
+	path := newSimPath(cfg)
+	now := time.Now()
+	for i := range cfg.Vehicles {
+		writer, err := frame.NewWriter(frame.WriterConf{
+			Writer:         &link.buf,
+			DialectRW:      dialectRW,
+			OutVersion:     frame.V2,
+			OutSystemID:    uint8(i + 1),
+			OutComponentID: 1,
+		})
+		if err != nil {
+			cancel()
+			return nil, fmt.Errorf("failed to create frame writer: %w", err)
+		}
+		link.vehicles = append(link.vehicles, newSimVehicle(cfg, path, i, now))
+		link.writers = append(link.writers, writer)
+
+		for name, rate := range cfg.MessageRates {
+			if rate <= 0 {
+				continue
+			}
+			interval := time.Duration(float64(time.Second) / rate)
+			// stagger the vehicles so their messages do not arrive in bursts
+			offset := interval * time.Duration(i) / time.Duration(cfg.Vehicles)
+			link.schedule = append(link.schedule, simEmission{now.Add(offset), i, name, interval})
+		}
+	}
+	heap.Init(&link.schedule)
+	return link, nil
+}
+
+// Read returns the frame of the next due message, waiting until it is due
+func (s *simLink) Read(buf []byte) (int, error) {
+	if s.buf.Len() == 0 {
+		if err := s.next(); err != nil {
+			return 0, err
+		}
+	}
+	return s.buf.Read(buf)
+}
+
+func (s *simLink) next() error {
+	if len(s.schedule) == 0 {
+		// every message is disabled
+		<-s.ctx.Done()
+		return io.EOF
+	}
+
+	emission := s.schedule[0]
+	if wait := time.Until(emission.due); wait > 0 {
+		select {
+		case <-s.ctx.Done():
+			return io.EOF
+		case <-time.After(wait):
+		}
+	}
+	if s.ctx.Err() != nil {
+		return io.EOF
+	}
+
+	next := emission.due.Add(emission.interval)
+	if now := time.Now(); next.Before(now) {
+		// the relay fell behind; skip the missed messages instead of
+		// sending them in a burst
+		next = now
+	}
+	s.schedule[0].due = next
+	heap.Fix(&s.schedule, 0)
+
+	msg := s.vehicles[emission.vehicle].message(emission.name, emission.due)
+	return s.writers[emission.vehicle].WriteMessage(msg)
+}
+
+// Write discards frames the relay sends to the simulated vehicles
+func (s *simLink) Write(buf []byte) (int, error) {
+	return len(buf), nil
+}
+
+// Close stops the simulation
+func (s *simLink) Close() error {
+	s.cancel()
+	return nil
+}
@@ -0,0 +1,216 @@
+package relay
+
+import (
+	"context"
+	"log/slog"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/makinje/aero-arc-relay/pkg/telemetry"
+)
+
+const (
+	statusTextChunkLen = 50              // text length of a STATUSTEXT chunk; shorter chunks end a message
+	statusTextTimeout  = 2 * time.Second // how long to wait for the missing chunks of a message
+)
+
+// statusTextSeverities maps MAV_SEVERITY to the RFC 5424 level names it is
+// defined by
+var statusTextSeverities = map[common.MAV_SEVERITY]string{
+	common.MAV_SEVERITY_EMERGENCY: "emergency",
+	common.MAV_SEVERITY_ALERT:     "alert",
+	common.MAV_SEVERITY_CRITICAL:  "critical",
+	common.MAV_SEVERITY_ERROR:     "error",
+	common.MAV_SEVERITY_WARNING:   "warning",
+	common.MAV_SEVERITY_NOTICE:    "notice",
+	common.MAV_SEVERITY_INFO:      "info",
+	common.MAV_SEVERITY_DEBUG:     "debug",
+}
+
+func severityName(severity common.MAV_SEVERITY) string {
+	if name, ok := statusTextSeverities[severity]; ok {
+		return name
+	}
+	return "unknown"
+}
+
+// statusTextKey identifies a chunked message; chunk IDs are chosen by the
+// sending component
+type statusTextKey struct {
+	droneID     string
+	componentID uint8
+	id          uint16
+}
+
+// statusText is a status text reassembled from one or more chunks
+type statusText struct {
+	droneID     string
+	endpoint    string
+	systemID    uint8
+	componentID uint8
+	id          uint16
+	severity    common.MAV_SEVERITY
+	text        string
+	chunks      int
+	complete    bool // false when chunks were still missing at the timeout
+}
+
+// pendingStatusText holds the chunks of a message received so far
+type pendingStatusText struct {
+	statusText
+	parts     map[uint8]string
+	last      int // chunk_seq of the final chunk, -1 until it arrives
+	firstSeen time.Time
+}
+
+// assemble joins the received chunks in order and reports whether all of
+// them arrived
+func (p *pendingStatusText) assemble() (statusText, bool) {
+	seqs := make([]int, 0, len(p.parts))
+	for seq := range p.parts {
+		seqs = append(seqs, int(seq))
+	}
+	sort.Ints(seqs)
+
+	var b strings.Builder
+	for _, seq := range seqs {
+		b.WriteString(p.parts[uint8(seq)])
+	}
+
+	text := p.statusText
+	text.text = b.String()
+	text.chunks = len(seqs)
+	text.complete = p.last >= 0 && len(seqs) == p.last+1
+	return text, text.complete
+}
+
+// statusTextAssembler reassembles STATUSTEXT messages sent in chunks
+type statusTextAssembler struct {
+	timeout time.Duration
+
+	mu      sync.Mutex
+	pending map[statusTextKey]*pendingStatusText
+}
+
+func newStatusTextAssembler(timeout time.Duration) *statusTextAssembler {
+	return &statusTextAssembler{
+		timeout: timeout,
+		pending: make(map[statusTextKey]*pendingStatusText),
+	}
+}
+
+// add records a STATUSTEXT chunk and returns the message once it is complete.
+// Chunks with ID 0 are messages on their own.
+func (a *statusTextAssembler) add(text statusText, msg *common.MessageStatustext, now time.Time) (statusText, bool) {
+	text.severity = msg.Severity
+	text.id = msg.Id
+	if msg.Id == 0 {
+		text.text = msg.Text
+		text.chunks = 1
+		text.complete = true
+		return text, true
+	}
+
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	key := statusTextKey{text.droneID, text.componentID, msg.Id}
+	p, ok := a.pending[key]
+	if !ok {
+		p = &pendingStatusText{statusText: text, parts: make(map[uint8]string), last: -1, firstSeen: now}
+		a.pending[key] = p
+	}
+	p.parts[msg.ChunkSeq] = msg.Text
+	if len(msg.Text) < statusTextChunkLen {
+		p.last = int(msg.ChunkSeq)
+	}
+
+	assembled, complete := p.assemble()
+	if !complete {
+		return statusText{}, false
+	}
+	delete(a.pending, key)
+	return assembled, true
+}
+
+// expire returns the messages still missing chunks after the timeout, with
+// the chunks that did arrive
+func (a *statusTextAssembler) expire(now time.Time) []statusText {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+
+	var expired []statusText
+	for key, p := range a.pending {
+		if now.Sub(p.firstSeen) < a.timeout {
+			continue
+		}
+		text, _ := p.assemble()
+		expired = append(expired, text)
+		delete(a.pending, key)
+	}
+	return expired
+}
+
+// handleStatusText collects a STATUSTEXT chunk received from a drone and
+// publishes the message once it is complete
+func (r *Relay) handleStatusText(droneID, endpoint string, systemID, componentID uint8, msg *common.MessageStatustext) {
+	text, ok := r.statusTexts.add(statusText{
+		droneID:     droneID,
+		endpoint:    endpoint,
+		systemID:    systemID,
+		componentID: componentID,
+	}, msg, time.Now())
+	if ok {
+		r.publishStatusText(text)
+	}
+}
+
+// flushStatusTexts publishes chunked messages whose remaining chunks never
+// arrived, until the context is done
+func (r *Relay) flushStatusTexts(ctx context.Context) {
+	ticker := time.NewTicker(r.statusTexts.timeout / 2)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, text := range r.statusTexts.expire(now) {
+				r.publishStatusText(text)
+			}
+		}
+	}
+}
+
+// publishStatusText sends a StatusText event envelope to the sinks
+func (r *Relay) publishStatusText(text statusText) {
+	severity := severityName(text.severity)
+	relayStatusTextsTotal.WithLabelValues(text.droneID, severity).Inc()
+	slog.LogAttrs(context.Background(), slog.LevelDebug, "autopilot status text",
+		slog.String("drone_id", text.droneID),
+		slog.String("severity", severity),
+		slog.String("text", text.text))
+
+	r.handleTelemetryMessage(telemetry.TelemetryEnvelope{
+		Kind:           telemetry.KindEvent,
+		DroneID:        text.droneID,
+		Source:         text.endpoint,
+		TimestampRelay: time.Now().UTC(),
+		MsgID:          (&common.MessageStatustext{}).GetID(),
+		MsgName:        "StatusText",
+		SystemID:       text.systemID,
+		ComponentID:    text.componentID,
+		Fields: map[string]any{
+			"text":           text.text,
+			"severity":       severity,
+			"severity_level": uint8(text.severity),
+			"id":             text.id,
+			"chunks":         text.chunks,
+			"complete":       text.complete,
+		},
+	})
+}
@@ -0,0 +1,308 @@
+package relay
+
+import (
+	"context"
+	"errors"
+	"log/slog"
+	"maps"
+	"math"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v2/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/makinje/aero-arc-relay/internal/config"
+	"github.com/makinje/aero-arc-relay/pkg/telemetry"
+)
+
+const (
+	streamCheckWindow   = 10 * time.Second
+	streamCheckAttempts = 3   // applications of the rates before giving up on mismatches
+	streamRateTolerance = 0.5 // observed rates within 50% of the requested rate match
+
+	// SET_MESSAGE_INTERVAL commands left unanswered in a row before an
+	// ArduPilot vehicle is taken not to support the command
+	streamFallbackTimeouts = 3
+)
+
+// dataStreams maps messages to the REQUEST_DATA_STREAM group ArduPilot sends
+// them in, for autopilots without SET_MESSAGE_INTERVAL
+var dataStreams = map[uint32]common.MAV_DATA_STREAM{
+	(&common.MessageRawImu{}).GetID():                  common.MAV_DATA_STREAM_RAW_SENSORS,
+	(&common.MessageScaledImu2{}).GetID():              common.MAV_DATA_STREAM_RAW_SENSORS,
+	(&common.MessageScaledPressure{}).GetID():          common.MAV_DATA_STREAM_RAW_SENSORS,
+	(&common.MessageSysStatus{}).GetID():               common.MAV_DATA_STREAM_EXTENDED_STATUS,
+	(&common.MessagePowerStatus{}).GetID():             common.MAV_DATA_STREAM_EXTENDED_STATUS,
+	(&common.MessageMissionCurrent{}).GetID():          common.MAV_DATA_STREAM_EXTENDED_STATUS,
+	(&common.MessageGpsRawInt{}).GetID():               common.MAV_DATA_STREAM_EXTENDED_STATUS,
+	(&common.MessageNavControllerOutput{}).GetID():     common.MAV_DATA_STREAM_EXTENDED_STATUS,
+	(&common.MessageRcChannels{}).GetID():              common.MAV_DATA_STREAM_RC_CHANNELS,
+	(&common.MessageServoOutputRaw{}).GetID():          common.MAV_DATA_STREAM_RC_CHANNELS,
+	(&common.MessageGlobalPositionInt{}).GetID():       common.MAV_DATA_STREAM_POSITION,
+	(&common.MessageLocalPositionNed{}).GetID():        common.MAV_DATA_STREAM_POSITION,
+	(&common.MessageAttitude{}).GetID():                common.MAV_DATA_STREAM_EXTRA1,
+	(&common.MessageVfrHud{}).GetID():                  common.MAV_DATA_STREAM_EXTRA2,
+	(&common.MessageSystemTime{}).GetID():              common.MAV_DATA_STREAM_EXTRA3,
+	(&common.MessageBatteryStatus{}).GetID():           common.MAV_DATA_STREAM_EXTRA3,
+	(&common.MessageVibration{}).GetID():               common.MAV_DATA_STREAM_EXTRA3,
+	(&common.MessageDistanceSensor{}).GetID():          common.MAV_DATA_STREAM_EXTRA3,
+	(&common.MessageTerrainReport{}).GetID():           common.MAV_DATA_STREAM_EXTRA3,
+	(&common.MessagePositionTargetGlobalInt{}).GetID(): common.MAV_DATA_STREAM_POSITION,
+}
+
+// streamKey identifies a message type received from a drone
+type streamKey struct {
+	droneID string
+	msgID   uint32
+}
+
+// streamManager holds the configured stream rates and counts the frames of
+// those messages received from each drone, so observed rates can be checked
+type streamManager struct {
+	names       map[uint32]string // message names for metrics and logs
+	checkWindow time.Duration
+
+	mu     sync.Mutex
+	rates  map[string]map[uint32]float64 // endpoint name -> message ID -> Hz
+	counts map[streamKey]int
+}
+
+// newStreamManager returns a manager for the endpoints with streams, or nil
+// when none has any
+func newStreamManager(endpoints []config.MAVLinkEndpoint, d *dialect.Dialect) *streamManager {
+	m := newEmptyStreamManager(d)
+	for _, endpoint := range endpoints {
+		m.setRates(endpoint.Name, endpoint.StreamRates)
+	}
+	if len(m.rates) == 0 {
+		return nil
+	}
+	return m
+}
+
+// newEmptyStreamManager returns a manager without rates, for relays whose
+// endpoints may gain streams at runtime
+func newEmptyStreamManager(d *dialect.Dialect) *streamManager {
+	m := &streamManager{
+		names:       make(map[uint32]string),
+		checkWindow: streamCheckWindow,
+		rates:       make(map[string]map[uint32]float64),
+		counts:      make(map[streamKey]int),
+	}
+	if d != nil {
+		for _, msg := range d.Messages {
+			m.names[msg.GetID()] = telemetry.MessageDefName(msg)
+		}
+	}
+	return m
+}
+
+// setRates replaces the stream rates of an endpoint; no rates removes it
+func (m *streamManager) setRates(endpoint string, rates map[uint32]float64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(rates) == 0 {
+		delete(m.rates, endpoint)
+		return
+	}
+	m.rates[endpoint] = rates
+}
+
+// endpointRates returns the stream rates of an endpoint
+func (m *streamManager) endpointRates(endpoint string) map[uint32]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.rates[endpoint]
+}
+
+func (m *streamManager) name(msgID uint32) string {
+	if name, ok := m.names[msgID]; ok {
+		return name
+	}
+	return strconv.FormatUint(uint64(msgID), 10)
+}
+
+// observe counts a frame received from a drone when its rate is configured
+func (m *streamManager) observe(endpoint, droneID string, msgID uint32) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.rates[endpoint][msgID]; ok {
+		m.counts[streamKey{droneID, msgID}]++
+	}
+}
+
+// reset starts a new observation window for a drone
+func (m *streamManager) reset(droneID string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for key := range m.counts {
+		if key.droneID == droneID {
+			delete(m.counts, key)
+		}
+	}
+}
+
+// check compares the rates observed over a window with the requested ones
+// and returns those that do not match
+func (m *streamManager) check(droneID string, rates map[uint32]float64, window time.Duration) map[uint32]float64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	mismatched := make(map[uint32]float64)
+	for msgID, rate := range rates {
+		observed := float64(m.counts[streamKey{droneID, msgID}]) / window.Seconds()
+		relayStreamRateHz.WithLabelValues(droneID, m.name(msgID)).Set(observed)
+		if math.Abs(observed-rate) > rate*streamRateTolerance {
+			mismatched[msgID] = rate
+		}
+	}
+	return mismatched
+}
+
+// messageInterval converts a rate to the SET_MESSAGE_INTERVAL interval in
+// microseconds; a zero rate stops the message
+func messageInterval(rate float64) float64 {
+	if rate == 0 {
+		return -1
+	}
+	return math.Round(1e6 / rate)
+}
+
+// applyStreams requests an endpoint's stream rates from a drone that just
+// connected, then checks the observed rates and re-requests those that do not
+// match. Messages requested through REQUEST_DATA_STREAM are not checked, as
+// their group sets a rate for all its messages. A drone reconnecting on
+// another link starts over.
+func (r *Relay) applyStreams(droneID, endpoint string) {
+	rates := r.streams.endpointRates(endpoint)
+	value, ok := r.droneLinks.Load(droneID)
+	if len(rates) == 0 || !ok {
+		return
+	}
+	link := value.(droneLink)
+
+	pending := rates
+	for attempt := 0; attempt < streamCheckAttempts; attempt++ {
+		if grouped := r.setStreamRates(droneID, link, pending); len(grouped) > 0 {
+			rates = maps.Clone(rates)
+			for msgID := range grouped {
+				delete(rates, msgID)
+			}
+		}
+		r.streams.reset(droneID)
+
+		time.Sleep(r.streams.checkWindow)
+		if current, ok := r.droneLinks.Load(droneID); !ok || current.(droneLink) != link {
+			return
+		}
+
+		pending = r.streams.check(droneID, rates, r.streams.checkWindow)
+		if len(pending) == 0 {
+			slog.LogAttrs(context.Background(), slog.LevelInfo, "stream rates applied",
+				slog.String("drone_id", droneID),
+				slog.Int("streams", len(rates)))
+			return
+		}
+		for msgID, rate := range pending {
+			slog.LogAttrs(context.Background(), slog.LevelWarn, "stream rate does not match",
+				slog.String("drone_id", droneID),
+				slog.String("message", r.streams.name(msgID)),
+				slog.Float64("requested_hz", rate),
+				slog.Int("attempt", attempt+1))
+		}
+	}
+}
+
+// setStreamRates sends MAV_CMD_SET_MESSAGE_INTERVAL for every rate. ArduPilot
+// vehicles that reject the command as unsupported, or leave it unanswered for
+// streamFallbackTimeouts messages in a row, get REQUEST_DATA_STREAM instead
+// for the messages not set yet. Those messages are returned.
+func (r *Relay) setStreamRates(droneID string, link droneLink, rates map[uint32]float64) map[uint32]float64 {
+	ids := make([]uint32, 0, len(rates))
+	for msgID := range rates {
+		ids = append(ids, msgID)
+	}
+	slices.Sort(ids)
+
+	accepted := make(map[uint32]bool)
+	timeouts := 0
+	for _, msgID := range ids {
+		result, err := r.sendCommand(context.Background(), CommandRequest{
+			DroneID: droneID,
+			Command: common.MAV_CMD_SET_MESSAGE_INTERVAL.String(),
+			Params:  []float64{float64(msgID), messageInterval(rates[msgID])},
+		})
+
+		timeouts++
+		if !errors.Is(err, errCommandTimeout) {
+			timeouts = 0
+		}
+		unsupported := result.Result == common.MAV_RESULT_UNSUPPORTED.String() || timeouts >= streamFallbackTimeouts
+		if unsupported && link.autopilot == common.MAV_AUTOPILOT_ARDUPILOTMEGA {
+			grouped := make(map[uint32]float64)
+			for id, rate := range rates {
+				if !accepted[id] {
+					grouped[id] = rate
+				}
+			}
+			r.requestDataStreams(droneID, link, grouped)
+			return grouped
+		}
+		if err != nil || result.Result != common.MAV_RESULT_ACCEPTED.String() {
+			slog.LogAttrs(context.Background(), slog.LevelWarn, "failed to set message interval",
+				slog.String("drone_id", droneID),
+				slog.String("message", r.streams.name(msgID)),
+				slog.String("result", result.Result),
+				slog.String("error", result.Error))
+			continue
+		}
+		accepted[msgID] = true
+	}
+	return nil
+}
+
+// requestDataStreams requests the REQUEST_DATA_STREAM groups holding the
+// configured messages, each at the highest rate configured for its messages
+func (r *Relay) requestDataStreams(droneID string, link droneLink, rates map[uint32]float64) {
+	_, writer, err := r.droneTarget(droneID)
+	if err != nil {
+		return
+	}
+
+	groups := make(map[common.MAV_DATA_STREAM]float64)
+	for msgID, rate := range rates {
+		group, ok := dataStreams[msgID]
+		if !ok {
+			slog.LogAttrs(context.Background(), slog.LevelWarn, "message has no data stream group",
+				slog.String("drone_id", droneID),
+				slog.String("message", r.streams.name(msgID)))
+			continue
+		}
+		groups[group] = max(groups[group], rate)
+	}
+
+	for group, rate := range groups {
+		msg := &common.MessageRequestDataStream{
+			TargetSystem:    link.systemID,
+			TargetComponent: link.componentID,
+			ReqStreamId:     uint8(group),
+			ReqMessageRate:  uint16(math.Ceil(rate)),
+			StartStop:       1,
+		}
+		if rate == 0 {
+			msg.StartStop = 0
+		}
+		if err := writer.WriteMessageTo(link.channel, msg); err != nil {
+			slog.LogAttrs(context.Background(), slog.LevelWarn, "failed to request data stream",
+				slog.String("drone_id", droneID),
+				slog.String("stream", group.String()),
+				slog.String("error", err.Error()))
+		}
+	}
+}
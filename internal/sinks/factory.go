@@ -1,11 +1,59 @@
 package sinks
 
 import (
+	"errors"
 	"fmt"
+	"reflect"
+	"slices"
 
 	"github.com/makinje/aero-arc-relay/internal/config"
 )
 
+// sinkKind is a sink type and the config section it is created from
+type sinkKind struct {
+	name   string // key under sinks in the config
+	label  string // used in error messages
+	config func(*config.SinksConfig) any
+	create func(*config.SinksConfig) (Sink, error)
+}
+
+// sinkKinds are the sinks the factory can create, in creation order
+var sinkKinds = []sinkKind{
+	{"nats", "NATS",
+		func(s *config.SinksConfig) any { return s.NATS },
+		func(s *config.SinksConfig) (Sink, error) { return NewNATSSink(*s.NATS) }},
+	{"s3", "S3",
+		func(s *config.SinksConfig) any { return s.S3 },
+		func(s *config.SinksConfig) (Sink, error) { return NewS3Sink(s.S3) }},
+	{"gcs", "GCS",
+		func(s *config.SinksConfig) any { return s.GCS },
+		func(s *config.SinksConfig) (Sink, error) { return NewGCSSink(s.GCS) }},
+	{"bigquery", "BigQuery",
+		func(s *config.SinksConfig) any { return s.BigQuery },
+		func(s *config.SinksConfig) (Sink, error) { return NewBigQuerySink(s.BigQuery) }},
+	{"timestream", "Timestream",
+		func(s *config.SinksConfig) any { return s.Timestream },
+		func(s *config.SinksConfig) (Sink, error) { return NewTimestreamSink(s.Timestream) }},
+	{"influxdb", "InfluxDB",
+		func(s *config.SinksConfig) any { return s.InfluxDB },
+		func(s *config.SinksConfig) (Sink, error) { return NewInfluxDBSink(s.InfluxDB) }},
+	{"prometheus", "Prometheus",
+		func(s *config.SinksConfig) any { return s.Prometheus },
+		func(s *config.SinksConfig) (Sink, error) { return NewPrometheusSink(s.Prometheus) }},
+	{"elasticsearch", "Elasticsearch",
+		func(s *config.SinksConfig) any { return s.Elasticsearch },
+		func(s *config.SinksConfig) (Sink, error) { return NewElasticsearchSink(s.Elasticsearch) }},
+	{"file", "File",
+		func(s *config.SinksConfig) any { return s.File },
+		func(s *config.SinksConfig) (Sink, error) { return NewFileSink(s.File) }},
+	// Note: Kafka support has been removed - use NATS for similar streaming functionality
+	{"kafka", "Kafka",
+		func(s *config.SinksConfig) any { return s.Kafka },
+		func(s *config.SinksConfig) (Sink, error) {
+			return nil, errors.New("kafka sink is no longer supported - use NATS JetStream for similar functionality")
+		}},
+}
+
 // SinkFactory creates sinks based on configuration
 type SinkFactory struct{}
 
@@ -14,102 +62,66 @@ func NewSinkFactory() *SinkFactory {
 	return &SinkFactory{}
 }
 
-// CreateConfiguredSinks creates only the sinks that are configured in the config
-func (f *SinkFactory) CreateConfiguredSinks(cfg *config.Config) ([]Sink, error) {
-	var sinks []Sink
-	var errors []error
-
-	// Only create sinks for configured types
-	if cfg.Sinks.NATS != nil {
-		sink, err := NewNATSSink(*cfg.Sinks.NATS)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to create NATS sink: %w", err))
-		} else {
-			sinks = append(sinks, sink)
-		}
-	}
-
-	if cfg.Sinks.S3 != nil {
-		sink, err := NewS3Sink(cfg.Sinks.S3)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to create S3 sink: %w", err))
-		} else {
-			sinks = append(sinks, sink)
-		}
-	}
-
-	if cfg.Sinks.GCS != nil {
-		sink, err := NewGCSSink(cfg.Sinks.GCS)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to create GCS sink: %w", err))
-		} else {
-			sinks = append(sinks, sink)
+// ConfiguredKinds returns the sink kinds that have a section in the config,
+// in creation order
+func (f *SinkFactory) ConfiguredKinds(cfg *config.Config) []string {
+	var kinds []string
+	for _, kind := range sinkKinds {
+		if !reflect.ValueOf(kind.config(&cfg.Sinks)).IsNil() {
+			kinds = append(kinds, kind.name)
 		}
 	}
+	return kinds
+}
 
-	if cfg.Sinks.BigQuery != nil {
-		sink, err := NewBigQuerySink(cfg.Sinks.BigQuery)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to create BigQuery sink: %w", err))
-		} else {
-			sinks = append(sinks, sink)
-		}
+// CreateSink creates the sink configured under a kind
+func (f *SinkFactory) CreateSink(kind string, cfg *config.Config) (Sink, error) {
+	i := slices.IndexFunc(sinkKinds, func(k sinkKind) bool { return k.name == kind })
+	if i < 0 {
+		return nil, fmt.Errorf("unknown sink %q", kind)
 	}
-
-	if cfg.Sinks.Timestream != nil {
-		sink, err := NewTimestreamSink(cfg.Sinks.Timestream)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to create Timestream sink: %w", err))
-		} else {
-			sinks = append(sinks, sink)
-		}
+	if reflect.ValueOf(sinkKinds[i].config(&cfg.Sinks)).IsNil() {
+		return nil, fmt.Errorf("sink %q is not configured", kind)
 	}
 
-	if cfg.Sinks.InfluxDB != nil {
-		sink, err := NewInfluxDBSink(cfg.Sinks.InfluxDB)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to create InfluxDB sink: %w", err))
-		} else {
-			sinks = append(sinks, sink)
-		}
+	sink, err := sinkKinds[i].create(&cfg.Sinks)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create %s sink: %w", sinkKinds[i].label, err)
 	}
+	return sink, nil
+}
 
-	if cfg.Sinks.Prometheus != nil {
-		sink, err := NewPrometheusSink(cfg.Sinks.Prometheus)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to create Prometheus sink: %w", err))
-		} else {
-			sinks = append(sinks, sink)
-		}
+// ConfigChanged reports whether the section of a sink kind differs between
+// two configs, so its sink has to be rebuilt
+func (f *SinkFactory) ConfigChanged(kind string, old, new *config.Config) bool {
+	i := slices.IndexFunc(sinkKinds, func(k sinkKind) bool { return k.name == kind })
+	if i < 0 {
+		return false
 	}
+	return !reflect.DeepEqual(sinkKinds[i].config(&old.Sinks), sinkKinds[i].config(&new.Sinks))
+}
 
-	if cfg.Sinks.Elasticsearch != nil {
-		sink, err := NewElasticsearchSink(cfg.Sinks.Elasticsearch)
-		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to create Elasticsearch sink: %w", err))
-		} else {
-			sinks = append(sinks, sink)
-		}
-	}
+// CreateConfiguredSinks creates only the sinks that are configured in the
+// config, skipping those that fail as long as one can be created
+func (f *SinkFactory) CreateConfiguredSinks(cfg *config.Config) (map[string]Sink, error) {
+	sinks := make(map[string]Sink)
+	var errs []error
 
-	if cfg.Sinks.File != nil {
-		sink, err := NewFileSink(cfg.Sinks.File)
+	for _, kind := range f.ConfiguredKinds(cfg) {
+		sink, err := f.CreateSink(kind, cfg)
 		if err != nil {
-			errors = append(errors, fmt.Errorf("failed to create File sink: %w", err))
-		} else {
-			sinks = append(sinks, sink)
+			errs = append(errs, err)
+			continue
 		}
-	}
-
-	// Note: Kafka support has been removed - use NATS for similar streaming functionality
-	if cfg.Sinks.Kafka != nil {
-		errors = append(errors, fmt.Errorf("kafka sink is no longer supported - use NATS JetStream for similar functionality"))
+		sinks[kind] = sink
 	}
 
 	if len(sinks) == 0 {
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("no sinks could be created - check configuration and dependencies: %w", errors.Join(errs...))
+		}
 		return nil, fmt.Errorf("no sinks could be created - check configuration and dependencies")
 	}
 
-	// Return successfully created sinks, log any errors as warnings
 	return sinks, nil
 }
@@ -2,6 +2,7 @@ package sinks
 
 import (
 	"context"
+	"encoding/base64"
 	"encoding/csv"
 	"encoding/json"
 	"fmt"
@@ -20,6 +21,8 @@ type FileSink struct {
 	config       *config.FileConfig
 	file         *os.File
 	writer       *csv.Writer
+	events       *os.File    // event envelopes, opened on the first event
+	eventsWriter *csv.Writer // csv writer for events
 	mu           sync.Mutex
 	lastRotation time.Time
 	*BaseAsyncSink
@@ -111,6 +114,11 @@ func (f *FileSink) Close(ctx context.Context) error {
 	if err := f.flushLocked(); err != nil {
 		return err
 	}
+	if f.events != nil {
+		if err := f.events.Close(); err != nil {
+			return err
+		}
+	}
 	return f.file.Close()
 }
 
@@ -130,33 +138,42 @@ func (f *FileSink) handleMessage(envelope telemetry.TelemetryEnvelope) error {
 		envelope.Fields = map[string]any{}
 	}
 
+	// Events go to their own log next to the telemetry file
+	file, writer := f.file, f.writer
+	if envelope.IsEvent() {
+		if err := f.openEventsLocked(); err != nil {
+			return fmt.Errorf("failed to open events file: %w", err)
+		}
+		file, writer = f.events, f.eventsWriter
+	}
+
 	// Write message based on format
 	switch f.config.Format {
 	case "json":
-		return f.writeJSON(envelope)
+		return writeJSON(file, envelope)
 	case "csv":
-		return f.writeCSV(envelope)
+		return writeCSV(writer, envelope)
 	case "binary":
-		return f.writeBinary(envelope)
+		return writeBinary(file, envelope)
 	default:
 		return fmt.Errorf("unsupported format: %s", f.config.Format)
 	}
 }
 
 // writeJSON writes message in JSON format
-func (f *FileSink) writeJSON(msg telemetry.TelemetryEnvelope) error {
+func writeJSON(file *os.File, msg telemetry.TelemetryEnvelope) error {
 	jsonData, err := msg.ToJSON()
 	if err != nil {
 		return err
 	}
 
-	_, err = f.file.Write(append(jsonData, '\n'))
+	_, err = file.Write(append(jsonData, '\n'))
 	return err
 }
 
 // writeCSV writes message in CSV format
-func (f *FileSink) writeCSV(msg telemetry.TelemetryEnvelope) error {
-	if f.writer == nil {
+func writeCSV(writer *csv.Writer, msg telemetry.TelemetryEnvelope) error {
+	if writer == nil {
 		return fmt.Errorf("csv writer not configured")
 	}
 
@@ -176,25 +193,25 @@ func (f *FileSink) writeCSV(msg telemetry.TelemetryEnvelope) error {
 		strconv.Itoa(int(msg.ComponentID)),
 		strconv.FormatUint(uint64(msg.Sequence), 10),
 		string(fieldsJSON),
-		string(msg.Raw),
+		base64.StdEncoding.EncodeToString(msg.Raw),
 	}
 
-	if err := f.writer.Write(row); err != nil {
+	if err := writer.Write(row); err != nil {
 		return err
 	}
 
-	f.writer.Flush()
-	return f.writer.Error()
+	writer.Flush()
+	return writer.Error()
 }
 
 // writeBinary writes message in binary format
-func (f *FileSink) writeBinary(msg telemetry.TelemetryEnvelope) error {
+func writeBinary(file *os.File, msg telemetry.TelemetryEnvelope) error {
 	binaryData, err := msg.ToBinary()
 	if err != nil {
 		return err
 	}
 
-	_, err = f.file.Write(binaryData)
+	_, err = file.Write(binaryData)
 	return err
 }
 
@@ -227,15 +244,38 @@ func generateFilename(basePath, prefix, format string) string {
 }
 
 func (f *FileSink) flushLocked() error {
-	if f.writer != nil {
-		f.writer.Flush()
-		if err := f.writer.Error(); err != nil {
+	for _, writer := range []*csv.Writer{f.writer, f.eventsWriter} {
+		if writer == nil {
+			continue
+		}
+		writer.Flush()
+		if err := writer.Error(); err != nil {
 			return err
 		}
 	}
 	return nil
 }
 
+// openEventsLocked opens the events file of the current rotation if it is
+// not open yet
+func (f *FileSink) openEventsLocked() error {
+	if f.events != nil {
+		return nil
+	}
+
+	filename := generateFilename(f.config.Path, f.config.Prefix+"_events", f.config.Format)
+	file, err := os.OpenFile(filename, os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+
+	f.events = file
+	if f.config.Format == "csv" {
+		f.eventsWriter = csv.NewWriter(file)
+	}
+	return nil
+}
+
 func (f *FileSink) rotateFileLocked() error {
 	if err := f.flushLocked(); err != nil {
 		return err
@@ -243,6 +283,12 @@ func (f *FileSink) rotateFileLocked() error {
 	if err := f.file.Close(); err != nil {
 		return err
 	}
+	if f.events != nil {
+		if err := f.events.Close(); err != nil {
+			return err
+		}
+		f.events, f.eventsWriter = nil, nil
+	}
 
 	filename := generateFilename(f.config.Path, f.config.Prefix, f.config.Format)
 
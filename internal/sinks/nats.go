@@ -20,9 +20,12 @@ type NATSSink struct {
 	js             nats.JetStreamContext
 	kv             nats.KeyValue
 	subjectPattern string
+	eventPattern   string // subject pattern for event envelopes
 	streamName     string
 	kvKeyPattern   string
 	kvMessageTypes map[string]bool // Message types that should update KV state
+	commands       *config.NATSCommands
+	commandSub     *nats.Subscription
 	base           *BaseAsyncSink
 }
 
@@ -62,7 +65,9 @@ func NewNATSSink(cfg config.NATSConfig) (*NATSSink, error) {
 		nc:             nc,
 		js:             js,
 		subjectPattern: cfg.Subject,
+		eventPattern:   cfg.EventSubject,
 		kvMessageTypes: make(map[string]bool),
+		commands:       cfg.Commands,
 	}
 
 	// Create or update JetStream if configured
@@ -93,6 +98,9 @@ func NewNATSSink(cfg config.NATSConfig) (*NATSSink, error) {
 			sink.kvMessageTypes["Attitude"] = true
 			sink.kvMessageTypes["SystemStatus"] = true
 			sink.kvMessageTypes["VFR_HUD"] = true
+			sink.kvMessageTypes["LinkStatus"] = true
+			sink.kvMessageTypes["VehicleOnline"] = true
+			sink.kvMessageTypes["VehicleOffline"] = true
 		}
 	}
 
@@ -119,10 +127,107 @@ func (s *NATSSink) WriteMessage(msg telemetry.TelemetryEnvelope) error {
 // Close implements the Sink interface
 func (s *NATSSink) Close(ctx context.Context) error {
 	s.base.Close()
+	if err := s.UnsubscribeCommands(); err != nil {
+		logger.Warn("Failed to unsubscribe from NATS commands", zap.Error(err))
+	}
 	s.nc.Drain()
 	return nil
 }
 
+// SubscribeCommands implements the CommandSource interface. Commands arrive on
+// the configured subject pattern with the entity ID taken from the subject;
+// results go to the request's reply inbox, or to the reply subject pattern
+// when the publisher did not ask for a reply.
+func (s *NATSSink) SubscribeCommands(handler CommandHandler) error {
+	if s.commands == nil || s.commands.Subject == "" {
+		return nil
+	}
+
+	subject, entityToken, err := commandSubscription(s.commands.Subject)
+	if err != nil {
+		return err
+	}
+
+	sub, err := s.nc.Subscribe(subject, func(msg *nats.Msg) {
+		// commands block until acknowledged, so don't hold up the subscription
+		go s.handleCommand(msg, entityToken, handler)
+	})
+	if err != nil {
+		return fmt.Errorf("failed to subscribe to command subject: %w", err)
+	}
+	s.commandSub = sub
+
+	logger.Info("Subscribed to NATS command subject",
+		zap.String("subject", subject),
+		zap.String("reply_subject", s.commands.ReplySubject))
+	return nil
+}
+
+// UnsubscribeCommands implements the CommandSource interface. Commands
+// published afterwards are left to other subscribers.
+func (s *NATSSink) UnsubscribeCommands() error {
+	if s.commandSub == nil {
+		return nil
+	}
+	sub := s.commandSub
+	s.commandSub = nil
+	if err := sub.Unsubscribe(); err != nil {
+		return fmt.Errorf("failed to unsubscribe from command subject: %w", err)
+	}
+	return nil
+}
+
+// handleCommand runs a single command and publishes its result
+func (s *NATSSink) handleCommand(msg *nats.Msg, entityToken int, handler CommandHandler) {
+	tokens := strings.Split(msg.Subject, ".")
+	if entityToken >= len(tokens) {
+		return
+	}
+	entityID := tokens[entityToken]
+
+	reply, ok := handler(context.Background(), entityID, msg.Data)
+	if !ok {
+		return
+	}
+
+	subject := msg.Reply
+	if subject == "" && s.commands.ReplySubject != "" {
+		subject = strings.ReplaceAll(s.commands.ReplySubject, "{entity_id}", entityID)
+		subject = strings.ReplaceAll(subject, "{drone_id}", entityID)
+	}
+	if subject == "" {
+		return
+	}
+
+	if err := s.nc.Publish(subject, reply); err != nil {
+		logger.Warn("Failed to publish command result",
+			zap.String("subject", subject),
+			zap.String("entity_id", entityID),
+			zap.Error(err))
+	}
+}
+
+// commandSubscription turns a command subject pattern into a NATS wildcard
+// subscription and returns the token index holding the entity ID
+func commandSubscription(pattern string) (string, int, error) {
+	tokens := strings.Split(pattern, ".")
+	entityToken := -1
+	for i, token := range tokens {
+		if token == "{entity_id}" || token == "{drone_id}" {
+			if entityToken >= 0 {
+				return "", 0, fmt.Errorf("command subject %q has more than one entity placeholder", pattern)
+			}
+			entityToken = i
+			tokens[i] = "*"
+		}
+	}
+	if entityToken < 0 {
+		return "", 0, fmt.Errorf("command subject %q must contain an {entity_id} token", pattern)
+	}
+
+	return strings.Join(tokens, "."), entityToken, nil
+}
+
 // ensureStream creates or updates a JetStream stream
 func (s *NATSSink) ensureStream(cfg *config.StreamConfig) error {
 	// Set defaults
@@ -251,6 +356,10 @@ func (s *NATSSink) publishMessage(msg telemetry.TelemetryEnvelope) error {
 
 	// Resolve subject pattern with entity/drone ID
 	subject := s.resolveSubject(msg)
+	kind := msg.Kind
+	if kind == "" {
+		kind = telemetry.KindTelemetry
+	}
 
 	// Create NATS message with headers
 	natsMsg := &nats.Msg{
@@ -260,6 +369,7 @@ func (s *NATSSink) publishMessage(msg telemetry.TelemetryEnvelope) error {
 			"entity_id":    []string{msg.DroneID},
 			"source":       []string{msg.Source},
 			"message_type": []string{msg.MsgName},
+			"kind":         []string{kind},
 			"timestamp":    []string{msg.TimestampRelay.Format(time.RFC3339Nano)},
 		},
 	}
@@ -390,7 +500,21 @@ type DeviceState struct {
 	ClimbRate   *float32 `json:"climb_rate,omitempty"`
 
 	// Heartbeat data
-	VehicleType *string `json:"vehicle_type,omitempty"`
+	VehicleType  *string `json:"vehicle_type,omitempty"`
+	Autopilot    *string `json:"autopilot,omitempty"`
+	FlightMode   *string `json:"flight_mode,omitempty"`
+	Armed        *bool   `json:"armed,omitempty"`
+	SystemStatus *string `json:"system_status,omitempty"`
+
+	// Liveness (from VehicleOnline and VehicleOffline)
+	Connected *bool `json:"connected,omitempty"`
+
+	// Link quality over the last interval (from LinkStatus)
+	LinkReceived    *uint64  `json:"link_received,omitempty"`
+	LinkLost        *uint64  `json:"link_lost,omitempty"`
+	LinkDuplicate   *uint64  `json:"link_duplicate,omitempty"`
+	LinkOutOfOrder  *uint64  `json:"link_out_of_order,omitempty"`
+	LinkLossPercent *float64 `json:"link_loss_percent,omitempty"`
 }
 
 // UpdateFromMessage updates device state fields from a telemetry message
@@ -468,6 +592,40 @@ func (s *DeviceState) UpdateFromMessage(msg telemetry.TelemetryEnvelope) {
 		if v, ok := msg.Fields["type"].(string); ok {
 			s.VehicleType = &v
 		}
+		if v, ok := msg.Fields["autopilot"].(string); ok {
+			s.Autopilot = &v
+		}
+		if v, ok := msg.Fields["flight_mode"].(string); ok {
+			s.FlightMode = &v
+		}
+		if v, ok := msg.Fields["armed"].(bool); ok {
+			s.Armed = &v
+		}
+		if v, ok := msg.Fields["system_status"].(string); ok {
+			s.SystemStatus = &v
+		}
+
+	case "VehicleOnline", "VehicleOffline":
+		if v, ok := msg.Fields["connected"].(bool); ok {
+			s.Connected = &v
+		}
+
+	case "LinkStatus":
+		if v, ok := msg.Fields["received"].(uint64); ok {
+			s.LinkReceived = &v
+		}
+		if v, ok := msg.Fields["lost"].(uint64); ok {
+			s.LinkLost = &v
+		}
+		if v, ok := msg.Fields["duplicate"].(uint64); ok {
+			s.LinkDuplicate = &v
+		}
+		if v, ok := msg.Fields["out_of_order"].(uint64); ok {
+			s.LinkOutOfOrder = &v
+		}
+		if v, ok := msg.Fields["loss_percent"].(float64); ok {
+			s.LinkLossPercent = &v
+		}
 	}
 }
 
@@ -476,8 +634,10 @@ func mergeDeviceState(existing, new DeviceState, msgType string) DeviceState {
 	// Start with existing state
 	merged := existing
 
-	// Update common fields
-	merged.LastSeen = new.LastSeen
+	// Update common fields; going offline is not a sighting of the device
+	if msgType != "VehicleOffline" {
+		merged.LastSeen = new.LastSeen
+	}
 	merged.LastMsgType = new.LastMsgType
 	merged.SystemID = new.SystemID
 	merged.ComponentID = new.ComponentID
@@ -514,6 +674,20 @@ func mergeDeviceState(existing, new DeviceState, msgType string) DeviceState {
 
 	case "Heartbeat":
 		merged.VehicleType = new.VehicleType
+		merged.Autopilot = new.Autopilot
+		merged.FlightMode = new.FlightMode
+		merged.Armed = new.Armed
+		merged.SystemStatus = new.SystemStatus
+
+	case "VehicleOnline", "VehicleOffline":
+		merged.Connected = new.Connected
+
+	case "LinkStatus":
+		merged.LinkReceived = new.LinkReceived
+		merged.LinkLost = new.LinkLost
+		merged.LinkDuplicate = new.LinkDuplicate
+		merged.LinkOutOfOrder = new.LinkOutOfOrder
+		merged.LinkLossPercent = new.LinkLossPercent
 	}
 
 	return merged
@@ -522,6 +696,9 @@ func mergeDeviceState(existing, new DeviceState, msgType string) DeviceState {
 // resolveSubject resolves subject pattern with entity information
 func (s *NATSSink) resolveSubject(msg telemetry.TelemetryEnvelope) string {
 	subject := s.subjectPattern
+	if msg.IsEvent() && s.eventPattern != "" {
+		subject = s.eventPattern
+	}
 
 	// Replace placeholders with actual values
 	// For 1:1 mode: constellation.telemetry.{entity_id}
@@ -12,6 +12,18 @@ type Sink interface {
 	Close(ctx context.Context) error
 }
 
+// CommandHandler executes a command payload received for an entity and
+// returns the JSON result to reply with. A false return means the command is
+// not for this relay and must not be answered.
+type CommandHandler func(ctx context.Context, entityID string, payload []byte) ([]byte, bool)
+
+// CommandSource is implemented by sinks that can also receive commands for
+// vehicles, turning a one-way sink into a two-way link
+type CommandSource interface {
+	SubscribeCommands(handler CommandHandler) error
+	UnsubscribeCommands() error
+}
+
 // SinkType represents the type of sink
 type SinkType string
 
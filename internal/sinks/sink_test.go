@@ -2,6 +2,9 @@ package sinks
 
 import (
 	"context"
+	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 	"time"
 
@@ -206,6 +209,64 @@ func TestFileSinkConfiguration(t *testing.T) {
 	}
 }
 
+// TestFileSinkEvents tests that event envelopes are written to a separate log
+func TestFileSinkEvents(t *testing.T) {
+	dir := t.TempDir()
+	sink, err := NewFileSink(&config.FileConfig{Path: dir, Prefix: "telemetry", Format: "json", RotationInterval: time.Hour})
+	if err != nil {
+		t.Fatalf("Failed to create file sink: %v", err)
+	}
+
+	event := makeEnvelope("drone-1", "StatusText", map[string]any{"text": "PreArm: Throttle below failsafe"})
+	event.Kind = telemetry.KindEvent
+	if err := sink.handleMessage(makeEnvelope("drone-1", "Heartbeat", nil)); err != nil {
+		t.Fatalf("Failed to write telemetry: %v", err)
+	}
+	if err := sink.handleMessage(event); err != nil {
+		t.Fatalf("Failed to write event: %v", err)
+	}
+	if err := sink.Close(context.Background()); err != nil {
+		t.Fatalf("Failed to close file sink: %v", err)
+	}
+
+	telemetryFiles, _ := filepath.Glob(filepath.Join(dir, "telemetry_[0-9]*.json"))
+	eventFiles, _ := filepath.Glob(filepath.Join(dir, "telemetry_events_*.json"))
+	if len(telemetryFiles) != 1 || len(eventFiles) != 1 {
+		t.Fatalf("Expected one telemetry and one events file, got %v and %v", telemetryFiles, eventFiles)
+	}
+
+	telemetryData, _ := os.ReadFile(telemetryFiles[0])
+	eventData, _ := os.ReadFile(eventFiles[0])
+	if strings.Contains(string(telemetryData), "StatusText") || !strings.Contains(string(telemetryData), "Heartbeat") {
+		t.Errorf("Expected only telemetry in the telemetry file, got %s", telemetryData)
+	}
+	if !strings.Contains(string(eventData), "PreArm: Throttle below failsafe") || strings.Contains(string(eventData), "Heartbeat") {
+		t.Errorf("Expected only events in the events file, got %s", eventData)
+	}
+}
+
+// TestNATSEventSubject tests that events are published on the event subject
+func TestNATSEventSubject(t *testing.T) {
+	sink := &NATSSink{
+		subjectPattern: "constellation.telemetry.{entity_id}.{message_type}",
+		eventPattern:   "constellation.events.{entity_id}.{message_type}",
+	}
+
+	event := makeEnvelope("drone-1", "StatusText", nil)
+	event.Kind = telemetry.KindEvent
+	if got := sink.resolveSubject(event); got != "constellation.events.drone-1.statustext" {
+		t.Errorf("Expected event subject, got %s", got)
+	}
+	if got := sink.resolveSubject(makeEnvelope("drone-1", "Heartbeat", nil)); got != "constellation.telemetry.drone-1.heartbeat" {
+		t.Errorf("Expected telemetry subject, got %s", got)
+	}
+
+	sink.eventPattern = ""
+	if got := sink.resolveSubject(event); got != "constellation.telemetry.drone-1.statustext" {
+		t.Errorf("Expected events on the telemetry subject without an event subject, got %s", got)
+	}
+}
+
 // TestS3SinkConfiguration tests S3 sink configuration
 func TestS3SinkConfiguration(t *testing.T) {
 	cfg := &config.S3Config{
@@ -245,6 +306,49 @@ func TestKafkaSinkConfiguration(t *testing.T) {
 	}
 }
 
+// TestSinkFactory tests creating sinks per config section and detecting
+// changed sections
+func TestSinkFactory(t *testing.T) {
+	factory := NewSinkFactory()
+	cfg := &config.Config{Sinks: config.SinksConfig{
+		File:  &config.FileConfig{Path: t.TempDir(), Format: "json", RotationInterval: time.Hour},
+		Kafka: &config.KafkaConfig{Topic: "telemetry"},
+	}}
+
+	kinds := factory.ConfiguredKinds(cfg)
+	if len(kinds) != 2 || kinds[0] != "file" || kinds[1] != "kafka" {
+		t.Fatalf("Expected [file kafka], got %v", kinds)
+	}
+
+	created, err := factory.CreateConfiguredSinks(cfg)
+	if err != nil {
+		t.Fatalf("Failed to create sinks: %v", err)
+	}
+	if _, ok := created["file"]; !ok || len(created) != 1 {
+		t.Errorf("Expected only the file sink to be created, got %v", created)
+	}
+	created["file"].Close(context.Background())
+
+	if _, err := factory.CreateSink("nats", cfg); err == nil {
+		t.Error("Expected an error for an unconfigured sink")
+	}
+
+	changed := *cfg
+	fileConfig := *cfg.Sinks.File
+	changed.Sinks.File = &fileConfig
+	if factory.ConfigChanged("file", cfg, &changed) {
+		t.Error("Expected an equal file section to be unchanged")
+	}
+	fileConfig.Format = "csv"
+	if !factory.ConfigChanged("file", cfg, &changed) || factory.ConfigChanged("kafka", cfg, &changed) {
+		t.Error("Expected only the file section to change")
+	}
+	changed.Sinks.Kafka = nil
+	if !factory.ConfigChanged("kafka", cfg, &changed) {
+		t.Error("Expected a removed section to change")
+	}
+}
+
 // TestMessageSerialization tests that messages can be serialized to JSON
 func TestMessageSerialization(t *testing.T) {
 	heartbeat := makeEnvelope("test-drone", "heartbeat", map[string]any{
@@ -337,3 +441,111 @@ func TestSinkTypeConstants(t *testing.T) {
 		t.Errorf("Expected SinkTypeFile to be 'file', got '%s'", SinkTypeFile)
 	}
 }
+
+// TestCommandSubscription tests turning command subject patterns into subscriptions
+func TestCommandSubscription(t *testing.T) {
+	testCases := []struct {
+		pattern     string
+		wantSubject string
+		wantToken   int
+		wantErr     bool
+	}{
+		{"constellation.commands.{entity_id}", "constellation.commands.*", 2, false},
+		{"fleet.{drone_id}.commands", "fleet.*.commands", 1, false},
+		{"constellation.commands", "", 0, true},
+		{"fleet.{entity_id}.{drone_id}", "", 0, true},
+	}
+
+	for _, tc := range testCases {
+		subject, token, err := commandSubscription(tc.pattern)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("%s: expected error %v, got %v", tc.pattern, tc.wantErr, err)
+			continue
+		}
+		if subject != tc.wantSubject || token != tc.wantToken {
+			t.Errorf("%s: expected (%s, %d), got (%s, %d)", tc.pattern, tc.wantSubject, tc.wantToken, subject, token)
+		}
+	}
+}
+
+// TestDeviceStateLinkStatus tests merging link quality into device state
+func TestDeviceStateLinkStatus(t *testing.T) {
+	var existing DeviceState
+	existing.UpdateFromMessage(makeEnvelope("drone-1", "Heartbeat", map[string]any{"type": "MAV_TYPE_QUADROTOR"}))
+
+	var update DeviceState
+	update.UpdateFromMessage(makeEnvelope("drone-1", "LinkStatus", map[string]any{
+		"received":     uint64(95),
+		"lost":         uint64(5),
+		"duplicate":    uint64(1),
+		"out_of_order": uint64(0),
+		"loss_percent": 5.0,
+	}))
+
+	merged := mergeDeviceState(existing, update, "LinkStatus")
+	if merged.LinkLost == nil || *merged.LinkLost != 5 || merged.LinkLossPercent == nil || *merged.LinkLossPercent != 5 {
+		t.Errorf("Expected link quality in device state, got lost=%v loss=%v", merged.LinkLost, merged.LinkLossPercent)
+	}
+	if merged.VehicleType == nil || *merged.VehicleType != "MAV_TYPE_QUADROTOR" {
+		t.Error("Expected heartbeat state to be preserved")
+	}
+}
+
+// TestDeviceStateHeartbeat tests the decoded heartbeat state
+func TestDeviceStateHeartbeat(t *testing.T) {
+	var existing DeviceState
+	existing.UpdateFromMessage(makeEnvelope("drone-1", "Heartbeat", map[string]any{
+		"type":          "MAV_TYPE_QUADROTOR",
+		"autopilot":     "MAV_AUTOPILOT_PX4",
+		"flight_mode":   "AUTO.MISSION",
+		"armed":         true,
+		"system_status": "MAV_STATE_ACTIVE",
+	}))
+
+	var update DeviceState
+	update.UpdateFromMessage(makeEnvelope("drone-1", "Heartbeat", map[string]any{
+		"type":          "MAV_TYPE_QUADROTOR",
+		"autopilot":     "MAV_AUTOPILOT_PX4",
+		"flight_mode":   "AUTO.LAND",
+		"armed":         false,
+		"system_status": "MAV_STATE_STANDBY",
+	}))
+
+	merged := mergeDeviceState(existing, update, "Heartbeat")
+	if merged.FlightMode == nil || *merged.FlightMode != "AUTO.LAND" {
+		t.Errorf("Expected flight mode AUTO.LAND, got %v", merged.FlightMode)
+	}
+	if merged.Armed == nil || *merged.Armed {
+		t.Error("Expected the device to be disarmed")
+	}
+	if merged.SystemStatus == nil || *merged.SystemStatus != "MAV_STATE_STANDBY" {
+		t.Errorf("Expected system status MAV_STATE_STANDBY, got %v", merged.SystemStatus)
+	}
+	if merged.Autopilot == nil || *merged.Autopilot != "MAV_AUTOPILOT_PX4" {
+		t.Errorf("Expected autopilot MAV_AUTOPILOT_PX4, got %v", merged.Autopilot)
+	}
+}
+
+// TestDeviceStateConnected tests the connected flag set by liveness envelopes
+func TestDeviceStateConnected(t *testing.T) {
+	online := makeEnvelope("drone-1", "VehicleOnline", map[string]any{"connected": true})
+	var existing DeviceState
+	existing.LastSeen = online.TimestampRelay
+	existing.UpdateFromMessage(online)
+	if existing.Connected == nil || !*existing.Connected {
+		t.Fatal("Expected VehicleOnline to mark the device connected")
+	}
+
+	offline := makeEnvelope("drone-1", "VehicleOffline", map[string]any{"connected": false})
+	offline.TimestampRelay = online.TimestampRelay.Add(time.Minute)
+	update := DeviceState{LastSeen: offline.TimestampRelay}
+	update.UpdateFromMessage(offline)
+
+	merged := mergeDeviceState(existing, update, "VehicleOffline")
+	if merged.Connected == nil || *merged.Connected {
+		t.Error("Expected VehicleOffline to mark the device disconnected")
+	}
+	if !merged.LastSeen.Equal(online.TimestampRelay) {
+		t.Errorf("Expected last seen to stay at %v, got %v", online.TimestampRelay, merged.LastSeen)
+	}
+}
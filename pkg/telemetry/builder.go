@@ -0,0 +1,188 @@
+package telemetry
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
+)
+
+// EnvelopeBuilder converts a decoded MAVLink message into a telemetry envelope
+type EnvelopeBuilder func(source string, droneID string, msg message.Message) TelemetryEnvelope
+
+var (
+	overridesMu sync.RWMutex
+	overrides   = map[reflect.Type]EnvelopeBuilder{}
+
+	// messageFieldCache caches the reflected field layout per message type
+	messageFieldCache sync.Map // map[reflect.Type][]messageField
+
+	upperCaseRe = regexp.MustCompile("([A-Z])")
+)
+
+func init() {
+	RegisterEnvelopeBuilder(&common.MessageHeartbeat{}, func(source, droneID string, msg message.Message) TelemetryEnvelope {
+		return BuildHeartbeatEnvelope(source, droneID, msg.(*common.MessageHeartbeat))
+	})
+	RegisterEnvelopeBuilder(&common.MessageGlobalPositionInt{}, func(source, droneID string, msg message.Message) TelemetryEnvelope {
+		return BuildGlobalPositionIntEnvelope(source, droneID, msg.(*common.MessageGlobalPositionInt))
+	})
+	RegisterEnvelopeBuilder(&common.MessageAttitude{}, func(source, droneID string, msg message.Message) TelemetryEnvelope {
+		return BuildAttitudeEnvelope(source, droneID, msg.(*common.MessageAttitude))
+	})
+	RegisterEnvelopeBuilder(&common.MessageVfrHud{}, func(source, droneID string, msg message.Message) TelemetryEnvelope {
+		return BuildVfrHudEnvelope(source, droneID, msg.(*common.MessageVfrHud))
+	})
+	RegisterEnvelopeBuilder(&common.MessageSysStatus{}, func(source, droneID string, msg message.Message) TelemetryEnvelope {
+		return BuildSysStatusEnvelope(source, droneID, msg.(*common.MessageSysStatus))
+	})
+}
+
+// RegisterEnvelopeBuilder installs a hand-written builder for a message type,
+// overriding the generic reflection-based builder. Passing a nil builder
+// removes the override.
+func RegisterEnvelopeBuilder(msg message.Message, builder EnvelopeBuilder) {
+	overridesMu.Lock()
+	defer overridesMu.Unlock()
+
+	msgType := reflect.TypeOf(msg)
+	if builder == nil {
+		delete(overrides, msgType)
+		return
+	}
+	overrides[msgType] = builder
+}
+
+// BuildEnvelope converts any MAVLink message into a telemetry envelope, using
+// a registered override when one exists and the generic builder otherwise.
+func BuildEnvelope(source string, droneID string, msg message.Message) TelemetryEnvelope {
+	overridesMu.RLock()
+	builder, ok := overrides[reflect.TypeOf(msg)]
+	overridesMu.RUnlock()
+
+	if ok {
+		return builder(source, droneID, msg)
+	}
+
+	return BuildGenericEnvelope(source, droneID, msg)
+}
+
+// BuildGenericEnvelope converts a MAVLink message into a telemetry envelope by
+// reflecting over its fields. Field names are rendered in snake_case as in the
+// MAVLink XML definitions and enum values are rendered as their string labels.
+func BuildGenericEnvelope(source string, droneID string, msg message.Message) TelemetryEnvelope {
+	return TelemetryEnvelope{
+		DroneID:         droneID,
+		Source:          source,
+		TimestampRelay:  time.Now().UTC(),
+		TimestampDevice: deviceTimestamp(msg),
+		MsgID:           msg.GetID(),
+		MsgName:         MessageName(msg),
+		Fields:          messageFields(msg),
+	}
+}
+
+// MessageName returns the CamelCase name of a message, e.g. "GlobalPositionInt"
+func MessageName(msg message.Message) string {
+	return strings.TrimPrefix(messageType(msg).Name(), "Message")
+}
+
+// MessageDefName returns the name of a message as written in the MAVLink
+// definitions, e.g. "GLOBAL_POSITION_INT"
+func MessageDefName(msg message.Message) string {
+	name := upperCaseRe.ReplaceAllString(MessageName(msg), "_${1}")
+	return strings.ToUpper(strings.TrimPrefix(name, "_"))
+}
+
+// messageField describes how a single struct field is rendered
+type messageField struct {
+	index  int
+	name   string
+	isEnum bool
+}
+
+func messageType(msg message.Message) reflect.Type {
+	t := reflect.TypeOf(msg)
+	if t.Kind() == reflect.Pointer {
+		t = t.Elem()
+	}
+	return t
+}
+
+func messageFieldsFor(t reflect.Type) []messageField {
+	if cached, ok := messageFieldCache.Load(t); ok {
+		return cached.([]messageField)
+	}
+
+	fields := make([]messageField, 0, t.NumField())
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+
+		name := field.Tag.Get("mavname")
+		if name == "" {
+			name = fieldDefName(field.Name)
+		}
+
+		fields = append(fields, messageField{
+			index:  i,
+			name:   name,
+			isEnum: field.Tag.Get("mavenum") != "",
+		})
+	}
+
+	messageFieldCache.Store(t, fields)
+	return fields
+}
+
+func messageFields(msg message.Message) map[string]any {
+	v := reflect.ValueOf(msg)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return map[string]any{}
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return map[string]any{}
+	}
+
+	layout := messageFieldsFor(v.Type())
+	fields := make(map[string]any, len(layout))
+	for _, f := range layout {
+		fields[f.name] = fieldValue(v.Field(f.index), f.isEnum)
+	}
+
+	return fields
+}
+
+func fieldValue(v reflect.Value, isEnum bool) any {
+	if isEnum {
+		if v.Kind() == reflect.Array {
+			labels := make([]string, v.Len())
+			for i := range labels {
+				labels[i] = fmt.Sprint(v.Index(i).Interface())
+			}
+			return labels
+		}
+		if stringer, ok := v.Interface().(fmt.Stringer); ok {
+			return stringer.String()
+		}
+	}
+
+	return v.Interface()
+}
+
+// fieldDefName converts a Go field name to its MAVLink definition name,
+// e.g. "TimeBootMs" -> "time_boot_ms"
+func fieldDefName(name string) string {
+	name = upperCaseRe.ReplaceAllString(name, "_${1}")
+	return strings.ToLower(strings.TrimPrefix(name, "_"))
+}
@@ -7,7 +7,16 @@ import (
 	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
 )
 
+// Envelope kinds. Telemetry envelopes carry MAVLink messages and relay
+// measurements; event envelopes carry discrete occurrences such as autopilot
+// status texts, which sinks may route separately.
+const (
+	KindTelemetry = "telemetry"
+	KindEvent     = "event"
+)
+
 type TelemetryEnvelope struct {
+	Kind            string         `json:"kind,omitempty"` // empty is KindTelemetry
 	DroneID         string         `json:"drone_id"`
 	Source          string         `json:"source"`
 	TimestampRelay  time.Time      `json:"timestamp_relay"`
@@ -55,6 +64,11 @@ func (e TelemetryEnvelope) GetMessageType() string {
 	return e.MsgName
 }
 
+// IsEvent reports whether the envelope is an event rather than telemetry
+func (e TelemetryEnvelope) IsEvent() bool {
+	return e.Kind == KindEvent
+}
+
 func (e TelemetryEnvelope) ToJSON() ([]byte, error) {
 	return json.Marshal(e)
 }
@@ -69,17 +83,19 @@ func (e TelemetryEnvelope) ToBinary() ([]byte, error) {
 
 func BuildHeartbeatEnvelope(source string, droneID string, msg *common.MessageHeartbeat) TelemetryEnvelope {
 	envelope := TelemetryEnvelope{
-		DroneID:         droneID,
-		Source:          source,
-		TimestampRelay:  time.Now().UTC(),
-		TimestampDevice: 0,
-		MsgID:           msg.GetID(),
-		MsgName:         "Heartbeat",
-		SystemID:        0,
-		ComponentID:     0,
-		Sequence:        0,
+		DroneID:        droneID,
+		Source:         source,
+		TimestampRelay: time.Now().UTC(),
+		MsgID:          msg.GetID(),
+		MsgName:        "Heartbeat",
 		Fields: map[string]any{
-			"type": msg.Type.String(),
+			"type":          msg.Type.String(),
+			"autopilot":     msg.Autopilot.String(),
+			"base_mode":     msg.BaseMode.String(),
+			"custom_mode":   msg.CustomMode,
+			"armed":         msg.BaseMode&common.MAV_MODE_FLAG_SAFETY_ARMED != 0,
+			"system_status": msg.SystemStatus.String(),
+			"flight_mode":   FlightMode(msg.Autopilot, msg.Type, msg.CustomMode),
 		},
 	}
 
@@ -91,12 +107,9 @@ func BuildGlobalPositionIntEnvelope(source string, droneID string, msg *common.M
 		DroneID:         droneID,
 		Source:          source,
 		TimestampRelay:  time.Now().UTC(),
-		TimestampDevice: 0,
+		TimestampDevice: float64(msg.TimeBootMs) / 1e3,
 		MsgID:           msg.GetID(),
 		MsgName:         "GlobalPositionInt",
-		SystemID:        0,
-		ComponentID:     0,
-		Sequence:        0,
 		Fields: map[string]any{
 			"latitude":     msg.Lat,
 			"longitude":    msg.Lon,
@@ -117,12 +130,9 @@ func BuildAttitudeEnvelope(source string, droneID string, msg *common.MessageAtt
 		DroneID:         droneID,
 		Source:          source,
 		TimestampRelay:  time.Now().UTC(),
-		TimestampDevice: 0,
+		TimestampDevice: float64(msg.TimeBootMs) / 1e3,
 		MsgID:           msg.GetID(),
 		MsgName:         "Attitude",
-		SystemID:        0,
-		ComponentID:     0,
-		Sequence:        0,
 		Fields: map[string]any{
 			"pitch":       msg.Pitch,
 			"roll":        msg.Roll,
@@ -138,15 +148,11 @@ func BuildAttitudeEnvelope(source string, droneID string, msg *common.MessageAtt
 
 func BuildVfrHudEnvelope(source string, droneID string, msg *common.MessageVfrHud) TelemetryEnvelope {
 	envelope := TelemetryEnvelope{
-		DroneID:         droneID,
-		Source:          source,
-		TimestampRelay:  time.Now().UTC(),
-		TimestampDevice: 0,
-		MsgID:           msg.GetID(),
-		MsgName:         "VFR_HUD",
-		SystemID:        0,
-		ComponentID:     0,
-		Sequence:        0,
+		DroneID:        droneID,
+		Source:         source,
+		TimestampRelay: time.Now().UTC(),
+		MsgID:          msg.GetID(),
+		MsgName:        "VFR_HUD",
 		Fields: map[string]any{
 			"ground_speed": msg.Groundspeed,
 			"altitude":     msg.Alt,
@@ -161,15 +167,11 @@ func BuildVfrHudEnvelope(source string, droneID string, msg *common.MessageVfrHu
 
 func BuildSysStatusEnvelope(source string, droneID string, msg *common.MessageSysStatus) TelemetryEnvelope {
 	envelope := TelemetryEnvelope{
-		DroneID:         droneID,
-		Source:          source,
-		TimestampRelay:  time.Now().UTC(),
-		TimestampDevice: 0,
-		MsgID:           msg.GetID(),
-		MsgName:         "SystemStatus",
-		SystemID:        0,
-		ComponentID:     0,
-		Sequence:        0,
+		DroneID:        droneID,
+		Source:         source,
+		TimestampRelay: time.Now().UTC(),
+		MsgID:          msg.GetID(),
+		MsgName:        "SystemStatus",
 		Fields: map[string]any{
 			"battery_remaining":               msg.BatteryRemaining,
 			"voltage_battery":                 msg.VoltageBattery,
@@ -0,0 +1,190 @@
+package telemetry
+
+import (
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+)
+
+// UnknownFlightMode is reported for modes the decoder has no name for
+const UnknownFlightMode = "UNKNOWN"
+
+// arduCopterModes are ArduCopter's custom_mode numbers. POSITION and
+// OF_LOITER are retired but still reported by old firmware.
+var arduCopterModes = map[uint32]string{
+	0:  "STABILIZE",
+	1:  "ACRO",
+	2:  "ALT_HOLD",
+	3:  "AUTO",
+	4:  "GUIDED",
+	5:  "LOITER",
+	6:  "RTL",
+	7:  "CIRCLE",
+	8:  "POSITION",
+	9:  "LAND",
+	10: "OF_LOITER",
+	11: "DRIFT",
+	13: "SPORT",
+	14: "FLIP",
+	15: "AUTOTUNE",
+	16: "POSHOLD",
+	17: "BRAKE",
+	18: "THROW",
+	19: "AVOID_ADSB",
+	20: "GUIDED_NOGPS",
+	21: "SMART_RTL",
+	22: "FLOWHOLD",
+	23: "FOLLOW",
+	24: "ZIGZAG",
+	25: "SYSTEMID",
+	26: "AUTOROTATE",
+	27: "AUTO_RTL",
+	28: "TURTLE",
+}
+
+// arduPlaneModes are ArduPlane's custom_mode numbers, including the Q modes
+// of QuadPlanes
+var arduPlaneModes = map[uint32]string{
+	0:  "MANUAL",
+	1:  "CIRCLE",
+	2:  "STABILIZE",
+	3:  "TRAINING",
+	4:  "ACRO",
+	5:  "FLY_BY_WIRE_A",
+	6:  "FLY_BY_WIRE_B",
+	7:  "CRUISE",
+	8:  "AUTOTUNE",
+	10: "AUTO",
+	11: "RTL",
+	12: "LOITER",
+	13: "TAKEOFF",
+	14: "AVOID_ADSB",
+	15: "GUIDED",
+	16: "INITIALISING",
+	17: "QSTABILIZE",
+	18: "QHOVER",
+	19: "QLOITER",
+	20: "QLAND",
+	21: "QRTL",
+	22: "QAUTOTUNE",
+	23: "QACRO",
+	24: "THERMAL",
+	25: "LOITER_ALT_QLAND",
+}
+
+// arduRoverModes are Rover's custom_mode numbers, shared by boats
+var arduRoverModes = map[uint32]string{
+	0:  "MANUAL",
+	1:  "ACRO",
+	3:  "STEERING",
+	4:  "HOLD",
+	5:  "LOITER",
+	6:  "FOLLOW",
+	7:  "SIMPLE",
+	8:  "DOCK",
+	9:  "CIRCLE",
+	10: "AUTO",
+	11: "RTL",
+	12: "SMART_RTL",
+	15: "GUIDED",
+	16: "INITIALISING",
+}
+
+// arduSubModes are ArduSub's custom_mode numbers
+var arduSubModes = map[uint32]string{
+	0:  "STABILIZE",
+	1:  "ACRO",
+	2:  "ALT_HOLD",
+	3:  "AUTO",
+	4:  "GUIDED",
+	7:  "CIRCLE",
+	9:  "SURFACE",
+	16: "POSHOLD",
+	19: "MANUAL",
+	20: "MOTOR_DETECT",
+	21: "SURFTRAK",
+}
+
+// px4MainModes are PX4's main modes, stored in the third byte of custom_mode
+var px4MainModes = map[uint32]string{
+	1:  "MANUAL",
+	2:  "ALTCTL",
+	3:  "POSCTL",
+	4:  "AUTO",
+	5:  "ACRO",
+	6:  "OFFBOARD",
+	7:  "STABILIZED",
+	8:  "RATTITUDE",
+	9:  "SIMPLE",
+	10: "TERMINATION",
+}
+
+// px4AutoModes are the sub modes of PX4's AUTO main mode, stored in the
+// fourth byte of custom_mode
+var px4AutoModes = map[uint32]string{
+	1:  "READY",
+	2:  "TAKEOFF",
+	3:  "LOITER",
+	4:  "MISSION",
+	5:  "RTL",
+	6:  "LAND",
+	8:  "FOLLOW_TARGET",
+	9:  "PRECLAND",
+	10: "VTOL_TAKEOFF",
+}
+
+const px4MainModeAuto = 4
+
+// FlightMode decodes the custom_mode of a HEARTBEAT into a flight mode name.
+// The numbering depends on the autopilot and, for ArduPilot, on the vehicle
+// type; modes it cannot decode are reported as UnknownFlightMode.
+func FlightMode(autopilot common.MAV_AUTOPILOT, vehicleType common.MAV_TYPE, customMode uint32) string {
+	var name string
+	switch autopilot {
+	case common.MAV_AUTOPILOT_ARDUPILOTMEGA:
+		name = arduPilotModes(vehicleType)[customMode]
+	case common.MAV_AUTOPILOT_PX4:
+		name = px4FlightMode(customMode)
+	}
+
+	if name == "" {
+		return UnknownFlightMode
+	}
+	return name
+}
+
+// arduPilotModes returns the mode table of the ArduPilot firmware flying a
+// vehicle type, or nil when there is none
+func arduPilotModes(vehicleType common.MAV_TYPE) map[uint32]string {
+	switch vehicleType {
+	case common.MAV_TYPE_QUADROTOR, common.MAV_TYPE_HEXAROTOR, common.MAV_TYPE_OCTOROTOR,
+		common.MAV_TYPE_TRICOPTER, common.MAV_TYPE_COAXIAL, common.MAV_TYPE_HELICOPTER,
+		common.MAV_TYPE_DODECAROTOR, common.MAV_TYPE_DECAROTOR, common.MAV_TYPE_GENERIC_MULTIROTOR:
+		return arduCopterModes
+	case common.MAV_TYPE_FIXED_WING, common.MAV_TYPE_VTOL_TAILSITTER_DUOROTOR,
+		common.MAV_TYPE_VTOL_TAILSITTER_QUADROTOR, common.MAV_TYPE_VTOL_TILTROTOR,
+		common.MAV_TYPE_VTOL_FIXEDROTOR, common.MAV_TYPE_VTOL_TAILSITTER,
+		common.MAV_TYPE_VTOL_TILTWING:
+		return arduPlaneModes
+	case common.MAV_TYPE_GROUND_ROVER, common.MAV_TYPE_SURFACE_BOAT:
+		return arduRoverModes
+	case common.MAV_TYPE_SUBMARINE:
+		return arduSubModes
+	default:
+		return nil
+	}
+}
+
+// px4FlightMode decodes PX4's custom_mode, whose third byte is the main mode
+// and fourth byte the sub mode. AUTO modes are reported as AUTO.<sub mode>.
+func px4FlightMode(customMode uint32) string {
+	mainMode := (customMode >> 16) & 0xFF
+	subMode := (customMode >> 24) & 0xFF
+
+	name, ok := px4MainModes[mainMode]
+	if !ok || mainMode != px4MainModeAuto {
+		return name
+	}
+	if sub, ok := px4AutoModes[subMode]; ok {
+		return name + "." + sub
+	}
+	return name
+}
@@ -0,0 +1,127 @@
+package telemetry
+
+import (
+	"bytes"
+	"fmt"
+	"reflect"
+	"sync"
+
+	"github.com/bluenviron/gomavlib/v2/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v2/pkg/frame"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
+)
+
+// SetFrame copies the MAVLink frame header (system ID, component ID and
+// sequence number) into the envelope along with the encoded frame bytes.
+func (e *TelemetryEnvelope) SetFrame(fr frame.Frame, raw []byte) {
+	e.SystemID = fr.GetSystemID()
+	e.ComponentID = fr.GetComponentID()
+	e.Sequence = uint16(FrameSequence(fr))
+	e.Raw = raw
+}
+
+// FrameSequence returns the sequence number from a frame header
+func FrameSequence(fr frame.Frame) uint8 {
+	switch f := fr.(type) {
+	case *frame.V1Frame:
+		return f.SequenceID
+	case *frame.V2Frame:
+		return f.SequenceID
+	default:
+		return 0
+	}
+}
+
+// FrameEncoder encodes frames into their wire representation, reusing one
+// writer and buffer. It is safe for concurrent use.
+type FrameEncoder struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+	w   *frame.Writer
+}
+
+// NewFrameEncoder returns an encoder for frames of the dialect. A nil dialect
+// only encodes frames whose message is still raw.
+func NewFrameEncoder(rw *dialect.ReadWriter) (*FrameEncoder, error) {
+	e := &FrameEncoder{}
+	w, err := frame.NewWriter(frame.WriterConf{
+		Writer:      &e.buf,
+		DialectRW:   rw,
+		OutVersion:  frame.V2,
+		OutSystemID: 1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	e.w = w
+	return e, nil
+}
+
+// Encode returns the wire bytes of a frame. The frame's header, checksum and
+// signature are written as they are. A raw message, as read without a
+// dialect, is written unchanged, so the result matches the bytes that arrived
+// on the link. A decoded message is re-encoded with the dialect, which does
+// not reproduce payloads the sender did not truncate, so the checksum and
+// signature may not match the result.
+func (e *FrameEncoder) Encode(fr frame.Frame) ([]byte, error) {
+	// the writer replaces a decoded message with its raw form, so work on a
+	// copy to leave the caller's frame untouched
+	var cp frame.Frame
+	switch f := fr.(type) {
+	case *frame.V1Frame:
+		c := *f
+		cp = &c
+	case *frame.V2Frame:
+		c := *f
+		cp = &c
+	default:
+		return nil, fmt.Errorf("unsupported frame type %T", fr)
+	}
+
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	e.buf.Reset()
+	if err := e.w.WriteFrame(cp); err != nil {
+		return nil, err
+	}
+	return bytes.Clone(e.buf.Bytes()), nil
+}
+
+// EncodeFrame returns the wire bytes of a frame, as FrameEncoder.Encode does.
+// Encoding many frames is cheaper with a FrameEncoder.
+func EncodeFrame(rw *dialect.ReadWriter, fr frame.Frame) ([]byte, error) {
+	if rw == nil {
+		return nil, fmt.Errorf("dialect is nil")
+	}
+	e, err := NewFrameEncoder(rw)
+	if err != nil {
+		return nil, err
+	}
+	return e.Encode(fr)
+}
+
+// deviceTimestamp returns the vehicle-side timestamp of a message in seconds,
+// taken from its time_boot_ms or time_usec field. Messages without either
+// field return 0.
+func deviceTimestamp(msg message.Message) float64 {
+	v := reflect.ValueOf(msg)
+	if v.Kind() == reflect.Pointer {
+		if v.IsNil() {
+			return 0
+		}
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return 0
+	}
+
+	if f := v.FieldByName("TimeBootMs"); f.IsValid() && f.CanUint() {
+		return float64(f.Uint()) / 1e3
+	}
+	if f := v.FieldByName("TimeUsec"); f.IsValid() && f.CanUint() {
+		return float64(f.Uint()) / 1e6
+	}
+
+	return 0
+}
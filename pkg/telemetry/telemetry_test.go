@@ -1,8 +1,14 @@
 package telemetry
 
 import (
+	"bytes"
 	"testing"
 	"time"
+
+	"github.com/bluenviron/gomavlib/v2/pkg/dialect"
+	"github.com/bluenviron/gomavlib/v2/pkg/dialects/common"
+	"github.com/bluenviron/gomavlib/v2/pkg/frame"
+	"github.com/bluenviron/gomavlib/v2/pkg/message"
 )
 
 func makeTestEnvelope(source, msgName string, fields map[string]any) TelemetryEnvelope {
@@ -137,3 +143,260 @@ func TestTelemetryEnvelopeImplementsTelemetryMessage(t *testing.T) {
 		}
 	}
 }
+
+func TestBuildEnvelopeUsesOverride(t *testing.T) {
+	envelope := BuildEnvelope("drone-1", "drone-1", &common.MessageVfrHud{Groundspeed: 12.5})
+
+	if envelope.MsgName != "VFR_HUD" {
+		t.Errorf("MsgName = %q, want %q", envelope.MsgName, "VFR_HUD")
+	}
+	if _, ok := envelope.Fields["ground_speed"]; !ok {
+		t.Error("expected override field ground_speed")
+	}
+}
+
+func TestBuildGenericEnvelope(t *testing.T) {
+	msg := &common.MessageStatustext{
+		Severity: common.MAV_SEVERITY_WARNING,
+		Text:     "PreArm: Compass not calibrated",
+		ChunkSeq: 2,
+	}
+	envelope := BuildEnvelope("drone-1", "drone-alpha", msg)
+
+	if envelope.DroneID != "drone-alpha" {
+		t.Errorf("DroneID = %q, want %q", envelope.DroneID, "drone-alpha")
+	}
+	if envelope.MsgID != msg.GetID() {
+		t.Errorf("MsgID = %d, want %d", envelope.MsgID, msg.GetID())
+	}
+	if envelope.MsgName != "Statustext" {
+		t.Errorf("MsgName = %q, want %q", envelope.MsgName, "Statustext")
+	}
+	if got := envelope.Fields["severity"]; got != "MAV_SEVERITY_WARNING" {
+		t.Errorf("severity = %v, want MAV_SEVERITY_WARNING", got)
+	}
+	if got := envelope.Fields["text"]; got != msg.Text {
+		t.Errorf("text = %v, want %q", got, msg.Text)
+	}
+	if got := envelope.Fields["chunk_seq"]; got != uint8(2) {
+		t.Errorf("chunk_seq = %v, want 2", got)
+	}
+
+	attitude := BuildGenericEnvelope("drone-1", "drone-1", &common.MessageAttitudeQuaternion{Q1: 1})
+	if _, ok := attitude.Fields["time_boot_ms"]; !ok {
+		t.Error("expected snake_case field time_boot_ms")
+	}
+	if _, ok := attitude.Fields["q1"]; !ok {
+		t.Error("expected field q1")
+	}
+
+	if _, err := envelope.ToJSON(); err != nil {
+		t.Fatalf("ToJSON() error = %v", err)
+	}
+}
+
+func TestMessageNames(t *testing.T) {
+	testCases := []struct {
+		msg     message.Message
+		name    string
+		defName string
+	}{
+		{&common.MessageGlobalPositionInt{}, "GlobalPositionInt", "GLOBAL_POSITION_INT"},
+		{&common.MessageVfrHud{}, "VfrHud", "VFR_HUD"},
+		{&common.MessageGps2Raw{}, "Gps2Raw", "GPS2_RAW"},
+		{&common.MessageHeartbeat{}, "Heartbeat", "HEARTBEAT"},
+	}
+
+	for _, tc := range testCases {
+		if got := MessageName(tc.msg); got != tc.name {
+			t.Errorf("MessageName(%T) = %q, want %q", tc.msg, got, tc.name)
+		}
+		if got := MessageDefName(tc.msg); got != tc.defName {
+			t.Errorf("MessageDefName(%T) = %q, want %q", tc.msg, got, tc.defName)
+		}
+	}
+}
+
+func TestEncodeFrameRoundTrip(t *testing.T) {
+	rw, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		t.Fatalf("NewReadWriter() error = %v", err)
+	}
+
+	msg := &common.MessageAttitude{TimeBootMs: 123456, Roll: 0.25, Yaw: 1.5}
+	var wire bytes.Buffer
+	w, err := frame.NewWriter(frame.WriterConf{
+		Writer:         &wire,
+		DialectRW:      rw,
+		OutVersion:     frame.V2,
+		OutSystemID:    7,
+		OutComponentID: 1,
+	})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := w.WriteMessage(msg); err != nil {
+		t.Fatalf("WriteMessage() error = %v", err)
+	}
+
+	r, err := frame.NewReader(frame.ReaderConf{Reader: bytes.NewReader(wire.Bytes()), DialectRW: rw})
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	fr, err := r.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+
+	raw, err := EncodeFrame(rw, fr)
+	if err != nil {
+		t.Fatalf("EncodeFrame() error = %v", err)
+	}
+	if !bytes.Equal(raw, wire.Bytes()) {
+		t.Errorf("EncodeFrame() = %x, want %x", raw, wire.Bytes())
+	}
+	if _, ok := fr.GetMessage().(*common.MessageAttitude); !ok {
+		t.Errorf("EncodeFrame() modified the source frame message: %T", fr.GetMessage())
+	}
+
+	envelope := BuildEnvelope("drone-1", "drone-1", fr.GetMessage())
+	envelope.SetFrame(fr, raw)
+	if envelope.SystemID != 7 || envelope.ComponentID != 1 {
+		t.Errorf("SetFrame() system/component = %d/%d, want 7/1", envelope.SystemID, envelope.ComponentID)
+	}
+	if envelope.TimestampDevice != 123.456 {
+		t.Errorf("TimestampDevice = %v, want 123.456", envelope.TimestampDevice)
+	}
+}
+
+func TestFrameEncoder(t *testing.T) {
+	rw, err := dialect.NewReadWriter(common.Dialect)
+	if err != nil {
+		t.Fatalf("NewReadWriter() error = %v", err)
+	}
+	encoder, err := NewFrameEncoder(rw)
+	if err != nil {
+		t.Fatalf("NewFrameEncoder() error = %v", err)
+	}
+
+	// a sender that does not truncate trailing zeros
+	mrw := rw.GetMessage((&common.MessageAttitude{}).GetID())
+	payload := mrw.Write(&common.MessageAttitude{TimeBootMs: 1000}, true)
+	payload.Payload = append(payload.Payload, make([]byte, 28-len(payload.Payload))...)
+	untruncated := &frame.V2Frame{SequenceID: 3, SystemID: 1, ComponentID: 1, Message: payload}
+	untruncated.Checksum = untruncated.GenerateChecksum(mrw.CRCExtra())
+
+	var wire bytes.Buffer
+	w, err := frame.NewWriter(frame.WriterConf{Writer: &wire, OutVersion: frame.V2, OutSystemID: 1})
+	if err != nil {
+		t.Fatalf("NewWriter() error = %v", err)
+	}
+	if err := w.WriteFrame(untruncated); err != nil {
+		t.Fatalf("WriteFrame() error = %v", err)
+	}
+
+	// read without a dialect, as received, the frame is kept byte for byte
+	r, err := frame.NewReader(frame.ReaderConf{Reader: bytes.NewReader(wire.Bytes())})
+	if err != nil {
+		t.Fatalf("NewReader() error = %v", err)
+	}
+	fr, err := r.Read()
+	if err != nil {
+		t.Fatalf("Read() error = %v", err)
+	}
+	for range 2 {
+		raw, err := encoder.Encode(fr)
+		if err != nil {
+			t.Fatalf("Encode() error = %v", err)
+		}
+		if !bytes.Equal(raw, wire.Bytes()) {
+			t.Errorf("Encode() = %x, want %x", raw, wire.Bytes())
+		}
+	}
+
+	// decoded messages are re-encoded with the dialect
+	raw, err := encoder.Encode(&frame.V2Frame{SystemID: 1, ComponentID: 1, Message: &common.MessageHeartbeat{}})
+	if err != nil || len(raw) == 0 || raw[0] != frame.V2MagicByte {
+		t.Errorf("Encode() = %x, %v, want a v2 frame", raw, err)
+	}
+}
+
+func TestDeviceTimestamp(t *testing.T) {
+	testCases := []struct {
+		msg  message.Message
+		want float64
+	}{
+		{&common.MessageGpsRawInt{TimeUsec: 2_500_000}, 2.5},
+		{&common.MessageScaledPressure{TimeBootMs: 1500}, 1.5},
+		{&common.MessageHeartbeat{}, 0},
+	}
+
+	for _, tc := range testCases {
+		if got := BuildEnvelope("drone-1", "drone-1", tc.msg).TimestampDevice; got != tc.want {
+			t.Errorf("TimestampDevice(%T) = %v, want %v", tc.msg, got, tc.want)
+		}
+	}
+}
+
+func TestFlightMode(t *testing.T) {
+	px4 := func(mainMode, subMode uint32) uint32 { return mainMode<<16 | subMode<<24 }
+
+	testCases := []struct {
+		autopilot   common.MAV_AUTOPILOT
+		vehicleType common.MAV_TYPE
+		customMode  uint32
+		expected    string
+	}{
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_QUADROTOR, 0, "STABILIZE"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_QUADROTOR, 5, "LOITER"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_HEXAROTOR, 10, "OF_LOITER"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_HELICOPTER, 27, "AUTO_RTL"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_QUADROTOR, 12, "UNKNOWN"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_QUADROTOR, 999, "UNKNOWN"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_FIXED_WING, 5, "FLY_BY_WIRE_A"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_VTOL_TILTROTOR, 19, "QLOITER"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_GROUND_ROVER, 4, "HOLD"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_SURFACE_BOAT, 10, "AUTO"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_SUBMARINE, 19, "MANUAL"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_SUBMARINE, 9, "SURFACE"},
+		{common.MAV_AUTOPILOT_ARDUPILOTMEGA, common.MAV_TYPE_ANTENNA_TRACKER, 0, "UNKNOWN"},
+		{common.MAV_AUTOPILOT_PX4, common.MAV_TYPE_QUADROTOR, px4(3, 0), "POSCTL"},
+		{common.MAV_AUTOPILOT_PX4, common.MAV_TYPE_QUADROTOR, px4(6, 0), "OFFBOARD"},
+		{common.MAV_AUTOPILOT_PX4, common.MAV_TYPE_FIXED_WING, px4(4, 4), "AUTO.MISSION"},
+		{common.MAV_AUTOPILOT_PX4, common.MAV_TYPE_QUADROTOR, px4(4, 5), "AUTO.RTL"},
+		{common.MAV_AUTOPILOT_PX4, common.MAV_TYPE_QUADROTOR, px4(4, 0), "AUTO"},
+		{common.MAV_AUTOPILOT_PX4, common.MAV_TYPE_QUADROTOR, px4(0, 0), "UNKNOWN"},
+		{common.MAV_AUTOPILOT_GENERIC, common.MAV_TYPE_QUADROTOR, 3, "UNKNOWN"},
+	}
+
+	for _, tc := range testCases {
+		if got := FlightMode(tc.autopilot, tc.vehicleType, tc.customMode); got != tc.expected {
+			t.Errorf("%s %s mode %d: expected %q, got %q", tc.autopilot, tc.vehicleType, tc.customMode, tc.expected, got)
+		}
+	}
+}
+
+func TestBuildHeartbeatEnvelope(t *testing.T) {
+	envelope := BuildHeartbeatEnvelope("udp", "drone-1", &common.MessageHeartbeat{
+		Type:         common.MAV_TYPE_QUADROTOR,
+		Autopilot:    common.MAV_AUTOPILOT_ARDUPILOTMEGA,
+		BaseMode:     common.MAV_MODE_FLAG_SAFETY_ARMED | common.MAV_MODE_FLAG_CUSTOM_MODE_ENABLED,
+		CustomMode:   6,
+		SystemStatus: common.MAV_STATE_ACTIVE,
+	})
+
+	expected := map[string]any{
+		"type":          "MAV_TYPE_QUADROTOR",
+		"autopilot":     "MAV_AUTOPILOT_ARDUPILOTMEGA",
+		"base_mode":     "MAV_MODE_FLAG_CUSTOM_MODE_ENABLED | MAV_MODE_FLAG_SAFETY_ARMED",
+		"custom_mode":   uint32(6),
+		"armed":         true,
+		"system_status": "MAV_STATE_ACTIVE",
+		"flight_mode":   "RTL",
+	}
+	for key, want := range expected {
+		if got := envelope.Fields[key]; got != want {
+			t.Errorf("field %s: expected %v, got %v", key, want, got)
+		}
+	}
+}